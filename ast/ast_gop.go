@@ -44,11 +44,36 @@ func (*SliceLit) exprNode() {}
 
 // -----------------------------------------------------------------------------
 
-// ErrWrapExpr represents `expr!`, `expr?` or `expr?: defaultValue`
+// A StringLitEx node represents a string literal with embedded `${expr}`
+// expressions, eg. "Hello ${name}!".
+type StringLitEx struct {
+	ValuePos token.Pos // literal position
+	Value    string    // literal string as it appears in the source
+	Parts    []Expr    // string segments (*BasicLit of kind token.STRING) and embedded expressions
+}
+
+// Pos - position of first character belonging to the node
+func (p *StringLitEx) Pos() token.Pos {
+	return p.ValuePos
+}
+
+// End - position of first character immediately after the node
+func (p *StringLitEx) End() token.Pos {
+	return token.Pos(int(p.ValuePos) + len(p.Value))
+}
+
+func (*StringLitEx) exprNode() {}
+
+// -----------------------------------------------------------------------------
+
+// ErrWrapExpr represents `expr!`, `expr?` or `expr?: defaultValue`.
+// `expr!` and `expr?` can be followed by a context message, eg.
+// `expr? "reading config"`.
 type ErrWrapExpr struct {
 	X       Expr
 	Tok     token.Token // ! or ?
 	TokPos  token.Pos
+	Msg     Expr // context message (a string literal); or nil
 	Default Expr // can be nil
 }
 
@@ -62,6 +87,9 @@ func (p *ErrWrapExpr) End() token.Pos {
 	if p.Default != nil {
 		return p.Default.End()
 	}
+	if p.Msg != nil {
+		return p.Msg.End()
+	}
 	return p.TokPos + 1
 }
 
@@ -143,21 +171,35 @@ func (p *ForPhrase) exprNode() {}
 //    `{vexpr for k1, v1 <- container1, cond1 ...}` or
 //    `{kexpr: vexpr for k1, v1 <- container1, cond1 ...}` or
 //    `{for k1, v1 <- container1, cond1 ...}` or
+//    `set{vexpr for k1, v1 <- container1, cond1 ...}` or
+//    `(vexpr for k1, v1 <- container1, cond1 ...)` or
+//    `vexpr for k1, v1 <- container1, cond1 ...` (argument of `sum(...)` etc.)
 type ComprehensionExpr struct {
-	Lpos token.Pos   // position of "[" or "{"
-	Tok  token.Token // token.LBRACK '[' or token.LBRACE '{'
-	Elt  Expr        // *KeyValueExpr or Expr or nil
-	Fors []*ForPhrase
-	Rpos token.Pos // position of "]" or "}"
+	Type  Expr        // set of a set comprehension; or nil
+	Lpos  token.Pos   // position of "[", "{" or "(" (of the call for token.FOR)
+	Tok   token.Token // token.LBRACK '[', token.LBRACE '{', token.LPAREN '(' or token.FOR
+	Elt   Expr        // *KeyValueExpr or Expr or nil
+	Fors  []*ForPhrase
+	Query []*QueryClause // query clauses of a list comprehension; or nil
+	Rpos  token.Pos      // position of "]", "}" or ")" (of the call for token.FOR)
 }
 
 // Pos - position of first character belonging to the node
 func (p *ComprehensionExpr) Pos() token.Pos {
+	if p.Tok == token.FOR {
+		return p.Elt.Pos()
+	}
+	if p.Type != nil {
+		return p.Type.Pos()
+	}
 	return p.Lpos
 }
 
 // End - position of first character immediately after the node
 func (p *ComprehensionExpr) End() token.Pos {
+	if p.Tok == token.FOR {
+		return p.Rpos
+	}
 	return p.Rpos + 1
 }
 
@@ -165,6 +207,59 @@ func (*ComprehensionExpr) exprNode() {}
 
 // -----------------------------------------------------------------------------
 
+// A QueryClause node represents a query clause of a list comprehension:
+//    `groupby key1, key2 ...` or
+//    `distinct` or
+//    `orderby key1 [asc|desc], key2 [asc|desc] ...` or
+//    `skip n` or
+//    `take n`
+type QueryClause struct {
+	Keyword *Ident      // groupby, distinct, orderby, skip or take
+	Args    []Expr      // keys of groupby, or n of skip and take
+	Orders  []*OrderKey // keys of orderby
+}
+
+// Pos returns position of first character belonging to the node.
+func (p *QueryClause) Pos() token.Pos { return p.Keyword.Pos() }
+
+// End returns position of first character immediately after the node.
+func (p *QueryClause) End() token.Pos {
+	if n := len(p.Orders); n > 0 {
+		return p.Orders[n-1].End()
+	}
+	if n := len(p.Args); n > 0 {
+		return p.Args[n-1].End()
+	}
+	return p.Keyword.End()
+}
+
+func (p *QueryClause) exprNode() {}
+
+// An OrderKey node represents a key of an orderby clause: `key [asc|desc]`.
+type OrderKey struct {
+	X      Expr
+	DirPos token.Pos // position of asc or desc; or NoPos
+	Desc   bool      // descending order
+}
+
+// Pos returns position of first character belonging to the node.
+func (p *OrderKey) Pos() token.Pos { return p.X.Pos() }
+
+// End returns position of first character immediately after the node.
+func (p *OrderKey) End() token.Pos {
+	switch {
+	case !p.DirPos.IsValid():
+		return p.X.End()
+	case p.Desc:
+		return p.DirPos + 4
+	}
+	return p.DirPos + 3
+}
+
+func (p *OrderKey) exprNode() {}
+
+// -----------------------------------------------------------------------------
+
 // A ForPhraseStmt represents a for statement with a for <- clause.
 type ForPhraseStmt struct {
 	*ForPhrase
@@ -219,3 +314,70 @@ func (p *RangeExpr) End() token.Pos {
 func (*RangeExpr) exprNode() {}
 
 // -----------------------------------------------------------------------------
+
+// An EnumType node represents an enumeration type, eg.
+//    enum { Red; Green; Blue }
+//    enum { A = 1, B, C }
+// A value without an explicit value is the previous value plus one, or 0 for
+// the first value.
+type EnumType struct {
+	Enum   token.Pos    // position of "enum" keyword
+	Lbrace token.Pos    // position of "{"
+	Values []*EnumValue // values of the enumeration
+	Rbrace token.Pos    // position of "}"
+}
+
+// An EnumValue node represents a value of an enumeration type.
+type EnumValue struct {
+	Name  *Ident
+	Value Expr // explicit value; or nil
+}
+
+// Pos - position of first character belonging to the node
+func (p *EnumType) Pos() token.Pos {
+	return p.Enum
+}
+
+// End - position of first character immediately after the node
+func (p *EnumType) End() token.Pos {
+	return p.Rbrace + 1
+}
+
+// Pos - position of first character belonging to the node
+func (p *EnumValue) Pos() token.Pos {
+	return p.Name.Pos()
+}
+
+// End - position of first character immediately after the node
+func (p *EnumValue) End() token.Pos {
+	if p.Value != nil {
+		return p.Value.End()
+	}
+	return p.Name.End()
+}
+
+func (*EnumType) exprNode() {}
+
+// -----------------------------------------------------------------------------
+
+// A KwargExpr node represents a keyword argument `name = value` of a call,
+// eg. `timeout = 5` in `connect(host, timeout = 5)`.
+type KwargExpr struct {
+	Name   *Ident
+	Assign token.Pos // position of "="
+	Value  Expr
+}
+
+// Pos - position of first character belonging to the node
+func (p *KwargExpr) Pos() token.Pos {
+	return p.Name.Pos()
+}
+
+// End - position of first character immediately after the node
+func (p *KwargExpr) End() token.Pos {
+	return p.Value.End()
+}
+
+func (*KwargExpr) exprNode() {}
+
+// -----------------------------------------------------------------------------
@@ -153,6 +153,9 @@ func gopExpr(val ast.Expr) gopast.Expr {
 			Elt:      gopExpr(v.Elt),
 		}
 	}
+	if ret, ok := gopExprEx(val); ok {
+		return ret
+	}
 	log.Panicln("gopExpr: unknown expr -", reflect.TypeOf(val))
 	return nil
 }
@@ -173,9 +176,10 @@ func gopExprs(vals []ast.Expr) []gopast.Expr {
 
 func gopFuncType(v *ast.FuncType) *gopast.FuncType {
 	return &gopast.FuncType{
-		Func:    v.Func,
-		Params:  gopFieldList(v.Params),
-		Results: gopFieldList(v.Results),
+		Func:       v.Func,
+		TypeParams: gopFieldList(funcTypeParams(v)),
+		Params:     gopFieldList(v.Params),
+		Results:    gopFieldList(v.Results),
 	}
 }
 
@@ -254,9 +258,10 @@ func gopImportSpec(spec *ast.ImportSpec) *gopast.ImportSpec {
 
 func gopTypeSpec(spec *ast.TypeSpec) *gopast.TypeSpec {
 	return &gopast.TypeSpec{
-		Name:   gopIdent(spec.Name),
-		Assign: spec.Assign,
-		Type:   gopType(spec.Type),
+		Name:       gopIdent(spec.Name),
+		TypeParams: gopFieldList(typeSpecParams(spec)),
+		Assign:     spec.Assign,
+		Type:       gopType(spec.Type),
 	}
 }
 
@@ -0,0 +1,50 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fromgo
+
+import (
+	"go/ast"
+
+	gopast "github.com/goplus/gop/ast"
+)
+
+// ----------------------------------------------------------------------------
+
+func funcTypeParams(v *ast.FuncType) *ast.FieldList {
+	return v.TypeParams
+}
+
+func typeSpecParams(spec *ast.TypeSpec) *ast.FieldList {
+	return spec.TypeParams
+}
+
+func gopExprEx(val ast.Expr) (gopast.Expr, bool) {
+	if v, ok := val.(*ast.IndexListExpr); ok {
+		return &gopast.IndexListExpr{
+			X:       gopExpr(v.X),
+			Lbrack:  v.Lbrack,
+			Indices: gopExprs(v.Indices),
+			Rbrack:  v.Rbrack,
+		}, true
+	}
+	return nil, false
+}
+
+// ----------------------------------------------------------------------------
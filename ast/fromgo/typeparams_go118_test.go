@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fromgo
+
+import (
+	"testing"
+)
+
+func TestTypeParams(t *testing.T) {
+	test(t, `package main
+
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+type Map[K comparable, V any] struct {
+	data map[K]V
+}
+
+type Pair[K, V any] struct {
+	First  K
+	Second V
+}
+
+func (p *Map[K, V]) Get(key K) (V) {}
+
+func Sum[T Number](vals []T) (T) {}
+
+var m = Map[string, Pair[int, float64]]{}
+`)
+}
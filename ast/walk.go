@@ -87,6 +87,9 @@ func Walk(v Visitor, node Node) {
 		if n.Tag != nil {
 			Walk(v, n.Tag)
 		}
+		if n.Default != nil {
+			Walk(v, n.Default)
+		}
 		if n.Comment != nil {
 			Walk(v, n.Comment)
 		}
@@ -100,6 +103,9 @@ func Walk(v Visitor, node Node) {
 	case *BadExpr, *Ident, *BasicLit:
 		// nothing to do
 
+	case *StringLitEx:
+		walkExprList(v, n.Parts)
+
 	case *Ellipsis:
 		if n.Elt != nil {
 			Walk(v, n.Elt)
@@ -126,6 +132,10 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.X)
 		Walk(v, n.Index)
 
+	case *IndexListExpr:
+		Walk(v, n.X)
+		walkExprList(v, n.Indices)
+
 	case *SliceExpr:
 		Walk(v, n.X)
 		if n.Low != nil {
@@ -147,6 +157,9 @@ func Walk(v Visitor, node Node) {
 	case *CallExpr:
 		Walk(v, n.Fun)
 		walkExprList(v, n.Args)
+		for _, kw := range n.Kwargs {
+			Walk(v, kw)
+		}
 
 	case *StarExpr:
 		Walk(v, n.X)
@@ -173,6 +186,9 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.Fields)
 
 	case *FuncType:
+		if n.TypeParams != nil {
+			Walk(v, n.TypeParams)
+		}
 		if n.Params != nil {
 			Walk(v, n.Params)
 		}
@@ -296,6 +312,96 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.X)
 		Walk(v, n.Body)
 
+	// Go+ expressions and statements
+	case *SliceLit:
+		walkExprList(v, n.Elts)
+
+	case *ErrWrapExpr:
+		Walk(v, n.X)
+		if n.Msg != nil {
+			Walk(v, n.Msg)
+		}
+		if n.Default != nil {
+			Walk(v, n.Default)
+		}
+
+	case *LambdaExpr:
+		walkIdentList(v, n.Lhs)
+		walkExprList(v, n.Rhs)
+
+	case *LambdaExpr2:
+		walkIdentList(v, n.Lhs)
+		Walk(v, n.Body)
+
+	case *ForPhrase:
+		if n.Key != nil {
+			Walk(v, n.Key)
+		}
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		Walk(v, n.X)
+		if n.Init != nil {
+			Walk(v, n.Init)
+		}
+		if n.Cond != nil {
+			Walk(v, n.Cond)
+		}
+
+	case *ComprehensionExpr:
+		if n.Type != nil {
+			Walk(v, n.Type)
+		}
+		if n.Elt != nil {
+			Walk(v, n.Elt)
+		}
+		for _, f := range n.Fors {
+			Walk(v, f)
+		}
+		for _, q := range n.Query {
+			Walk(v, q)
+		}
+
+	case *QueryClause:
+		Walk(v, n.Keyword)
+		walkExprList(v, n.Args)
+		for _, key := range n.Orders {
+			Walk(v, key)
+		}
+
+	case *OrderKey:
+		Walk(v, n.X)
+
+	case *ForPhraseStmt:
+		Walk(v, n.ForPhrase)
+		Walk(v, n.Body)
+
+	case *KwargExpr:
+		Walk(v, n.Name)
+		Walk(v, n.Value)
+
+	case *EnumType:
+		for _, ev := range n.Values {
+			Walk(v, ev)
+		}
+
+	case *EnumValue:
+		Walk(v, n.Name)
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+
+	case *RangeExpr:
+		if n.First != nil {
+			Walk(v, n.First)
+		}
+		if n.Last != nil {
+			Walk(v, n.Last)
+		}
+		if n.Expr3 != nil {
+			Walk(v, n.Expr3)
+		}
+
 	// Declarations
 	case *ImportSpec:
 		if n.Doc != nil {
@@ -327,6 +433,9 @@ func Walk(v Visitor, node Node) {
 			Walk(v, n.Doc)
 		}
 		Walk(v, n.Name)
+		if n.TypeParams != nil {
+			Walk(v, n.TypeParams)
+		}
 		Walk(v, n.Type)
 		if n.Comment != nil {
 			Walk(v, n.Comment)
@@ -18,8 +18,7 @@ package gop
 
 import (
 	"errors"
-	"log"
-	"os"
+	"path/filepath"
 
 	"github.com/goplus/gop/x/gocmd"
 )
@@ -31,17 +30,15 @@ func InstallDir(dir string, conf *Config, install *gocmd.InstallConfig) (err err
 	if err != nil {
 		return
 	}
-	return gocmd.Install(dir, install)
+	return gocmd.Install(dir, withOverlay(install, conf))
 }
 
 func InstallPkgPath(workDir, pkgPath string, conf *Config, install *gocmd.InstallConfig) (err error) {
-	localDir, recursively, err := GenGoPkgPath(workDir, pkgPath, conf, true)
+	localDir, recursively, conf, err := genGoPkgPath(workDir, pkgPath, conf, true)
 	if err != nil {
 		return
 	}
-	old := chdir(localDir)
-	defer os.Chdir(old)
-	return gocmd.Install(cwdParam(recursively), install)
+	return gocmd.Install(cwdParam(recursively), withOverlay(withDir(install, localDir), conf))
 }
 
 func cwdParam(recursively bool) string {
@@ -56,19 +53,17 @@ func InstallFiles(files []string, conf *Config, install *gocmd.InstallConfig) (e
 	if err != nil {
 		return
 	}
-	return gocmd.InstallFiles(files, install)
+	return gocmd.InstallFiles(files, withOverlay(install, conf))
 }
 
-func chdir(dir string) string {
-	old, err := os.Getwd()
-	if err != nil {
-		log.Panicln(err)
-	}
-	err = os.Chdir(dir)
-	if err != nil {
-		log.Panicln(err)
+// withDir returns the config of go commands run in dir.
+func withDir(c *gocmd.Config, dir string) *gocmd.Config {
+	var ret gocmd.Config
+	if c != nil {
+		ret = *c
 	}
-	return old
+	ret.Dir = dir
+	return &ret
 }
 
 // -----------------------------------------------------------------------------
@@ -78,17 +73,17 @@ func BuildDir(dir string, conf *Config, build *gocmd.BuildConfig) (err error) {
 	if err != nil {
 		return
 	}
-	return gocmd.Build(dir, build)
+	return gocmd.Build(dir, withOverlay(build, conf))
 }
 
 func BuildPkgPath(workDir, pkgPath string, conf *Config, build *gocmd.BuildConfig) (err error) {
-	localDir, recursively, err := GenGoPkgPath(workDir, pkgPath, conf, false)
+	_, _, conf, err = genGoPkgPath(workDir, pkgPath, conf, false)
 	if err != nil {
 		return
 	}
-	old := chdirAndMod(localDir)
-	defer restoreDirAndMod(old)
-	return gocmd.Build(cwdParam(recursively), build)
+	// build pkgPath in workDir, not to write its directory, which may be in
+	// the module cache
+	return gocmd.Build(pkgPath, withOverlay(withDir(build, workDir), conf))
 }
 
 func BuildFiles(files []string, conf *Config, build *gocmd.BuildConfig) (err error) {
@@ -96,17 +91,7 @@ func BuildFiles(files []string, conf *Config, build *gocmd.BuildConfig) (err err
 	if err != nil {
 		return
 	}
-	return gocmd.BuildFiles(files, build)
-}
-
-func chdirAndMod(dir string) string {
-	os.Chmod(dir, 0777)
-	return chdir(dir)
-}
-
-func restoreDirAndMod(old string) {
-	os.Chmod(".", 0555)
-	os.Chdir(old)
+	return gocmd.BuildFiles(files, withOverlay(build, conf))
 }
 
 // -----------------------------------------------------------------------------
@@ -116,23 +101,32 @@ func RunDir(dir string, args []string, conf *Config, run *gocmd.RunConfig) (err
 	if err != nil {
 		return
 	}
+	return runDir(dir, args, conf, run)
+}
+
+func runDir(dir string, args []string, conf *Config, run *gocmd.RunConfig) (err error) {
+	if conf != nil && conf.GenDir != "" { // Go files of the package aren't all in dir
+		if dir, err = filepath.Abs(dir); err != nil {
+			return
+		}
+		return gocmd.RunFiles([]string{dir}, args, withOverlay(run, conf))
+	}
 	return gocmd.RunDir(dir, args, run)
 }
 
-func RunPkgPath(pkgPath string, args []string, chDir bool, conf *Config, run *gocmd.RunConfig) (err error) {
-	localDir, recursively, err := GenGoPkgPath("", pkgPath, conf, true)
+func RunPkgPath(workDir, pkgPath string, args []string, chDir bool, conf *Config, run *gocmd.RunConfig) (err error) {
+	localDir, recursively, conf, err := genGoPkgPath(workDir, pkgPath, conf, true)
 	if err != nil {
 		return
 	}
 	if recursively {
 		return errors.New("can't use ... pattern for `gop run` command")
 	}
+	dir := workDir
 	if chDir {
-		old := chdir(localDir)
-		defer os.Chdir(old)
-		localDir = "."
+		dir = localDir
 	}
-	return gocmd.RunDir(localDir, args, run)
+	return runDir(localDir, args, conf, withDir(run, dir))
 }
 
 func RunFiles(autogen string, files []string, args []string, conf *Config, run *gocmd.RunConfig) (err error) {
@@ -140,27 +134,29 @@ func RunFiles(autogen string, files []string, args []string, conf *Config, run *
 	if err != nil {
 		return
 	}
-	return gocmd.RunFiles(files, args, run)
+	return gocmd.RunFiles(files, args, withOverlay(run, conf))
 }
 
 // -----------------------------------------------------------------------------
 
+// vetOff disables go vet in go test with the overlay of Go files generated,
+// which go vet doesn't support.
+const vetOff = "-vet=off"
+
 func TestDir(dir string, conf *Config, test *gocmd.TestConfig) (err error) {
 	_, _, err = GenGo(dir, conf, true)
 	if err != nil {
 		return
 	}
-	return gocmd.Test(dir, test)
+	return gocmd.Test(dir, withOverlay(test, conf, vetOff))
 }
 
 func TestPkgPath(workDir, pkgPath string, conf *Config, test *gocmd.TestConfig) (err error) {
-	localDir, recursively, err := GenGoPkgPath(workDir, pkgPath, conf, false)
+	_, _, conf, err = genGoPkgPath(workDir, pkgPath, conf, false)
 	if err != nil {
 		return
 	}
-	old := chdirAndMod(localDir)
-	defer restoreDirAndMod(old)
-	return gocmd.Test(cwdParam(recursively), test)
+	return gocmd.Test(pkgPath, withOverlay(withDir(test, workDir), conf, vetOff))
 }
 
 func TestFiles(files []string, conf *Config, test *gocmd.TestConfig) (err error) {
@@ -168,7 +164,7 @@ func TestFiles(files []string, conf *Config, test *gocmd.TestConfig) (err error)
 	if err != nil {
 		return
 	}
-	return gocmd.TestFiles(files, test)
+	return gocmd.TestFiles(files, withOverlay(test, conf, vetOff))
 }
 
 // -----------------------------------------------------------------------------
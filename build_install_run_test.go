@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gop_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/env"
+	"github.com/goplus/gop/x/gocmd"
+	modenv "github.com/goplus/mod/env"
+	"github.com/goplus/mod/modcache"
+)
+
+func TestBuildPkgPathConcurrently(t *testing.T) {
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopEnv := &modenv.Gop{Version: env.Version(), BuildDate: env.BuildDate(), Root: root}
+	tmp := t.TempDir()
+
+	const n = 3
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	outs := make([]string, n)
+	for i := 0; i < n; i++ {
+		modDir := filepath.Join(tmp, fmt.Sprint("mod", i))
+		modPath := fmt.Sprint("example.com/mod", i)
+		writeFile(t, filepath.Join(modDir, "go.mod"), "module "+modPath+"\n\ngo 1.16\n")
+		writeFile(t, filepath.Join(modDir, "hello", "hello.gop"), fmt.Sprintf("println \"hello\", %d\n", i))
+		outs[i] = filepath.Join(tmp, fmt.Sprint("hello", i))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conf := &gop.Config{Gop: gopEnv, DontUpdateGoMod: true}
+			build := &gocmd.BuildConfig{Gop: gopEnv, Flags: []string{"-o", outs[i]}}
+			errs[i] = gop.BuildPkgPath(modDir, modPath+"/hello", conf, build)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("BuildPkgPath mod%d: %v", i, errs[i])
+		}
+		out, err := exec.Command(outs[i]).Output()
+		if err != nil {
+			t.Fatalf("run hello%d: %v", i, err)
+		}
+		if ret := string(out); ret != fmt.Sprintf("hello %d\n", i) {
+			t.Fatalf("hello%d: %q", i, ret)
+		}
+	}
+	if dir, _ := os.Getwd(); dir != root {
+		t.Fatal("working directory changed:", dir)
+	}
+}
+
+func TestBuildPkgPathGenDir(t *testing.T) {
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopEnv := &modenv.Gop{Version: env.Version(), BuildDate: env.BuildDate(), Root: root}
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "hello")
+	goMod := "module example.com/hello\n\ngo 1.16\n"
+	writeFile(t, filepath.Join(dir, "go.mod"), goMod)
+	writeFile(t, filepath.Join(dir, "gop.mod"), goMod)
+	writeFile(t, filepath.Join(dir, "hello.gop"), "println \"hello\"\n")
+	old := time.Now().Add(-time.Hour) // go.mod is older than gop.mod
+	if err = os.Chtimes(filepath.Join(dir, "go.mod"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(tmp, "hello.out")
+	var warnings []string
+	conf := &gop.Config{Gop: gopEnv, GenDir: filepath.Join(tmp, "gen"), OnWarning: func(err error) {
+		warnings = append(warnings, err.Error())
+	}}
+	build := &gocmd.BuildConfig{Gop: gopEnv, Flags: []string{"-o", out}}
+	for i := 0; i < 2; i++ { // GenDir removed isn't stale
+		if err = gop.BuildPkgPath(dir, "example.com/hello", conf, build); err != nil {
+			t.Fatal("BuildPkgPath:", err)
+		}
+		if ret, err := exec.Command(out).Output(); err != nil || string(ret) != "hello\n" {
+			t.Fatalf("run hello: %q, %v", ret, err)
+		}
+		if err = os.RemoveAll(conf.GenDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(warnings) == 0 || !strings.Contains(warnings[0], "doesn't require github.com/goplus/gop of gop.mod") {
+		t.Fatal("missing requires of go.mod not reported:", warnings)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if name := e.Name(); name != "go.mod" && name != "gop.mod" && name != "hello.gop" {
+			t.Fatal("file written in the source directory:", name)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "go.mod")); err != nil || string(b) != goMod {
+		t.Fatalf("go.mod changed: %q, %v", b, err)
+	}
+}
+
+func TestBuildPkgPathModCache(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG_CACHE_HOME isn't the user cache directory")
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopEnv := &modenv.Gop{Version: env.Version(), BuildDate: env.BuildDate(), Root: root}
+	tmp := t.TempDir()
+	goEnv, err := exec.Command("go", "env", "GOCACHE", "GOMODCACHE").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	goCache, goModCache := splitLines(goEnv)
+
+	// example.com/hello v1.0.0 served by a file proxy
+	proxy := filepath.Join(tmp, "proxy", "example.com", "hello", "@v")
+	goMod := "module example.com/hello\n\ngo 1.16\n"
+	writeFile(t, filepath.Join(proxy, "list"), "v1.0.0\n")
+	writeFile(t, filepath.Join(proxy, "v1.0.0.info"), `{"Version":"v1.0.0"}`)
+	writeFile(t, filepath.Join(proxy, "v1.0.0.mod"), goMod)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string]string{"go.mod": goMod, "hello.gop": "println \"hello\"\n"} {
+		w, err := zw.Create("example.com/hello@v1.0.0/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, data)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(proxy, "v1.0.0.zip"), buf.String())
+
+	modCache := filepath.Join(tmp, "modcache")
+	// other modules are served by the download cache of the module cache
+	setEnv(t, "GOPROXY", "file://"+filepath.ToSlash(filepath.Join(tmp, "proxy"))+
+		",file://"+filepath.ToSlash(filepath.Join(goModCache, "cache", "download")))
+	setEnv(t, "GOSUMDB", "off")
+	setEnv(t, "GOFLAGS", "-mod=mod")
+	setEnv(t, "GOMODCACHE", modCache)
+	setEnv(t, "GOCACHE", goCache)
+	setEnv(t, "XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+	old := modcache.GOMODCACHE
+	modcache.GOMODCACHE = modCache
+	t.Cleanup(func() {
+		modcache.GOMODCACHE = old
+		exec.Command("go", "clean", "-modcache").Run() // the module cache is read-only
+	})
+
+	work := filepath.Join(tmp, "work")
+	writeFile(t, filepath.Join(work, "go.mod"), "module example.com/work\n\ngo 1.16\n\nrequire example.com/hello v1.0.0\n")
+	cmd := exec.Command("go", "mod", "download", "example.com/hello")
+	cmd.Dir = work
+	if ret, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go mod download: %s, %v", ret, err)
+	}
+	hello := filepath.Join(modCache, "example.com", "hello@v1.0.0")
+	before := snapshot(t, hello)
+
+	out := filepath.Join(tmp, "hello.out")
+	build := &gocmd.BuildConfig{Gop: gopEnv, Flags: []string{"-o", out}}
+	if err = gop.BuildPkgPath(work, "example.com/hello", &gop.Config{Gop: gopEnv}, build); err != nil {
+		t.Fatal("BuildPkgPath:", err)
+	}
+	if ret, err := exec.Command(out).Output(); err != nil || string(ret) != "hello\n" {
+		t.Fatalf("run hello: %q, %v", ret, err)
+	}
+	if after := snapshot(t, hello); !reflect.DeepEqual(after, before) {
+		t.Fatalf("module cache changed:\n%v\n%v", before, after)
+	}
+}
+
+// snapshot returns the modes and modification times of files in dir.
+func snapshot(t *testing.T, dir string) map[string]string {
+	ret := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ret[path] = fmt.Sprint(fi.Mode(), fi.ModTime())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func splitLines(b []byte) (string, string) {
+	lines := strings.SplitN(strings.TrimSpace(string(b)), "\n", 2)
+	return lines[0], lines[1]
+}
+
+func setEnv(t *testing.T, key, val string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, val)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func writeFile(t *testing.T, file, data string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iox
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+
+// LineReader is a reader whose values in for phrases are its lines, without
+// the trailing "\n" or "\r\n":
+//
+//   for line <- lines(r) { ... }
+//   for i, line <- lines(r) { ... }
+//
+// An io.Reader r in a for phrase is the same as lines(r). The iteration stops
+// at the first error, which is returned by Err. A File is closed when the
+// iteration stops.
+type LineReader struct {
+	r   io.Reader
+	err error
+}
+
+// Lines returns a reader of the lines of r.
+func Lines(r io.Reader) *LineReader {
+	return &LineReader{r: r}
+}
+
+// Gop_Enum returns an iterator of the lines.
+func (p *LineReader) Gop_Enum() *LineIter {
+	return &LineIter{p: p, r: bufio.NewReader(p.r)}
+}
+
+// Err returns the first error of reading lines, except io.EOF.
+func (p *LineReader) Err() error {
+	return p.err
+}
+
+// LineIter is an iterator of lines of a LineReader.
+type LineIter struct {
+	p *LineReader
+	r *bufio.Reader
+	n int
+}
+
+// Next returns the next line and its index, or ok = false if there are no
+// more lines.
+func (p *LineIter) Next() (i int, line string, ok bool) {
+	if p.r == nil {
+		return
+	}
+	line, err := p.r.ReadString('\n')
+	if err != nil {
+		p.r = nil
+		closeFile(p.p.r)
+		if err != io.EOF {
+			p.p.err = err
+			return
+		}
+		if line == "" {
+			return
+		}
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	i, ok = p.n, true
+	p.n++
+	return
+}
+
+// -----------------------------------------------------------------------------
+
+// CSVReader is a reader whose values in for phrases are its CSV records:
+//
+//   for rec <- csv(r) { ... }
+//   for i, rec <- csv(r) { ... }
+//
+// Records may have a variable number of fields. The iteration stops at the
+// first error, which is returned by Err. A File is closed when the iteration
+// stops.
+type CSVReader struct {
+	r   io.Reader
+	err error
+}
+
+// CSV returns a reader of the CSV records of r.
+func CSV(r io.Reader) *CSVReader {
+	return &CSVReader{r: r}
+}
+
+// Gop_Enum returns an iterator of the records.
+func (p *CSVReader) Gop_Enum() *CSVIter {
+	r := csv.NewReader(p.r)
+	r.FieldsPerRecord = -1
+	return &CSVIter{p: p, r: r}
+}
+
+// Err returns the first error of reading records, except io.EOF.
+func (p *CSVReader) Err() error {
+	return p.err
+}
+
+// CSVIter is an iterator of records of a CSVReader.
+type CSVIter struct {
+	p *CSVReader
+	r *csv.Reader
+	n int
+}
+
+// Next returns the next record and its index, or ok = false if there are no
+// more records.
+func (p *CSVIter) Next() (i int, rec []string, ok bool) {
+	if p.r == nil {
+		return
+	}
+	rec, err := p.r.Read()
+	if err != nil {
+		p.r = nil
+		closeFile(p.p.r)
+		if err != io.EOF {
+			p.p.err = err
+		}
+		return
+	}
+	i, ok = p.n, true
+	p.n++
+	return
+}
+
+// SplitCSV splits s into the fields of a CSV record. It is the method
+// `s.csv` of string.
+func SplitCSV(s string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(s))
+	r.FieldsPerRecord = -1
+	rec, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return rec, err
+}
+
+// -----------------------------------------------------------------------------
+
+// File is a file opened by Open. It is closed when the iteration over its
+// lines or CSV records stops, at the end of the file or at an error, so only a
+// file which isn't iterated to the end, eg. by a loop left by break, has to be
+// closed by Close.
+type File struct {
+	*os.File
+	closed bool
+}
+
+// Open opens the named file for reading, like os.Open.
+func Open(name string) (*File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: f}, nil
+}
+
+// Close closes the file. It does nothing if the file is already closed.
+func (p *File) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	return p.File.Close()
+}
+
+func closeFile(r io.Reader) {
+	if f, ok := r.(*File); ok {
+		f.Close()
+	}
+}
+
+// ReadFile returns the content of the named file.
+func ReadFile(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	return string(b), err
+}
+
+// -----------------------------------------------------------------------------
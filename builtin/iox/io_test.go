@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenClosedByIteration(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "x.txt")
+	if err := os.WriteFile(name, []byte("a,b\nc,d\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	lf, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := Lines(lf).Gop_Enum()
+	var lines []string
+	for {
+		_, line, ok := it.Next()
+		if !ok {
+			break
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[1] != "c,d" {
+		t.Fatal("Lines:", lines)
+	}
+	cf, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cit := CSV(cf).Gop_Enum()
+	for {
+		if _, _, ok := cit.Next(); !ok {
+			break
+		}
+	}
+	for _, f := range []*File{lf, cf} {
+		if _, err := f.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+			t.Fatal("file isn't closed:", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal("Close:", err)
+		}
+	}
+}
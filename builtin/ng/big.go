@@ -311,6 +311,12 @@ func Bigint_Cast__7() Bigint {
 	return Bigint{new(big.Int)}
 }
 
+// Bigint_Cast: func bigint(x bigfloat) bigint
+func Bigint_Cast__8(x Bigfloat) Bigint {
+	ret, _ := x.Int(nil)
+	return Bigint{ret}
+}
+
 // Bigint_Init: func bigint.init(x int) bigint
 func Bigint_Init__0(x int) Bigint {
 	return Bigint{big.NewInt(int64(x))}
@@ -487,6 +493,12 @@ func Bigrat_Cast__6(a, b int64) Bigrat {
 	return Bigrat{big.NewRat(a, b)}
 }
 
+// Bigrat_Cast: func bigrat(a bigfloat) bigrat
+func Bigrat_Cast__7(a Bigfloat) Bigrat {
+	ret, _ := a.Rat(nil)
+	return Bigrat{ret}
+}
+
 // Bigrat_Init: func bigrat.init(x untyped_int) bigrat
 func Bigrat_Init__0(x int) Bigrat {
 	return Bigrat{big.NewRat(int64(x), 1)}
@@ -505,10 +517,261 @@ func Bigrat_Init__2(x *big.Rat) Bigrat {
 // -----------------------------------------------------------------------------
 // type bigfloat
 
+// BigfloatPrec and BigfloatMode are the precision and rounding mode of the
+// bigfloat values created by conversions and initializations. A zero precision
+// means the precision math/big chooses for the source value (eg. 53 bits for a
+// float64).
+//
+// They are global settings of the process, which are read without any
+// synchronization: set them once at startup, before any goroutine creates
+// bigfloat values. Use WithPrec and WithMode to set the precision and rounding
+// mode of a single value.
+var (
+	BigfloatPrec uint
+	BigfloatMode big.RoundingMode
+)
+
 // A Bigfloat represents a multi-precision floating point number.
 // The zero value for a Float represents nil.
 type Bigfloat struct {
 	*big.Float
 }
 
+func newfloat() *big.Float {
+	return new(big.Float).SetPrec(BigfloatPrec).SetMode(BigfloatMode)
+}
+
+func tmpfloat(a, b Bigfloat) Bigfloat {
+	if Gop_istmp(a) {
+		return a
+	} else if Gop_istmp(b) {
+		return b
+	}
+	return Bigfloat{new(big.Float).SetMode(a.Mode())}
+}
+
+func tmpfloat1(a Bigfloat) Bigfloat {
+	if Gop_istmp(a) {
+		return a
+	}
+	return Bigfloat{new(big.Float).SetMode(a.Mode())}
+}
+
+// IsNil returns a bigfloat object is nil or not
+func (a Bigfloat) IsNil() bool {
+	return a.Float == nil
+}
+
+// WithPrec returns a copy of a rounded to prec bits, which is also the
+// precision of the results of operations on it.
+func (a Bigfloat) WithPrec(prec uint) Bigfloat {
+	return Bigfloat{new(big.Float).SetMode(a.Mode()).SetPrec(prec).Set(a.Float)}
+}
+
+// WithMode returns a copy of a that uses mode to round the results of
+// operations on it.
+func (a Bigfloat) WithMode(mode big.RoundingMode) Bigfloat {
+	return Bigfloat{new(big.Float).Copy(a.Float).SetMode(mode)}
+}
+
+// Gop_Assign: func (a bigfloat) = (b bigfloat)
+func (a Bigfloat) Gop_Assign(b Bigfloat) {
+	if Gop_istmp(b) {
+		*a.Float = *b.Float
+	} else {
+		a.Float.Set(b.Float)
+	}
+}
+
+// Gop_Add: func (a bigfloat) + (b bigfloat) bigfloat
+func (a Bigfloat) Gop_Add(b Bigfloat) Bigfloat {
+	return Bigfloat{tmpfloat(a, b).Add(a.Float, b.Float)}
+}
+
+// Gop_Sub: func (a bigfloat) - (b bigfloat) bigfloat
+func (a Bigfloat) Gop_Sub(b Bigfloat) Bigfloat {
+	return Bigfloat{tmpfloat(a, b).Sub(a.Float, b.Float)}
+}
+
+// Gop_Mul: func (a bigfloat) * (b bigfloat) bigfloat
+func (a Bigfloat) Gop_Mul(b Bigfloat) Bigfloat {
+	return Bigfloat{tmpfloat(a, b).Mul(a.Float, b.Float)}
+}
+
+// Gop_Quo: func (a bigfloat) / (b bigfloat) bigfloat
+func (a Bigfloat) Gop_Quo(b Bigfloat) Bigfloat {
+	return Bigfloat{tmpfloat(a, b).Quo(a.Float, b.Float)}
+}
+
+// Gop_LT: func (a bigfloat) < (b bigfloat) bool
+func (a Bigfloat) Gop_LT(b Bigfloat) bool {
+	return a.Cmp(b.Float) < 0
+}
+
+// Gop_LE: func (a bigfloat) <= (b bigfloat) bool
+func (a Bigfloat) Gop_LE(b Bigfloat) bool {
+	return a.Cmp(b.Float) <= 0
+}
+
+// Gop_GT: func (a bigfloat) > (b bigfloat) bool
+func (a Bigfloat) Gop_GT(b Bigfloat) bool {
+	return a.Cmp(b.Float) > 0
+}
+
+// Gop_GE: func (a bigfloat) >= (b bigfloat) bool
+func (a Bigfloat) Gop_GE(b Bigfloat) bool {
+	return a.Cmp(b.Float) >= 0
+}
+
+// Gop_EQ: func (a bigfloat) == (b bigfloat) bool
+func (a Bigfloat) Gop_EQ(b Bigfloat) bool {
+	return a.Cmp(b.Float) == 0
+}
+
+// Gop_NE: func (a bigfloat) != (b bigfloat) bool
+func (a Bigfloat) Gop_NE(b Bigfloat) bool {
+	return a.Cmp(b.Float) != 0
+}
+
+// Gop_Neg: func -(a bigfloat) bigfloat
+func (a Bigfloat) Gop_Neg() Bigfloat {
+	return Bigfloat{tmpfloat1(a).Neg(a.Float)}
+}
+
+// Gop_Dup: func +(a bigfloat) bigfloat
+func (a Bigfloat) Gop_Dup() Bigfloat {
+	return Bigfloat{new(big.Float).Copy(a.Float)}
+}
+
+// Gop_Inc: func ++(b bigfloat)
+func (a Bigfloat) Gop_Inc() {
+	a.Float.Add(a.Float, big.NewFloat(1))
+}
+
+// Gop_Dec: func --(b bigfloat)
+func (a Bigfloat) Gop_Dec() {
+	a.Float.Sub(a.Float, big.NewFloat(1))
+}
+
+// Gop_AddAssign: func (a bigfloat) += (b bigfloat)
+func (a Bigfloat) Gop_AddAssign(b Bigfloat) {
+	a.Float.Add(a.Float, b.Float)
+}
+
+// Gop_SubAssign: func (a bigfloat) -= (b bigfloat)
+func (a Bigfloat) Gop_SubAssign(b Bigfloat) {
+	a.Float.Sub(a.Float, b.Float)
+}
+
+// Gop_MulAssign: func (a bigfloat) *= (b bigfloat)
+func (a Bigfloat) Gop_MulAssign(b Bigfloat) {
+	a.Float.Mul(a.Float, b.Float)
+}
+
+// Gop_QuoAssign: func (a bigfloat) /= (b bigfloat)
+func (a Bigfloat) Gop_QuoAssign(b Bigfloat) {
+	a.Float.Quo(a.Float, b.Float)
+}
+
+// -----------------------------------------------------------------------------
+
+// Gop_Rcast: func float64(x bigfloat) float64
+func (a Bigfloat) Gop_Rcast__0() float64 {
+	ret, _ := a.Float64()
+	return ret
+}
+
+// Gop_Rcast: func float64(x bigfloat) (float64, bool)
+func (a Bigfloat) Gop_Rcast__1() (float64, bool) {
+	ret, acc := a.Float64()
+	return ret, acc == big.Exact
+}
+
+// Gop_Rcast: func int64(x bigfloat) int64
+func (a Bigfloat) Gop_Rcast__2() int64 {
+	ret, _ := a.Int64()
+	return ret
+}
+
+// Gop_Rcast: func int64(x bigfloat) (int64, bool)
+func (a Bigfloat) Gop_Rcast__3() (int64, bool) {
+	ret, acc := a.Int64()
+	return ret, acc == big.Exact
+}
+
+// Bigfloat_Cast: func bigfloat(x untyped_int) bigfloat
+func Bigfloat_Cast__0(x int) Bigfloat {
+	return Bigfloat{newfloat().SetInt64(int64(x))}
+}
+
+// Bigfloat_Cast: func bigfloat(x untyped_float) bigfloat
+func Bigfloat_Cast__1(x float64) Bigfloat {
+	return Bigfloat{newfloat().SetFloat64(x)}
+}
+
+// Bigfloat_Cast: func bigfloat(x untyped_bigint) bigfloat
+func Bigfloat_Cast__2(x UntypedBigint) Bigfloat {
+	return Bigfloat{newfloat().SetInt(x)}
+}
+
+// Bigfloat_Cast: func bigfloat(x untyped_bigrat) bigfloat
+func Bigfloat_Cast__3(x UntypedBigrat) Bigfloat {
+	return Bigfloat{newfloat().SetRat(x)}
+}
+
+// Bigfloat_Cast: func bigfloat(x bigint) bigfloat
+func Bigfloat_Cast__4(x Bigint) Bigfloat {
+	return Bigfloat{newfloat().SetInt(x.Int)}
+}
+
+// Bigfloat_Cast: func bigfloat(x bigrat) bigfloat
+func Bigfloat_Cast__5(x Bigrat) Bigfloat {
+	return Bigfloat{newfloat().SetRat(x.Rat)}
+}
+
+// Bigfloat_Cast: func bigfloat(x *big.Float) bigfloat
+func Bigfloat_Cast__6(x *big.Float) Bigfloat {
+	return Bigfloat{x}
+}
+
+// Bigfloat_Cast: func bigfloat(x int64) bigfloat
+func Bigfloat_Cast__7(x int64) Bigfloat {
+	return Bigfloat{newfloat().SetInt64(x)}
+}
+
+// Bigfloat_Cast: func bigfloat(x uint64) bigfloat
+func Bigfloat_Cast__8(x uint64) Bigfloat {
+	return Bigfloat{newfloat().SetUint64(x)}
+}
+
+// Bigfloat_Cast: func bigfloat() bigfloat
+func Bigfloat_Cast__9() Bigfloat {
+	return Bigfloat{newfloat()}
+}
+
+// Bigfloat_Init: func bigfloat.init(x untyped_int) bigfloat
+func Bigfloat_Init__0(x int) Bigfloat {
+	return Bigfloat{newfloat().SetInt64(int64(x))}
+}
+
+// Bigfloat_Init: func bigfloat.init(x untyped_float) bigfloat
+func Bigfloat_Init__1(x float64) Bigfloat {
+	return Bigfloat{newfloat().SetFloat64(x)}
+}
+
+// Bigfloat_Init: func bigfloat.init(x untyped_bigint) bigfloat
+func Bigfloat_Init__2(x UntypedBigint) Bigfloat {
+	return Bigfloat{newfloat().SetInt(x)}
+}
+
+// Bigfloat_Init: func bigfloat.init(x untyped_bigrat) bigfloat
+func Bigfloat_Init__3(x UntypedBigrat) Bigfloat {
+	return Bigfloat{newfloat().SetRat(x)}
+}
+
+// Bigfloat_Init: func bigfloat.init(x *big.Float) bigfloat
+func Bigfloat_Init__4(x *big.Float) Bigfloat {
+	return Bigfloat{x}
+}
+
 // -----------------------------------------------------------------------------
@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	goast "go/ast"
+	gotoken "go/token"
+	"go/types"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+	"github.com/goplus/gox"
+)
+
+// -----------------------------------------------------------------------------
+
+// An aggregate comprehension `sum(elt for x <- container, cond)` (or min, max,
+// avg, count) is compiled to a closure with a single loop:
+//
+//   func() (_gop_ret T) {
+//       var _gop_ok bool
+//       for _, x := range container {
+//           if cond {
+//               if _gop_v := elt; !_gop_ok {
+//                   _gop_ret, _gop_ok = _gop_v, true
+//               } else if _gop_v < _gop_ret { // min; _gop_ret < _gop_v for max
+//                   _gop_ret = _gop_v
+//               }
+//           }
+//       }
+//       return
+//   }()
+//
+// sum adds elt to _gop_ret by `_gop_ret += elt` for basic types. For other
+// types, like bigint, the first value initializes _gop_ret as above, and the
+// others are added by `_gop_ret = _gop_ret + _gop_v`. The result is the zero
+// value of T if there are no values.
+//
+// avg sums the values to _gop_sum and counts them by _gop_n. The result is a
+// float64 for basic types, and `_gop_sum / T(_gop_n)` otherwise. count returns
+// the number of values, as an int.
+
+const aggregateNames = "sum, min, max, avg or count"
+
+var aggregates = map[string]bool{
+	"sum": true, "min": true, "max": true, "avg": true, "count": true,
+}
+
+// aggregateInfo is the kinds of basic types that can be aggregated.
+var aggregateInfo = map[string]types.BasicInfo{
+	"sum": types.IsNumeric | types.IsString,
+	"min": types.IsOrdered,
+	"max": types.IsOrdered,
+	"avg": types.IsInteger | types.IsFloat,
+}
+
+// aggregateOf returns the comprehension of call v if v is an aggregate
+// comprehension.
+func aggregateOf(v *ast.CallExpr) (*ast.ComprehensionExpr, bool) {
+	if fn, ok := v.Fun.(*ast.Ident); ok && aggregates[fn.Name] && len(v.Args) == 1 {
+		if ce, ok := v.Args[0].(*ast.ComprehensionExpr); ok && ce.Tok == token.FOR {
+			return ce, true
+		}
+	}
+	return nil, false
+}
+
+func compileAggregateExpr(ctx *blockCtx, fn string, v *ast.ComprehensionExpr) {
+	pkg, cb := ctx.pkg, ctx.cb
+	cb.NewClosure(nil, nil, false).BodyStart(pkg)
+	scope := cb.Scope()
+	var decls []*types.Var // variables declared before the loops
+	newVar := func(name string, typ types.Type, decl bool) *types.Var {
+		o := types.NewVar(token.NoPos, pkg.Types, name, typ)
+		scope.Insert(o)
+		if decl {
+			decls = append(decls, o)
+		}
+		return o
+	}
+	end := compileForPhrases(ctx, v.Fors)
+	if fn == "count" {
+		ret := newVar("_gop_ret", types.Typ[types.Int], false)
+		cb.VarRef(ret).IncDec(gotoken.INC)
+		end()
+		endAggregate(ctx, ret, decls)
+		return
+	}
+
+	// the types of the variables are the type of the values, which is known
+	// after compiling them in the loops
+	compileExpr(ctx, v.Elt)
+	elt := cb.InternalStack().Pop()
+	typ := gox.Default(pkg, elt.Type)
+	t, basic := typ.Underlying().(*types.Basic)
+	if basic && t.Info()&aggregateInfo[fn] == 0 {
+		src, _ := ctx.LoadExpr(v.Elt)
+		panic(ctx.newCodeErrorf(v.Pos(), "invalid argument: cannot compute %s of %s (type %v)", fn, src, typ))
+	}
+	var ret *types.Var
+	switch fn {
+	case "avg":
+		sum, n := newVar("_gop_sum", typ, true), newVar("_gop_n", types.Typ[types.Int], true)
+		if basic { // _gop_sum += elt
+			cb.VarRef(sum)
+			cb.InternalStack().Push(elt)
+			cb.AssignOp(gotoken.ADD_ASSIGN, v.Elt)
+		} else {
+			compileAggregateElt(ctx, "sum", v.Elt, elt, sum, nil, func() {
+				cb.Val(n).Val(0).BinaryOp(gotoken.EQL)
+			})
+		}
+		cb.VarRef(n).IncDec(gotoken.INC)
+		end()
+		if basic {
+			ret = newVar("_gop_ret", types.Typ[types.Float64], false)
+		} else {
+			ret = newVar("_gop_ret", typ, false)
+		}
+		cb.If().Val(n).Val(0).BinaryOp(gotoken.GTR).Then()
+		cb.VarRef(ret)
+		if basic { // float64(_gop_sum) / float64(_gop_n)
+			cb.Typ(ret.Type()).Val(sum).CallWith(1, 0, v).Typ(ret.Type()).Val(n).Call(1)
+		} else { // _gop_sum / T(_gop_n)
+			cb.Val(sum).Typ(typ).Val(n).CallWith(1, 0, v)
+		}
+		cb.BinaryOp(gotoken.QUO, v).Assign(1)
+		cb.End()
+	default:
+		ret = newVar("_gop_ret", typ, false)
+		if basic && fn == "sum" { // _gop_ret += elt
+			cb.VarRef(ret)
+			cb.InternalStack().Push(elt)
+			cb.AssignOp(gotoken.ADD_ASSIGN, v.Elt)
+			end()
+			break
+		}
+		ok := newVar("_gop_ok", types.Typ[types.Bool], true)
+		compileAggregateElt(ctx, fn, v.Elt, elt, ret, ok, func() {
+			cb.Val(ok).UnaryOp(gotoken.NOT)
+		})
+		end()
+	}
+	endAggregate(ctx, ret, decls)
+}
+
+// endAggregate ends the closure of an aggregate comprehension and calls it.
+// The closure is started without results, as their types aren't known then,
+// so its result ret and the variables decls are declared afterwards.
+func endAggregate(ctx *blockCtx, ret *types.Var, decls []*types.Var) {
+	pkg, cb := ctx.pkg, ctx.cb
+	cb.Return(0).End()
+	fn := cb.InternalStack().Pop()
+	lit := fn.Val.(*goast.FuncLit)
+	lit.Type.Results = &goast.FieldList{List: []*goast.Field{
+		{Names: []*goast.Ident{goast.NewIdent(ret.Name())}, Type: gox.TypeAST(pkg, ret.Type())},
+	}}
+	stmts := make([]goast.Stmt, 0, len(decls)+len(lit.Body.List))
+	for _, o := range decls {
+		stmts = append(stmts, &goast.DeclStmt{Decl: &goast.GenDecl{Tok: gotoken.VAR, Specs: []goast.Spec{
+			&goast.ValueSpec{Names: []*goast.Ident{goast.NewIdent(o.Name())}, Type: gox.TypeAST(pkg, o.Type())},
+		}}})
+	}
+	lit.Body.List = append(stmts, lit.Body.List...)
+	fn.Type = types.NewSignature(nil, nil, types.NewTuple(ret), false)
+	cb.InternalStack().Push(fn)
+	cb.Call(0)
+}
+
+// compileAggregateElt compiles, with v the compiled elt:
+//
+//   if _gop_v := elt; first {
+//       acc, ok = _gop_v, true
+//   } else {
+//       ... // aggregate _gop_v to acc
+//   }
+func compileAggregateElt(ctx *blockCtx, fn string, elt ast.Expr, v *gox.Element, acc, ok types.Object, first func()) {
+	cb := ctx.cb
+	cb.If().DefineVarStart(elt.Pos(), "_gop_v")
+	cb.InternalStack().Push(v)
+	cb.EndInit(1)
+	val := cb.Scope().Lookup("_gop_v")
+	first()
+	cb.Then().VarRef(acc)
+	if ok != nil {
+		cb.VarRef(ok).Val(val).Val(true).Assign(2)
+	} else {
+		cb.Val(val).Assign(1)
+	}
+	switch fn {
+	case "sum": // acc = acc + _gop_v, as bigint values are pointers
+		cb.Else().VarRef(acc).Val(acc).Val(val).BinaryOp(gotoken.ADD, elt).Assign(1)
+	case "min":
+		cb.Else().If().Val(val).Val(acc).BinaryOp(gotoken.LSS, elt).Then()
+		cb.VarRef(acc).Val(val).Assign(1).End()
+	case "max":
+		cb.Else().If().Val(acc).Val(val).BinaryOp(gotoken.LSS, elt).Then()
+		cb.VarRef(acc).Val(val).Assign(1).End()
+	}
+	cb.End()
+}
+
+// -----------------------------------------------------------------------------
@@ -54,15 +54,108 @@ func initBuiltin(pkg *gox.Package, builtin *types.Package, fmt, ng, buil *gox.Pk
 	scope.Insert(types.NewTypeName(token.NoPos, builtin, "any", gox.TyEmptyInterface))
 }
 
+// initBuiltinIO inserts the builtins of files and readers:
+//
+//   open(name) (*os.File, error)     // opens a file for reading
+//   readFile(name) (string, error)   // the content of a file
+//   lines(r) *iox.LineReader         // lines of a reader in for phrases
+//   csv(r) *iox.CSVReader            // CSV records of a reader in for phrases
+func initBuiltinIO(builtin *types.Package, iox *gox.PkgRef) {
+	scope := builtin.Scope()
+	scope.Insert(gox.NewOverloadFunc(token.NoPos, builtin, "open", iox.Ref("Open")))
+	scope.Insert(gox.NewOverloadFunc(token.NoPos, builtin, "readFile", iox.Ref("ReadFile")))
+	scope.Insert(gox.NewOverloadFunc(token.NoPos, builtin, "lines", iox.Ref("Lines")))
+	scope.Insert(gox.NewOverloadFunc(token.NoPos, builtin, "csv", iox.Ref("CSV")))
+}
+
+// BuiltinMethods are methods of a builtin type, like `s.toUpper` which is
+// compiled to strings.ToUpper(s). A method is a function of package PkgPath,
+// whose first parameter is the receiver. Untyped constants have the methods
+// of their default types.
+type BuiltinMethods struct {
+	Type    types.Type        // the builtin type, eg. types.Typ[types.String]
+	PkgPath string            // the package of the functions, eg. "strings"
+	Methods map[string]string // names of functions by methods, eg. "toUpper": "ToUpper"
+}
+
+// builtinMethods are the default methods of builtin types, in addition to the
+// ones of gox, like len, int and split of string, and string of int.
+var builtinMethods = []*BuiltinMethods{
+	{Type: types.Typ[types.String], PkgPath: "strings", Methods: map[string]string{
+		"toUpper": "ToUpper", "toLower": "ToLower", "toTitle": "ToTitle",
+		"trimSpace": "TrimSpace", "trim": "Trim", "trimLeft": "TrimLeft", "trimRight": "TrimRight",
+		"trimPrefix": "TrimPrefix", "trimSuffix": "TrimSuffix",
+		"hasPrefix": "HasPrefix", "hasSuffix": "HasSuffix", "containsAny": "ContainsAny",
+		"index": "Index", "lastIndex": "LastIndex", "count": "Count", "equalFold": "EqualFold",
+		"splitN": "SplitN", "repeat": "Repeat", "replace": "Replace", "replaceAll": "ReplaceAll",
+	}},
+	{Type: types.Typ[types.String], PkgPath: "strconv", Methods: map[string]string{
+		"bool": "ParseBool",
+	}},
+	{Type: types.Typ[types.Bool], PkgPath: "strconv", Methods: map[string]string{
+		"string": "FormatBool",
+	}},
+	{Type: types.Typ[types.Float64], PkgPath: "math", Methods: map[string]string{
+		"abs": "Abs", "ceil": "Ceil", "floor": "Floor", "trunc": "Trunc", "sqrt": "Sqrt", "round": "Round",
+	}},
+	{Type: types.Typ[types.Float64], PkgPath: "github.com/goplus/gop/builtin", Methods: map[string]string{
+		"round": "RoundN",
+	}},
+	{Type: types.Typ[types.String], PkgPath: "github.com/goplus/gop/builtin/iox", Methods: map[string]string{
+		"csv": "SplitCSV",
+	}},
+}
+
+// withDefaultBuiltinMethods returns methods of builtin types ms followed by
+// the default ones.
+func withDefaultBuiltinMethods(ms []*BuiltinMethods) []*BuiltinMethods {
+	ret := make([]*BuiltinMethods, 0, len(ms)+len(builtinMethods))
+	return append(append(ret, ms...), builtinMethods...)
+}
+
+// builtinMethod returns the method name of builtin type typ, which is an
+// overloaded function if there are methods with the name in several
+// packages; or nil if there is no such method.
+func (p *blockCtx) builtinMethod(typ types.Type, name string) types.Object {
+	switch typ.(type) {
+	case *types.Basic:
+		typ = types.Default(typ)
+	case *types.Named:
+		return nil
+	}
+	key := typ.String() + "." + name
+	if o, ok := p.bmcache[key]; ok {
+		return o
+	}
+	var fns []types.Object
+	for _, ms := range p.bmethods {
+		if fn, ok := ms.Methods[name]; ok && types.Identical(ms.Type, typ) {
+			fns = append(fns, p.pkg.Import(ms.PkgPath).Ref(fn))
+		}
+	}
+	var o types.Object
+	switch len(fns) {
+	case 0:
+	case 1:
+		o = fns[0]
+	default:
+		o = gox.NewOverloadFunc(token.NoPos, p.pkg.Types, name, fns...)
+	}
+	p.bmcache[key] = o
+	return o
+}
+
 func newBuiltinDefault(pkg *gox.Package, conf *gox.Config) *types.Package {
 	builtin := types.NewPackage("", "")
 	fmt := pkg.Import("fmt")
 	buil := pkg.Import("github.com/goplus/gop/builtin")
 	ng := pkg.Import("github.com/goplus/gop/builtin/ng")
+	iox := pkg.Import("github.com/goplus/gop/builtin/iox")
 	pkg.Import("strconv")
 	pkg.Import("strings")
 	initMathBig(pkg, conf, ng)
 	initBuiltin(pkg, builtin, fmt, ng, buil)
+	initBuiltinIO(builtin, iox)
 	gox.InitBuiltin(pkg, builtin, conf)
 	return builtin
 }
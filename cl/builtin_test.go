@@ -19,6 +19,7 @@ package cl
 import (
 	"errors"
 	"go/types"
+	"strings"
 	"testing"
 
 	"github.com/goplus/gop/ast"
@@ -41,12 +42,16 @@ func getGoxConf() *gox.Config {
 
 func TestCompileErrWrapExpr(t *testing.T) {
 	defer func() {
-		if e := recover(); e != "TODO: can't use expr? in global" {
-			t.Fatal("TestCompileErrWrapExpr failed")
+		e, ok := recover().(*gox.CodeError)
+		if !ok || !strings.Contains(e.Msg, "can't use expr? in a package level initializer") {
+			t.Fatal("TestCompileErrWrapExpr failed:", e)
 		}
 	}()
 	pkg := gox.NewPackage("", "foo", goxConf)
-	ctx := &blockCtx{pkg: pkg, cb: pkg.CB()}
+	ctx := &blockCtx{
+		pkg: pkg, cb: pkg.CB(),
+		pkgCtx: &pkgCtx{nodeInterp: &nodeInterp{fset: token.NewFileSet()}},
+	}
 	compileErrWrapExpr(ctx, &ast.ErrWrapExpr{Tok: token.QUESTION})
 }
 
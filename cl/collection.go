@@ -0,0 +1,488 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	gotoken "go/token"
+	"go/types"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+	"github.com/goplus/gox"
+)
+
+// -----------------------------------------------------------------------------
+
+// Slices and maps have higher-order methods, which are inlined to closures
+// with loops. A function argument is either a lambda expression, whose body
+// is inlined with its parameters bound to the loop variables, or a function
+// value. For a slice a of type S ([]T) and a map m of type map[K]V:
+//
+//   a.map(x => expr)              // []U: expr of the values
+//   a.filter(x => cond)           // S: the values that cond is true for
+//   a.reduce((acc, x) => expr)    // T: the values combined by expr, from the first one
+//   a.reduce(init, (acc, x) => expr)
+//   a.sortBy(x => key)            // S: a copy of a stably sorted by key in ascending order
+//   a.contains(v)                 // bool: whether v is a value of a
+//   m.keys                        // []K: the keys in unspecified order
+//   m.values                      // []V: the values in unspecified order
+//
+// For example, a.map(x => x*2) is compiled to:
+//
+//   func(_gop_a []int) (_gop_ret []int) {
+//       for _, x := range _gop_a {
+//           _gop_ret = append(_gop_ret, x*2)
+//       }
+//       return
+//   }(a)
+
+// collectionMethod returns the compiler of higher-order method name of slice
+// or map type typ; or nil if there is no such method.
+func collectionMethod(ctx *blockCtx, typ types.Type, name string) func(ctx *blockCtx, a *hofCall) {
+	if _, ok := typ.(*gox.TypeType); ok {
+		return nil
+	}
+	switch getUnderlying(ctx, typ).(type) {
+	case *types.Slice:
+		switch name {
+		case "map":
+			return compileSliceMap
+		case "filter":
+			return compileSliceFilter
+		case "reduce":
+			return compileSliceReduce
+		case "sortBy":
+			return compileSliceSortBy
+		case "contains":
+			return compileSliceContains
+		}
+	case *types.Map:
+		switch name {
+		case "keys":
+			return compileMapKeys
+		case "values":
+			return compileMapValues
+		}
+	}
+	return nil
+}
+
+// hofCall is a call of a higher-order method, whose receiver is compiled.
+type hofCall struct {
+	recv *gox.Element
+	a    *types.Var // _gop_a, the receiver in the closure
+	sel  *ast.SelectorExpr
+	args []ast.Expr
+	src  ast.Node
+}
+
+// compileCollectionMethod compiles call v (or sel if it is called as an auto
+// property) of higher-order method fn, whose receiver is on the top of the
+// stack.
+func compileCollectionMethod(ctx *blockCtx, sel *ast.SelectorExpr, v *ast.CallExpr, fn func(ctx *blockCtx, a *hofCall)) {
+	a := &hofCall{recv: ctx.cb.InternalStack().Pop(), sel: sel, src: sel}
+	if v != nil {
+		a.args, a.src = v.Args, v
+	}
+	fn(ctx, a)
+}
+
+// checkArgs checks that there are n arguments, or nopt more optional ones.
+func (a *hofCall) checkArgs(ctx *blockCtx, n, nopt int) {
+	if got := len(a.args); got < n || got > n+nopt {
+		fewOrMany := "few"
+		if got > n {
+			fewOrMany = "many"
+		}
+		src, pos := ctx.LoadExpr(a.src)
+		panic(newCodeErrorf(&pos, "too %s arguments in call to %s", fewOrMany, src))
+	}
+}
+
+func (a *hofCall) elem(ctx *blockCtx) types.Type {
+	return getUnderlying(ctx, a.recv.Type).(*types.Slice).Elem()
+}
+
+// closure starts a closure with the receiver as its first parameter _gop_a,
+// followed by params.
+func (a *hofCall) closure(ctx *blockCtx, params []*types.Var, results ...*types.Var) {
+	pkg := ctx.pkg
+	a.a = pkg.NewParam(token.NoPos, "_gop_a", a.recv.Type)
+	params = append([]*types.Var{a.a}, params...)
+	ctx.cb.NewClosure(types.NewTuple(params...), types.NewTuple(results...), false).BodyStart(pkg)
+}
+
+// end ends the closure and calls it with the receiver, followed by args.
+func (a *hofCall) end(ctx *blockCtx, args ...*gox.Element) {
+	cb := ctx.cb
+	cb.End()
+	cb.InternalStack().Push(a.recv)
+	for _, arg := range args {
+		cb.InternalStack().Push(arg)
+	}
+	cb.CallWith(len(args)+1, 0, a.src)
+}
+
+// forRange starts `for key, val := range recv`, and returns the variables.
+func (a *hofCall) forRange(ctx *blockCtx, key, val string) (k, v types.Object) {
+	cb := ctx.cb
+	names := []string{key, val}
+	if val == "_" {
+		names = names[:1]
+	}
+	cb.ForRange(names...).Val(a.a).RangeAssignThen(token.NoPos)
+	return cb.Scope().Lookup(key), cb.Scope().Lookup(val)
+}
+
+// appendTo compiles `ret = append(ret, val)`.
+func appendTo(ctx *blockCtx, ret types.Object, val func()) {
+	cb := ctx.cb
+	cb.VarRef(ret).Val(ctx.pkg.Builtin().Ref("append")).Val(ret)
+	val()
+	cb.Call(2).Assign(1)
+}
+
+// -----------------------------------------------------------------------------
+
+// hofFunc is a function argument of a higher-order method.
+type hofFunc struct {
+	lambda *ast.LambdaExpr  // lambda expression to inline; or nil
+	fn     *gox.Element     // function value if it isn't a lambda
+	sig    *types.Signature // signature of fn
+	f      *types.Var       // _gop_f, the function if it isn't inlined
+}
+
+// newHofFunc compiles function argument arg of a with nparams parameters.
+func newHofFunc(ctx *blockCtx, a *hofCall, arg ast.Expr, nparams int) *hofFunc {
+	switch v := arg.(type) {
+	case *ast.LambdaExpr:
+		if len(v.Lhs) != nparams || len(v.Rhs) != 1 {
+			src, _ := ctx.LoadExpr(a.sel)
+			panic(ctx.newCodeErrorf(
+				v.Pos(), "cannot use lambda literal as argument of %s: want %d parameters and 1 result", src, nparams))
+		}
+		return &hofFunc{lambda: v}
+	case *ast.LambdaExpr2:
+		src, _ := ctx.LoadExpr(a.sel)
+		panic(ctx.newCodeErrorf(
+			v.Pos(), "cannot use lambda literal with statements as argument of %s, use a lambda expression", src))
+	}
+	compileExpr(ctx, arg)
+	fn := ctx.cb.InternalStack().Pop()
+	sig, ok := getUnderlying(ctx, fn.Type).(*types.Signature)
+	if !ok || sig.Params().Len() != nparams || sig.Results().Len() != 1 || sig.Variadic() {
+		src, pos := ctx.LoadExpr(arg)
+		msrc, _ := ctx.LoadExpr(a.sel)
+		panic(newCodeErrorf(&pos, "cannot use %s (type %v) as function argument of %s", src, fn.Type, msrc))
+	}
+	f := ctx.pkg.NewParam(token.NoPos, "_gop_f", fn.Type)
+	return &hofFunc{fn: fn, sig: sig, f: f}
+}
+
+// params returns the parameter _gop_f of the closure if f isn't a lambda.
+func (f *hofFunc) params() []*types.Var {
+	if f.fn == nil {
+		return nil
+	}
+	return []*types.Var{f.f}
+}
+
+// args returns the argument of parameter _gop_f if f isn't a lambda.
+func (f *hofFunc) args() []*gox.Element {
+	if f.fn == nil {
+		return nil
+	}
+	return []*gox.Element{f.fn}
+}
+
+// name returns the name of parameter i, or def if it isn't an inlined lambda
+// or the parameter is _.
+func (f *hofFunc) name(i int, def string) string {
+	if f.lambda != nil {
+		if name := f.lambda.Lhs[i].Name; name != "_" {
+			return name
+		}
+	}
+	return def
+}
+
+// resultType returns the result type of the function with parameters of
+// types params.
+func (f *hofFunc) resultType(ctx *blockCtx, params ...types.Type) types.Type {
+	if f.lambda == nil {
+		return f.sig.Results().At(0).Type()
+	}
+	pkg := ctx.pkg
+	vars := make([]*types.Var, len(params))
+	for i, t := range params {
+		vars[i] = pkg.NewParam(token.NoPos, f.lambda.Lhs[i].Name, t)
+	}
+	var typ types.Type
+	inferTypes(ctx, types.NewTuple(vars...), func() {
+		compileExpr(ctx, f.lambda.Rhs[0])
+		typ = ctx.cb.InternalStack().Pop().Type
+	})
+	return gox.Default(pkg, typ)
+}
+
+// declare declares `_gop_f := func(...) ... { return expr }` of type sig if f
+// is a lambda, which isn't inlined then.
+func (f *hofFunc) declare(ctx *blockCtx, sig *types.Signature) {
+	if f.lambda == nil {
+		return
+	}
+	cb := ctx.cb
+	cb.DefineVarStart(token.NoPos, "_gop_f")
+	compileLambdaExpr(ctx, f.lambda, sig)
+	cb.EndInit(1)
+	f.f = cb.Scope().Lookup("_gop_f").(*types.Var)
+}
+
+// call pushes the result of calling the function with args, which are the
+// parameters of an inlined lambda.
+func (f *hofFunc) call(ctx *blockCtx, args ...func()) {
+	cb := ctx.cb
+	if f.f == nil {
+		compileExpr(ctx, f.lambda.Rhs[0])
+		return
+	}
+	cb.Val(f.f)
+	for _, arg := range args {
+		arg()
+	}
+	cb.Call(len(args))
+}
+
+func valOf(ctx *blockCtx, o types.Object) func() {
+	return func() { ctx.cb.Val(o) }
+}
+
+// -----------------------------------------------------------------------------
+
+// a.map(x => expr)
+func compileSliceMap(ctx *blockCtx, a *hofCall) {
+	a.checkArgs(ctx, 1, 0)
+	pkg := ctx.pkg
+	f := newHofFunc(ctx, a, a.args[0], 1)
+	typ := types.NewSlice(f.resultType(ctx, a.elem(ctx)))
+	if dryResult(ctx, typ) {
+		return
+	}
+	ret := pkg.NewParam(token.NoPos, "_gop_ret", typ)
+	a.closure(ctx, f.params(), ret)
+	_, x := a.forRange(ctx, "_", f.name(0, "_gop_v"))
+	appendTo(ctx, ret, func() { f.call(ctx, valOf(ctx, x)) })
+	ctx.cb.End().Return(0)
+	a.end(ctx, f.args()...)
+}
+
+// a.filter(x => cond)
+func compileSliceFilter(ctx *blockCtx, a *hofCall) {
+	a.checkArgs(ctx, 1, 0)
+	pkg, cb := ctx.pkg, ctx.cb
+	f := newHofFunc(ctx, a, a.args[0], 1)
+	ret := pkg.NewParam(token.NoPos, "_gop_ret", a.recv.Type)
+	a.closure(ctx, f.params(), ret)
+	_, x := a.forRange(ctx, "_", f.name(0, "_gop_v"))
+	cb.If()
+	f.call(ctx, valOf(ctx, x))
+	cb.Then()
+	appendTo(ctx, ret, valOf(ctx, x))
+	cb.End().End().Return(0)
+	a.end(ctx, f.args()...)
+}
+
+// a.reduce((acc, x) => expr):
+//
+//   func(_gop_a S) (acc T) {
+//       for _gop_i, x := range _gop_a {
+//           if _gop_i == 0 {
+//               acc = x
+//           } else {
+//               acc = expr
+//           }
+//       }
+//       return
+//   }(a)
+//
+// a.reduce(init, (acc, x) => expr), whose result is of the type of init:
+//
+//   func(_gop_a S, _gop_init A) (acc A) {
+//       acc = _gop_init
+//       for _, x := range _gop_a {
+//           acc = expr
+//       }
+//       return
+//   }(a, init)
+func compileSliceReduce(ctx *blockCtx, a *hofCall) {
+	a.checkArgs(ctx, 1, 1)
+	pkg, cb := ctx.pkg, ctx.cb
+	var init *gox.Element
+	var initVar *types.Var
+	typ := a.elem(ctx)
+	if len(a.args) == 2 {
+		compileExpr(ctx, a.args[0])
+		init = cb.InternalStack().Pop()
+		typ = gox.Default(pkg, init.Type)
+		initVar = pkg.NewParam(token.NoPos, "_gop_init", typ)
+	}
+	f := newHofFunc(ctx, a, a.args[len(a.args)-1], 2)
+	ret := pkg.NewParam(token.NoPos, f.name(0, "_gop_ret"), typ)
+	params, args := f.params(), f.args()
+	if init != nil {
+		params, args = append(params, initVar), append(args, init)
+	}
+	a.closure(ctx, params, ret)
+	key := "_gop_i"
+	if init != nil {
+		cb.VarRef(ret).Val(initVar).Assign(1)
+		key = "_"
+	}
+	i, x := a.forRange(ctx, key, f.name(1, "_gop_v"))
+	if init == nil {
+		cb.If().Val(i).Val(0).BinaryOp(gotoken.EQL).Then()
+		cb.VarRef(ret).Val(x).Assign(1)
+		cb.Else()
+	}
+	cb.VarRef(ret)
+	f.call(ctx, valOf(ctx, ret), valOf(ctx, x))
+	cb.AssignWith(1, 1, a.src)
+	if init == nil {
+		cb.End()
+	}
+	cb.End().Return(0)
+	a.end(ctx, args...)
+}
+
+// a.sortBy(x => key):
+//
+//   func(_gop_a S) (_gop_ret S) {
+//       _gop_f := func(x T) K {
+//           return key
+//       }
+//       _gop_ret = append(_gop_ret, _gop_a...)
+//       sort.SliceStable(_gop_ret, func(i, j int) bool {
+//           return _gop_f(_gop_ret[i]) < _gop_f(_gop_ret[j])
+//       })
+//       return
+//   }(a)
+func compileSliceSortBy(ctx *blockCtx, a *hofCall) {
+	a.checkArgs(ctx, 1, 0)
+	pkg, cb := ctx.pkg, ctx.cb
+	f := newHofFunc(ctx, a, a.args[0], 1)
+	elem := a.elem(ctx)
+	key := f.resultType(ctx, elem)
+	if !isOrderedKey(key) {
+		panic(ctx.newCodeErrorf(a.args[0].Pos(), "invalid sortBy: keys of type %v are not ordered", key))
+	}
+	if dryResult(ctx, a.recv.Type) {
+		return
+	}
+	ret := pkg.NewParam(token.NoPos, "_gop_ret", a.recv.Type)
+	a.closure(ctx, f.params(), ret)
+	f.declare(ctx, types.NewSignature(nil,
+		types.NewTuple(pkg.NewParam(token.NoPos, "", elem)),
+		types.NewTuple(pkg.NewParam(token.NoPos, "", key)), false))
+	cb.VarRef(ret).Val(pkg.Builtin().Ref("append")).Val(ret).Val(a.a).
+		CallWith(2, gox.InstrFlagEllipsis).Assign(1)
+	i := pkg.NewParam(token.NoPos, "i", types.Typ[types.Int])
+	j := pkg.NewParam(token.NoPos, "j", types.Typ[types.Int])
+	less := pkg.NewParam(token.NoPos, "", types.Typ[types.Bool])
+	cb.Val(pkg.Import("sort").Ref("SliceStable")).Val(ret)
+	cb.NewClosure(types.NewTuple(i, j), types.NewTuple(less), false).BodyStart(pkg)
+	f.call(ctx, func() { cb.Val(ret).Val(i).Index(1, false) })
+	f.call(ctx, func() { cb.Val(ret).Val(j).Index(1, false) })
+	cb.BinaryOp(gotoken.LSS, a.args[0]).Return(1).End()
+	cb.Call(2).EndStmt()
+	cb.Return(0)
+	a.end(ctx, f.args()...)
+}
+
+// isOrderedKey reports whether keys of type t can be compared by <, which are
+// basic ordered values or of a named type that may have a method Gop_LT.
+func isOrderedKey(t types.Type) bool {
+	if b, ok := t.Underlying().(*types.Basic); ok {
+		return b.Info()&types.IsOrdered != 0
+	}
+	_, ok := t.(*types.Named)
+	return ok
+}
+
+// a.contains(v):
+//
+//   func(_gop_a S, _gop_x T) bool {
+//       for _, _gop_v := range _gop_a {
+//           if _gop_v == _gop_x {
+//               return true
+//           }
+//       }
+//       return false
+//   }(a, v)
+func compileSliceContains(ctx *blockCtx, a *hofCall) {
+	a.checkArgs(ctx, 1, 0)
+	pkg, cb := ctx.pkg, ctx.cb
+	compileExpr(ctx, a.args[0])
+	arg := cb.InternalStack().Pop()
+	elem := a.elem(ctx)
+	if !types.Comparable(elem) {
+		panic(ctx.newCodeErrorf(a.src.Pos(), "invalid contains: values of type %v are not comparable", elem))
+	}
+	x := pkg.NewParam(token.NoPos, "_gop_x", elem)
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.Bool])
+	a.closure(ctx, []*types.Var{x}, ret)
+	_, v := a.forRange(ctx, "_", "_gop_v")
+	cb.If().Val(v).Val(x).BinaryOp(gotoken.EQL, a.src).Then().Val(true).Return(1).End()
+	cb.End()
+	cb.Val(false).Return(1)
+	a.end(ctx, arg)
+}
+
+// m.keys
+func compileMapKeys(ctx *blockCtx, a *hofCall) {
+	a.checkArgs(ctx, 0, 0)
+	t := getUnderlying(ctx, a.recv.Type).(*types.Map)
+	compileMapList(ctx, a, t.Key(), "_gop_k", "_")
+}
+
+// m.values
+func compileMapValues(ctx *blockCtx, a *hofCall) {
+	a.checkArgs(ctx, 0, 0)
+	t := getUnderlying(ctx, a.recv.Type).(*types.Map)
+	compileMapList(ctx, a, t.Elem(), "_", "_gop_v")
+}
+
+// compileMapList compiles:
+//
+//   func(_gop_a M) (_gop_ret []T) {
+//       for k, v := range _gop_a {
+//           _gop_ret = append(_gop_ret, k) // or v
+//       }
+//       return
+//   }(m)
+func compileMapList(ctx *blockCtx, a *hofCall, typ types.Type, key, val string) {
+	ret := ctx.pkg.NewParam(token.NoPos, "_gop_ret", types.NewSlice(typ))
+	a.closure(ctx, nil, ret)
+	k, v := a.forRange(ctx, key, val)
+	if key == "_" {
+		k = v
+	}
+	appendTo(ctx, ret, valOf(ctx, k))
+	ctx.cb.End().Return(0)
+	a.end(ctx)
+}
+
+// -----------------------------------------------------------------------------
@@ -23,7 +23,9 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/goplus/gop/ast"
 	"github.com/goplus/gop/ast/fromgo"
@@ -98,6 +100,14 @@ type Config struct {
 
 	// NoSkipConstant = true means disable optimization of skip constants
 	NoSkipConstant bool
+
+	// OnWarning is called to report a warning, eg. a switch on an enum type
+	// that misses some of its values. Warnings are ignored if it is nil.
+	OnWarning func(err error)
+
+	// BuiltinMethods specifies methods of builtin types in addition to the
+	// default ones. Methods of a type with the same name are overloaded.
+	BuiltinMethods []*BuiltinMethods
 }
 
 type nodeInterp struct {
@@ -243,6 +253,26 @@ type pkgCtx struct {
 	inits []func()
 	tylds []*typeLoader
 	errs  errors.List
+
+	generics     map[string]*genericDecl
+	genlist      []*genericDecl
+	insts        map[interface{}][]*instance // instances by generics
+	instNames    map[string]bool             // names of the instances
+	typeInsts    map[*types.Named]*typeInstance
+	instRefs     map[string][]*types.Named // instances of generic types by names
+	placeholders map[*types.Named]bool     // placeholders of type parameters
+	genRefs      bool                      // the generated code refers to generics
+
+	overloads map[*ast.FuncDecl]*overloadFunc
+	enums     map[string]*enumType
+	fdecls    map[*ast.File][]ast.Decl // declarations of files with enum types or conversions
+
+	inferring int // depth of the dry runs of inferTypes
+
+	bmethods []*BuiltinMethods       // methods of builtin types
+	bmcache  map[string]types.Object // methods of builtin types by type and name
+
+	onWarning func(err error)
 }
 
 type blockCtx struct {
@@ -259,6 +289,9 @@ type blockCtx struct {
 	fileLine     bool
 	relativePath bool
 	isClass      bool
+
+	tparams   map[string]types.Type    // type arguments of the generic being instantiated
+	groupAggs map[*ast.CallExpr]func() // results of aggregates of the groupby being compiled
 }
 
 func (bc *blockCtx) findImport(name string) (pr *gox.PkgRef, ok bool) {
@@ -345,7 +378,13 @@ const (
 	testingGoFile  = "_test"
 )
 
-// NewPackage creates a Go+ package instance.
+// newPackageMutex serializes gox.NewPackage, which initializes the builtin
+// package in global variables.
+var newPackageMutex sync.Mutex
+
+// NewPackage creates a Go+ package instance. Packages can be compiled
+// concurrently, but not with the same conf.Importer, since gox updates the Go+
+// packages imported by a package when compiling it.
 func NewPackage(pkgPath string, pkg *ast.Package, conf *Config) (p *gox.Package, err error) {
 	workingDir := conf.WorkingDir
 	if workingDir == "" {
@@ -362,6 +401,11 @@ func NewPackage(pkgPath string, pkg *ast.Package, conf *Config) (p *gox.Package,
 	}
 	ctx := &pkgCtx{
 		syms: make(map[string]loader), nodeInterp: interp,
+		generics: make(map[string]*genericDecl), insts: make(map[interface{}][]*instance),
+		instNames: make(map[string]bool), typeInsts: make(map[*types.Named]*typeInstance),
+		instRefs: make(map[string][]*types.Named), placeholders: make(map[*types.Named]bool),
+		onWarning: conf.OnWarning,
+		bmethods:  withDefaultBuiltinMethods(conf.BuiltinMethods), bmcache: make(map[string]types.Object),
 	}
 	confGox := &gox.Config{
 		Fset:            fset,
@@ -373,20 +417,26 @@ func NewPackage(pkgPath string, pkg *ast.Package, conf *Config) (p *gox.Package,
 		DefaultGoFile:   defaultGoFile,
 		NoSkipConstant:  conf.NoSkipConstant,
 	}
+	newPackageMutex.Lock()
 	p = gox.NewPackage(pkgPath, pkg.Name, confGox)
+	newPackageMutex.Unlock()
 	ctx.cpkgs = cpackages.NewImporter(&cpackages.Config{
 		Pkg: p, LookupPub: conf.LookupPub,
 	})
-	for file, gmx := range files {
-		if gmx.IsProj {
-			ctx.gmxSettings = newGmx(ctx, p, file, conf)
+	fpaths := sortedFiles(files)
+	for _, fpath := range fpaths {
+		if gmx := files[fpath]; gmx.IsProj {
+			ctx.gmxSettings = newGmx(ctx, p, fpath, conf)
 			break
 		}
 	}
 	if ctx.errs != nil {
 		return nil, ctx.errs.ToError()
 	}
-	for fpath, f := range files {
+	collectEnums(ctx, files)
+	collectOverloads(ctx, files)
+	for _, fpath := range fpaths {
+		f := files[fpath]
 		fileLine := !conf.NoFileLine
 		ctx := &blockCtx{
 			pkg: p, pkgCtx: ctx, cb: p.CB(), fset: p.Fset, targetDir: targetDir,
@@ -395,32 +445,40 @@ func NewPackage(pkgPath string, pkg *ast.Package, conf *Config) (p *gox.Package,
 		}
 		preloadGopFile(p, ctx, fpath, f, conf)
 	}
-	for fpath, gof := range pkg.GoFiles {
-		f := fromgo.ASTFile(gof, 0)
+	gofpaths := make([]string, 0, len(pkg.GoFiles))
+	for fpath := range pkg.GoFiles {
+		gofpaths = append(gofpaths, fpath)
+	}
+	sort.Strings(gofpaths)
+	for _, fpath := range gofpaths {
+		f := fromgo.ASTFile(pkg.GoFiles[fpath], 0)
 		ctx := &blockCtx{
 			pkg: p, pkgCtx: ctx, cb: p.CB(), fset: p.Fset, targetDir: targetDir,
 			imports: make(map[string]*gox.PkgRef),
 		}
 		preloadFile(p, ctx, fpath, f, false)
 	}
-	for _, f := range files {
-		if f.IsProj {
+	checkGenerics(ctx)
+	declGopPackage(p, ctx)
+	for _, fpath := range fpaths {
+		if f := files[fpath]; f.IsProj {
 			loadFile(ctx, f)
 			gmxMainFunc(p, ctx)
 			break
 		}
 	}
-	for _, f := range files {
-		if !f.IsProj { // only one .gmx file
+	for _, fpath := range fpaths {
+		if f := files[fpath]; !f.IsProj { // only one .gmx file
 			loadFile(ctx, f)
 		}
 	}
 	for _, ld := range ctx.tylds {
 		ld.load()
 	}
-	for _, load := range ctx.inits {
-		load()
+	for i := 0; i < len(ctx.inits); i++ { // instantiating generics may add inits
+		ctx.inits[i]()
 	}
+	completeGenerics(ctx, p)
 	err = ctx.complete()
 
 	if !conf.NoAutoGenMain && pkg.Name == "main" {
@@ -433,6 +491,17 @@ func NewPackage(pkgPath string, pkg *ast.Package, conf *Config) (p *gox.Package,
 	return
 }
 
+// sortedFiles returns the paths of files in ascending order, which is the order
+// files are compiled in, so that the generated code is the same every time.
+func sortedFiles(files map[string]*ast.File) []string {
+	fpaths := make([]string, 0, len(files))
+	for fpath := range files {
+		fpaths = append(fpaths, fpath)
+	}
+	sort.Strings(fpaths)
+	return fpaths
+}
+
 func hasMethod(o types.Object, name string) bool {
 	if obj, ok := o.(*types.TypeName); ok {
 		if t, ok := obj.Type().(*types.Named); ok {
@@ -460,11 +529,18 @@ func getEntrypoint(f *ast.File, isMod bool) string {
 }
 
 func loadFile(ctx *pkgCtx, f *ast.File) {
-	for _, decl := range f.Decls {
+	for _, decl := range ctx.fileDecls(f) {
 		switch d := decl.(type) {
 		case *ast.FuncDecl:
-			if d.Recv == nil {
+			if d.Type.TypeParams != nil {
+				if d.Recv == nil {
+					loadGeneric(ctx, d.Name.Name)
+				}
+			} else if d.Recv == nil {
 				name := d.Name.Name
+				if g := ctx.overloadOf(d); g != nil {
+					name = g.name
+				}
 				if name != "init" {
 					ctx.loadSymbol(name)
 				}
@@ -477,7 +553,9 @@ func loadFile(ctx *pkgCtx, f *ast.File) {
 			switch d.Tok {
 			case token.TYPE:
 				for _, spec := range d.Specs {
-					ctx.loadType(spec.(*ast.TypeSpec).Name.Name)
+					name := spec.(*ast.TypeSpec).Name.Name
+					ctx.loadType(name)
+					loadGeneric(ctx, name)
 				}
 			case token.CONST, token.VAR:
 				for _, spec := range d.Specs {
@@ -609,7 +687,7 @@ func preloadFile(p *gox.Package, ctx *blockCtx, file string, f *ast.File, genCod
 	goFile := getGoFile(file, genCode)
 	old, _ := p.SetCurFile(goFile, true)
 	defer p.RestoreCurFile(old)
-	for _, decl := range f.Decls {
+	for _, decl := range parent.fileDecls(f) {
 		switch d := decl.(type) {
 		case *ast.FuncDecl:
 			if f.NoEntrypoint && d.Name.Name == "main" {
@@ -620,8 +698,15 @@ func preloadFile(p *gox.Package, ctx *blockCtx, file string, f *ast.File, genCod
 					d.Recv = ctx.classRecv
 				}
 			}
-			if d.Recv == nil {
-				var name = d.Name
+			if d.Type.TypeParams != nil {
+				if d.Recv != nil {
+					pos := ctx.Position(d.Type.TypeParams.Pos())
+					ctx.handleCodeErrorf(&pos, "methods cannot have type parameters")
+				} else {
+					preloadGeneric(ctx, goFile, d.Name, nil, nil, d)
+				}
+			} else if d.Recv == nil {
+				var name = d.Name.Name
 				var fn func()
 				if genCode {
 					fn = func() {
@@ -634,7 +719,7 @@ func preloadFile(p *gox.Package, ctx *blockCtx, file string, f *ast.File, genCod
 						declFunc(ctx, nil, d)
 					}
 				}
-				if name.Name == "init" {
+				if name == "init" {
 					if genCode {
 						if debugLoad {
 							log.Println("==> Preload func init")
@@ -642,11 +727,17 @@ func preloadFile(p *gox.Package, ctx *blockCtx, file string, f *ast.File, genCod
 						parent.inits = append(parent.inits, fn)
 					}
 				} else {
+					if g := parent.overloadOf(d); g != nil {
+						preloadOverloadFunc(ctx, g, genCode)
+						name = g.memberName(d)
+					}
 					if debugLoad {
-						log.Println("==> Preload func", name.Name)
+						log.Println("==> Preload func", name)
 					}
-					initLoader(parent, syms, name.Pos(), name.Name, fn, genCode)
+					initLoader(parent, syms, d.Name.Pos(), name, fn, genCode)
 				}
+			} else if name, _, ok := getGenericRecv(d.Recv); ok {
+				preloadGenericMethod(ctx, goFile, name, d)
 			} else {
 				if name, ok := getRecvTypeName(parent, d.Recv, true); ok {
 					if debugLoad {
@@ -670,6 +761,9 @@ func preloadFile(p *gox.Package, ctx *blockCtx, file string, f *ast.File, genCod
 						}
 					}
 					ld.methods = append(ld.methods, fn)
+					if g := parent.overloadOf(d); g != nil {
+						preloadOverloadMethod(ctx, g, ld, name)
+					}
 				}
 			}
 		case *ast.GenDecl:
@@ -681,6 +775,14 @@ func preloadFile(p *gox.Package, ctx *blockCtx, file string, f *ast.File, genCod
 			case token.TYPE:
 				for _, spec := range d.Specs {
 					t := spec.(*ast.TypeSpec)
+					if t.TypeParams != nil || isConstraintType(t.Type) {
+						doc := t.Doc
+						if doc == nil {
+							doc = d.Doc
+						}
+						preloadGeneric(ctx, goFile, t.Name, doc, t, nil)
+						continue
+					}
 					name := t.Name.Name
 					if debugLoad {
 						log.Println("==> Preload type", name)
@@ -752,6 +854,7 @@ func preloadFile(p *gox.Package, ctx *blockCtx, file string, f *ast.File, genCod
 								v := s.(*ast.ValueSpec)
 								removeNames(syms, v.Names)
 							}
+							checkEnumValues(ctx, d)
 						}
 					})
 				}
@@ -812,8 +915,11 @@ func declFunc(ctx *blockCtx, recv *types.Var, d *ast.FuncDecl) {
 	if name == "_" {
 		return
 	}
+	if g := ctx.overloadOf(d); g != nil {
+		name = g.memberName(d)
+	}
 	pkg := ctx.pkg.Types
-	sig := toFuncType(ctx, d.Type, recv)
+	sig := toSignature(ctx, d.Type, recv)
 	fn := types.NewFunc(d.Pos(), pkg, name, sig)
 	if recv != nil {
 		typ := recv.Type()
@@ -858,7 +964,10 @@ func loadFunc(ctx *blockCtx, recv *types.Var, d *ast.FuncDecl) {
 			}
 		}
 	}
-	sig := toFuncType(ctx, d.Type, recv)
+	if g := ctx.overloadOf(d); g != nil {
+		name = g.memberName(d)
+	}
+	sig := toSignature(ctx, d.Type, recv)
 	fn, err := ctx.pkg.NewFuncWith(d.Pos(), name, sig, func() token.Pos {
 		return d.Recv.List[0].Type.Pos()
 	})
@@ -866,6 +975,7 @@ func loadFunc(ctx *blockCtx, recv *types.Var, d *ast.FuncDecl) {
 		ctx.handleErr(err)
 		return
 	}
+	declDefaults(ctx, recv, name, d)
 	if d.Doc != nil {
 		fn.SetComments(d.Doc)
 	}
@@ -932,6 +1042,7 @@ var unaryGopNames = map[string]string{
 
 func loadFuncBody(ctx *blockCtx, fn *gox.Func, body *ast.BlockStmt) {
 	cb := fn.BodyStart(ctx.pkg)
+	declTypeParams(ctx)
 	compileStmts(ctx, body.List)
 	cb.End()
 }
@@ -261,6 +261,38 @@ func (this *bar) onInit() {
 `, "Game.tgmx", "bar.tspx")
 }
 
+func TestSpxPropertySetter(t *testing.T) {
+	gopSpxTestEx(t, `
+func onInit() {
+}
+`, `
+func onInit() {
+	costume = "kai-a"
+	this.costume = "kai-b"
+}
+`, `package main
+
+import spx "github.com/goplus/gop/cl/internal/spx"
+
+type Game struct {
+	*spx.MyGame
+}
+
+func (this *Game) onInit() {
+}
+
+type bar struct {
+	spx.Sprite
+	*Game
+}
+
+func (this *bar) onInit() {
+	this.SetCostume("kai-a")
+	this.SetCostume("kai-b")
+}
+`, "Game.tgmx", "bar.tspx")
+}
+
 func TestSpxVar(t *testing.T) {
 	gopSpxTestEx(t, `
 var (
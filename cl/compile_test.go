@@ -18,7 +18,9 @@ package cl_test
 
 import (
 	"bytes"
+	"go/types"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -819,7 +821,10 @@ func main() {
 	println(a, b)
 }`, `package main
 
-import fmt "fmt"
+import (
+	fmt "fmt"
+	errors "github.com/qiniu/x/errors"
+)
 
 func t() (int, int, error) {
 	return 0, 0, nil
@@ -829,6 +834,7 @@ func main() {
 		var _gop_err error
 		_gop_ret, _gop_ret2, _gop_err = t()
 		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "t()!", "/foo/bar.gop", 9, "main.main")
 			panic(_gop_err)
 		}
 		return
@@ -850,6 +856,8 @@ func main() {
 	t()!
 }`, `package main
 
+import errors "github.com/qiniu/x/errors"
+
 func t() error {
 	return nil
 }
@@ -858,6 +866,7 @@ func main() {
 		var _gop_err error
 		_gop_err = t()
 		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "t()!", "/foo/bar.gop", 9, "main.main")
 			panic(_gop_err)
 		}
 		return
@@ -1361,6 +1370,7 @@ func foo(script string) {
 import (
 	fmt "fmt"
 	goptest "github.com/goplus/gop/ast/goptest"
+	errors "github.com/qiniu/x/errors"
 	gopq "github.com/goplus/gop/ast/gopq"
 )
 
@@ -1369,6 +1379,7 @@ func foo(script string) {
 		var _gop_err error
 		_gop_ret, _gop_err = goptest.New(script)
 		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "goptest.New(script)!", "/foo/bar.gop", 4, "main.foo", script)
 			panic(_gop_err)
 		}
 		return
@@ -1393,6 +1404,7 @@ func foo(script string) {
 import (
 	fmt "fmt"
 	goptest "github.com/goplus/gop/ast/goptest"
+	errors "github.com/qiniu/x/errors"
 	gopq "github.com/goplus/gop/ast/gopq"
 )
 
@@ -1401,6 +1413,7 @@ func foo(script string) {
 		var _gop_err error
 		_gop_ret, _gop_err = goptest.New(script)
 		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "goptest.New(script)!", "/foo/bar.gop", 4, "main.foo", script)
 			panic(_gop_err)
 		}
 		return
@@ -1411,6 +1424,68 @@ func foo(script string) {
 `)
 }
 
+func TestPropertySetter(t *testing.T) {
+	gopClTest(t, `
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Count() int {
+	return c.n
+}
+
+func (c *Counter) SetCount(v int) {
+	c.n = v
+}
+
+func (c *Counter) SetName(v string) {
+}
+
+func newCounter() *Counter {
+	return &Counter{}
+}
+
+c := &Counter{}
+c.count = 1
+c.count += 2
+c.count++
+c.name = "x"
+newCounter().count *= 2
+println c.count
+`, `package main
+
+import fmt "fmt"
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Count() int {
+	return c.n
+}
+func (c *Counter) SetCount(v int) {
+	c.n = v
+}
+func (c *Counter) SetName(v string) {
+}
+func newCounter() *Counter {
+	return &Counter{}
+}
+func main() {
+	c := &Counter{}
+	c.SetCount(1)
+	c.SetCount(c.Count() + 2)
+	c.SetCount(c.Count() + 1)
+	c.SetName("x")
+	{
+		_gop_recv := newCounter()
+		_gop_recv.SetCount(_gop_recv.Count() * 2)
+	}
+	fmt.Println(c.Count())
+}
+`)
+}
+
 func TestErrWrap(t *testing.T) {
 	gopClTest(t, `
 import "strconv"
@@ -1420,7 +1495,10 @@ func add(x, y string) (int, error) {
 }
 `, `package main
 
-import strconv "strconv"
+import (
+	strconv "strconv"
+	errors "github.com/qiniu/x/errors"
+)
 
 func add(x string, y string) (int, error) {
 	var _autoGo_1 int
@@ -1428,6 +1506,7 @@ func add(x string, y string) (int, error) {
 		var _gop_err error
 		_autoGo_1, _gop_err = strconv.Atoi(x)
 		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "strconv.Atoi(x)?", "/foo/bar.gop", 5, "main.add", x, y)
 			return 0, _gop_err
 		}
 		goto _autoGo_2
@@ -1438,6 +1517,7 @@ func add(x string, y string) (int, error) {
 		var _gop_err error
 		_autoGo_3, _gop_err = strconv.Atoi(y)
 		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "strconv.Atoi(y)?", "/foo/bar.gop", 5, "main.add", x, y)
 			return 0, _gop_err
 		}
 		goto _autoGo_4
@@ -1448,6 +1528,90 @@ func add(x string, y string) (int, error) {
 `)
 }
 
+func TestErrWrapMsg(t *testing.T) {
+	gopClTest(t, `
+import "os"
+
+type T struct{}
+
+func (p *T) load(name string) ([]byte, error) {
+	b := os.ReadFile(name)? "reading ${name}"
+	return b, nil
+}
+`, `package main
+
+import (
+	os "os"
+	errors "github.com/qiniu/x/errors"
+)
+
+type T struct {
+}
+
+func (p *T) load(name string) ([]byte, error) {
+	var _autoGo_1 []byte
+	{
+		var _gop_err error
+		_autoGo_1, _gop_err = os.ReadFile(name)
+		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "os.ReadFile(name)?: "+("reading "+name), "/foo/bar.gop", 7, "main.(*T).load", name)
+			return nil, _gop_err
+		}
+		goto _autoGo_2
+	_autoGo_2:
+	}
+	b := _autoGo_1
+	return b, nil
+}
+`)
+}
+
+func TestErrWrapMain(t *testing.T) {
+	gopClTest(t, `
+import "strconv"
+
+var y = strconv.Atoi("2")!
+
+x := strconv.Atoi("1")?
+println x, y
+`, `package main
+
+import (
+	fmt "fmt"
+	strconv "strconv"
+	errors "github.com/qiniu/x/errors"
+	os "os"
+)
+
+var y = func() (_gop_ret int) {
+	var _gop_err error
+	_gop_ret, _gop_err = strconv.Atoi("2")
+	if _gop_err != nil {
+		_gop_err = errors.NewFrame(_gop_err, "strconv.Atoi(\"2\")!", "/foo/bar.gop", 4, "main.init")
+		panic(_gop_err)
+	}
+	return
+}()
+
+func main() {
+	var _autoGo_1 int
+	{
+		var _gop_err error
+		_autoGo_1, _gop_err = strconv.Atoi("1")
+		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "strconv.Atoi(\"1\")?", "/foo/bar.gop", 6, "main.main")
+			fmt.Fprintln(os.Stderr, _gop_err)
+			os.Exit(1)
+		}
+		goto _autoGo_2
+	_autoGo_2:
+	}
+	x := _autoGo_1
+	fmt.Println(x, y)
+}
+`)
+}
+
 func TestErrWrapDefVal(t *testing.T) {
 	gopClTest(t, `
 import "strconv"
@@ -1484,12 +1648,16 @@ func TestErrWrapPanic(t *testing.T) {
 var ret int = println("Hi")!
 `, `package main
 
-import fmt "fmt"
+import (
+	fmt "fmt"
+	errors "github.com/qiniu/x/errors"
+)
 
 var ret int = func() (_gop_ret int) {
 	var _gop_err error
 	_gop_ret, _gop_err = fmt.Println("Hi")
 	if _gop_err != nil {
+		_gop_err = errors.NewFrame(_gop_err, "println(\"Hi\")!", "/foo/bar.gop", 2, "main.init")
 		panic(_gop_err)
 	}
 	return
@@ -1624,6 +1792,28 @@ var x = ng.Bigrat_Init__2(big.NewRat(7, 2))
 `)
 }
 
+func TestBigRatDecimalLit(t *testing.T) {
+	gopClTest(t, `
+var a = 1.5r
+var b = 0.1r + 0.2r
+var c = 0x1Fr + 0o17r + 0b101r + 1_000r
+var d = 1e3r
+var e = 0x1p-2r
+`, `package main
+
+import (
+	ng "github.com/goplus/gop/builtin/ng"
+	big "math/big"
+)
+
+var a = ng.Bigrat_Init__2(big.NewRat(3, 2))
+var b = ng.Bigrat_Init__2(big.NewRat(3, 10))
+var c = ng.Bigint_Init__1(big.NewInt(1051))
+var d = ng.Bigint_Init__1(big.NewInt(1000))
+var e = ng.Bigrat_Init__2(big.NewRat(1, 4))
+`)
+}
+
 func TestBigRatAdd(t *testing.T) {
 	gox.SetDebug(gox.DbgFlagAll)
 	gopClTest(t, `
@@ -1643,6 +1833,92 @@ var z = ng.Bigrat_Init__0(100) + y
 `)
 }
 
+func TestBigFloatOps(t *testing.T) {
+	gopClTest(t, `
+var a bigfloat = 1.5
+var b = a + a*2
+var c = -b / bigfloat(3)
+var d = a < b
+var e = float64(c)
+var f = bigint(b)
+var g = bigrat(c)
+var h = bigfloat(1/3r).WithPrec(200)
+`, `package main
+
+import (
+	ng "github.com/goplus/gop/builtin/ng"
+	big "math/big"
+)
+
+var a ng.Bigfloat = ng.Bigfloat_Init__1(1.5)
+var b = a.Gop_Add(a.Gop_Mul(ng.Bigfloat_Init__0(2)))
+var c = b.Gop_Neg().Gop_Quo(ng.Bigfloat_Cast__0(3))
+var d = a.Gop_LT(b)
+var e = c.Gop_Rcast__0()
+var f = ng.Bigint_Cast__8(b)
+var g = ng.Bigrat_Cast__7(c)
+var h = ng.Bigfloat_Cast__3(big.NewRat(1, 3)).WithPrec(200)
+`)
+}
+
+func TestBigFloatAssignOp(t *testing.T) {
+	gopClTest(t, `
+var a bigfloat
+a = bigfloat(2)
+a += bigfloat(1.5)
+a *= bigfloat(2)
+a++
+`, `package main
+
+import ng "github.com/goplus/gop/builtin/ng"
+
+var a ng.Bigfloat
+
+func main() {
+	a = ng.Bigfloat_Cast__0(2)
+	a.Gop_AddAssign(ng.Bigfloat_Cast__1(1.5))
+	a.Gop_MulAssign(ng.Bigfloat_Cast__0(2))
+	a.Gop_Inc()
+}
+`)
+}
+
+func TestStringLitEx(t *testing.T) {
+	gopClTest(t, `
+type T struct{}
+
+func (T) String() string { return "T" }
+
+name := "Ken"
+n := 3
+var u uint8 = 2
+var e error
+println "Hello ${name}, you have ${n+1} items"
+println "${u} ${1.5} ${true} ${T{}} ${e} $${name} ${"[${name}]"}"
+`, `package main
+
+import (
+	fmt "fmt"
+	strconv "strconv"
+)
+
+type T struct {
+}
+
+func (T) String() string {
+	return "T"
+}
+func main() {
+	name := "Ken"
+	n := 3
+	var u uint8 = 2
+	var e error
+	fmt.Println("Hello " + name + ", you have " + strconv.Itoa(n+1) + " items")
+	fmt.Println(strconv.FormatUint(uint64(u), 10) + " " + strconv.FormatFloat(1.5, 'g', -1, 64) + " " + strconv.FormatBool(true) + " " + T{}.String() + " " + fmt.Sprint(e) + " ${name} " + ("[" + name + "]"))
+}
+`)
+}
+
 func TestTypeConv(t *testing.T) {
 	gopClTest(t, `
 var a = (*struct{})(nil)
@@ -2792,127 +3068,491 @@ var e = a.Gop_NE(b)
 `)
 }
 
-func TestCmdlineNoEOL(t *testing.T) {
-	gopClTest(t, `println "Hi"`, `package main
-
-import fmt "fmt"
-
-func main() {
-	fmt.Println("Hi")
+func TestOverloadFunc(t *testing.T) {
+	gopClTest(t, `
+type Vec struct {
+	X, Y float64
 }
-`)
+
+func (a Vec) * (b Vec) float64 {
+	return a.X*b.X + a.Y*b.Y
 }
 
-func TestImport(t *testing.T) {
-	gopClTest(t, `import "fmt"
+func (a Vec) * (k float64) Vec {
+	return Vec{a.X * k, a.Y * k}
+}
 
-func main() {
-	fmt.println "Hi"
-}`, `package main
+func (v Vec) Scale(k float64) Vec {
+	return v * k
+}
 
-import fmt "fmt"
+func (v Vec) Scale(kx, ky float64) Vec {
+	return Vec{v.X * kx, v.Y * ky}
+}
 
-func main() {
-	fmt.Println("Hi")
+func add(a, b int) int {
+	return a + b
 }
-`)
+
+func add(a, b string) string {
+	return a + b
 }
 
-func TestDotImport(t *testing.T) {
-	gopClTest(t, `import . "math"
+func add(a, b float64) float64 {
+	return a + b
+}
 
-var a = round(1.2)
+v := Vec{1, 2}
+println add(1, 2), add("a", "b"), add(1.5, 2), v*v, v*2, v.Scale(2), v.Scale(1, 2)
 `, `package main
 
-import math "math"
-
-var a = math.Round(1.2)
-`)
-}
-
-func TestLocalImport(t *testing.T) {
-	gopClTest(t, `import "./internal/spx"
+import fmt "fmt"
 
-var a = spx.TestIntValue
-`, `package main
+const GopPackage = true
 
-import spx "github.com/goplus/gop/cl/internal/spx"
+type Vec struct {
+	X float64
+	Y float64
+}
 
-var a = spx.TestIntValue
+func (a Vec) Gop_Mul__0(b Vec) float64 {
+	return a.X*b.X + a.Y*b.Y
+}
+func (a Vec) Gop_Mul__1(k float64) Vec {
+	return Vec{a.X * k, a.Y * k}
+}
+func (v Vec) Scale__0(k float64) Vec {
+	return v.Gop_Mul__1(k)
+}
+func (v Vec) Scale__1(kx float64, ky float64) Vec {
+	return Vec{v.X * kx, v.Y * ky}
+}
+func add__0(a int, b int) int {
+	return a + b
+}
+func add__1(a string, b string) string {
+	return a + b
+}
+func add__2(a float64, b float64) float64 {
+	return a + b
+}
+func main() {
+	v := Vec{1, 2}
+	fmt.Println(add__0(1, 2), add__1("a", "b"), add__2(1.5, 2), v.Gop_Mul__0(v), v.Gop_Mul__1(2), v.Scale__0(2), v.Scale__1(1, 2))
+}
 `)
 }
 
-func TestImportUnused(t *testing.T) {
-	gopClTest(t, `import "fmt"
+func TestOverloadFuncExact(t *testing.T) {
+	gopClTest(t, `
+type Value interface{}
 
-func main() {
-}`, `package main
+func show(v Value) {
+	println "any:", v
+}
 
-func main() {
+func show(v string) {
+	println "string:", v
 }
-`)
+
+func show(v ...int) {
+	println "ints:", v
 }
 
-func TestImportForceUsed(t *testing.T) {
-	gopClTest(t, `import _ "fmt"
+show "hi"
+show 1.5
+show 1, 2
+show
+`, `package main
 
-func main() {
-}`, `package main
+import fmt "fmt"
 
-import _ "fmt"
+const GopPackage = true
 
+type Value interface {
+}
+
+func show__0(v Value) {
+	fmt.Println("any:", v)
+}
+func show__1(v string) {
+	fmt.Println("string:", v)
+}
+func show__2(v ...int) {
+	fmt.Println("ints:", v)
+}
 func main() {
+	show__1("hi")
+	show__0(1.5)
+	show__2(1, 2)
+	show__2()
 }
 `)
 }
 
-func TestAnonymousImport(t *testing.T) {
-	gopClTest(t, `println("Hello")
-printf("Hello Go+\n")
+func TestOverloadImported(t *testing.T) {
+	gopClTest(t, `import "github.com/goplus/gop/cl/internal/spx"
+
+println spx.Rand(1), spx.Rand(1.5)
 `, `package main
 
-import fmt "fmt"
+import (
+	fmt "fmt"
+	spx "github.com/goplus/gop/cl/internal/spx"
+)
 
 func main() {
-	fmt.Println("Hello")
-	fmt.Printf("Hello Go+\n")
+	fmt.Println(spx.Rand__0(1), spx.Rand__1(1.5))
 }
 `)
 }
 
-func TestVarAndConst(t *testing.T) {
+func TestOverloadIndexOp(t *testing.T) {
 	gopClTest(t, `
-const (
-	i = 1
-	x float64 = 1
-)
-var j int = i
-`, `package main
+type Matrix struct {
+	rows, cols int
+	data       []float64
+}
 
-const (
-	i         = 1
-	x float64 = 1
-)
+func (m *Matrix) Gop_Index(i, j int) float64 {
+	return m.data[i*m.cols+j]
+}
 
-var j int = i
-`)
+func (m *Matrix) Gop_SetIndex(i, j int, v float64) {
+	m.data[i*m.cols+j] = v
 }
 
-func TestDeclStmt(t *testing.T) {
-	gopClTest(t, `import "fmt"
+type Vec []float64
 
-func main() {
-	const (
-		i = 1
-		x float64 = 1
-	)
-	var j int = i
-	fmt.Println("Hi")
-}`, `package main
+type Sparse struct {
+	m map[int]float64
+}
 
-import fmt "fmt"
+func (v Sparse) Gop_Index(i int) float64 {
+	return v.m[i]
+}
 
-func main() {
+func (v Sparse) Gop_SetIndex(i int, x float64) {
+	v.m[i] = x
+}
+
+func (v Sparse) Gop_Slice(low int) Sparse {
+	return v
+}
+
+func (v Sparse) Gop_Slice(low, high int) Sparse {
+	return v
+}
+
+func newMatrix() *Matrix {
+	return &Matrix{2, 2, make([]float64, 4)}
+}
+
+func next() int {
+	return 1
+}
+
+m := newMatrix()
+m[0, 1] = 2
+m[1, 1] += 3
+m[1, next()] *= 2
+newMatrix()[0, 0]++
+println m[0, 1], m[1, 1]
+s := Sparse{m: make(map[int]float64)}
+s[1] = 2
+s[2]++
+println s[1], s[1:], s[:3], s[1:2]
+var v Vec = [1, 2]
+v[0] = 3
+println v[1:]
+`, `package main
+
+import fmt "fmt"
+
+const GopPackage = true
+
+type Matrix struct {
+	rows int
+	cols int
+	data []float64
+}
+
+func (m *Matrix) Gop_Index(i int, j int) float64 {
+	return m.data[i*m.cols+j]
+}
+func (m *Matrix) Gop_SetIndex(i int, j int, v float64) {
+	m.data[i*m.cols+j] = v
+}
+
+type Vec []float64
+type Sparse struct {
+	m map[int]float64
+}
+
+func (v Sparse) Gop_Index(i int) float64 {
+	return v.m[i]
+}
+func (v Sparse) Gop_SetIndex(i int, x float64) {
+	v.m[i] = x
+}
+func (v Sparse) Gop_Slice__0(low int) Sparse {
+	return v
+}
+func (v Sparse) Gop_Slice__1(low int, high int) Sparse {
+	return v
+}
+func newMatrix() *Matrix {
+	return &Matrix{2, 2, make([]float64, 4)}
+}
+func next() int {
+	return 1
+}
+func main() {
+	m := newMatrix()
+	m.Gop_SetIndex(0, 1, 2)
+	m.Gop_SetIndex(1, 1, m.Gop_Index(1, 1)+3)
+	{
+		_gop_idx2 := next()
+		m.Gop_SetIndex(1, _gop_idx2, m.Gop_Index(1, _gop_idx2)*2)
+	}
+	{
+		_gop_recv := newMatrix()
+		_gop_recv.Gop_SetIndex(0, 0, _gop_recv.Gop_Index(0, 0)+1)
+	}
+	fmt.Println(m.Gop_Index(0, 1), m.Gop_Index(1, 1))
+	s := Sparse{m: make(map[int]float64)}
+	s.Gop_SetIndex(1, 2)
+	s.Gop_SetIndex(2, s.Gop_Index(2)+1)
+	fmt.Println(s.Gop_Index(1), s.Gop_Slice__0(1), s.Gop_Slice__1(0, 3), s.Gop_Slice__1(1, 2))
+	var v Vec = Vec{1, 2}
+	v[0] = 3
+	fmt.Println(v[1:])
+}
+`)
+}
+
+func TestConversionOverload(t *testing.T) {
+	gopClTest(t, `
+type MyMoney struct {
+	r bigrat
+}
+
+func MyMoney(r bigrat) MyMoney {
+	return MyMoney{r}
+}
+
+func MyMoney(cents int) MyMoney {
+	return MyMoney{bigrat(cents) / 100}
+}
+
+func float64(m MyMoney) float64 {
+	f, _ := m.r.Float64()
+	return f
+}
+
+func string(m MyMoney) string {
+	return m.r.FloatString(2)
+}
+
+m := MyMoney(3r)
+n := MyMoney(250)
+println float64(m), string(n)
+`, `package main
+
+import (
+	fmt "fmt"
+	ng "github.com/goplus/gop/builtin/ng"
+	big "math/big"
+)
+
+const GopPackage = true
+
+type MyMoney struct {
+	r ng.Bigrat
+}
+
+func (m MyMoney) Gop_Rcast__0() float64 {
+	f, _ := m.r.Float64()
+	return f
+}
+func (m MyMoney) Gop_Rcast__1() string {
+	return m.r.FloatString(2)
+}
+func MyMoney_Cast__0(r ng.Bigrat) MyMoney {
+	return MyMoney{r}
+}
+func MyMoney_Cast__1(cents int) MyMoney {
+	return MyMoney{ng.Bigrat_Cast__0(cents).Gop_Quo(ng.Bigrat_Init__0(100))}
+}
+func main() {
+	m := MyMoney_Cast__0(ng.Bigrat_Init__1(big.NewInt(3)))
+	n := MyMoney_Cast__1(250)
+	fmt.Println(m.Gop_Rcast__0(), n.Gop_Rcast__1())
+}
+`)
+}
+
+func TestCompileTwice(t *testing.T) {
+	fs := parsertest.NewSingleFileFS("/foo", "bar.gop", `
+type MyMoney struct {
+	cents int
+}
+
+func MyMoney(cents int) MyMoney {
+	return MyMoney{cents}
+}
+
+func float64(m MyMoney) float64 {
+	return float64(m.cents) / 100
+}
+
+println float64(MyMoney(250))
+`)
+	pkgs, err := parser.ParseFSDir(gblFset, fs, "/foo", parser.Config{})
+	if err != nil {
+		t.Fatal("ParseFSDir:", err)
+	}
+	var rets [2]string
+	for i := range rets {
+		pkg, err := cl.NewPackage("", pkgs["main"], gblConf)
+		if err != nil {
+			t.Fatal("NewPackage:", err)
+		}
+		var b bytes.Buffer
+		if err = pkg.WriteTo(&b); err != nil {
+			t.Fatal("gox.WriteTo failed:", err)
+		}
+		rets[i] = b.String()
+	}
+	if rets[0] != rets[1] {
+		t.Fatalf("compiling the same AST twice:\n%s\n----\n%s", rets[0], rets[1])
+	}
+	if !strings.Contains(rets[0], "func (m MyMoney) Gop_Rcast__0() float64 {") {
+		t.Fatal("conversion not compiled as a method:\n" + rets[0])
+	}
+}
+
+func TestCmdlineNoEOL(t *testing.T) {
+	gopClTest(t, `println "Hi"`, `package main
+
+import fmt "fmt"
+
+func main() {
+	fmt.Println("Hi")
+}
+`)
+}
+
+func TestImport(t *testing.T) {
+	gopClTest(t, `import "fmt"
+
+func main() {
+	fmt.println "Hi"
+}`, `package main
+
+import fmt "fmt"
+
+func main() {
+	fmt.Println("Hi")
+}
+`)
+}
+
+func TestDotImport(t *testing.T) {
+	gopClTest(t, `import . "math"
+
+var a = round(1.2)
+`, `package main
+
+import math "math"
+
+var a = math.Round(1.2)
+`)
+}
+
+func TestLocalImport(t *testing.T) {
+	gopClTest(t, `import "./internal/spx"
+
+var a = spx.TestIntValue
+`, `package main
+
+import spx "github.com/goplus/gop/cl/internal/spx"
+
+var a = spx.TestIntValue
+`)
+}
+
+func TestImportUnused(t *testing.T) {
+	gopClTest(t, `import "fmt"
+
+func main() {
+}`, `package main
+
+func main() {
+}
+`)
+}
+
+func TestImportForceUsed(t *testing.T) {
+	gopClTest(t, `import _ "fmt"
+
+func main() {
+}`, `package main
+
+import _ "fmt"
+
+func main() {
+}
+`)
+}
+
+func TestAnonymousImport(t *testing.T) {
+	gopClTest(t, `println("Hello")
+printf("Hello Go+\n")
+`, `package main
+
+import fmt "fmt"
+
+func main() {
+	fmt.Println("Hello")
+	fmt.Printf("Hello Go+\n")
+}
+`)
+}
+
+func TestVarAndConst(t *testing.T) {
+	gopClTest(t, `
+const (
+	i = 1
+	x float64 = 1
+)
+var j int = i
+`, `package main
+
+const (
+	i         = 1
+	x float64 = 1
+)
+
+var j int = i
+`)
+}
+
+func TestDeclStmt(t *testing.T) {
+	gopClTest(t, `import "fmt"
+
+func main() {
+	const (
+		i = 1
+		x float64 = 1
+	)
+	var j int = i
+	fmt.Println("Hi")
+}`, `package main
+
+import fmt "fmt"
+
+func main() {
 	const (
 		i         = 1
 		x float64 = 1
@@ -3799,19 +4439,11 @@ func TestRangeExpr7(t *testing.T) {
 println [x for x <- 0:3:1]
 `, `package main
 
-import (
-	fmt "fmt"
-	builtin "github.com/goplus/gop/builtin"
-)
+import fmt "fmt"
 
 func main() {
 	fmt.Println(func() (_gop_ret []int) {
-		for _gop_it := builtin.NewRange__0(0, 3, 1).Gop_Enum(); ; {
-			var _gop_ok bool
-			x, _gop_ok := _gop_it.Next()
-			if !_gop_ok {
-				break
-			}
+		for x := 0; x < 3; x += 1 {
 			_gop_ret = append(_gop_ret, x)
 		}
 		return
@@ -3820,33 +4452,169 @@ func main() {
 `)
 }
 
-func testRangeExpr8(t *testing.T, codeTpl, expect string) {
-	for _, s := range []string{" <- ", " := range "} {
-		gopClTest(t, strings.Replace(codeTpl, "$", s, -1), expect)
-	}
+func TestRangeExprFloat(t *testing.T) {
+	gopClTest(t, `
+for x <- 0:1:0.25 {
+	println x
 }
+var y float32
+for y = range :1:0.5 {
+	println y
+}
+println [x*2 for x <- 0.5:2:0.5, x > 1]
+`, `package main
 
-func TestRangeExpr8(t *testing.T) {
-	testRangeExpr8(t, `
-	type T struct{}
+import fmt "fmt"
 
-	func (t T)start()int{
-		return 0
+func main() {
+	for x, _gop_n := float64(0), 1; x < 1; x, _gop_n = 0+float64(_gop_n)*0.25, _gop_n+1 {
+		fmt.Println(x)
 	}
-	func (t T)end()int{
-		return 3 
+	var y float32
+	for _gop_k, _gop_n := float32(0), 1; _gop_k < 1; _gop_k, _gop_n = 0+float32(_gop_n)*0.5, _gop_n+1 {
+		y = _gop_k
+		fmt.Println(y)
 	}
-	func (t T)step()int{
-		return 1 
+	fmt.Println(func() (_gop_ret []float64) {
+		for x, _gop_n := 0.5, 1; x < 2; x, _gop_n = 0.5+float64(_gop_n)*0.5, _gop_n+1 {
+			if x > 1 {
+				_gop_ret = append(_gop_ret, x*2)
+			}
+		}
+		return
+	}())
+}
+`)
+}
+
+func TestRangeExprTyped(t *testing.T) {
+	gopClTest(t, `
+func f(n int64, b bigint) {
+	for i <- n:0:-1 {
+		println i
 	}
-	
-	t:=T{}
-	
-	for i <- t.start():t.end():t.step(){
+	for i <- 0:100000000000000000000r:b {
 		println i
 	}
-	`, `package main
-
+}
+`, `package main
+
+import (
+	fmt "fmt"
+	ng "github.com/goplus/gop/builtin/ng"
+	big "math/big"
+)
+
+func f(n int64, b ng.Bigint) {
+	for i := n; i > 0; i += -1 {
+		fmt.Println(i)
+	}
+	for i, _gop_end := ng.Bigint_Cast__0(0), ng.Bigint_Init__1(func() *big.Int {
+		v, _ := new(big.Int).SetString("100000000000000000000", 10)
+		return v
+	}()); b.Gop_GT(ng.Bigint_Init__0(0)) && i.Gop_LT(_gop_end) || b.Gop_LT(ng.Bigint_Init__0(0)) && i.Gop_GT(_gop_end); i = i.Gop_Add(b) {
+		fmt.Println(i)
+	}
+}
+`)
+}
+
+func TestRangeExprStepSign(t *testing.T) {
+	gopClTest(t, `
+func f(n, s int, u uint) {
+	for i <- n:0:s {
+		println i
+	}
+	for i <- u:10:u {
+		println i
+	}
+}
+`, `package main
+
+import fmt "fmt"
+
+func f(n int, s int, u uint) {
+	for i, _gop_ok := n, true; _gop_ok && (s > 0 && i < 0 || s < 0 && i > 0); i, _gop_ok = i+s, s > 0 && i < i+s || s < 0 && i > i+s {
+		fmt.Println(i)
+	}
+	for i, _gop_ok := u, true; _gop_ok && i < 10; i, _gop_ok = i+u, i < i+u {
+		fmt.Println(i)
+	}
+}
+`)
+}
+
+func TestRangeExprOverflow(t *testing.T) {
+	gopClTest(t, `
+for i <- int8(120):127:5 {
+	println i
+}
+for i <- uint8(250):255:3 {
+	println i
+}
+var j int8
+for j = range -100:127:100 {
+	println j
+}
+for i <- int8(-120):-128:-5 {
+	println i
+}
+for i <- int8(0):127:1 {
+	println i
+}
+`, `package main
+
+import fmt "fmt"
+
+func main() {
+	for i, _gop_ok := int8(120), true; _gop_ok && i < 127; i, _gop_ok = i+5, i < i+5 {
+		fmt.Println(i)
+	}
+	for i, _gop_ok := uint8(250), true; _gop_ok && i < 255; i, _gop_ok = i+3, i < i+3 {
+		fmt.Println(i)
+	}
+	var j int8
+	for _gop_k, _gop_ok := int8(-100), true; _gop_ok && _gop_k < 127; _gop_k, _gop_ok = _gop_k+100, _gop_k < _gop_k+100 {
+		j = _gop_k
+		fmt.Println(j)
+	}
+	for i, _gop_ok := int8(-120), true; _gop_ok && i > -128; i, _gop_ok = i+-5, i > i+-5 {
+		fmt.Println(i)
+	}
+	for i := int8(0); i < 127; i += 1 {
+		fmt.Println(i)
+	}
+}
+`)
+}
+
+func testRangeExpr8(t *testing.T, codeTpl, expect string) {
+	for _, s := range []string{" <- ", " := range "} {
+		gopClTest(t, strings.Replace(codeTpl, "$", s, -1), expect)
+	}
+}
+
+func TestRangeExpr8(t *testing.T) {
+	testRangeExpr8(t, `
+	type T struct{}
+
+	func (t T)start()int{
+		return 0
+	}
+	func (t T)end()int{
+		return 3 
+	}
+	func (t T)step()int{
+		return 1 
+	}
+	
+	t:=T{}
+	
+	for i <- t.start():t.end():t.step(){
+		println i
+	}
+	`, `package main
+
 import fmt "fmt"
 
 type T struct {
@@ -3863,7 +4631,7 @@ func (t T) step() int {
 }
 func main() {
 	t := T{}
-	for i, _gop_end, _gop_step := t.start(), t.end(), t.step(); i < _gop_end; i += _gop_step {
+	for i, _gop_end, _gop_step, _gop_ok := t.start(), t.end(), t.step(), true; _gop_ok && (_gop_step > 0 && i < _gop_end || _gop_step < 0 && i > _gop_end); i, _gop_ok = i+_gop_step, _gop_step > 0 && i < i+_gop_step || _gop_step < 0 && i > i+_gop_step {
 		fmt.Println(i)
 	}
 }
@@ -3908,7 +4676,7 @@ func (t T) step() int {
 func main() {
 	t := T{}
 	i := 0
-	for _gop_k, _gop_end, _gop_step := t.start(), t.end(), t.step(); _gop_k < _gop_end; _gop_k += _gop_step {
+	for _gop_k, _gop_end, _gop_step, _gop_ok := t.start(), t.end(), t.step(), true; _gop_ok && (_gop_step > 0 && _gop_k < _gop_end || _gop_step < 0 && _gop_k > _gop_end); _gop_k, _gop_ok = _gop_k+_gop_step, _gop_step > 0 && _gop_k < _gop_k+_gop_step || _gop_step < 0 && _gop_k > _gop_k+_gop_step {
 		i = _gop_k
 		fmt.Println(i)
 	}
@@ -4096,3 +4864,1009 @@ func main() {
 }
 `)
 }
+
+func TestEnum(t *testing.T) {
+	gopClTest(t, `
+type Color enum {
+	Red
+	Green
+	Blue
+}
+
+type Level enum{Debug = -1, Info, Warn = 4, Error}
+
+for c <- Color.values {
+	println c
+}
+c, ok := Color.parse("Green")
+println c, ok, Info
+`, `package main
+
+import fmt "fmt"
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
+const (
+	Debug Level = -1
+	Info  Level = Debug + 1
+	Warn  Level = 4
+	Error Level = Warn + 1
+)
+
+type Color int
+
+func (v Color) String() string {
+	switch v {
+	case Red:
+		return "Red"
+	case Green:
+		return "Green"
+	case Blue:
+		return "Blue"
+	}
+	return fmt.Sprintf("Color(%d)", int(v))
+}
+func Color_Parse(s string) (Color, bool) {
+	switch s {
+	case "Red":
+		return Red, true
+	case "Green":
+		return Green, true
+	case "Blue":
+		return Blue, true
+	}
+	return 0, false
+}
+
+var Color_Values = []Color{Red, Green, Blue}
+
+type Level int
+
+func (v Level) String() string {
+	switch v {
+	case Debug:
+		return "Debug"
+	case Info:
+		return "Info"
+	case Warn:
+		return "Warn"
+	case Error:
+		return "Error"
+	}
+	return fmt.Sprintf("Level(%d)", int(v))
+}
+func Level_Parse(s string) (Level, bool) {
+	switch s {
+	case "Debug":
+		return Debug, true
+	case "Info":
+		return Info, true
+	case "Warn":
+		return Warn, true
+	case "Error":
+		return Error, true
+	}
+	return 0, false
+}
+
+var Level_Values = []Level{Debug, Info, Warn, Error}
+
+func main() {
+	for _, c := range Color_Values {
+		fmt.Println(c)
+	}
+	c, ok := Color_Parse("Green")
+	fmt.Println(c, ok, Info)
+}
+`)
+}
+
+func TestEnumSwitchWarning(t *testing.T) {
+	var warnings []string
+	conf := *gblConf
+	conf.WorkingDir = "/foo"
+	conf.OnWarning = func(err error) {
+		warnings = append(warnings, err.Error())
+	}
+	gopClTestEx(t, &conf, "main", `
+type Color enum {
+	Red
+	Green
+	Blue
+}
+
+func name(c Color) string {
+	switch c {
+	case Red:
+		return "red"
+	}
+	switch c {
+	case Green, Blue:
+	default:
+	}
+	return ""
+}
+`, `package main
+
+import fmt "fmt"
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
+
+type Color int
+
+func (v Color) String() string {
+	switch v {
+	case Red:
+		return "Red"
+	case Green:
+		return "Green"
+	case Blue:
+		return "Blue"
+	}
+	return fmt.Sprintf("Color(%d)", int(v))
+}
+func Color_Parse(s string) (Color, bool) {
+	switch s {
+	case "Red":
+		return Red, true
+	case "Green":
+		return Green, true
+	case "Blue":
+		return Blue, true
+	}
+	return 0, false
+}
+
+var Color_Values = []Color{Red, Green, Blue}
+
+func name(c Color) string {
+	switch c {
+	case Red:
+		return "red"
+	}
+	switch c {
+	case Green, Blue:
+	default:
+	}
+	return ""
+}
+`)
+	if len(warnings) != 1 || warnings[0] != "./bar.gop:9:2: switch of type Color misses cases: Green, Blue" {
+		t.Fatal("TestEnumSwitchWarning:", warnings)
+	}
+}
+
+func TestDefaultParams(t *testing.T) {
+	gopClTest(t, `
+type Client struct {
+	host string
+}
+
+func (c *Client) Get(path string, retries int = 3, verbose bool = false) {
+	println c.host, path, retries, verbose
+}
+
+func connect(host string, port int = 80, timeout int = 30) {
+	println host, port, timeout
+}
+
+connect "localhost", timeout = 5
+connect "localhost"
+connect("a", 1)
+c := &Client{host: "x"}
+c.Get "/", verbose = true
+`, `package main
+
+import fmt "fmt"
+
+type Client struct {
+	host string
+}
+
+func (c *Client) Get(path string, retries int, verbose bool) {
+	fmt.Println(c.host, path, retries, verbose)
+}
+
+const Gopd_Client_Get__retries int = 3
+const Gopd_Client_Get__verbose bool = false
+
+func connect(host string, port int, timeout int) {
+	fmt.Println(host, port, timeout)
+}
+
+const Gopd_connect__port int = 80
+const Gopd_connect__timeout int = 30
+
+func main() {
+	connect("localhost", Gopd_connect__port, 5)
+	connect("localhost", Gopd_connect__port, Gopd_connect__timeout)
+	connect("a", 1, Gopd_connect__timeout)
+	c := &Client{host: "x"}
+	c.Get("/", Gopd_Client_Get__retries, true)
+}
+`)
+}
+
+func TestAggregateComprehension(t *testing.T) {
+	gopClTest(t, `
+type student struct {
+	name  string
+	score int
+	pass  bool
+}
+
+func f(students []student, a []bigint) {
+	println sum(x.score for x <- students if x.pass), min(x.name for x <- students)
+	println count(x for x <- students if x.score > 60), avg(x.score for x <- students)
+	println sum(x for x <- a), max(x for x <- a), avg(bigrat(x) for x <- a)
+}
+`, `package main
+
+import (
+	fmt "fmt"
+	ng "github.com/goplus/gop/builtin/ng"
+)
+
+type student struct {
+	name  string
+	score int
+	pass  bool
+}
+
+func f(students []student, a []ng.Bigint) {
+	fmt.Println(func() (_gop_ret int) {
+		for _, x := range students {
+			if x.pass {
+				_gop_ret += x.score
+			}
+		}
+		return
+	}(), func() (_gop_ret string) {
+		var _gop_ok bool
+		for _, x := range students {
+			if _gop_v := x.name; !_gop_ok {
+				_gop_ret, _gop_ok = _gop_v, true
+			} else if _gop_v < _gop_ret {
+				_gop_ret = _gop_v
+			}
+		}
+		return
+	}())
+	fmt.Println(func() (_gop_ret int) {
+		for _, x := range students {
+			if x.score > 60 {
+				_gop_ret++
+			}
+		}
+		return
+	}(), func() (_gop_ret float64) {
+		var _gop_sum int
+		var _gop_n int
+		for _, x := range students {
+			_gop_sum += x.score
+			_gop_n++
+		}
+		if _gop_n > 0 {
+			_gop_ret = float64(_gop_sum) / float64(_gop_n)
+		}
+		return
+	}())
+	fmt.Println(func() (_gop_ret ng.Bigint) {
+		var _gop_ok bool
+		for _, x := range a {
+			if _gop_v := x; !_gop_ok {
+				_gop_ret, _gop_ok = _gop_v, true
+			} else {
+				_gop_ret = _gop_ret.Gop_Add(_gop_v)
+			}
+		}
+		return
+	}(), func() (_gop_ret ng.Bigint) {
+		var _gop_ok bool
+		for _, x := range a {
+			if _gop_v := x; !_gop_ok {
+				_gop_ret, _gop_ok = _gop_v, true
+			} else if _gop_ret.Gop_LT(_gop_v) {
+				_gop_ret = _gop_v
+			}
+		}
+		return
+	}(), func() (_gop_ret ng.Bigrat) {
+		var _gop_sum ng.Bigrat
+		var _gop_n int
+		for _, x := range a {
+			if _gop_v := ng.Bigrat_Cast__3(x); _gop_n == 0 {
+				_gop_sum = _gop_v
+			} else {
+				_gop_sum = _gop_sum.Gop_Add(_gop_v)
+			}
+			_gop_n++
+		}
+		if _gop_n > 0 {
+			_gop_ret = _gop_sum.Gop_Quo(ng.Bigrat_Cast__0(_gop_n))
+		}
+		return
+	}())
+}
+`)
+}
+
+func TestNestedAggregateComprehension(t *testing.T) {
+	// each level is compiled once, the types are inferred in the same pass
+	const depth = 20
+	src := "1"
+	for i := 0; i < depth; i++ {
+		src = "sum(" + src + " for x" + strconv.Itoa(i) + " <- [1])"
+	}
+	fs := parsertest.NewSingleFileFS("/foo", "bar.gop", "println "+src+"\n")
+	pkgs, err := parser.ParseFSDir(gblFset, fs, "/foo", parser.Config{})
+	if err != nil {
+		t.Fatal("ParseFSDir:", err)
+	}
+	if _, err = cl.NewPackage("", pkgs["main"], gblConf); err != nil {
+		t.Fatal("NewPackage:", err)
+	}
+}
+
+func TestLazyComprehension(t *testing.T) {
+	gopClTest(t, `
+func f(lines chan string, m map[string]int) {
+	words := (w for w <- [line, line + "!"] for line <- lines, line != "")
+	for w <- (k for k, v <- m, v > 0 && k == w2 for w2 <- words) {
+		println w
+	}
+}
+`, `package main
+
+import fmt "fmt"
+
+func f(lines chan string, m map[string]int) {
+	words := func() Gop_Lazy_string {
+		var line string
+		var _gop_c0 chan string
+		var _gop_ok bool
+		var w string
+		var _gop_c1 []string
+		var _gop_i1 int
+		_gop_c0 = lines
+		_gop_lv := 0
+		return func() (string, bool) {
+			for {
+				switch _gop_lv {
+				case 0:
+					if line, _gop_ok = <-_gop_c0; !_gop_ok {
+						_gop_lv--
+						continue
+					}
+					if line != "" {
+						_gop_lv = 1
+						_gop_c1, _gop_i1 = []string{line, line + "!"}, -1
+					}
+				case 1:
+					if _gop_i1++; _gop_i1 >= len(_gop_c1) {
+						_gop_lv--
+						continue
+					}
+					w = _gop_c1[_gop_i1]
+					return w, true
+				default:
+					return "", false
+				}
+			}
+		}
+	}()
+	for _gop_it := func() Gop_Lazy_string {
+		var w2 string
+		var _gop_c0 func() (string, bool)
+		var _gop_ok bool
+		var k string
+		var v int
+		var _gop_c1 map[string]int
+		var _gop_i1 int
+		var _gop_keys1 []string
+		_gop_c0 = words.Gop_Enum().Next
+		_gop_lv := 0
+		return func() (string, bool) {
+			for {
+				switch _gop_lv {
+				case 0:
+					if w2, _gop_ok = _gop_c0(); !_gop_ok {
+						_gop_lv--
+						continue
+					}
+					_gop_lv = 1
+					_gop_c1, _gop_i1, _gop_keys1 = m, -1, _gop_keys1[:0]
+					for _gop_k := range _gop_c1 {
+						_gop_keys1 = append(_gop_keys1, _gop_k)
+					}
+				case 1:
+					if _gop_i1++; _gop_i1 >= len(_gop_keys1) {
+						_gop_lv--
+						continue
+					}
+					k, v = _gop_keys1[_gop_i1], _gop_c1[_gop_keys1[_gop_i1]]
+					if v > 0 && k == w2 {
+						return k, true
+					}
+				default:
+					return "", false
+				}
+			}
+		}
+	}().Gop_Enum(); ; {
+		var _gop_ok bool
+		w, _gop_ok := _gop_it.Next()
+		if !_gop_ok {
+			break
+		}
+		fmt.Println(w)
+	}
+}
+
+type Gop_Lazy_string func() (string, bool)
+
+func (p Gop_Lazy_string) Gop_Enum() Gop_Lazy_string {
+	return p
+}
+func (p Gop_Lazy_string) Next() (string, bool) {
+	return p()
+}
+`)
+}
+
+func TestQueryComprehension(t *testing.T) {
+	gopClTest(t, `
+type student struct {
+	name  string
+	class string
+	score int
+}
+
+func f(students []student) {
+	println [x.name for x <- students if x.score > 60 orderby x.score desc, x.name take 3]
+	println [[x.class, sum(x.score), count(), avg(x.score)] for x <- students groupby x.class orderby max(x.score) desc]
+	println [x.class for x <- students distinct skip 1]
+}
+`, `package main
+
+import (
+	fmt "fmt"
+	sort "sort"
+)
+
+type student struct {
+	name  string
+	class string
+	score int
+}
+
+func f(students []student) {
+	fmt.Println(func() (_gop_ret []string) {
+		_gop_take := 3
+		if _gop_take <= 0 {
+			return
+		}
+		var _gop_recs []struct {
+			v  string
+			k0 int
+			k1 string
+		}
+		for _, x := range students {
+			if x.score > 60 {
+				_gop_v := x.name
+				_gop_recs = append(_gop_recs, struct {
+					v  string
+					k0 int
+					k1 string
+				}{_gop_v, x.score, x.name})
+			}
+		}
+		sort.SliceStable(_gop_recs, func(i int, j int) bool {
+			if _gop_recs[j].k0 < _gop_recs[i].k0 {
+				return true
+			}
+			if _gop_recs[i].k0 < _gop_recs[j].k0 {
+				return false
+			}
+			return _gop_recs[i].k1 < _gop_recs[j].k1
+		})
+		for _, _gop_r := range _gop_recs {
+			_gop_ret = append(_gop_ret, _gop_r.v)
+			if len(_gop_ret) >= _gop_take {
+				return
+			}
+		}
+		return
+	}())
+	fmt.Println(func() (_gop_ret [][]interface {
+	}) {
+		var _gop_recs []struct {
+			v []interface {
+			}
+			k0 int
+		}
+		_gop_index := map[string]int{}
+		var _gop_n []int
+		var _gop_var0 []student
+		var _gop_agg0 []int
+		var _gop_agg2 []int
+		var _gop_agg3 []int
+		for _, x := range students {
+			_gop_k := x.class
+			if _gop_gi, _gop_ok := _gop_index[_gop_k]; !_gop_ok {
+				_gop_index[_gop_k] = len(_gop_n)
+				_gop_n = append(_gop_n, 1)
+				_gop_var0 = append(_gop_var0, x)
+				_gop_agg0 = append(_gop_agg0, x.score)
+				_gop_agg2 = append(_gop_agg2, x.score)
+				_gop_agg3 = append(_gop_agg3, x.score)
+			} else {
+				_gop_n[_gop_gi]++
+				_gop_agg0[_gop_gi] += x.score
+				_gop_agg2[_gop_gi] += x.score
+				if _gop_v := x.score; _gop_agg3[_gop_gi] < _gop_v {
+					_gop_agg3[_gop_gi] = _gop_v
+				}
+			}
+		}
+		for _gop_gi := range _gop_n {
+			x := _gop_var0[_gop_gi]
+			_gop_v := []interface {
+			}{x.class, _gop_agg0[_gop_gi], _gop_n[_gop_gi], float64(_gop_agg2[_gop_gi]) / float64(_gop_n[_gop_gi])}
+			_gop_recs = append(_gop_recs, struct {
+				v []interface {
+				}
+				k0 int
+			}{_gop_v, _gop_agg3[_gop_gi]})
+		}
+		sort.SliceStable(_gop_recs, func(i int, j int) bool {
+			return _gop_recs[j].k0 < _gop_recs[i].k0
+		})
+		for _, _gop_r := range _gop_recs {
+			_gop_ret = append(_gop_ret, _gop_r.v)
+		}
+		return
+	}())
+	fmt.Println(func() (_gop_ret []string) {
+		_gop_skip := 1
+		_gop_seen := map[string]bool{}
+		for _, x := range students {
+			_gop_v := x.class
+			if !_gop_seen[_gop_v] {
+				_gop_seen[_gop_v] = true
+				if _gop_skip > 0 {
+					_gop_skip--
+				} else {
+					_gop_ret = append(_gop_ret, _gop_v)
+				}
+			}
+		}
+		return
+	}())
+}
+`)
+}
+
+func TestSetLit(t *testing.T) {
+	gopClTest(t, `
+a := {"Go", "Go+"}
+b := set{x for x <- ["C", "Go", "C"] if x != ""}
+println "Go" in a, a | b, a & b, a - b
+`, `package main
+
+import fmt "fmt"
+
+func main() {
+	a := Gop_Set_string{"Go": struct {
+	}{}, "Go+": struct {
+	}{}}
+	b := func() (_gop_ret Gop_Set_string) {
+		_gop_ret = Gop_Set_string{}
+		for _, x := range []string{"C", "Go", "C"} {
+			if x != "" {
+				_gop_ret[x] = struct {
+				}{}
+			}
+		}
+		return
+	}()
+	fmt.Println(a.Gop_In("Go"), a.Gop_Or(b), a.Gop_And(b), a.Gop_Sub(b))
+}
+
+type Gop_Set_string map[string]struct {
+}
+
+func (p Gop_Set_string) Gop_In(x string) (ok bool) {
+	_, ok = p[x]
+	return
+}
+func (p Gop_Set_string) Gop_Or(q Gop_Set_string) (r Gop_Set_string) {
+	r = Gop_Set_string{}
+	for x := range p {
+		r[x] = struct {
+		}{}
+	}
+	for x := range q {
+		r[x] = struct {
+		}{}
+	}
+	return
+}
+func (p Gop_Set_string) Gop_And(q Gop_Set_string) (r Gop_Set_string) {
+	r = Gop_Set_string{}
+	for x := range p {
+		if q.Gop_In(x) {
+			r[x] = struct {
+			}{}
+		}
+	}
+	return
+}
+func (p Gop_Set_string) Gop_Sub(q Gop_Set_string) (r Gop_Set_string) {
+	r = Gop_Set_string{}
+	for x := range p {
+		if !q.Gop_In(x) {
+			r[x] = struct {
+			}{}
+		}
+	}
+	return
+}
+`)
+}
+
+func TestInMap(t *testing.T) {
+	gopClTest(t, `
+m := map[string]int{"a": 1}
+var s map[int]struct{} = {1, 2}
+println "a" in m, 3 in s
+`, `package main
+
+import fmt "fmt"
+
+func main() {
+	m := map[string]int{"a": 1}
+	var s map[int]struct {
+	} = map[int]struct {
+	}{1: struct {
+	}{}, 2: struct {
+	}{}}
+	fmt.Println(func() (_gop_ok bool) {
+		_, _gop_ok = m["a"]
+		return
+	}(), func() (_gop_ok bool) {
+		_, _gop_ok = s[3]
+		return
+	}())
+}
+`)
+}
+
+func TestBuiltinMethod(t *testing.T) {
+	gopClTest(t, `
+s := " Hello, Go+ "
+f, n := 3.14159, 42
+println s.trimSpace.toUpper, s.split(","), s.len, s.hasPrefix(" H")
+println n.string, f.round(2), f.round, "x".repeat(3)
+`, `package main
+
+import (
+	fmt "fmt"
+	builtin "github.com/goplus/gop/builtin"
+	strconv "strconv"
+	strings "strings"
+	math "math"
+)
+
+func main() {
+	s := " Hello, Go+ "
+	f, n := 3.14159, 42
+	fmt.Println(strings.ToUpper(strings.TrimSpace(s)), strings.Split(s, ","), len(s), strings.HasPrefix(s, " H"))
+	fmt.Println(strconv.Itoa(n), builtin.RoundN(f, 2), math.Round(f), strings.Repeat("x", 3))
+}
+`)
+}
+
+func TestBuiltinMethodConf(t *testing.T) {
+	conf := *gblConf
+	conf.BuiltinMethods = []*cl.BuiltinMethods{
+		{Type: types.Typ[types.String], PkgPath: "unicode/utf8", Methods: map[string]string{
+			"runeCount": "RuneCountInString", "valid": "ValidString",
+		}},
+	}
+	gopClTestEx(t, &conf, "main", `
+println "Go+".runeCount, "Go+".valid, "Go+".toLower
+`, `package main
+
+import (
+	fmt "fmt"
+	strings "strings"
+	utf8 "unicode/utf8"
+)
+
+func main() {
+	fmt.Println(utf8.RuneCountInString("Go+"), utf8.ValidString("Go+"), strings.ToLower("Go+"))
+}
+`)
+}
+
+func TestSliceMethods(t *testing.T) {
+	gopClTest(t, `
+a := [3, 1, 2]
+b := a.map(x => x*2)
+c := a.filter(x => x > 1)
+d := a.reduce((acc, x) => acc+x)
+e := a.reduce("", (acc, x) => acc+x.string)
+f := a.sortBy(x => -x)
+g := a.contains(2)
+println b, c, d, e, f, g
+`, `package main
+
+import (
+	fmt "fmt"
+	strconv "strconv"
+	sort "sort"
+)
+
+func main() {
+	a := []int{3, 1, 2}
+	b := func(_gop_a []int) (_gop_ret []int) {
+		for _, x := range _gop_a {
+			_gop_ret = append(_gop_ret, x*2)
+		}
+		return
+	}(a)
+	c := func(_gop_a []int) (_gop_ret []int) {
+		for _, x := range _gop_a {
+			if x > 1 {
+				_gop_ret = append(_gop_ret, x)
+			}
+		}
+		return
+	}(a)
+	d := func(_gop_a []int) (acc int) {
+		for _gop_i, x := range _gop_a {
+			if _gop_i == 0 {
+				acc = x
+			} else {
+				acc = acc + x
+			}
+		}
+		return
+	}(a)
+	e := func(_gop_a []int, _gop_init string) (acc string) {
+		acc = _gop_init
+		for _, x := range _gop_a {
+			acc = acc + strconv.Itoa(x)
+		}
+		return
+	}(a, "")
+	f := func(_gop_a []int) (_gop_ret []int) {
+		_gop_f := func(x int) int {
+			return -x
+		}
+		_gop_ret = append(_gop_ret, _gop_a...)
+		sort.SliceStable(_gop_ret, func(i int, j int) bool {
+			return _gop_f(_gop_ret[i]) < _gop_f(_gop_ret[j])
+		})
+		return
+	}(a)
+	g := func(_gop_a []int, _gop_x int) bool {
+		for _, _gop_v := range _gop_a {
+			if _gop_v == _gop_x {
+				return true
+			}
+		}
+		return false
+	}(a, 2)
+	fmt.Println(b, c, d, e, f, g)
+}
+`)
+}
+
+func TestSliceMethodsFuncArg(t *testing.T) {
+	gopClTest(t, `
+import "strconv"
+
+a := [1, 2]
+println a.map(strconv.Itoa)
+`, `package main
+
+import (
+	fmt "fmt"
+	strconv "strconv"
+)
+
+func main() {
+	a := []int{1, 2}
+	fmt.Println(func(_gop_a []int, _gop_f func(i int) string) (_gop_ret []string) {
+		for _, _gop_v := range _gop_a {
+			_gop_ret = append(_gop_ret, _gop_f(_gop_v))
+		}
+		return
+	}(a, strconv.Itoa))
+}
+`)
+}
+
+func TestMapMethods(t *testing.T) {
+	gopClTest(t, `
+m := {"a": 1}
+println m.keys, m.values
+`, `package main
+
+import fmt "fmt"
+
+func main() {
+	m := map[string]int{"a": 1}
+	fmt.Println(func(_gop_a map[string]int) (_gop_ret []string) {
+		for _gop_k := range _gop_a {
+			_gop_ret = append(_gop_ret, _gop_k)
+		}
+		return
+	}(m), func(_gop_a map[string]int) (_gop_ret []int) {
+		for _, _gop_v := range _gop_a {
+			_gop_ret = append(_gop_ret, _gop_v)
+		}
+		return
+	}(m))
+}
+`)
+}
+
+func TestForPhraseReader(t *testing.T) {
+	gopClTest(t, `
+import "os"
+
+for i, line <- os.Stdin {
+	println i, line
+}
+println [line for line <- open("x.txt")! if line != ""]
+`, `package main
+
+import (
+	fmt "fmt"
+	iox "github.com/goplus/gop/builtin/iox"
+	os "os"
+	errors "github.com/qiniu/x/errors"
+)
+
+func main() {
+	for _gop_it := iox.Lines(os.Stdin).Gop_Enum(); ; {
+		var _gop_ok bool
+		i, line, _gop_ok := _gop_it.Next()
+		if !_gop_ok {
+			break
+		}
+		fmt.Println(i, line)
+	}
+	fmt.Println(func() (_gop_ret []string) {
+		for _gop_it := iox.Lines(func() (_gop_ret *iox.File) {
+			var _gop_err error
+			_gop_ret, _gop_err = iox.Open("x.txt")
+			if _gop_err != nil {
+				_gop_err = errors.NewFrame(_gop_err, "open(\"x.txt\")!", "/foo/bar.gop", 7, "main.main")
+				panic(_gop_err)
+			}
+			return
+		}()).Gop_Enum(); ; {
+			var _gop_ok bool
+			_, line, _gop_ok := _gop_it.Next()
+			if !_gop_ok {
+				break
+			}
+			if line != "" {
+				_gop_ret = append(_gop_ret, line)
+			}
+		}
+		return
+	}())
+}
+`)
+}
+
+func TestBuiltinIO(t *testing.T) {
+	gopClTest(t, `
+import "os"
+
+for rec <- csv(os.Stdin) {
+	println rec
+}
+println readFile("x.txt")!, "a,b".csv!
+`, `package main
+
+import (
+	fmt "fmt"
+	iox "github.com/goplus/gop/builtin/iox"
+	os "os"
+	errors "github.com/qiniu/x/errors"
+)
+
+func main() {
+	for _gop_it := iox.CSV(os.Stdin).Gop_Enum(); ; {
+		var _gop_ok bool
+		_, rec, _gop_ok := _gop_it.Next()
+		if !_gop_ok {
+			break
+		}
+		fmt.Println(rec)
+	}
+	fmt.Println(func() (_gop_ret string) {
+		var _gop_err error
+		_gop_ret, _gop_err = iox.ReadFile("x.txt")
+		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "readFile(\"x.txt\")!", "/foo/bar.gop", 7, "main.main")
+			panic(_gop_err)
+		}
+		return
+	}(), func() (_gop_ret []string) {
+		var _gop_err error
+		_gop_ret, _gop_err = iox.SplitCSV("a,b")
+		if _gop_err != nil {
+			_gop_err = errors.NewFrame(_gop_err, "\"a,b\".csv!", "/foo/bar.gop", 7, "main.main")
+			panic(_gop_err)
+		}
+		return
+	}())
+}
+`)
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	const n = 8
+	var fnames []string
+	files := make(map[string]string)
+	for i := 0; i < n; i++ {
+		name := string(rune('a' + i))
+		fnames = append(fnames, name+".gop", name+"_go.go")
+		files["/foo/"+name+".gop"] = `
+type T` + name + ` struct {
+	v int
+}
+
+func (p *T` + name + `) Get() int {
+	return p.v
+}
+
+var V` + name + ` = &T` + name + `{v: ` + string(rune('1'+i)) + `}
+
+func F` + name + `() int {
+	return V` + name + `.Get()
+}
+`
+		files["/foo/"+name+"_go.go"] = `package main
+
+func G` + name + `() int {
+	return F` + name + `()
+}
+`
+	}
+	fs := parsertest.NewMemFS(map[string][]string{"/foo": fnames}, files)
+	var expected string
+	for i := 0; i < 20; i++ {
+		pkgs, err := parser.ParseFSDir(gblFset, fs, "/foo", parser.Config{})
+		if err != nil {
+			t.Fatal("ParseFSDir:", err)
+		}
+		pkg, err := cl.NewPackage("", pkgs["main"], gblConf)
+		if err != nil {
+			t.Fatal("NewPackage:", err)
+		}
+		var b bytes.Buffer
+		if err = pkg.WriteTo(&b); err != nil {
+			t.Fatal("gox.WriteTo failed:", err)
+		}
+		if i == 0 {
+			expected = b.String()
+		} else if result := b.String(); result != expected {
+			t.Fatalf("\nResult:\n%s\nExpected:\n%s\n", result, expected)
+		}
+	}
+}
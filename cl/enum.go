@@ -0,0 +1,358 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	"go/constant"
+	gotoken "go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+	"github.com/goplus/gox"
+)
+
+// -----------------------------------------------------------------------------
+
+// An enum type `type T enum { A; B; C }` is compiled to a named int type T and
+// the declarations:
+//
+//   const (
+//       A T = iota
+//       B
+//       C
+//   )
+//   func (v T) String() string         // "A", "B", "C"
+//   func T_Parse(s string) (T, bool)   // T_Parse("B") == B, true
+//   var T_Values = []T{A, B, C}
+//
+// A value after an explicit one is declared as the previous value plus one.
+// The values must be distinct.
+// T_Parse and T_Values can be referred as T.parse and T.values.
+
+type enumType struct {
+	spec   *ast.TypeSpec
+	values []string
+	consts *ast.GenDecl   // const (A T = iota; ...)
+	cases  *ast.BlockStmt // cases of the switch in the String method
+}
+
+// collectEnums collects the enum types declared in files, and the
+// declarations they are compiled to, which follow the type declarations.
+func collectEnums(ctx *pkgCtx, files map[string]*ast.File) {
+	for _, fpath := range sortedFiles(files) {
+		f := files[fpath]
+		var decls []ast.Decl
+		var hasEnum bool
+		for _, decl := range f.Decls {
+			decls = append(decls, decl)
+			d, ok := decl.(*ast.GenDecl)
+			if !ok || d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				t := spec.(*ast.TypeSpec)
+				et, ok := t.Type.(*ast.EnumType)
+				if !ok {
+					continue
+				}
+				if ctx.enums == nil {
+					ctx.enums = make(map[string]*enumType)
+				}
+				e := &enumType{spec: t}
+				for _, v := range et.Values {
+					e.values = append(e.values, v.Name.Name)
+				}
+				ctx.enums[t.Name.Name] = e
+				edecls := enumDecls(t, et)
+				e.consts = edecls[0].(*ast.GenDecl)
+				e.cases = edecls[1].(*ast.FuncDecl).Body.List[0].(*ast.SwitchStmt).Body
+				decls = append(decls, edecls...)
+				hasEnum = true
+			}
+		}
+		if hasEnum {
+			if ctx.fdecls == nil {
+				ctx.fdecls = make(map[*ast.File][]ast.Decl)
+			}
+			ctx.fdecls[f] = decls
+		}
+	}
+}
+
+// fileDecls returns the declarations of f, including the ones its enum types
+// are compiled to, and with the conversions to other types replaced by the
+// methods they are compiled to.
+func (p *pkgCtx) fileDecls(f *ast.File) []ast.Decl {
+	if decls, ok := p.fdecls[f]; ok {
+		return decls
+	}
+	return f.Decls
+}
+
+func enumDecls(t *ast.TypeSpec, et *ast.EnumType) []ast.Decl {
+	pos := et.Enum
+	name := t.Name.Name
+	ident := func(name string) *ast.Ident {
+		return &ast.Ident{NamePos: pos, Name: name}
+	}
+	str := func(s string) *ast.BasicLit {
+		return &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(s)}
+	}
+	ret := func(results ...ast.Expr) ast.Stmt {
+		return &ast.ReturnStmt{Return: pos, Results: results}
+	}
+	field := func(name string, typ string) *ast.Field {
+		f := &ast.Field{Type: ident(typ)}
+		if name != "" {
+			f.Names = []*ast.Ident{ident(name)}
+		}
+		return f
+	}
+
+	specs := make([]ast.Spec, len(et.Values))
+	elts := make([]ast.Expr, len(et.Values))
+	strCases := make([]ast.Stmt, len(et.Values))
+	parseCases := make([]ast.Stmt, len(et.Values))
+	implicit := true // values are iota
+	for i, v := range et.Values {
+		spec := &ast.ValueSpec{Names: []*ast.Ident{{NamePos: v.Name.NamePos, Name: v.Name.Name}}}
+		switch {
+		case v.Value != nil:
+			spec.Type, spec.Values, implicit = ident(name), []ast.Expr{v.Value}, false
+		case i == 0:
+			spec.Type, spec.Values = ident(name), []ast.Expr{ident("iota")}
+		case !implicit:
+			prev := et.Values[i-1].Name.Name
+			spec.Type = ident(name)
+			spec.Values = []ast.Expr{&ast.BinaryExpr{
+				X: ident(prev), OpPos: pos, Op: token.ADD, Y: &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: "1"},
+			}}
+		}
+		specs[i] = spec
+		elts[i] = ident(v.Name.Name)
+		strCases[i] = &ast.CaseClause{
+			Case: pos, List: []ast.Expr{ident(v.Name.Name)}, Body: []ast.Stmt{ret(str(v.Name.Name))},
+		}
+		parseCases[i] = &ast.CaseClause{
+			Case: pos, List: []ast.Expr{str(v.Name.Name)}, Body: []ast.Stmt{ret(ident(v.Name.Name), ident("true"))},
+		}
+	}
+	consts := &ast.GenDecl{TokPos: pos, Tok: token.CONST, Lparen: pos, Specs: specs, Rparen: pos}
+
+	// func (v T) String() string
+	stringer := &ast.FuncDecl{
+		Recv: &ast.FieldList{List: []*ast.Field{field("v", name)}},
+		Name: ident("String"),
+		Type: &ast.FuncType{
+			Func: pos, Params: &ast.FieldList{}, Results: &ast.FieldList{List: []*ast.Field{field("", "string")}},
+		},
+		Body: &ast.BlockStmt{Lbrace: pos, List: []ast.Stmt{
+			&ast.SwitchStmt{Switch: pos, Tag: ident("v"), Body: &ast.BlockStmt{Lbrace: pos, List: strCases, Rbrace: pos}},
+			ret(&ast.CallExpr{Fun: ident("sprintf"), Lparen: pos, Args: []ast.Expr{
+				str(name + "(%d)"), &ast.CallExpr{Fun: ident("int"), Lparen: pos, Args: []ast.Expr{ident("v")}, Rparen: pos},
+			}, Rparen: pos}),
+		}, Rbrace: pos},
+	}
+
+	// func T_Parse(s string) (T, bool)
+	parse := &ast.FuncDecl{
+		Name: ident(name + "_Parse"),
+		Type: &ast.FuncType{
+			Func:    pos,
+			Params:  &ast.FieldList{List: []*ast.Field{field("s", "string")}},
+			Results: &ast.FieldList{List: []*ast.Field{field("", name), field("", "bool")}},
+		},
+		Body: &ast.BlockStmt{Lbrace: pos, List: []ast.Stmt{
+			&ast.SwitchStmt{Switch: pos, Tag: ident("s"), Body: &ast.BlockStmt{Lbrace: pos, List: parseCases, Rbrace: pos}},
+			ret(&ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: "0"}, ident("false")),
+		}, Rbrace: pos},
+	}
+
+	// var T_Values = []T{...}
+	values := &ast.GenDecl{TokPos: pos, Tok: token.VAR, Specs: []ast.Spec{&ast.ValueSpec{
+		Names:  []*ast.Ident{ident(name + "_Values")},
+		Values: []ast.Expr{&ast.CompositeLit{Type: &ast.ArrayType{Lbrack: pos, Elt: ident(name)}, Lbrace: pos, Elts: elts, Rbrace: pos}},
+	}}}
+	return []ast.Decl{consts, stringer, parse, values}
+}
+
+// checkEnumValues reports a duplicate value of the enum type whose values are
+// declared by d, which has been loaded. The cases of duplicate values are
+// removed from the String method, so only the first name of a value is used.
+func checkEnumValues(ctx *blockCtx, d *ast.GenDecl) {
+	for _, e := range ctx.enums {
+		if e.consts != d {
+			continue
+		}
+		var err error
+		var cases []ast.Stmt
+		seen := make(map[string]*ast.Ident)
+		scope := ctx.pkg.Types.Scope()
+		for i, v := range e.spec.Type.(*ast.EnumType).Values {
+			c, ok := scope.Lookup(v.Name.Name).(*types.Const)
+			if !ok {
+				continue
+			}
+			val := c.Val().ExactString()
+			if prev, ok := seen[val]; ok {
+				if err == nil {
+					pos := ctx.Position(v.Name.Pos())
+					err = newCodeErrorf(&pos, "duplicate enum value %s (value %s)\n\tprevious value %s at %v",
+						v.Name.Name, val, prev.Name, ctx.Position(prev.Pos()))
+				}
+				continue
+			}
+			seen[val] = v.Name
+			cases = append(cases, e.cases.List[i])
+		}
+		if err != nil {
+			e.cases.List = cases
+			panic(err)
+		}
+		return
+	}
+}
+
+func toEnumType(ctx *blockCtx, v *ast.EnumType) types.Type {
+	for _, e := range ctx.enums {
+		if e.spec.Type == v {
+			return types.Typ[types.Int]
+		}
+	}
+	panic(ctx.newCodeErrorf(v.Pos(), "enum type must be declared at package level"))
+}
+
+// -----------------------------------------------------------------------------
+
+// compileTypeMember compiles `T.name` to `T_Name` declared in the package of
+// type T, eg. `Color.values` to `Color_Values`, if T has no method name.
+// The type is on the stack.
+func compileTypeMember(ctx *blockCtx, v *ast.SelectorExpr) bool {
+	cb := ctx.cb
+	tt, ok := cb.Get(-1).Type.(*gox.TypeType)
+	if !ok {
+		return false
+	}
+	t, ok := tt.Type().(*types.Named)
+	if !ok {
+		return false
+	}
+	tn := t.Obj()
+	name := v.Sel.Name
+	if c := name[0]; c >= 'a' && c <= 'z' {
+		name = string(rune(c)+('A'-'a')) + name[1:]
+	}
+	name = tn.Name() + "_" + name
+	if tn.Pkg() == ctx.pkg.Types {
+		ctx.loadSymbol(name)
+	}
+	o := tn.Pkg().Scope().Lookup(name)
+	if o == nil || (tn.Pkg() != ctx.pkg.Types && !o.Exported()) {
+		return false
+	}
+	cb.InternalStack().Pop()
+	cb.Val(o, v)
+	return true
+}
+
+// -----------------------------------------------------------------------------
+
+// checkEnumSwitch warns a switch on an enum type without a default case if
+// some values of the enum have no case.
+func checkEnumSwitch(ctx *blockCtx, v *ast.SwitchStmt, tag types.Type, seen valueMap) {
+	names := enumValues(ctx, tag)
+	if names == nil || ctx.onWarning == nil {
+		return
+	}
+	for _, stmt := range v.Body.List {
+		if stmt.(*ast.CaseClause).List == nil { // default
+			return
+		}
+	}
+	var missing []string
+	for _, name := range names {
+		o := ctx.pkg.Types.Scope().Lookup(name)
+		if t := tag.(*types.Named); t.Obj().Pkg() != ctx.pkg.Types {
+			o = t.Obj().Pkg().Scope().Lookup(name)
+		}
+		c, ok := o.(*types.Const)
+		if !ok || hasSeen(seen, goVal(c.Val()), tag) {
+			continue
+		}
+		missing = append(missing, name)
+	}
+	if missing != nil {
+		pos := ctx.Position(v.Switch)
+		ctx.onWarning(newCodeErrorf(&pos, "switch of type %v misses cases: %s",
+			types.TypeString(tag, types.RelativeTo(ctx.pkg.Types)), strings.Join(missing, ", ")))
+	}
+}
+
+func hasSeen(seen valueMap, val interface{}, typ types.Type) bool {
+	for _, vt := range seen[val] {
+		if types.Identical(vt.typ, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// enumValues returns the names of the values of enum type t, or nil if t isn't
+// an enum type. Enum types of other packages are recognized by T_Values.
+func enumValues(ctx *blockCtx, t types.Type) []string {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil
+	}
+	tn := named.Obj()
+	if tn.Pkg() == ctx.pkg.Types {
+		if e, ok := ctx.enums[tn.Name()]; ok {
+			for _, name := range e.values {
+				ctx.loadSymbol(name)
+			}
+			return e.values
+		}
+		return nil
+	}
+	if tn.Pkg() == nil {
+		return nil
+	}
+	scope := tn.Pkg().Scope()
+	if scope.Lookup(tn.Name()+"_Values") == nil {
+		return nil
+	}
+	var consts []*types.Const
+	for _, name := range scope.Names() {
+		if c, ok := scope.Lookup(name).(*types.Const); ok && types.Identical(c.Type(), t) {
+			consts = append(consts, c)
+		}
+	}
+	sort.SliceStable(consts, func(i, j int) bool {
+		return constant.Compare(consts[i].Val(), gotoken.LSS, consts[j].Val())
+	})
+	names := make([]string, len(consts))
+	for i, c := range consts {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// -----------------------------------------------------------------------------
@@ -160,6 +160,23 @@ func TestErrErrWrap(t *testing.T) {
 		"./bar.gop:2:2: undefined: a", `func main() {
 	a!
 }
+`)
+	codeErrorTest(t, `./bar.gop:3:26: can't use expr? in a package level initializer, use expr! or expr?:defval instead`, `
+import "strconv"
+var x = strconv.Atoi("1")?
+`)
+	codeErrorTest(t, `./bar.gop:4:19: can't use expr? in a function whose last result isn't an error`, `
+import "strconv"
+func f() int {
+	strconv.Atoi("1")?
+	return 0
+}
+`)
+	codeErrorTest(t, `./bar.gop:4:19: can't use expr? in a function whose last result isn't an error`, `
+import "strconv"
+func() {
+	strconv.Atoi("1")?
+}()
 `)
 }
 
@@ -866,3 +883,326 @@ import (
 )
 `)
 }
+
+func TestErrOverloadFunc(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:8:1: ambiguous call to f(untyped int, untyped int), candidates:
+	f(a int, b interface{})
+	f(a interface{}, b int)`, `
+func f(a int, b any) {
+}
+
+func f(a any, b int) {
+}
+
+f 1, 2
+`)
+	codeErrorTest(t, `./bar.gop:5:6: f redeclared in this block
+	previous declaration at ./bar.gop:2:6`, `
+func f(a int) {
+}
+
+func f(b int) {
+}
+`)
+	codeErrorTest(t, `./bar.gop:11:1: ambiguous call to v.Set(untyped int), candidates:
+	(T).Set(a int64)
+	(T).Set(a float64)`, `
+type T int
+
+func (T) Set(a int64) {
+}
+
+func (T) Set(a float64) {
+}
+
+var v T
+v.Set 1
+`)
+}
+
+func TestErrPropertySetter(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:9:1: cannot assign to c.count (property count is read-only: *Counter has no method SetCount)`, `
+type Counter int
+
+func (c *Counter) Count() int {
+	return int(*c)
+}
+
+c := new(Counter)
+c.count = 1
+`)
+	codeErrorTest(t, `./bar.gop:8:1: cannot use c.name in assignment operation (property name is write-only: *Counter has no method Name)`, `
+type Counter int
+
+func (c *Counter) SetName(v string) {
+}
+
+c := new(Counter)
+c.name += "x"
+`)
+	codeErrorTest(t, `./bar.gop:8:1: cannot assign to property c.name in a multiple assignment`, `
+type Counter int
+
+func (c *Counter) SetName(v string) {
+}
+
+c := new(Counter)
+c.name, c = "x", nil
+`)
+}
+
+func TestErrOverloadIndexOp(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:10:1: cannot assign to v[1] (Vec has no method Gop_SetIndex)`, `
+type Vec struct {
+}
+
+func (v Vec) Gop_Index(i int) int {
+	return i
+}
+
+var v Vec
+v[1] = 2
+`)
+	codeErrorTest(t, `./bar.gop:9:1: cannot use v[1] in assignment operation (Vec has no method Gop_Index)`, `
+type Vec struct {
+}
+
+func (v Vec) Gop_SetIndex(i, x int) {
+}
+
+var v Vec
+v[1] += 2
+`)
+	codeErrorTest(t, `./bar.gop:9:1: cannot assign to overloaded index v[1] in a multiple assignment`, `
+type Vec struct {
+}
+
+func (v Vec) Gop_SetIndex(i, x int) {
+}
+
+var v Vec
+v[1], v = 2, Vec{}
+`)
+	codeErrorTest(t, `./bar.gop:3:1: invalid operation: more than one index`, `
+a := [1, 2]
+a[0, 1] = 2
+`)
+}
+
+func TestErrConversionOverload(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:9:6: MyMoney redeclared in this block
+	previous declaration at ./bar.gop:5:6`, `
+type MyMoney struct {
+}
+
+func MyMoney(cents int) MyMoney {
+	return MyMoney{}
+}
+
+func MyMoney(n int) MyMoney {
+	return MyMoney{}
+}
+`)
+	codeErrorTest(t, `./bar.gop:9:6: float64 redeclared in this block
+	previous declaration at ./bar.gop:5:6`, `
+type MyMoney struct {
+}
+
+func float64(m MyMoney) float64 {
+	return 0
+}
+
+func float64(m MyMoney) float64 {
+	return 1
+}
+`)
+}
+
+func TestErrEnum(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:3:13: enum type must be declared at package level`, `
+func f() {
+	type Color enum{Red, Green}
+}
+`)
+	codeErrorTest(t, `./bar.gop:5:5: Color.Black undefined (type Color has no method Black)`, `
+type Color enum {
+	Red
+}
+_ = Color.Black
+`)
+	codeErrorTest(t, `./bar.gop:5:2: duplicate enum value Blue (value 1)
+	previous value Green at ./bar.gop:4:2`, `
+type Color enum {
+	Red
+	Green
+	Blue = 1
+}
+`)
+	codeErrorTest(t, `./bar.gop:4:2: duplicate enum value Green (value 0)
+	previous value Red at ./bar.gop:3:2`, `
+type Color enum {
+	Red = 0
+	Green = 0
+	Blue
+}
+println Blue
+`)
+}
+
+func TestErrDefaultParams(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:2:26: missing default value of parameter c`, `
+func f(a int, b int = 1, c int) {}
+`)
+	codeErrorTest(t, `./bar.gop:2:26: variadic parameter b can't have a default value`, `
+func f(a int, b ...int = 1) {}
+`)
+	codeErrorTest(t, `./bar.gop:3:23: default value x of parameter b is not a constant`, `
+var x = 1
+func f(a int, b int = x) {}
+f 1, 2
+`)
+	codeErrorTest(t, `./bar.gop:2:19: default values of parameters are only allowed in function declarations`, `
+g := func(a int = 1) {}
+`)
+	codeErrorTest(t, `./bar.gop:3:26: parameter b of overloaded function f can't have a default value
+./bar.gop:4:1: too few arguments in call to f "a"
+	have (untyped string)
+	want (a string, b int)`, `
+func f(a int) {}
+func f(a string, b int = 1) {}
+f "a"
+`)
+	codeErrorTest(t, `./bar.gop:5:34: parameter b of overloaded function Get can't have a default value`, `
+type T struct{}
+
+func (t T) Get(a int) {}
+func (t T) Get(a string, b int = 1) {}
+`)
+}
+
+func TestErrKwargs(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:3:6: unknown keyword argument c in call to f`, `
+func f(a int, b int = 1) {}
+f 1, c = 2
+`)
+	codeErrorTest(t, `./bar.gop:3:6: argument a is passed both by position and by keyword in call to f`, `
+func f(a int, b int = 1) {}
+f 1, a = 2
+`)
+	codeErrorTest(t, `./bar.gop:3:13: duplicate keyword argument b in call to f`, `
+func f(a int, b int = 1) {}
+f 1, b = 2, b = 3
+`)
+	codeErrorTest(t, `./bar.gop:3:1: missing argument a in call to f`, `
+func f(a int, b int = 1) {}
+f b = 2
+`)
+	codeErrorTest(t, `./bar.gop:3:6: cannot pass variadic parameter c by keyword in call to f`, `
+func f(a int, b int = 1, c ...int) {}
+f 1, c = 2
+`)
+	codeErrorTest(t, `./bar.gop:2:12: cannot use keyword arguments in call to println`, `
+println 1, a = 2
+`)
+}
+
+func TestErrRangeExpr(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:2:8: range over 0:10 permits only one iteration variable`, `
+for i, x := range 0:10 {
+}
+`)
+	codeErrorTest(t, `./bar.gop:3:21: invalid operation: 0:1r:n (mismatched types int64 and github.com/goplus/gop/builtin/ng.Bigint)`, `
+var n int64
+println [i for i <- 0:1r:n]
+`)
+	codeErrorTest(t, `./bar.gop:2:15: range step must not be zero`, `
+for i <- 1:10:0 {
+}
+`)
+	codeErrorTest(t, `./bar.gop:2:25: range step must not be zero`, `
+println [x for x <- 0:1:0.0]
+`)
+	codeErrorTest(t, `./bar.gop:2:20: negative range step -1 for unsigned type uint`, `
+for i <- uint(5):0:-1 {
+}
+`)
+}
+
+func TestErrAggregateComprehension(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:2:9: comprehension without brackets is only allowed as argument of sum, min, max, avg or count`, `
+println(x for x <- [1, 2])
+`)
+	codeErrorTest(t, `./bar.gop:2:13: invalid argument: cannot compute min of x > 1 (type bool)`, `
+println min(x > 1 for x <- [1, 2])
+`)
+	codeErrorTest(t, `./bar.gop:3:2: undefined: foo`, `
+n := sum(func() int {
+	foo
+	return x
+}() for x <- [1, 2])
+println n
+`)
+}
+
+func TestErrLazyComprehension(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:2:27: init statement of for phrase is not allowed in lazy comprehension`, `
+it := (x for x <- [1, 2], y := x; y > 1)
+`)
+	codeErrorTest(t, `./bar.gop:6:19: cannot range over foo{} (type foo) in lazy comprehension`, `
+type foo struct{}
+
+func (p foo) Gop_Enum(c func(v int)) {}
+
+it := (v for v <- foo{})
+`)
+}
+
+func TestErrQueryClause(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:2:40: invalid groupby: keys of type []int are not comparable`, `
+println [x for x <- [[1], [2]] groupby x]
+`)
+	codeErrorTest(t, `./bar.gop:2:10: invalid distinct: values of type []int are not comparable`, `
+println [[x] for x <- [1, 2] distinct]
+`)
+	codeErrorTest(t, `./bar.gop:2:10: invalid argument: cannot compute sum of x > 1 (type bool)`, `
+println [sum(x > 1) for x <- [1, 2] groupby x%2]
+`)
+}
+
+func TestErrSetLit(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:2:9: cannot infer element type of empty set`, `
+println set{}
+`)
+	codeErrorTest(t, `./bar.gop:2:10: invalid set: values of type []int are not comparable`, `
+println {[1], [2]}
+`)
+	codeErrorTest(t, `./bar.gop:2:13: invalid set: values of type []int are not comparable`, `
+println set{[x] for x <- [1, 2]}
+`)
+	codeErrorTest(t, `./bar.gop:2:9: invalid operation: 1 in [1, 2] ([]int is not a map and has no method Gop_In)`, `
+println 1 in [1, 2]
+`)
+}
+
+func TestErrCollectionMethod(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:2:9: too few arguments in call to [1].map()`, `
+println [1].map()
+`)
+	codeErrorTest(t, `./bar.gop:2:20: cannot use lambda literal as argument of [1].reduce: want 2 parameters and 1 result`, `
+println [1].reduce(x => x)
+`)
+	codeErrorTest(t, `./bar.gop:2:17: cannot use lambda literal with statements as argument of [1].map, use a lambda expression`, `
+println [1].map(x => {
+	return x
+})
+`)
+	codeErrorTest(t, `./bar.gop:2:17: cannot use 1 (type untyped int) as function argument of [1].map`, `
+println [1].map(1)
+`)
+	codeErrorTest(t, `./bar.gop:2:9: invalid contains: values of type []int are not comparable`, `
+println [[1]].contains([1])
+`)
+	codeErrorTest(t, `./bar.gop:2:22: invalid sortBy: keys of type []int are not ordered`, `
+println [[1]].sortBy(x => x)
+`)
+}
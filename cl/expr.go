@@ -102,6 +102,11 @@ func compileIdent(ctx *blockCtx, ident *ast.Ident, flags int) (obj *gox.PkgRef,
 		}
 	}
 
+	// generic object
+	if gen := ctx.lookupGeneric(ident); gen != nil {
+		panic(newGenericUseError(ctx, gen, ident.Pos()))
+	}
+
 	// global object
 	if ctx.loadSymbol(name) {
 		o, at = scope.Lookup(name), scope
@@ -176,7 +181,9 @@ func compileExprLHS(ctx *blockCtx, expr ast.Expr) {
 	case *ast.Ident:
 		compileIdent(ctx, v, clIdentLHS)
 	case *ast.IndexExpr:
-		compileIndexExprLHS(ctx, v)
+		compileIndexExprLHS(ctx, v.X, []ast.Expr{v.Index}, v)
+	case *ast.IndexListExpr:
+		compileIndexExprLHS(ctx, v.X, v.Indices, v)
 	case *ast.SelectorExpr:
 		compileSelectorExprLHS(ctx, v)
 	case *ast.StarExpr:
@@ -200,6 +207,8 @@ func compileExpr(ctx *blockCtx, expr ast.Expr, inFlags ...int) {
 		compileIdent(ctx, v, flags)
 	case *ast.BasicLit:
 		compileBasicLit(ctx, v)
+	case *ast.StringLitEx:
+		compileStringLitEx(ctx, v)
 	case *ast.CallExpr:
 		flags := 0
 		if inFlags != nil {
@@ -226,6 +235,8 @@ func compileExpr(ctx *blockCtx, expr ast.Expr, inFlags ...int) {
 		compileRangeExpr(ctx, v)
 	case *ast.IndexExpr:
 		compileIndexExpr(ctx, v, twoValue(inFlags))
+	case *ast.IndexListExpr:
+		compileIndexListExpr(ctx, v)
 	case *ast.SliceExpr:
 		compileSliceExpr(ctx, v)
 	case *ast.StarExpr:
@@ -273,15 +284,94 @@ func compileUnaryExpr(ctx *blockCtx, v *ast.UnaryExpr, twoValue bool) {
 }
 
 func compileBinaryExpr(ctx *blockCtx, v *ast.BinaryExpr) {
+	if v.Op == token.IN {
+		compileInExpr(ctx, v)
+		return
+	}
 	compileExpr(ctx, v.X)
 	compileExpr(ctx, v.Y)
 	ctx.cb.BinaryOp(gotoken.Token(v.Op), v)
 }
 
-func compileIndexExprLHS(ctx *blockCtx, v *ast.IndexExpr) {
-	compileExpr(ctx, v.X)
-	compileExpr(ctx, v.Index)
-	ctx.cb.IndexRef(1, v)
+func compileIndexExprLHS(ctx *blockCtx, x ast.Expr, indices []ast.Expr, v ast.Expr) {
+	if p := compileIndexExprLHSEx(ctx, x, indices, v); p != nil {
+		src, pos := ctx.LoadExpr(v)
+		panic(newCodeErrorf(&pos, "cannot assign to overloaded index %s in a multiple assignment", src))
+	}
+}
+
+// compileIndexExprLHSEx is like compileIndexExprLHS, but returns the index
+// expression as a property if x overloads the index operator.
+func compileIndexExprLHSEx(ctx *blockCtx, x ast.Expr, indices []ast.Expr, v ast.Expr) *propertyRef {
+	cb := ctx.cb
+	compileExpr(ctx, x)
+	t := cb.Get(-1).Type
+	if hasOpMethod(ctx, t, "Gop_SetIndex") {
+		p := &propertyRef{
+			x: cb.InternalStack().Pop(), src: v, getter: "Gop_Index", setter: "Gop_SetIndex",
+			readable: hasOpMethod(ctx, t, "Gop_Index"),
+		}
+		for _, index := range indices {
+			compileExpr(ctx, index)
+			p.args = append(p.args, cb.InternalStack().Pop())
+		}
+		return p
+	}
+	if hasOpMethod(ctx, t, "Gop_Index") {
+		src, pos := ctx.LoadExpr(v)
+		panic(newCodeErrorf(&pos, "cannot assign to %s (%v has no method Gop_SetIndex)", src, t))
+	}
+	if len(indices) > 1 {
+		panic(ctx.newCodeErrorf(v.Pos(), "invalid operation: more than one index"))
+	}
+	compileExpr(ctx, indices[0])
+	cb.IndexRef(1, v)
+	return nil
+}
+
+// hasOpMethod reports whether values of type t, which isn't a builtin
+// indexable type, overload the index or slice operator by the method name:
+//
+//   x[i, j]     => x.Gop_Index(i, j)
+//   x[i, j] = v => x.Gop_SetIndex(i, j, v)
+//   x[i:j]      => x.Gop_Slice(i, j)
+func hasOpMethod(ctx *blockCtx, t types.Type, name string) bool {
+	switch u := getUnderlying(ctx, t).(type) {
+	case *types.Slice, *types.Map, *types.Array, *types.Basic, nil:
+		return false
+	case *types.Pointer:
+		elem := u.Elem()
+		if _, ok := getUnderlying(ctx, elem).(*types.Array); ok {
+			return false
+		}
+		if named, ok := elem.(*types.Named); ok {
+			ctx.loadNamed(ctx.pkg, named)
+		}
+	case *types.Struct, *types.Interface:
+		if named, ok := t.(*types.Named); ok {
+			ctx.loadNamed(ctx.pkg, named)
+		}
+	}
+	o, _, _ := types.LookupFieldOrMethod(t, true, ctx.pkg.Types, name)
+	_, ok := o.(*types.Func)
+	return ok
+}
+
+// compileOpMethodCall calls the method name of the value on the top of the
+// stack with args.
+func compileOpMethodCall(ctx *blockCtx, name string, args []ast.Expr, v ast.Expr) {
+	cb := ctx.cb
+	if _, err := cb.Member(name, gox.MemberFlagMethodAlias, v); err != nil {
+		panic(err)
+	}
+	for _, arg := range args {
+		if arg == nil {
+			cb.Val(0)
+		} else {
+			compileExpr(ctx, arg)
+		}
+	}
+	cb.CallWith(len(args), 0, v)
 }
 
 func compileStarExprLHS(ctx *blockCtx, v *ast.StarExpr) { // *x = ...
@@ -304,13 +394,63 @@ func compileTypeAssertExpr(ctx *blockCtx, v *ast.TypeAssertExpr, twoValue bool)
 }
 
 func compileIndexExpr(ctx *blockCtx, v *ast.IndexExpr, twoValue bool) { // x[i]
-	compileExpr(ctx, v.X)
-	compileExpr(ctx, v.Index)
-	ctx.cb.Index(1, twoValue, v)
+	compileIndexExprEx(ctx, v, v.X, []ast.Expr{v.Index}, twoValue, nil)
+}
+
+func compileIndexListExpr(ctx *blockCtx, v *ast.IndexListExpr) { // x[i, j, ...]
+	compileIndexExprEx(ctx, v, v.X, v.Indices, false, nil)
+}
+
+// compileIndexExprEx compiles v = x[indices], which may be an instantiation. If
+// v is a generic function called by call, it returns the arguments of call
+// compiled to infer the missing type arguments.
+func compileIndexExprEx(
+	ctx *blockCtx, v, x ast.Expr, indices []ast.Expr, twoValue bool, call *ast.CallExpr) []*gox.Element {
+	if gen := ctx.lookupGeneric(x); gen != nil {
+		if call != nil {
+			return compileGenericCall(ctx, gen, call, indices)
+		}
+		compileGenericExpr(ctx, gen, v)
+		return nil
+	}
+	compileExpr(ctx, x)
+	cb := ctx.cb
+	t := cb.Get(-1).Type
+	if genericSigOf(t) != nil {
+		return compileGoGenericCall(ctx, call, x, indices, v)
+	}
+	if tt, ok := t.(*gox.TypeType); ok {
+		if orig := genericTypeOf(tt.Type()); orig != nil {
+			cb.InternalStack().Pop()
+			cb.Typ(instantiateGoType(ctx, orig, toTypes(ctx, indices), v), v)
+			return nil
+		}
+	}
+	if hasOpMethod(ctx, t, "Gop_Index") {
+		compileOpMethodCall(ctx, "Gop_Index", indices, v)
+		return nil
+	}
+	if len(indices) > 1 {
+		panic(ctx.newCodeErrorf(v.Pos(), "invalid operation: more than one index"))
+	}
+	compileExpr(ctx, indices[0])
+	cb.Index(1, twoValue, v)
+	return nil
 }
 
 func compileSliceExpr(ctx *blockCtx, v *ast.SliceExpr) { // x[i:j:k]
 	compileExpr(ctx, v.X)
+	if hasOpMethod(ctx, ctx.cb.Get(-1).Type, "Gop_Slice") { // x.Gop_Slice(i, j, k), i is 0 if omitted
+		args := []ast.Expr{v.Low}
+		if v.High != nil {
+			args = append(args, v.High)
+		}
+		if v.Slice3 {
+			args = append(args, v.Max)
+		}
+		compileOpMethodCall(ctx, "Gop_Slice", args, v)
+		return
+	}
 	compileExprOrNone(ctx, v.Low)
 	compileExprOrNone(ctx, v.High)
 	if v.Slice3 {
@@ -320,24 +460,143 @@ func compileSliceExpr(ctx *blockCtx, v *ast.SliceExpr) { // x[i:j:k]
 }
 
 func compileSelectorExprLHS(ctx *blockCtx, v *ast.SelectorExpr) {
+	if p := compileSelectorExprLHSEx(ctx, v); p != nil {
+		src, pos := ctx.LoadExpr(v)
+		panic(newCodeErrorf(&pos, "cannot assign to property %s in a multiple assignment", src))
+	}
+}
+
+// compileSelectorExprLHSEx is like compileSelectorExprLHS, but returns the
+// property if v is a property that has a setter method (nothing is pushed).
+func compileSelectorExprLHSEx(ctx *blockCtx, v *ast.SelectorExpr) *propertyRef {
 	switch x := v.X.(type) {
 	case *ast.Ident:
 		if at, kind := compileIdent(ctx, x, clIdentLHS|clIdentSelectorExpr); kind != objNormal {
 			ctx.cb.VarRef(at.Ref(v.Sel.Name))
-			return
+			return nil
 		}
 	default:
 		compileExpr(ctx, v.X)
 	}
+	if p := checkPropertyRef(ctx, v, v.Sel.Name); p != nil {
+		return p
+	}
 	ctx.cb.MemberRef(v.Sel.Name, v)
+	return nil
 }
 
-func compileSelectorExpr(ctx *blockCtx, v *ast.SelectorExpr, flags int) {
+// propertyRef is a property assigned by its setter method: `x.bar = v` is
+// compiled to `x.SetBar(v)` if x has no field named bar, and `x.bar += v`
+// to `x.SetBar(x.Bar() + v)`. Index expressions of types that overload the
+// index operator are assigned the same way, by Gop_SetIndex and Gop_Index.
+type propertyRef struct {
+	x        *gox.Element   // the receiver
+	args     []*gox.Element // indices
+	src      ast.Expr
+	desc     string // eg. "property bar", used in error messages
+	getter   string
+	setter   string
+	readable bool // the getter method exists
+}
+
+// compilePropertyLHS compiles lhs of an assignment. It returns the property
+// if lhs is a property that has a setter method, or pushes lhs as a reference
+// and returns nil.
+func compilePropertyLHS(ctx *blockCtx, lhs ast.Expr) *propertyRef {
+	switch v := lhs.(type) {
+	case *ast.SelectorExpr:
+		return compileSelectorExprLHSEx(ctx, v)
+	case *ast.IndexExpr:
+		return compileIndexExprLHSEx(ctx, v.X, []ast.Expr{v.Index}, v)
+	case *ast.IndexListExpr:
+		return compileIndexExprLHSEx(ctx, v.X, v.Indices, v)
+	case *ast.Ident:
+		if recv := classRecvOf(ctx, v.Name); recv != nil {
+			ctx.cb.Val(recv)
+			if p := checkPropertyRef(ctx, v, v.Name); p != nil {
+				return p
+			}
+			ctx.cb.InternalStack().Pop()
+		}
+	}
+	compileExprLHS(ctx, lhs)
+	return nil
+}
+
+// classRecvOf returns the receiver of the method being compiled if name isn't
+// a local variable in a Go+ class file, where it may be a member of the class.
+func classRecvOf(ctx *blockCtx, name string) *types.Var {
+	if !ctx.isClass {
+		return nil
+	}
+	if at, o := ctx.cb.Scope().LookupParent(name, token.NoPos); o != nil {
+		if at != ctx.pkg.Types.Scope() && at != types.Universe { // local object
+			return nil
+		}
+	}
+	if fn := ctx.cb.Func(); fn != nil {
+		return fn.Ancestor().Type().(*types.Signature).Recv()
+	}
+	return nil
+}
+
+// checkPropertyRef checks whether member name of the receiver on the top of
+// the stack is a property with a setter method. If it is, the receiver is
+// popped into the returned property.
+func checkPropertyRef(ctx *blockCtx, src ast.Expr, name string) *propertyRef {
+	cb := ctx.cb
+	t := cb.Get(-1).Type
+	pkg := ctx.pkg.Types
+	if o, _, _ := types.LookupFieldOrMethod(t, true, pkg, name); o != nil {
+		if _, ok := o.(*types.Var); ok { // a field
+			return nil
+		}
+	}
+	getter := strings.ToUpper(name[:1]) + name[1:]
+	readable := isPropertyMethod(t, pkg, getter, 0, 1)
+	setter := "Set" + getter
+	if !isPropertyMethod(t, pkg, setter, 1, -1) {
+		if readable {
+			code, pos := ctx.LoadExpr(src)
+			panic(newCodeErrorf(&pos, "cannot assign to %s (property %s is read-only: %v has no method %s)",
+				code, name, t, setter))
+		}
+		return nil
+	}
+	return &propertyRef{
+		x: cb.InternalStack().Pop(), src: src, desc: "property " + name,
+		getter: getter, setter: setter, readable: readable,
+	}
+}
+
+// isPropertyMethod reports whether t has a method named name that takes nin
+// arguments and returns nout results (any number of results if nout < 0).
+func isPropertyMethod(t types.Type, pkg *types.Package, name string, nin, nout int) bool {
+	o, _, _ := types.LookupFieldOrMethod(t, true, pkg, name)
+	fn, ok := o.(*types.Func)
+	if !ok {
+		return false
+	}
+	sig := fn.Type().(*types.Signature)
+	if fns, ok := gox.CheckOverloadMethod(sig); ok {
+		for _, fn := range fns {
+			if sig := fn.Type().(*types.Signature); sig.Params().Len() == nin {
+				return nout < 0 || sig.Results().Len() == nout
+			}
+		}
+		return false
+	}
+	return sig.Params().Len() == nin && (nout < 0 || sig.Results().Len() == nout)
+}
+
+// compileSelectorExpr compiles v, and returns the type of v.X, or nil if v.X
+// is a package.
+func compileSelectorExpr(ctx *blockCtx, v *ast.SelectorExpr, flags int) types.Type {
 	switch x := v.X.(type) {
 	case *ast.Ident:
 		if at, kind := compileIdent(ctx, x, flags|clIdentSelectorExpr); kind != objNormal {
 			if compilePkgRef(ctx, at, v.Sel, flags, kind) {
-				return
+				return nil
 			}
 			if token.IsExported(v.Sel.Name) {
 				panic(ctx.newCodeErrorf(x.Pos(), "undefined: %s.%s", x.Name, v.Sel.Name))
@@ -347,9 +606,72 @@ func compileSelectorExpr(ctx *blockCtx, v *ast.SelectorExpr, flags int) {
 	default:
 		compileExpr(ctx, v.X)
 	}
+	x := ctx.cb.Get(-1).Type
 	if err := compileMember(ctx, v, v.Sel.Name, flags); err != nil {
+		if compileTypeMember(ctx, v) || compileBuiltinMethod(ctx, v, flags) {
+			return nil
+		}
 		panic(err)
 	}
+	return x
+}
+
+// builtinRecv is the type of the receiver of a method of a builtin type, which
+// is pushed after the method to be passed as its first argument; or of a
+// higher-order method of a slice or map, which is compiled by hof.
+type builtinRecv struct {
+	types.Type
+	sel *ast.SelectorExpr
+	hof func(ctx *blockCtx, a *hofCall)
+}
+
+// compileBuiltinMethod compiles method v of a builtin type, like `s.toUpper`
+// to strings.ToUpper(s). Unless it is called, the method is called without
+// arguments, as an auto property.
+func compileBuiltinMethod(ctx *blockCtx, v *ast.SelectorExpr, flags int) bool {
+	cb := ctx.cb
+	recv := cb.Get(-1)
+	fn := ctx.builtinMethod(recv.Type, v.Sel.Name)
+	if fn == nil {
+		hof := collectionMethod(ctx, recv.Type, v.Sel.Name)
+		if hof == nil {
+			return false
+		}
+		if (flags & clIdentCanAutoCall) != 0 {
+			compileCollectionMethod(ctx, v, nil, hof)
+		} else {
+			recv.Type = &builtinRecv{recv.Type, v, hof}
+		}
+		return true
+	}
+	cb.InternalStack().Pop()
+	cb.Val(fn, v)
+	cb.InternalStack().Push(recv)
+	if (flags & clIdentCanAutoCall) != 0 {
+		cb.CallWith(1, 0, v)
+	} else {
+		recv.Type = &builtinRecv{Type: recv.Type}
+	}
+	return true
+}
+
+// compileBuiltinMethodCall compiles call v of a method of a builtin type, whose
+// receiver of type recv is on the top of the stack.
+func compileBuiltinMethodCall(ctx *blockCtx, v *ast.CallExpr, recv *builtinRecv, flags gox.InstrFlags) {
+	cb := ctx.cb
+	if v.Kwargs != nil {
+		src, _ := ctx.LoadExpr(v.Fun)
+		panic(ctx.newCodeErrorf(v.Kwargs[0].Pos(), "cannot use keyword arguments in call to %s", src))
+	}
+	cb.Get(-1).Type = recv.Type
+	if recv.hof != nil {
+		compileCollectionMethod(ctx, recv.sel, v, recv.hof)
+		return
+	}
+	for _, arg := range v.Args {
+		compileExpr(ctx, arg)
+	}
+	cb.CallWith(len(v.Args)+1, flags, v)
 }
 
 func pkgRef(at *gox.PkgRef, name string) (o types.Object, alias bool) {
@@ -463,14 +785,35 @@ func (p *fnType) initWith(fnt types.Type, idx, nin int) {
 }
 
 func compileCallExpr(ctx *blockCtx, v *ast.CallExpr, inFlags int) {
+	var args []*gox.Element // arguments compiled to infer type arguments
+	var recv types.Type     // type of the receiver if a method is called
 	switch fn := v.Fun.(type) {
 	case *ast.Ident:
+		if result, ok := ctx.groupAggs[v]; ok {
+			result()
+			return
+		}
+		if ce, ok := aggregateOf(v); ok {
+			compileAggregateExpr(ctx, fn.Name, ce)
+			return
+		}
+		if gen := ctx.lookupGeneric(fn); gen != nil {
+			args = compileGenericCall(ctx, gen, v, nil)
+			break
+		}
 		compileIdent(ctx, fn, clIdentAllowBuiltin|inFlags)
+	case *ast.IndexExpr:
+		args = compileIndexExprEx(ctx, fn, fn.X, []ast.Expr{fn.Index}, false, v)
+	case *ast.IndexListExpr:
+		args = compileIndexExprEx(ctx, fn, fn.X, fn.Indices, false, v)
 	case *ast.SelectorExpr:
-		compileSelectorExpr(ctx, fn, 0)
+		recv = compileSelectorExpr(ctx, fn, 0)
 	default:
 		compileExpr(ctx, fn)
 	}
+	if args == nil && genericSigOf(ctx.cb.Get(-1).Type) != nil { // generic function of Go
+		args = compileGoGenericCall(ctx, v, v.Fun, nil, v.Fun)
+	}
 	var fn fnType
 	var fnt = ctx.cb.Get(-1).Type
 	var flags gox.InstrFlags
@@ -481,7 +824,11 @@ func compileCallExpr(ctx *blockCtx, v *ast.CallExpr, inFlags int) {
 	if (inFlags & clCallWithTwoValue) != 0 {
 		flags |= gox.InstrFlagTwoValue
 	}
-	for i, arg := range v.Args {
+	if t, ok := fnt.(*builtinRecv); ok { // s.split(sep) => strings.Split(s, sep)
+		compileBuiltinMethodCall(ctx, v, t, flags)
+		return
+	}
+	compileArg := func(i int, arg ast.Expr) (done bool) {
 		switch expr := arg.(type) {
 		case *ast.LambdaExpr:
 			fn.initWith(fnt, i, len(expr.Lhs))
@@ -511,14 +858,38 @@ func compileCallExpr(ctx *blockCtx, v *ast.CallExpr, inFlags int) {
 				ctx.cb.InternalStack().Pop()
 			}
 			compileSliceLit(ctx, expr, t)
-			if typetype {
-				return
-			}
+			return typetype
 		default:
 			compileExpr(ctx, arg)
 		}
+		return false
+	}
+	for i, arg := range v.Args {
+		if args != nil && args[i] != nil {
+			ctx.cb.InternalStack().Push(args[i])
+			continue
+		}
+		if compileArg(i, arg) {
+			return
+		}
 	}
-	ctx.cb.CallWith(len(v.Args), flags, v)
+	nargs := len(v.Args)
+	if args == nil {
+		for _, arg := range callArgs(ctx, v, fnt, recv, ellipsis) { // keyword arguments and defaults
+			if arg.def != nil {
+				ctx.cb.Val(arg.def, v)
+			} else {
+				compileArg(nargs, arg.expr)
+			}
+			nargs++
+		}
+	} else if v.Kwargs != nil {
+		src, _ := ctx.LoadExpr(v.Fun)
+		panic(ctx.newCodeErrorf(v.Kwargs[0].Pos(), "cannot use keyword arguments in call to generic function %s", src))
+	}
+	loadConversions(ctx, fnt, nargs)
+	resolveOverload(ctx, v, ellipsis)
+	ctx.cb.CallWith(nargs, flags, v)
 }
 
 type clLambaFlag string
@@ -632,9 +1003,18 @@ func compileBasicLit(ctx *blockCtx, v *ast.BasicLit) {
 	cb := ctx.cb
 	switch v.Kind {
 	case token.RAT:
-		val := v.Value
-		bi, _ := new(big.Int).SetString(val[:len(val)-1], 10) // remove r suffix
-		cb.UntypedBigInt(bi, v)
+		val := v.Value[:len(v.Value)-1] // remove r suffix
+		if bi, ok := new(big.Int).SetString(val, 0); ok {
+			cb.UntypedBigInt(bi, v)
+		} else if br, ok := new(big.Rat).SetString(val); ok {
+			if br.IsInt() {
+				cb.UntypedBigInt(br.Num(), v)
+			} else {
+				cb.UntypedBigRat(br, v)
+			}
+		} else {
+			panic(ctx.newCodeErrorf(v.Pos(), "invalid rational literal %s", v.Value))
+		}
 	case token.CSTRING:
 		s, err := strconv.Unquote(v.Value)
 		if err != nil {
@@ -653,6 +1033,105 @@ func compileBasicLit(ctx *blockCtx, v *ast.BasicLit) {
 	}
 }
 
+func compileStringLitEx(ctx *blockCtx, v *ast.StringLitEx) {
+	cb := ctx.cb
+	for i, part := range v.Parts {
+		if lit, ok := part.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			compileBasicLit(ctx, lit)
+		} else {
+			compileExpr(ctx, part)
+			compileStringOf(ctx)
+		}
+		if i > 0 {
+			cb.BinaryOp(gotoken.ADD, part)
+		}
+	}
+	if len(v.Parts) == 0 {
+		cb.Val("", v)
+	}
+}
+
+// compileStringOf converts the value on the top of the stack to a string the
+// way fmt.Sprint does.
+func compileStringOf(ctx *blockCtx) {
+	pkg, cb := ctx.pkg, ctx.cb
+	stk := cb.InternalStack()
+	x := stk.Pop()
+	t := x.Type
+	call := func(pkgPath, name string, conv types.Type, args ...interface{}) {
+		cb.Val(pkg.Import(pkgPath).Ref(name))
+		if conv != nil && !types.Identical(t, conv) && !isUntyped(t) {
+			cb.Typ(conv)
+			stk.Push(x)
+			cb.Call(1)
+		} else {
+			stk.Push(x)
+		}
+		for _, arg := range args {
+			cb.Val(arg)
+		}
+		cb.Call(1 + len(args))
+	}
+	if name := stringMethodOf(pkg, t); name != "" {
+		stk.Push(x)
+		cb.MemberVal(name).Call(0)
+		return
+	}
+	tyString := types.Typ[types.String]
+	if bt, ok := t.Underlying().(*types.Basic); ok {
+		switch bt.Kind() {
+		case types.String, types.UntypedString:
+			if types.Identical(t, tyString) || isUntyped(t) {
+				stk.Push(x)
+			} else {
+				cb.Typ(tyString)
+				stk.Push(x)
+				cb.Call(1)
+			}
+			return
+		case types.Bool, types.UntypedBool:
+			call("strconv", "FormatBool", types.Typ[types.Bool])
+			return
+		case types.Int, types.UntypedInt, types.UntypedRune:
+			call("strconv", "Itoa", types.Typ[types.Int])
+			return
+		case types.Int8, types.Int16, types.Int32, types.Int64:
+			call("strconv", "FormatInt", types.Typ[types.Int64], 10)
+			return
+		case types.Uint, types.Uint8, types.Uint16, types.Uint32, types.Uint64, types.Uintptr:
+			call("strconv", "FormatUint", types.Typ[types.Uint64], 10)
+			return
+		case types.Float32:
+			call("strconv", "FormatFloat", types.Typ[types.Float64], 'g', -1, 32)
+			return
+		case types.Float64, types.UntypedFloat:
+			call("strconv", "FormatFloat", types.Typ[types.Float64], 'g', -1, 64)
+			return
+		}
+	}
+	call("fmt", "Sprint", nil)
+}
+
+// stringMethodOf returns "Error" or "String" if t has such a method that
+// fmt.Sprint uses to format a non-nil value of t.
+func stringMethodOf(pkg *gox.Package, t types.Type) string {
+	switch t.Underlying().(type) {
+	case *types.Interface, *types.Pointer:
+		return "" // may be nil
+	}
+	for _, name := range [...]string{"Error", "String"} {
+		obj, _, _ := types.LookupFieldOrMethod(t, false, pkg.Types, name)
+		if fn, ok := obj.(*types.Func); ok {
+			sig := fn.Type().(*types.Signature)
+			if sig.Params().Len() == 0 && sig.Results().Len() == 1 &&
+				types.Identical(sig.Results().At(0).Type(), types.Typ[types.String]) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
 const (
 	compositeLitVal    = 0
 	compositeLitKeyVal = 1
@@ -769,6 +1248,10 @@ func compileCompositeLit(ctx *blockCtx, v *ast.CompositeLit, expected types.Type
 	var hasPtr bool
 	var typ, underlying types.Type
 	var kind = checkCompositeLitElts(ctx, v.Elts)
+	if v.Type != nil && isSetIdent(ctx, v.Type) { // set{v1, v2, ...}
+		compileSetLit(ctx, v, nil)
+		return
+	}
 	if v.Type != nil {
 		typ = toType(ctx, v.Type)
 		underlying = getUnderlying(ctx, typ)
@@ -794,12 +1277,16 @@ func compileCompositeLit(ctx *blockCtx, v *ast.CompositeLit, expected types.Type
 		}
 		return
 	}
-	compileCompositeLitElts(ctx, v.Elts, kind, &kvType{underlying: underlying})
 	n := len(v.Elts)
-	if typ == nil {
-		if kind == compositeLitVal && n > 0 {
-			panic("TODO: mapLit should be in {key: val, ...} form")
+	if kind == compositeLitVal && n > 0 && (typ == nil || isSetMap(underlying)) { // {v1, v2, ...}
+		compileSetLit(ctx, v, typ)
+		if hasPtr {
+			ctx.cb.UnaryOp(gotoken.AND)
 		}
+		return
+	}
+	compileCompositeLitElts(ctx, v.Elts, kind, &kvType{underlying: underlying})
+	if typ == nil {
 		ctx.cb.MapLit(nil, n<<1)
 		return
 	}
@@ -869,7 +1356,25 @@ func comprehensionKind(v *ast.ComprehensionExpr) int {
 // {for k, v <- container, cond}
 // {expr for k, v <- container, cond}
 // {kexpr: vexpr for k, v <- container, cond}
+// (expr for k, v <- container, cond)
+// [expr for k, v <- container, cond orderby key ...]
+// set{expr for k, v <- container, cond}
 func compileComprehensionExpr(ctx *blockCtx, v *ast.ComprehensionExpr, twoValue bool) {
+	switch v.Tok {
+	case token.LPAREN:
+		compileLazyExpr(ctx, v)
+		return
+	case token.FOR:
+		panic(ctx.newCodeErrorf(v.Pos(), "comprehension without brackets is only allowed as argument of %s", aggregateNames))
+	}
+	if v.Query != nil {
+		compileQueryExpr(ctx, v)
+		return
+	}
+	if v.Type != nil {
+		compileSetComprehension(ctx, v)
+		return
+	}
 	kind := comprehensionKind(v)
 	pkg, cb := ctx.pkg, ctx.cb
 	var results *types.Tuple
@@ -890,30 +1395,7 @@ func compileComprehensionExpr(ctx *blockCtx, v *ast.ComprehensionExpr, twoValue
 	if kind == comprehensionMap {
 		cb.VarRef(ret).ZeroLit(ret.Type()).Assign(1)
 	}
-	end := 0
-	for i := len(v.Fors) - 1; i >= 0; i-- {
-		names := make([]string, 0, 2)
-		forStmt := v.Fors[i]
-		if forStmt.Key != nil {
-			names = append(names, forStmt.Key.Name)
-		} else {
-			names = append(names, "_")
-		}
-		names = append(names, forStmt.Value.Name)
-		cb.ForRange(names...)
-		compileExpr(ctx, forStmt.X)
-		cb.RangeAssignThen(forStmt.TokPos)
-		if forStmt.Cond != nil {
-			cb.If()
-			if forStmt.Init != nil {
-				compileStmt(ctx, forStmt.Init)
-			}
-			compileExpr(ctx, forStmt.Cond)
-			cb.Then()
-			end++
-		}
-		end++
-	}
+	end := compileForPhrases(ctx, v.Fors)
 	switch kind {
 	case comprehensionList:
 		// _gop_ret = append(_gop_ret, elt)
@@ -946,12 +1428,96 @@ func compileComprehensionExpr(ctx *blockCtx, v *ast.ComprehensionExpr, twoValue
 			cb.Return(n)
 		}
 	}
-	for i := 0; i < end; i++ {
-		cb.End()
-	}
+	end()
 	cb.Return(0).End().Call(0)
 }
 
+// inferTypes learns the types of expressions that the code to emit depends on
+// (eg. the type of the values of a comprehension), by compiling fn into a
+// closure with parameters params, which is dropped.
+//
+// The code of fn is compiled again afterwards, so the errors reported during
+// this dry run are discarded. And in a dry run, the expressions that infer
+// types themselves only push a zero value of their type (see dryResult), so
+// nested ones are compiled a quadratic rather than exponential number of times.
+func inferTypes(ctx *blockCtx, params *types.Tuple, fn func()) {
+	pkg, cb := ctx.pkg, ctx.cb
+	nerrs := len(ctx.errs)
+	ctx.inferring++
+	defer func() { ctx.inferring-- }()
+	cb.NewClosure(params, nil, false).BodyStart(pkg)
+	fn()
+	cb.End()
+	cb.InternalStack().Pop()
+	ctx.errs = ctx.errs[:nerrs]
+}
+
+// dryResult pushes the zero value of typ instead of the code of an expression
+// of type typ, if types are being inferred by inferTypes.
+func dryResult(ctx *blockCtx, typ types.Type) bool {
+	if ctx.inferring == 0 {
+		return false
+	}
+	ctx.cb.ZeroLit(typ)
+	return true
+}
+
+// comprehensionEltType returns the type of the values of comprehension v, by
+// compiling its loops in a dry run.
+func comprehensionEltType(ctx *blockCtx, v *ast.ComprehensionExpr) (typ types.Type) {
+	cb := ctx.cb
+	inferTypes(ctx, nil, func() {
+		end := compileForPhrases(ctx, v.Fors)
+		compileExpr(ctx, v.Elt)
+		typ = cb.InternalStack().Pop().Type
+		end()
+	})
+	return gox.Default(ctx.pkg, typ)
+}
+
+// compileForPhrases starts the loops of for phrases fors of a comprehension,
+// and returns a function ending them.
+func compileForPhrases(ctx *blockCtx, fors []*ast.ForPhrase) (end func()) {
+	cb := ctx.cb
+	var ends []func()
+	endBlock := func() { cb.End() }
+	for i := len(fors) - 1; i >= 0; i-- {
+		forStmt := fors[i]
+		if re, ok := forStmt.X.(*ast.RangeExpr); ok { // no iterator for ranges
+			if forStmt.Key != nil {
+				panic(errRangeVars(ctx, forStmt.Key, re))
+			}
+			ends = append(ends, compileRangeLoop(ctx, forStmt.For, forStmt.Value, re, token.DEFINE))
+		} else {
+			names := make([]string, 0, 2)
+			if forStmt.Key != nil {
+				names = append(names, forStmt.Key.Name)
+			} else {
+				names = append(names, "_")
+			}
+			names = append(names, forStmt.Value.Name)
+			cb.ForRange(names...)
+			compileForPhraseX(ctx, forStmt.X)
+			cb.RangeAssignThen(forStmt.TokPos)
+			ends = append(ends, endBlock)
+		}
+		if forStmt.Cond != nil {
+			cb.If()
+			if forStmt.Init != nil {
+				compileStmt(ctx, forStmt.Init)
+			}
+			compileExpr(ctx, forStmt.Cond)
+			cb.Then()
+			ends = append(ends, endBlock)
+		}
+	}
+	return func() {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i]()
+		}
+	}
+}
+
 var (
 	tyError = types.Universe.Lookup("error").Type()
 )
@@ -959,8 +1525,17 @@ var (
 func compileErrWrapExpr(ctx *blockCtx, v *ast.ErrWrapExpr) {
 	pkg, cb := ctx.pkg, ctx.cb
 	useClosure := v.Tok == token.NOT || v.Default != nil
-	if !useClosure && (cb.Scope().Parent() == types.Universe) {
-		panic("TODO: can't use expr? in global")
+	fn := cb.Func()
+	exitOnErr := false
+	if !useClosure {
+		if fn == nil {
+			panic(ctx.newCodeErrorf(
+				v.TokPos, "can't use expr? in a package level initializer, use expr! or expr?:defval instead"))
+		}
+		if exitOnErr = isMainFunc(ctx, fn); !exitOnErr && !returnsError(fn) {
+			panic(ctx.newCodeErrorf(
+				v.TokPos, "can't use expr? in a function whose last result isn't an error"))
+		}
 	}
 
 	compileExpr(ctx, v.X)
@@ -1002,10 +1577,17 @@ func compileErrWrapExpr(ctx *blockCtx, v *ast.ErrWrapExpr) {
 	cb.Assign(n+1, 1)
 
 	cb.If().Val(err).CompareNil(gotoken.NEQ).Then()
+	if v.Default == nil {
+		compileErrFrame(ctx, v, err, fn)
+	}
 	if v.Tok == token.NOT { // expr!
-		cb.Val(pkg.Builtin().Ref("panic")).Val(err).Call(1).EndStmt() // TODO: wrap err
+		cb.Val(pkg.Builtin().Ref("panic")).Val(err).Call(1).EndStmt()
+	} else if exitOnErr { // expr? in main
+		os := pkg.Import("os")
+		cb.Val(pkg.Import("fmt").Ref("Fprintln")).Val(os.Ref("Stderr")).Val(err).Call(2).EndStmt()
+		cb.Val(os.Ref("Exit")).Val(1).Call(1).EndStmt()
 	} else if v.Default == nil { // expr?
-		cb.Val(err).ReturnErr(true) // TODO: wrap err & return err
+		cb.Val(err).ReturnErr(true)
 	} else { // expr?:val
 		compileExpr(ctx, v.Default)
 		cb.Return(1)
@@ -1016,4 +1598,81 @@ func compileErrWrapExpr(ctx *blockCtx, v *ast.ErrWrapExpr) {
 	}
 }
 
+// compileErrFrame wraps err in an error frame that records the Go+ source
+// position and code of v, and the enclosing function fn with its arguments.
+// The context message of v if any follows the code, eg. `load()?: reading`,
+// rather than wrapped into err, so err is still the inner frame.
+func compileErrFrame(ctx *blockCtx, v *ast.ErrWrapExpr, err types.Object, fn *gox.Func) {
+	pkg, cb := ctx.pkg, ctx.cb
+	code, _ := ctx.LoadExpr(v.X)
+	pos := ctx.fset.Position(v.X.Pos())
+	if ctx.relativePath {
+		pos.Filename = relFile(ctx.targetDir, pos.Filename)
+	}
+	cb.VarRef(err).Val(pkg.Import("github.com/qiniu/x/errors").Ref("NewFrame")).Val(err)
+	code += v.Tok.String()
+	if v.Msg != nil {
+		cb.Val(code + ": ")
+		compileExpr(ctx, v.Msg)
+		cb.BinaryOp(gotoken.ADD, v.Msg)
+	} else {
+		cb.Val(code)
+	}
+	cb.Val(pos.Filename).Val(pos.Line).Val(funcFullName(ctx, fn))
+	args := funcArgs(fn)
+	for _, arg := range args {
+		cb.Val(arg)
+	}
+	cb.Call(5 + len(args)).Assign(1)
+}
+
+// isMainFunc reports whether fn is the entry of the main package, where the
+// top level statements of a Go+ script go.
+func isMainFunc(ctx *blockCtx, fn *gox.Func) bool {
+	return fn.Ancestor() == fn && fn.Name() == "main" && ctx.pkg.Types.Name() == "main" &&
+		fn.Type().(*types.Signature).Recv() == nil
+}
+
+// returnsError reports whether the last result of fn is an error.
+func returnsError(fn *gox.Func) bool {
+	results := fn.Type().(*types.Signature).Results()
+	n := results.Len()
+	return n > 0 && types.Identical(results.At(n-1).Type(), tyError)
+}
+
+// funcFullName returns the name of fn (or the function enclosing it if it's a
+// closure) the way the runtime reports it, eg. main.(*T).Load, or main.init if
+// fn is nil (a package level expression).
+func funcFullName(ctx *blockCtx, fn *gox.Func) string {
+	name := ctx.pkg.Types.Name() + "."
+	if fn == nil {
+		return name + "init"
+	}
+	fn = fn.Ancestor()
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		if t, ok := recv.Type().(*types.Pointer); ok {
+			name += "(*" + t.Elem().(*types.Named).Obj().Name() + ")."
+		} else {
+			name += recv.Type().(*types.Named).Obj().Name() + "."
+		}
+	}
+	return name + fn.Name()
+}
+
+// funcArgs returns the parameters of fn, or nil if fn is a closure or some of
+// them are unnamed.
+func funcArgs(fn *gox.Func) []*types.Var {
+	if fn == nil || fn.Ancestor() != fn {
+		return nil
+	}
+	params := fn.Type().(*types.Signature).Params()
+	args := make([]*types.Var, params.Len())
+	for i := range args {
+		if args[i] = params.At(i); args[i].Name() == "" || args[i].Name() == "_" {
+			return nil
+		}
+	}
+	return args
+}
+
 // -----------------------------------------------------------------------------
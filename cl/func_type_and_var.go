@@ -23,6 +23,7 @@ import (
 	"math/big"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/goplus/gop/ast"
 	"github.com/goplus/gop/token"
@@ -32,6 +33,13 @@ import (
 // -----------------------------------------------------------------------------
 
 func toFuncType(ctx *blockCtx, typ *ast.FuncType, recv *types.Var) *types.Signature {
+	checkNoDefaults(ctx, typ.Params)
+	return toSignature(ctx, typ, recv)
+}
+
+// toSignature is like toFuncType, but allows default values of parameters,
+// which function declarations can have.
+func toSignature(ctx *blockCtx, typ *ast.FuncType, recv *types.Var) *types.Signature {
 	params, variadic := toParams(ctx, typ.Params.List)
 	results := toResults(ctx, typ.Results)
 	return types.NewSignature(recv, params, results, variadic)
@@ -119,6 +127,8 @@ func toType(ctx *blockCtx, typ ast.Expr) types.Type {
 		return toMapType(ctx, v)
 	case *ast.StructType:
 		return toStructType(ctx, v)
+	case *ast.EnumType:
+		return toEnumType(ctx, v)
 	case *ast.ChanType:
 		return toChanType(ctx, v)
 	case *ast.FuncType:
@@ -127,6 +137,8 @@ func toType(ctx *blockCtx, typ ast.Expr) types.Type {
 		return toExternalType(ctx, v)
 	case *ast.ParenExpr:
 		return toType(ctx, v.X)
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return toTypeInstance(ctx, v)
 	}
 	log.Panicln("toType: unknown -", reflect.TypeOf(typ))
 	return nil
@@ -145,6 +157,15 @@ func toChanType(ctx *blockCtx, v *ast.ChanType) *types.Chan {
 }
 
 func toExternalType(ctx *blockCtx, v *ast.SelectorExpr) types.Type {
+	t := lookupExternalType(ctx, v)
+	if genericTypeOf(t) != nil {
+		src, _ := ctx.LoadExpr(v)
+		panic(ctx.newCodeErrorf(v.Pos(), "cannot use generic type %s without instantiation", src))
+	}
+	return t
+}
+
+func lookupExternalType(ctx *blockCtx, v *ast.SelectorExpr) types.Type {
 	name := v.X.(*ast.Ident).Name
 	if pr, ok := ctx.findImport(name); ok {
 		o := pr.TryRef(v.Sel.Name)
@@ -166,6 +187,12 @@ Name context:
 // ---------------------------------------------------------------------------*/
 
 func toIdentType(ctx *blockCtx, ident *ast.Ident) types.Type {
+	if t, ok := ctx.tparams[ident.Name]; ok {
+		return t
+	}
+	if gen := ctx.lookupGeneric(ident); gen != nil {
+		panic(newGenericUseError(ctx, gen, ident.Pos()))
+	}
 	v, builtin := lookupType(ctx, ident.Name)
 	if isBuiltin(builtin) {
 		panic(ctx.newCodeErrorf(ident.Pos(), "use of builtin %s not in function call", ident.Name))
@@ -267,7 +294,11 @@ func getTypeName(typ types.Type) string {
 	}
 	switch t := typ.(type) {
 	case *types.Named:
-		return t.Obj().Name()
+		name := t.Obj().Name()
+		if i := strings.IndexByte(name, '['); i > 0 { // instance of a generic type
+			name = name[:i]
+		}
+		return name
 	case *types.Basic:
 		return t.Name()
 	default:
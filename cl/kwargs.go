@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	goast "go/ast"
+	"go/types"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gox"
+)
+
+// -----------------------------------------------------------------------------
+
+// A parameter of a function declaration can have a default value, which must
+// be a constant: `func connect(host string, timeout int = 30)`. It is compiled
+// to a constant named after the function and the parameter, so that packages
+// importing the function see it too:
+//
+//   const Gopd_connect__timeout int = 30          // for func connect
+//   const Gopd_Client_Get__timeout int = 30       // for method (*Client).Get
+//
+// A call can omit the arguments of parameters with default values, and pass
+// arguments by name after the positional ones: `connect host, timeout = 5`.
+// Parameters of overloaded functions can't have default values.
+
+func defaultName(recv types.Type, fn, param string) string {
+	if recv != nil {
+		if t, ok := recv.(*types.Pointer); ok {
+			recv = t.Elem()
+		}
+		if t, ok := recv.(*types.Named); ok {
+			fn = t.Obj().Name() + "_" + fn
+		}
+	}
+	return "Gopd_" + fn + "__" + param
+}
+
+func checkNoDefaults(ctx *blockCtx, params *ast.FieldList) {
+	for _, fld := range params.List {
+		if fld.Default != nil {
+			panic(ctx.newCodeErrorf(fld.Default.Pos(), "default values of parameters are only allowed in function declarations"))
+		}
+	}
+}
+
+// declDefaults declares the default values of parameters of function name.
+func declDefaults(ctx *blockCtx, recv *types.Var, name string, d *ast.FuncDecl) {
+	var hasDefault bool
+	list := d.Type.Params.List
+	for i, fld := range list {
+		if fld.Default == nil {
+			if hasDefault {
+				if _, ok := fld.Type.(*ast.Ellipsis); !ok || i != len(list)-1 {
+					pos := ctx.Position(fld.Pos())
+					ctx.handleCodeErrorf(&pos, "missing default value of parameter %s", fld.Names[0].Name)
+				}
+			}
+			continue
+		}
+		if ctx.overloadOf(d) != nil { // the overload called isn't known until its arguments are
+			pos := ctx.Position(fld.Default.Pos())
+			ctx.handleCodeErrorf(&pos, "parameter %s of overloaded function %s can't have a default value",
+				fld.Names[0].Name, d.Name.Name)
+			return
+		}
+		hasDefault = true
+		if _, ok := fld.Type.(*ast.Ellipsis); ok {
+			pos := ctx.Position(fld.Default.Pos())
+			ctx.handleCodeErrorf(&pos, "variadic parameter %s can't have a default value", fld.Names[0].Name)
+			continue
+		}
+		typ := toType(ctx, fld.Type)
+		var recvType types.Type
+		if recv != nil {
+			recvType = recv.Type()
+		}
+		scope := ctx.pkg.Types.Scope()
+		for _, param := range fld.Names {
+			cname := defaultName(recvType, name, param.Name)
+			def := fld.Default
+			ctx.pkg.NewConstDefs(scope).New(func(cb *gox.CodeBuilder) int {
+				compileExpr(ctx, def)
+				if cb.Get(-1).CVal == nil {
+					src, pos := ctx.LoadExpr(def)
+					panic(newCodeErrorf(&pos, "default value %s of parameter %s is not a constant", src, param.Name))
+				}
+				return 1
+			}, 0, def.Pos(), typ, cname)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+type callArg struct {
+	expr ast.Expr     // keyword argument
+	def  types.Object // default value
+}
+
+// callArgs returns the arguments of the parameters after the positional
+// arguments of call v, which are keyword arguments or default values. The
+// positional arguments are on the stack.
+func callArgs(ctx *blockCtx, v *ast.CallExpr, fnt, recv types.Type, ellipsis bool) []callArg {
+	sig, ok := fnt.(*types.Signature)
+	if !ok || ellipsis {
+		if v.Kwargs != nil {
+			src, _ := ctx.LoadExpr(v.Fun)
+			panic(ctx.newCodeErrorf(v.Kwargs[0].Pos(), "cannot use keyword arguments in call to %s", src))
+		}
+		return nil
+	}
+	params := sig.Params()
+	n := params.Len()
+	if sig.Variadic() {
+		n--
+	}
+	nargs := len(v.Args)
+	if v.Kwargs == nil && nargs >= n {
+		return nil
+	}
+	fun, _ := ctx.LoadExpr(v.Fun)
+	kwargs := make(map[string]*ast.KwargExpr, len(v.Kwargs))
+	for _, kw := range v.Kwargs {
+		name := kw.Name.Name
+		if _, ok := kwargs[name]; ok {
+			panic(ctx.newCodeErrorf(kw.Pos(), "duplicate keyword argument %s in call to %s", name, fun))
+		}
+		i := paramIndex(params, name)
+		switch {
+		case i < 0:
+			panic(ctx.newCodeErrorf(kw.Pos(), "unknown keyword argument %s in call to %s", name, fun))
+		case i >= n:
+			panic(ctx.newCodeErrorf(kw.Pos(), "cannot pass variadic parameter %s by keyword in call to %s", name, fun))
+		case i < nargs:
+			panic(ctx.newCodeErrorf(kw.Pos(), "argument %s is passed both by position and by keyword in call to %s", name, fun))
+		}
+		kwargs[name] = kw
+	}
+	callee := calleeOf(ctx, v, sig, recv)
+	var ret []callArg
+	for i := nargs; i < n; i++ {
+		name := params.At(i).Name()
+		if kw, ok := kwargs[name]; ok {
+			ret = append(ret, callArg{expr: kw.Value})
+			continue
+		}
+		if def := defaultOf(callee, name); def != nil {
+			ret = append(ret, callArg{def: def})
+			continue
+		}
+		if v.Kwargs == nil && i == nargs { // let gox report not enough arguments
+			return nil
+		}
+		panic(ctx.newCodeErrorf(v.Pos(), "missing argument %s in call to %s", name, fun))
+	}
+	return ret
+}
+
+func paramIndex(params *types.Tuple, name string) int {
+	for i, n := 0, params.Len(); i < n; i++ {
+		if params.At(i).Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// calleeOf returns the function or method called by v, or nil if it isn't a
+// declared function or method.
+func calleeOf(ctx *blockCtx, v *ast.CallExpr, sig *types.Signature, recv types.Type) *types.Func {
+	fn := ctx.cb.Get(-len(v.Args) - 1)
+	var name string
+	switch fv := fn.Val.(type) {
+	case *goast.Ident:
+		name = fv.Name
+	case *goast.SelectorExpr:
+		name = fv.Sel.Name
+	default:
+		return nil
+	}
+	if recv != nil {
+		o, _, _ := types.LookupFieldOrMethod(recv, true, ctx.pkg.Types, name)
+		if f, ok := o.(*types.Func); ok && f.Type().(*types.Signature).Params() == sig.Params() {
+			return f
+		}
+		return nil
+	}
+	var scope *types.Scope
+	switch x := v.Fun.(type) {
+	case *ast.Ident:
+		scope = ctx.pkg.Types.Scope()
+	case *ast.SelectorExpr:
+		if id, ok := x.X.(*ast.Ident); ok {
+			if pr, ok := ctx.findImport(id.Name); ok {
+				scope = pr.Types.Scope()
+			}
+		}
+	}
+	if scope != nil {
+		if f, ok := scope.Lookup(name).(*types.Func); ok && f.Type() == sig {
+			return f
+		}
+	}
+	return nil
+}
+
+func defaultOf(fn *types.Func, param string) types.Object {
+	if fn == nil || fn.Pkg() == nil {
+		return nil
+	}
+	var recv types.Type
+	if r := fn.Type().(*types.Signature).Recv(); r != nil {
+		recv = r.Type()
+	}
+	if o, ok := fn.Pkg().Scope().Lookup(defaultName(recv, fn.Name(), param)).(*types.Const); ok {
+		return o
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,441 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	gotoken "go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+	"github.com/goplus/gox"
+)
+
+// -----------------------------------------------------------------------------
+
+// A lazy comprehension `(elt for k, v <- container, cond ...)` yields its
+// values one by one instead of collecting them to a slice. It is compiled to a
+// value of type Gop_Lazy_T (T is the type of elt), which implements the
+// Gop_Enum iterator protocol:
+//
+//   type Gop_Lazy_T func() (T, bool)
+//
+//   func (p Gop_Lazy_T) Gop_Enum() Gop_Lazy_T { return p }
+//   func (p Gop_Lazy_T) Next() (T, bool)     { return p() }
+//
+// Lazy comprehensions of values of a local type or a type parameter are of type
+// Gop_Lazy[T] instead, which is declared by gopLazySrc with Go generics.
+//
+// As a Go function can't be suspended, the loops of the for phrases are turned
+// into a state machine, where _gop_lv is the loop being iterated:
+//
+//   func() Gop_Lazy_T {
+//       var k0, v0 K0, V0 // variables and cursors of the loops
+//       var _gop_c0 C0
+//       var _gop_i0 int
+//       ...
+//       _gop_c0, _gop_i0 = container0, -1
+//       _gop_lv := 0
+//       return func() (T, bool) {
+//           for {
+//               switch _gop_lv {
+//               case 0:
+//                   if _gop_i0++; _gop_i0 >= len(_gop_c0) {
+//                       _gop_lv--
+//                       continue
+//                   }
+//                   k0, v0 = _gop_i0, _gop_c0[_gop_i0]
+//                   if cond0 {
+//                       _gop_lv = 1
+//                       ... // start the loop over container1
+//                   }
+//               ...
+//               case n: // the innermost loop
+//                   ...
+//                   if condn {
+//                       return elt, true
+//                   }
+//               default:
+//                   return zero, false
+//               }
+//           }
+//       }
+//   }()
+//
+// The outermost container is evaluated with the lazy comprehension, and the
+// others when their loops start.
+
+const (
+	lazyIndex  = iota // slice, array or pointer to array
+	lazyString        // string
+	lazyMap           // map, whose keys are collected when the loop starts
+	lazyChan          // channel
+	lazyIter          // Gop_Enum() iterator, which is kept as its Next method
+)
+
+const gopLazySrc = `
+type Gop_Lazy[T any] func() (T, bool)
+
+func (p Gop_Lazy[T]) Gop_Enum() Gop_Lazy[T] {
+	return p
+}
+
+func (p Gop_Lazy[T]) Next() (T, bool) {
+	return p()
+}
+`
+
+type lazyLoop struct {
+	*ast.ForPhrase
+	kind     int
+	typ      types.Type // type of the cursor _gop_c
+	key, val types.Type
+	conv     bool // convert container to string
+	next     int  // number of results of Next
+
+	k, v       types.Object // loop variables; nil if they are not used
+	c, i, n, a types.Object // container, index, rune width and keys of map
+}
+
+func compileLazyExpr(ctx *blockCtx, v *ast.ComprehensionExpr) {
+	pkg, cb := ctx.pkg, ctx.cb
+	loops, elt := lazyLoops(ctx, v)
+	lazy := lazyType(ctx, elt, v)
+	if dryResult(ctx, lazy) {
+		return
+	}
+	cb.NewClosure(nil, types.NewTuple(pkg.NewParam(token.NoPos, "", lazy)), false).BodyStart(pkg)
+	used := lazyIdents(v)
+	var ok types.Object
+	for i, l := range loops {
+		l.declare(ctx, i, used)
+		if ok == nil && (l.kind == lazyChan || l.kind == lazyIter) {
+			cb.NewVar(types.Typ[types.Bool], "_gop_ok")
+			ok = cb.Scope().Lookup("_gop_ok")
+		}
+	}
+	loops[0].start(ctx)
+	cb.DefineVarStart(token.NoPos, "_gop_lv").Val(0).EndInit(1)
+	lv := cb.Scope().Lookup("_gop_lv")
+
+	results := types.NewTuple(
+		pkg.NewParam(token.NoPos, "", elt), pkg.NewParam(token.NoPos, "", types.Typ[types.Bool]))
+	cb.NewClosure(nil, results, false).BodyStart(pkg)
+	cb.For().None().Then()
+	cb.Switch().Val(lv).Then()
+	last := len(loops) - 1
+	for i, l := range loops {
+		cb.Val(i).Case(1)
+		l.advance(ctx, lv, ok)
+		if l.Cond != nil {
+			cb.If()
+			compileExpr(ctx, l.Cond)
+			cb.Then()
+		}
+		if i == last {
+			compileExpr(ctx, v.Elt)
+			cb.Val(true).Return(2)
+		} else {
+			cb.VarRef(lv).Val(i + 1).Assign(1)
+			loops[i+1].start(ctx)
+		}
+		if l.Cond != nil {
+			cb.End()
+		}
+		cb.End()
+	}
+	cb.Case(0).ZeroLit(elt).Val(false).Return(2).End()
+	cb.End() // switch
+	cb.End() // for
+	cb.End().Return(1).End().Call(0)
+}
+
+// lazyLoops returns the loops of lazy comprehension v from the outermost one,
+// and the type of its values, by compiling v to a closure which is dropped.
+func lazyLoops(ctx *blockCtx, v *ast.ComprehensionExpr) ([]*lazyLoop, types.Type) {
+	pkg, cb := ctx.pkg, ctx.cb
+	loops := make([]*lazyLoop, 0, len(v.Fors))
+	var elt types.Type
+	inferTypes(ctx, nil, func() {
+		for i := len(v.Fors) - 1; i >= 0; i-- {
+			f := v.Fors[i]
+			if f.Init != nil {
+				panic(ctx.newCodeErrorf(f.Init.Pos(), "init statement of for phrase is not allowed in lazy comprehension"))
+			}
+			names := make([]string, 0, 2)
+			if f.Key != nil {
+				names = append(names, f.Key.Name)
+			} else {
+				names = append(names, "_")
+			}
+			names = append(names, f.Value.Name)
+			cb.ForRange(names...)
+			compileForPhraseX(ctx, f.X)
+			typ := gox.Default(pkg, cb.Get(-1).Type)
+			cb.RangeAssignThen(f.TokPos)
+			loops = append(loops, newLazyLoop(ctx, f, typ))
+			if f.Cond != nil {
+				cb.If()
+				compileExpr(ctx, f.Cond)
+				cb.Then()
+			}
+		}
+		compileExpr(ctx, v.Elt)
+		elt = gox.Default(pkg, cb.InternalStack().Pop().Type)
+		for _, l := range loops {
+			if l.Cond != nil {
+				cb.End()
+			}
+			cb.End()
+		}
+	})
+	return loops, elt
+}
+
+func newLazyLoop(ctx *blockCtx, f *ast.ForPhrase, typ types.Type) *lazyLoop {
+	l := &lazyLoop{ForPhrase: f, typ: typ}
+	if m, _, _ := types.LookupFieldOrMethod(typ, false, ctx.pkg.Types, "Gop_Enum"); m != nil {
+		if sig := m.Type().(*types.Signature); sig.Params().Len() == 0 && sig.Results().Len() == 1 {
+			it := sig.Results().At(0).Type()
+			if next, _, _ := types.LookupFieldOrMethod(it, false, ctx.pkg.Types, "Next"); next != nil {
+				ret := next.Type().(*types.Signature).Results()
+				n := ret.Len()
+				l.kind, l.typ, l.next = lazyIter, types.NewSignature(nil, nil, ret, false), n
+				if n == 3 {
+					l.key = ret.At(0).Type()
+				}
+				l.val = ret.At(n - 2).Type()
+				return l
+			}
+		}
+	} else {
+		switch t := typ.Underlying().(type) {
+		case *types.Slice:
+			l.kind, l.key, l.val = lazyIndex, types.Typ[types.Int], t.Elem()
+			return l
+		case *types.Array:
+			l.kind, l.key, l.val = lazyIndex, types.Typ[types.Int], t.Elem()
+			return l
+		case *types.Pointer:
+			if at, ok := t.Elem().Underlying().(*types.Array); ok {
+				l.kind, l.key, l.val = lazyIndex, types.Typ[types.Int], at.Elem()
+				return l
+			}
+		case *types.Basic:
+			if t.Info()&types.IsString != 0 {
+				l.kind, l.key, l.val = lazyString, types.Typ[types.Int], types.Typ[types.Rune]
+				l.typ, l.conv = types.Typ[types.String], typ != types.Typ[types.String]
+				return l
+			}
+		case *types.Map:
+			l.kind, l.key, l.val = lazyMap, t.Key(), t.Elem()
+			return l
+		case *types.Chan:
+			l.kind, l.val = lazyChan, t.Elem()
+			return l
+		}
+	}
+	src, _ := ctx.LoadExpr(f.X)
+	panic(ctx.newCodeErrorf(f.X.Pos(), "cannot range over %s (type %v) in lazy comprehension", src, typ))
+}
+
+// lazyIdents returns the names referenced by the containers, conditions and
+// values of lazy comprehension v, to skip loop variables which aren't used.
+func lazyIdents(v *ast.ComprehensionExpr) map[string]bool {
+	used := make(map[string]bool)
+	f := func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok {
+			used[ident.Name] = true
+		}
+		return true
+	}
+	for _, fp := range v.Fors {
+		ast.Inspect(fp.X, f)
+		if fp.Cond != nil {
+			ast.Inspect(fp.Cond, f)
+		}
+	}
+	ast.Inspect(v.Elt, f)
+	return used
+}
+
+func (l *lazyLoop) declare(ctx *blockCtx, idx int, used map[string]bool) {
+	cb := ctx.cb
+	newVar := func(typ types.Type, name string) types.Object {
+		cb.NewVar(typ, name)
+		return cb.Scope().Lookup(name)
+	}
+	if l.Key != nil && l.Key.Name != "_" && used[l.Key.Name] {
+		l.k = newVar(l.key, l.Key.Name)
+	}
+	if l.Value.Name != "_" && used[l.Value.Name] {
+		l.v = newVar(l.val, l.Value.Name)
+	}
+	suffix := strconv.Itoa(idx)
+	l.c = newVar(l.typ, "_gop_c"+suffix)
+	switch l.kind {
+	case lazyIndex, lazyString, lazyMap:
+		l.i = newVar(types.Typ[types.Int], "_gop_i"+suffix)
+	}
+	switch l.kind {
+	case lazyString:
+		l.n = newVar(types.Typ[types.Int], "_gop_n"+suffix)
+	case lazyMap:
+		l.a = newVar(types.NewSlice(l.key), "_gop_keys"+suffix)
+	}
+}
+
+// start starts the loop, by initializing its cursor.
+func (l *lazyLoop) start(ctx *blockCtx) {
+	pkg, cb := ctx.pkg, ctx.cb
+	switch l.kind {
+	case lazyIndex: // _gop_c, _gop_i = container, -1
+		cb.VarRef(l.c).VarRef(l.i)
+		compileExpr(ctx, l.X)
+		cb.Val(-1).Assign(2)
+	case lazyString: // _gop_c, _gop_i, _gop_n = container, 0, 0
+		cb.VarRef(l.c).VarRef(l.i).VarRef(l.n)
+		if l.conv {
+			cb.Typ(l.typ)
+			compileExpr(ctx, l.X)
+			cb.CallWith(1, 0, l.X)
+		} else {
+			compileExpr(ctx, l.X)
+		}
+		cb.Val(0).Val(0).Assign(3)
+	case lazyMap: // _gop_c, _gop_i, _gop_keys = container, -1, _gop_keys[:0]
+		cb.VarRef(l.c).VarRef(l.i).VarRef(l.a)
+		compileExpr(ctx, l.X)
+		cb.Val(-1).Val(l.a).None().Val(0).Slice(false).Assign(3)
+		// for _gop_k := range _gop_c { _gop_keys = append(_gop_keys, _gop_k) }
+		cb.ForRange("_gop_k").Val(l.c).RangeAssignThen(token.NoPos)
+		cb.VarRef(l.a).Val(pkg.Builtin().Ref("append")).Val(l.a).Val(cb.Scope().Lookup("_gop_k")).Call(2).Assign(1)
+		cb.End()
+	case lazyChan: // _gop_c = container
+		cb.VarRef(l.c)
+		compileExpr(ctx, l.X)
+		cb.Assign(1)
+	default: // _gop_c = container.Gop_Enum().Next
+		cb.VarRef(l.c)
+		compileExpr(ctx, l.X)
+		cb.MemberVal("Gop_Enum").Call(0).MemberVal("Next").Assign(1)
+	}
+}
+
+// advance moves the loop to its next item, or to the outer loop if there are
+// no more items.
+func (l *lazyLoop) advance(ctx *blockCtx, lv, ok types.Object) {
+	pkg, cb := ctx.pkg, ctx.cb
+	var n int
+	ref := func(o types.Object) {
+		cb.VarRef(o)
+		n++
+	}
+	cb.If()
+	switch l.kind {
+	case lazyIndex, lazyMap: // if _gop_i++; _gop_i >= len(_gop_c) {
+		cb.VarRef(l.i).IncDec(gotoken.INC)
+		cb.Val(l.i).Val(pkg.Builtin().Ref("len"))
+		if l.kind == lazyMap {
+			cb.Val(l.a)
+		} else {
+			cb.Val(l.c)
+		}
+		cb.Call(1).BinaryOp(gotoken.GEQ)
+	case lazyString: // if _gop_i += _gop_n; _gop_i >= len(_gop_c) {
+		cb.VarRef(l.i).Val(l.n).AssignOp(gotoken.ADD_ASSIGN)
+		cb.Val(l.i).Val(pkg.Builtin().Ref("len")).Val(l.c).Call(1).BinaryOp(gotoken.GEQ)
+	case lazyChan: // if v, _gop_ok = <-_gop_c; !_gop_ok {
+		cb.VarRef(l.v).VarRef(ok).Val(l.c).UnaryOp(gotoken.ARROW, true).Assign(2, 1)
+		cb.Val(ok).UnaryOp(gotoken.NOT)
+	default: // if k, v, _gop_ok = _gop_c(); !_gop_ok {
+		if l.next == 3 {
+			cb.VarRef(l.k)
+		}
+		cb.VarRef(l.v).VarRef(ok).Val(l.c).Call(0).Assign(l.next, 1)
+		cb.Val(ok).UnaryOp(gotoken.NOT)
+	}
+	cb.Then().VarRef(lv).IncDec(gotoken.DEC).Continue(nil).End()
+	switch l.kind {
+	case lazyIndex: // k, v = _gop_i, _gop_c[_gop_i]
+		if l.k != nil {
+			ref(l.k)
+		}
+		if l.v != nil {
+			ref(l.v)
+		}
+		if l.k != nil {
+			cb.Val(l.i)
+		}
+		if l.v != nil {
+			cb.Val(l.c).Val(l.i).Index(1, false)
+		}
+		if n > 0 {
+			cb.Assign(n)
+		}
+	case lazyString: // k := _gop_i; v, _gop_n = utf8.DecodeRuneInString(_gop_c[_gop_i:])
+		if l.k != nil {
+			cb.VarRef(l.k).Val(l.i).Assign(1)
+		}
+		decode := pkg.Import("unicode/utf8").Ref("DecodeRuneInString")
+		cb.VarRef(l.v).VarRef(l.n).Val(decode).Val(l.c).Val(l.i).None().Slice(false).Call(1).Assign(2, 1)
+	case lazyMap: // k, v = _gop_keys[_gop_i], _gop_c[_gop_keys[_gop_i]]
+		if l.k != nil {
+			ref(l.k)
+		}
+		if l.v != nil {
+			ref(l.v)
+		}
+		if l.k != nil {
+			cb.Val(l.a).Val(l.i).Index(1, false)
+		}
+		if l.v != nil {
+			cb.Val(l.c).Val(l.a).Val(l.i).Index(1, false).Index(1, false)
+		}
+		if n > 0 {
+			cb.Assign(n)
+		}
+	}
+}
+
+// lazyType returns the type Gop_Lazy_T of lazy comprehensions of type T
+// values, and declares it with its methods the first time.
+func lazyType(ctx *blockCtx, elt types.Type, src ast.Node) *types.Named {
+	pkg := ctx.pkg
+	targs := []types.Type{elt}
+	if o := ctx.lookupInstance("Gop_Lazy", targs); o != nil {
+		return o.Type().(*types.Named)
+	}
+	if t := localInstance(ctx, "Gop_Lazy", gopLazySrc, targs, src); t != nil {
+		return t
+	}
+	decl := pkg.NewType(newInstanceName(ctx, "Gop_Lazy", targs, src))
+	t := decl.Type()
+	ctx.addInstance("Gop_Lazy", targs, t.Obj())
+	results := types.NewTuple(
+		pkg.NewParam(token.NoPos, "", elt), pkg.NewParam(token.NoPos, "", types.Typ[types.Bool]))
+	decl.InitType(pkg, types.NewSignature(nil, nil, results, false))
+	recv := pkg.NewParam(token.NoPos, "p", t)
+	pkg.NewFunc(recv, "Gop_Enum", nil, types.NewTuple(pkg.NewParam(token.NoPos, "", t)), false).
+		BodyStart(pkg).Val(recv).Return(1).End()
+	pkg.NewFunc(recv, "Next", nil, results, false).
+		BodyStart(pkg).Val(recv).Call(0).Return(1).End()
+	return t
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,526 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	goast "go/ast"
+	"go/constant"
+	"go/types"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+	"github.com/goplus/gox"
+)
+
+// -----------------------------------------------------------------------------
+
+// Functions (or methods of a type) declared several times with the same name
+// are overloads. They are compiled the way gox models overloads of Go+
+// packages: the i-th declaration is named `name__i` (i in 0-9, a-z), and an
+// overload object named `name` is added to the package scope (or the type).
+
+const overloadIndexes = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+type overloadFunc struct {
+	name  string // name of the overload, Gop_Add etc. for operators
+	decls []*ast.FuncDecl
+	left  int  // declarations not preloaded yet
+	conv  bool // conversions are compiled as overloads even if declared once
+	added bool
+}
+
+// memberName returns the name that the declaration d of p compiles to.
+func (p *overloadFunc) memberName(d *ast.FuncDecl) string {
+	for i, decl := range p.decls {
+		if decl == d {
+			return p.name + "__" + overloadIndexes[i:i+1]
+		}
+	}
+	panic("overloadFunc.memberName: not a member")
+}
+
+// overloadOf returns the overload that the declaration d is a member of, or
+// nil if d isn't overloaded.
+func (p *pkgCtx) overloadOf(d *ast.FuncDecl) *overloadFunc {
+	if p == nil {
+		return nil
+	}
+	return p.overloads[d]
+}
+
+// collectOverloads finds the functions and methods declared more than once in
+// the Go+ files of a package. Declarations are numbered by file name and then
+// by position, so the generated names don't depend on the order of files.
+func collectOverloads(ctx *pkgCtx, files map[string]*ast.File) {
+	paths := sortedFiles(files)
+	typeNames := make(map[string]bool)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.TYPE {
+				for _, spec := range d.Specs {
+					typeNames[spec.(*ast.TypeSpec).Name.Name] = true
+				}
+			}
+		}
+	}
+	groups := make(map[string]*overloadFunc)
+	var list []*overloadFunc
+	for _, path := range paths {
+		f := files[path]
+		decls := ctx.fileDecls(f)
+		for i, decl := range decls {
+			d, ok := decl.(*ast.FuncDecl)
+			if !ok || d.Type.TypeParams != nil {
+				continue
+			}
+			conv := false
+			if !f.IsClass && !f.IsProj {
+				var m *ast.FuncDecl
+				if conv, m = checkConversion(d, typeNames); m != nil {
+					if _, ok := ctx.fdecls[f]; !ok {
+						decls = append([]ast.Decl(nil), decls...)
+						if ctx.fdecls == nil {
+							ctx.fdecls = make(map[*ast.File][]ast.Decl)
+						}
+						ctx.fdecls[f] = decls
+					}
+					decls[i], d = m, m
+				}
+			}
+			key, name, ok := overloadKey(ctx, path, f, d, conv)
+			if !ok {
+				continue
+			}
+			g, ok := groups[key]
+			if !ok {
+				g = &overloadFunc{name: name, conv: conv}
+				groups[key] = g
+				list = append(list, g)
+			}
+			g.decls = append(g.decls, d)
+		}
+	}
+	for _, g := range list {
+		n := len(g.decls)
+		if n < 2 && !g.conv {
+			continue
+		}
+		if n > len(overloadIndexes) {
+			pos := ctx.Position(g.decls[len(overloadIndexes)].Name.Pos())
+			ctx.handleCodeErrorf(&pos, "too many overloads of %s (max %d)", g.name, len(overloadIndexes))
+			continue
+		}
+		if ctx.overloads == nil {
+			ctx.overloads = make(map[*ast.FuncDecl]*overloadFunc)
+		}
+		g.left = n
+		for _, d := range g.decls {
+			ctx.overloads[d] = g
+		}
+	}
+}
+
+// checkConversion checks whether d is a function named after the type it
+// returns, which declares a conversion:
+//
+//   func T(v V) T       => func T_Cast__i(v V) T, called by T(v)
+//   func U(v T) U       => func (v T) Gop_Rcast__i() U, called by U(v)
+//
+// where T is a type of this package. For the latter it returns the method that
+// d is compiled as, which is a copy of d: the AST of the package isn't changed.
+func checkConversion(d *ast.FuncDecl, typeNames map[string]bool) (conv bool, method *ast.FuncDecl) {
+	if d.Recv != nil || d.Operator {
+		return
+	}
+	results := d.Type.Results
+	if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+		return
+	}
+	if ret, ok := results.List[0].Type.(*ast.Ident); !ok || ret.Name != d.Name.Name {
+		return
+	}
+	if typeNames[d.Name.Name] { // conversion from
+		return true, nil
+	}
+	params := d.Type.Params
+	if len(params.List) != 1 || len(params.List[0].Names) > 1 {
+		return
+	}
+	if t, ok := params.List[0].Type.(*ast.Ident); !ok || !typeNames[t.Name] {
+		return
+	}
+	typ := *d.Type
+	typ.Params = &ast.FieldList{Opening: params.Closing, Closing: params.Closing}
+	m := *d
+	m.Recv, m.Type = params, &typ
+	return true, &m
+}
+
+func overloadKey(ctx *pkgCtx, path string, f *ast.File, d *ast.FuncDecl, conv bool) (key, name string, ok bool) {
+	name = d.Name.Name
+	if name == "_" {
+		return
+	}
+	if d.Recv == nil {
+		if f.IsClass || f.IsProj { // methods of the class
+			return path + "." + name, name, !d.Operator
+		}
+		if name == "init" || name == "main" {
+			return
+		}
+		if conv {
+			name += "_Cast"
+		}
+		return name, name, !d.Operator
+	}
+	if _, _, generic := getGenericRecv(d.Recv); generic {
+		return
+	}
+	typ, ok := getRecvTypeName(ctx, d.Recv, false)
+	if !ok {
+		return
+	}
+	if conv {
+		name = "Gop_Rcast"
+	} else if d.Operator {
+		if v, ok := binaryGopNames[name]; ok {
+			name = v
+		}
+	}
+	return typ + "." + name, name, true
+}
+
+// preloadOverloadFunc registers the loader of the overload object of a
+// function, which loads all its members.
+func preloadOverloadFunc(ctx *blockCtx, g *overloadFunc, genCode bool) {
+	if g.added {
+		return
+	}
+	g.added = true
+	initLoader(ctx.pkgCtx, ctx.syms, g.decls[0].Name.Pos(), g.name, func() {
+		pkg := ctx.pkg.Types
+		scope := pkg.Scope()
+		fns := make([]types.Object, len(g.decls))
+		for i, d := range g.decls {
+			name := g.memberName(d)
+			ctx.loadSymbol(name)
+			fns[i] = scope.Lookup(name)
+		}
+		fns = checkOverloads(ctx, g, fns)
+		scope.Insert(gox.NewOverloadFunc(g.decls[0].Name.Pos(), pkg, g.name, fns...))
+	}, genCode)
+}
+
+// preloadOverloadMethod adds the overload method to the type after all its
+// members are loaded.
+func preloadOverloadMethod(ctx *blockCtx, g *overloadFunc, ld *typeLoader, typName string) {
+	if g.left--; g.left > 0 {
+		return
+	}
+	ld.methods = append(ld.methods, func() {
+		pkg := ctx.pkg.Types
+		named, ok := pkg.Scope().Lookup(typName).Type().(*types.Named)
+		if !ok {
+			return
+		}
+		fns := make([]types.Object, len(g.decls))
+		for i, d := range g.decls {
+			name := g.memberName(d)
+			for j, n := 0, named.NumMethods(); j < n; j++ {
+				if m := named.Method(j); m.Name() == name {
+					fns[i] = m
+					break
+				}
+			}
+		}
+		fns = checkOverloads(ctx, g, fns)
+		gox.NewOverloadMethod(named, g.decls[0].Name.Pos(), pkg, g.name, fns...)
+	})
+}
+
+// checkOverloads reports overloads that can't be told apart by their
+// parameters. fns[i] is the member declared by g.decls[i], or nil if it failed
+// to load. It returns the loaded members.
+func checkOverloads(ctx *blockCtx, g *overloadFunc, fns []types.Object) []types.Object {
+	ret := fns[:0:0]
+	var decls []*ast.FuncDecl
+	for i, fn := range fns {
+		if fn == nil {
+			continue
+		}
+		d := g.decls[i]
+		for j, prev := range ret {
+			x, y := fn.Type().(*types.Signature), prev.Type().(*types.Signature)
+			if sameParams(x, y) && (!g.conv || g.name != "Gop_Rcast" || types.Identical(x.Results(), y.Results())) {
+				pos := ctx.Position(d.Name.Pos())
+				ctx.handleCodeErrorf(&pos, "%s redeclared in this block\n\tprevious declaration at %v",
+					d.Name.Name, ctx.Position(decls[j].Name.Pos()))
+				break
+			}
+		}
+		ret = append(ret, fn)
+		decls = append(decls, d)
+	}
+	return ret
+}
+
+func sameParams(x, y *types.Signature) bool {
+	xp, yp := x.Params(), y.Params()
+	if xp.Len() != yp.Len() || x.Variadic() != y.Variadic() {
+		return false
+	}
+	for i, n := 0, xp.Len(); i < n; i++ {
+		if !types.Identical(xp.At(i).Type(), yp.At(i).Type()) {
+			return false
+		}
+	}
+	return true
+}
+
+// declGopPackage marks a package with overloads as a Go+ package, so that
+// packages importing it see the overloads.
+func declGopPackage(p *gox.Package, ctx *pkgCtx) {
+	const name = "GopPackage"
+	if _, ok := ctx.syms[name]; ok || len(ctx.overloads) == 0 {
+		return
+	}
+	old, _ := p.SetCurFile(defaultGoFile, true)
+	defer p.RestoreCurFile(old)
+	p.NewConstDefs(p.Types.Scope()).New(func(cb *gox.CodeBuilder) int {
+		cb.Val(true)
+		return 1
+	}, 0, token.NoPos, nil, name)
+}
+
+// loadConversions loads the conversions declared in this package that the
+// type conversion on the stack may call, as gox looks them up by name.
+func loadConversions(ctx *blockCtx, fnt types.Type, nargs int) {
+	tt, ok := fnt.(*gox.TypeType)
+	if !ok {
+		return
+	}
+	pkg := ctx.pkg.Types
+	if t, ok := tt.Type().(*types.Named); ok && t.Obj().Pkg() == pkg {
+		ctx.loadSymbol(t.Obj().Name() + "_Cast")
+	}
+	if nargs == 1 {
+		if t, ok := ctx.cb.Get(-1).Type.(*types.Named); ok && t.Obj().Pkg() == pkg {
+			ctx.loadNamed(ctx.pkg, t)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// resolveOverload selects the overload to call by the types of the arguments
+// on the stack, and reports calls that more than one overload matches equally
+// well. The function is left as is when no overload matches, so that gox
+// reports the error.
+func resolveOverload(ctx *blockCtx, v *ast.CallExpr, ellipsis bool) {
+	cb := ctx.cb
+	nargs := len(v.Args)
+	fn := cb.Get(-nargs - 1)
+	fns, isMethod := overloadsOf(ctx, v.Fun, fn)
+	if fns == nil {
+		return
+	}
+	args := cb.InternalStack().GetArgs(nargs)
+	for _, arg := range args {
+		if arg.Type == nil {
+			return
+		}
+		if _, ok := arg.Type.(*types.Tuple); ok {
+			return
+		}
+	}
+	best, score, n := -1, -1, 0
+	for i, o := range fns {
+		sig, ok := o.Type().(*types.Signature)
+		if !ok {
+			return
+		}
+		if s := overloadScore(sig, args, ellipsis); s > score {
+			best, score, n = i, s, 1
+		} else if s == score && s >= 0 {
+			n++
+		}
+	}
+	if best < 0 {
+		return
+	}
+	if n > 1 {
+		var cands []string
+		for _, o := range fns {
+			if overloadScore(o.Type().(*types.Signature), args, ellipsis) == score {
+				cands = append(cands, overloadString(ctx, o))
+			}
+		}
+		targs := make([]string, nargs)
+		for i, arg := range args {
+			targs[i] = types.TypeString(arg.Type, types.RelativeTo(ctx.pkg.Types))
+		}
+		src, pos := ctx.LoadExpr(v.Fun)
+		panic(newCodeErrorf(&pos, "ambiguous call to %s(%s), candidates:\n\t%s",
+			src, strings.Join(targs, ", "), strings.Join(cands, "\n\t")))
+	}
+	o := fns[best]
+	sig := o.Type().(*types.Signature)
+	switch fv := fn.Val.(type) {
+	case *goast.Ident:
+		fn.Val = &goast.Ident{NamePos: fv.NamePos, Name: o.Name()}
+	case *goast.SelectorExpr:
+		fn.Val = &goast.SelectorExpr{X: fv.X, Sel: &goast.Ident{NamePos: fv.Sel.NamePos, Name: o.Name()}}
+	default:
+		return
+	}
+	if isMethod {
+		sig = types.NewSignature(nil, sig.Params(), sig.Results(), sig.Variadic())
+	}
+	fn.Type = sig
+}
+
+// overloadsOf returns the members of the overload function or method fn, or
+// of the conversions declared for the type fn of this package.
+func overloadsOf(ctx *blockCtx, x ast.Expr, fn *gox.Element) (fns []types.Object, isMethod bool) {
+	if sig, ok := fn.Type.(*types.Signature); ok {
+		return gox.CheckOverloadMethod(sig)
+	}
+	var scope *types.Scope
+	var name string
+	if tt, ok := fn.Type.(*gox.TypeType); ok {
+		if t, ok := tt.Type().(*types.Named); ok && t.Obj().Pkg() == ctx.pkg.Types {
+			scope, name = ctx.pkg.Types.Scope(), t.Obj().Name()+"_Cast"
+			if o := scope.Lookup(name); o == nil || !gox.IsFunc(o.Type()) {
+				return
+			}
+			return lookupOverloads(scope, name), false
+		}
+		return
+	}
+	if !gox.IsFunc(fn.Type) {
+		return
+	}
+	switch v := x.(type) {
+	case *ast.Ident:
+		scope, name = ctx.pkg.Types.Scope(), v.Name
+	case *ast.SelectorExpr:
+		if id, ok := v.X.(*ast.Ident); ok {
+			if pr, ok := ctx.findImport(id.Name); ok {
+				scope, name = pr.Types.Scope(), v.Sel.Name
+			}
+		}
+	}
+	if scope == nil {
+		return
+	}
+	if o := scope.Lookup(name); o == nil || o.Type() != fn.Type {
+		return
+	}
+	return lookupOverloads(scope, name), false
+}
+
+func lookupOverloads(scope *types.Scope, name string) (fns []types.Object) {
+	for i := range overloadIndexes {
+		o := scope.Lookup(name + "__" + overloadIndexes[i:i+1])
+		if o == nil {
+			break
+		}
+		fns = append(fns, o)
+	}
+	return
+}
+
+// overloadScore returns -1 if args can't be passed to sig, or the number of
+// args of the exact parameter type (the default type for untyped constants).
+func overloadScore(sig *types.Signature, args []*gox.Element, ellipsis bool) int {
+	params := sig.Params()
+	n := params.Len()
+	variadic := sig.Variadic() && !ellipsis
+	if ellipsis && !sig.Variadic() {
+		return -1
+	}
+	if variadic {
+		if len(args) < n-1 {
+			return -1
+		}
+	} else if len(args) != n {
+		return -1
+	}
+	score := 0
+	for i, arg := range args {
+		var t types.Type
+		if variadic && i >= n-1 {
+			t = params.At(n - 1).Type().(*types.Slice).Elem()
+		} else {
+			t = params.At(i).Type()
+		}
+		switch {
+		case types.Identical(types.Default(arg.Type), t):
+			score++
+		case !assignableArg(arg, t):
+			return -1
+		}
+	}
+	return score
+}
+
+func assignableArg(arg *gox.Element, t types.Type) bool {
+	b, ok := arg.Type.(*types.Basic)
+	if !ok || !isUntyped(b) {
+		return types.AssignableTo(arg.Type, t)
+	}
+	if b.Kind() == types.UntypedNil {
+		switch t.Underlying().(type) {
+		case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+			return true
+		}
+		return t.Underlying() == types.Typ[types.UnsafePointer]
+	}
+	tb, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return types.AssignableTo(types.Default(b), t)
+	}
+	switch info := tb.Info(); {
+	case info&types.IsBoolean != 0:
+		return b.Info()&types.IsBoolean != 0
+	case info&types.IsString != 0:
+		return b.Info()&types.IsString != 0
+	case info&types.IsInteger != 0:
+		if b.Info()&types.IsNumeric == 0 {
+			return false
+		}
+		return arg.CVal == nil || constant.ToInt(arg.CVal).Kind() == constant.Int
+	case info&types.IsNumeric != 0:
+		return b.Info()&types.IsNumeric != 0
+	}
+	return false
+}
+
+func overloadString(ctx *blockCtx, o types.Object) string {
+	qf := types.RelativeTo(ctx.pkg.Types)
+	sig := o.Type().(*types.Signature)
+	name := o.Name()
+	name = name[:len(name)-3] // strip __i
+	if recv := sig.Recv(); recv != nil {
+		name = "(" + types.TypeString(recv.Type(), qf) + ")." + name
+		sig = types.NewSignature(nil, sig.Params(), sig.Results(), sig.Variadic())
+	}
+	return name + strings.TrimPrefix(types.TypeString(sig, qf), "func")
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,551 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	gotoken "go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+	"github.com/goplus/gox"
+)
+
+// -----------------------------------------------------------------------------
+
+// A list comprehension can have query clauses after its for phrases:
+//
+//   [elt for x <- container, cond groupby key1, key2 distinct orderby key3 desc skip m take n]
+//
+// The rows of the for phrases are processed in the order of the clauses:
+//
+//   - groupby: rows with the same keys form a group, which produces one value.
+//     Calls of sum, min, max and avg with one argument, and count without
+//     arguments, aggregate the rows of the group. Other references to loop
+//     variables see the first row of the group. Groups are in the order they
+//     are found.
+//   - distinct: values equal to a previous one are dropped.
+//   - orderby: values are sorted by the keys, in a stable way.
+//   - skip, take: the first m values are dropped, and at most n values are
+//     kept. Without orderby, the loops stop once n values are taken.
+//
+// It is compiled to a closure like this:
+//
+//   func() (_gop_ret []T) {
+//       _gop_index := map[K]int{}   // groupby: index of groups by keys
+//       var _gop_n []int            // groupby: number of rows of groups
+//       var _gop_var0 []X           // groupby: loop variables of first rows
+//       var _gop_agg0 []A           // groupby: aggregates of groups
+//       for x := range container {
+//           if cond {
+//               _gop_k := key1
+//               if _gop_gi, _gop_ok := _gop_index[_gop_k]; !_gop_ok {
+//                   _gop_index[_gop_k] = len(_gop_n)
+//                   _gop_n, _gop_var0, _gop_agg0 = append(_gop_n, 1), append(_gop_var0, x), append(_gop_agg0, arg)
+//               } else {
+//                   _gop_n[_gop_gi]++
+//                   _gop_agg0[_gop_gi] += arg
+//               }
+//           }
+//       }
+//       for _gop_gi := range _gop_n {
+//           x := _gop_var0[_gop_gi]
+//           _gop_v := elt // with _gop_agg0[_gop_gi] for sum(arg)
+//           if !_gop_seen[_gop_v] { // distinct
+//               _gop_seen[_gop_v] = true
+//               _gop_recs = append(_gop_recs, struct{v T; k0 K0}{_gop_v, key3})
+//           }
+//       }
+//       sort.SliceStable(_gop_recs, func(i, j int) bool { return _gop_recs[j].k0 < _gop_recs[i].k0 })
+//       for _, _gop_r := range _gop_recs {
+//           if _gop_skip > 0 {
+//               _gop_skip--
+//           } else {
+//               _gop_ret = append(_gop_ret, _gop_r.v)
+//               if len(_gop_ret) >= _gop_take {
+//                   return
+//               }
+//           }
+//       }
+//       return
+//   }()
+
+type queryExpr struct {
+	groupby  []ast.Expr
+	distinct bool
+	orderby  []*ast.OrderKey
+	skip     ast.Expr
+	take     ast.Expr
+
+	aggs []*groupAgg    // aggregates of groupby
+	vars []types.Object // loop variables referenced out of aggregates
+	elt  types.Type     // type of values
+	keys types.Type     // type of groupby keys
+	recs *types.Struct  // type of values with their orderby keys
+	objs map[string]types.Object
+}
+
+type groupAgg struct {
+	call *ast.CallExpr
+	fn   string
+	typ  types.Type // type of the argument
+	ret  types.Type // type of the result
+	acc  types.Object
+}
+
+func newQueryExpr(ctx *blockCtx, v *ast.ComprehensionExpr) *queryExpr {
+	q := &queryExpr{objs: make(map[string]types.Object)}
+	for _, c := range v.Query {
+		switch c.Keyword.Name {
+		case "groupby":
+			q.groupby = c.Args
+		case "distinct":
+			q.distinct = true
+		case "orderby":
+			q.orderby = c.Orders
+		case "skip":
+			q.skip = c.Args[0]
+		case "take":
+			q.take = c.Args[0]
+		}
+	}
+	if q.groupby != nil {
+		q.aggs = groupAggsOf(v.Elt, q.orderby)
+	}
+	return q
+}
+
+// groupAggsOf returns the aggregate calls in elt and orderby keys, except
+// those in nested comprehensions and functions.
+func groupAggsOf(elt ast.Expr, orderby []*ast.OrderKey) (aggs []*groupAgg) {
+	f := func(node ast.Node) bool {
+		switch v := node.(type) {
+		case *ast.ComprehensionExpr, *ast.FuncLit, *ast.LambdaExpr, *ast.LambdaExpr2:
+			return false
+		case *ast.CallExpr:
+			if fn, ok := v.Fun.(*ast.Ident); ok && aggregates[fn.Name] && v.Kwargs == nil {
+				if fn.Name == "count" && len(v.Args) == 0 || fn.Name != "count" && len(v.Args) == 1 {
+					if _, ok := aggregateOf(v); !ok {
+						aggs = append(aggs, &groupAgg{call: v, fn: fn.Name})
+						return false
+					}
+				}
+			}
+		}
+		return true
+	}
+	ast.Inspect(elt, f)
+	for _, key := range orderby {
+		ast.Inspect(key.X, f)
+	}
+	return
+}
+
+// groupVarsOf returns names of loop variables of fors referenced by elt and
+// orderby keys out of aggregates.
+func groupVarsOf(fors []*ast.ForPhrase, elt ast.Expr, orderby []*ast.OrderKey, aggs []*groupAgg) (names []string) {
+	vars := make(map[string]bool)
+	for _, f := range fors {
+		if f.Key != nil {
+			vars[f.Key.Name] = true
+		}
+		vars[f.Value.Name] = true
+	}
+	skip := make(map[*ast.CallExpr]bool, len(aggs))
+	for _, agg := range aggs {
+		skip[agg.call] = true
+	}
+	found := make(map[string]bool)
+	var f func(node ast.Node) bool
+	f = func(node ast.Node) bool {
+		switch v := node.(type) {
+		case *ast.CallExpr:
+			if skip[v] {
+				return false
+			}
+		case *ast.SelectorExpr: // skip names of fields
+			ast.Inspect(v.X, f)
+			return false
+		case *ast.Ident:
+			if name := v.Name; name != "_" && vars[name] && !found[name] {
+				found[name] = true
+				names = append(names, name)
+			}
+		}
+		return true
+	}
+	ast.Inspect(elt, f)
+	for _, key := range orderby {
+		ast.Inspect(key.X, f)
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+
+func compileQueryExpr(ctx *blockCtx, v *ast.ComprehensionExpr) {
+	q := newQueryExpr(ctx, v)
+	queryTypes(ctx, v, q)
+	if dryResult(ctx, types.NewSlice(q.elt)) {
+		return
+	}
+
+	pkg, cb := ctx.pkg, ctx.cb
+	ret := pkg.NewParam(token.NoPos, "_gop_ret", types.NewSlice(q.elt))
+	cb.NewClosure(nil, types.NewTuple(ret), false).BodyStart(pkg)
+	q.objs["_gop_ret"] = ret
+	if q.take != nil { // _gop_take := n; if _gop_take <= 0 { return }
+		q.define(ctx, "_gop_take", func() { compileExpr(ctx, q.take) })
+		cb.If().Val(q.objs["_gop_take"]).Val(0).BinaryOp(gotoken.LEQ, q.take).Then().Return(0).End()
+	}
+	if q.skip != nil {
+		q.define(ctx, "_gop_skip", func() { compileExpr(ctx, q.skip) })
+	}
+	if q.distinct {
+		if !types.Comparable(q.elt) {
+			panic(ctx.newCodeErrorf(v.Elt.Pos(), "invalid distinct: values of type %v are not comparable", q.elt))
+		}
+		q.define(ctx, "_gop_seen", func() { cb.MapLit(types.NewMap(q.elt, types.Typ[types.Bool]), 0) })
+	}
+	if q.orderby != nil {
+		q.newVar(ctx, "_gop_recs", types.NewSlice(q.recs))
+	}
+	if q.groupby == nil {
+		end := compileForPhrases(ctx, v.Fors)
+		q.emit(ctx, v)
+		end()
+	} else {
+		q.compileGroups(ctx, v)
+	}
+	if q.orderby != nil {
+		q.sort(ctx)
+	}
+	cb.Return(0).End().Call(0)
+}
+
+// queryTypes determines the types of query q, by compiling its loops in a dry
+// run.
+func queryTypes(ctx *blockCtx, v *ast.ComprehensionExpr, q *queryExpr) {
+	pkg, cb := ctx.pkg, ctx.cb
+	inferTypes(ctx, nil, func() {
+		end := compileForPhrases(ctx, v.Fors)
+		typeOf := func(x ast.Expr) types.Type {
+			compileExpr(ctx, x)
+			return gox.Default(pkg, cb.InternalStack().Pop().Type)
+		}
+		if q.groupby != nil {
+			q.keys = typeOf(q.groupby[0])
+			if n := len(q.groupby); n > 1 {
+				fields := make([]*types.Var, n)
+				fields[0] = types.NewField(token.NoPos, pkg.Types, "k0", q.keys, false)
+				for i := 1; i < n; i++ {
+					fields[i] = types.NewField(token.NoPos, pkg.Types, "k"+strconv.Itoa(i), typeOf(q.groupby[i]), false)
+				}
+				q.keys = types.NewStruct(fields, nil)
+			}
+			if !types.Comparable(q.keys) {
+				panic(ctx.newCodeErrorf(q.groupby[0].Pos(), "invalid groupby: keys of type %v are not comparable", q.keys))
+			}
+			for _, agg := range q.aggs {
+				agg.ret = types.Typ[types.Int]
+				if agg.fn == "count" {
+					continue
+				}
+				arg := agg.call.Args[0]
+				agg.typ, agg.ret = typeOf(arg), nil
+				if t, ok := agg.typ.Underlying().(*types.Basic); ok {
+					if t.Info()&aggregateInfo[agg.fn] == 0 {
+						src, _ := ctx.LoadExpr(arg)
+						panic(ctx.newCodeErrorf(agg.call.Pos(), "invalid argument: cannot compute %s of %s (type %v)", agg.fn, src, agg.typ))
+					}
+					if agg.fn == "avg" {
+						agg.ret = types.Typ[types.Float64]
+					}
+				}
+				if agg.ret == nil {
+					agg.ret = agg.typ
+				}
+			}
+			for _, name := range groupVarsOf(v.Fors, v.Elt, q.orderby, q.aggs) {
+				_, o := cb.Scope().LookupParent(name, token.NoPos)
+				q.vars = append(q.vars, o)
+			}
+			old := ctx.groupAggs
+			ctx.groupAggs = make(map[*ast.CallExpr]func(), len(q.aggs))
+			for _, agg := range q.aggs {
+				ret := agg.ret
+				ctx.groupAggs[agg.call] = func() { cb.ZeroLit(ret) }
+			}
+			defer func() { ctx.groupAggs = old }()
+		}
+		q.elt = typeOf(v.Elt)
+		if q.orderby != nil {
+			fields := make([]*types.Var, len(q.orderby)+1)
+			fields[0] = types.NewField(token.NoPos, pkg.Types, "v", q.elt, false)
+			for i, key := range q.orderby {
+				fields[i+1] = types.NewField(token.NoPos, pkg.Types, "k"+strconv.Itoa(i), typeOf(key.X), false)
+			}
+			q.recs = types.NewStruct(fields, nil)
+		}
+		end()
+	})
+}
+
+// compileGroups groups the rows of the loops, and emits a value for each group.
+func (q *queryExpr) compileGroups(ctx *blockCtx, v *ast.ComprehensionExpr) {
+	pkg, cb := ctx.pkg, ctx.cb
+	q.define(ctx, "_gop_index", func() { cb.MapLit(types.NewMap(q.keys, types.Typ[types.Int]), 0) })
+	n := q.newVar(ctx, "_gop_n", types.NewSlice(types.Typ[types.Int]))
+	vars := make([]types.Object, len(q.vars))
+	for i, o := range q.vars {
+		vars[i] = q.newVar(ctx, "_gop_var"+strconv.Itoa(i), types.NewSlice(o.Type()))
+	}
+	for i, agg := range q.aggs {
+		if agg.fn != "count" {
+			agg.acc = q.newVar(ctx, "_gop_agg"+strconv.Itoa(i), types.NewSlice(agg.typ))
+		}
+	}
+	appendTo := func(list types.Object, val func()) {
+		cb.VarRef(list).Val(pkg.Builtin().Ref("append")).Val(list)
+		val()
+		cb.Call(2).Assign(1)
+	}
+
+	end := compileForPhrases(ctx, v.Fors)
+	// _gop_k := key
+	cb.DefineVarStart(token.NoPos, "_gop_k")
+	for _, key := range q.groupby {
+		compileExpr(ctx, key)
+	}
+	if len(q.groupby) > 1 {
+		cb.StructLit(q.keys, len(q.groupby), false)
+	}
+	cb.EndInit(1)
+	k := cb.Scope().Lookup("_gop_k")
+	// if _gop_gi, _gop_ok := _gop_index[_gop_k]; !_gop_ok {
+	cb.If().DefineVarStart(token.NoPos, "_gop_gi", "_gop_ok").
+		Val(q.objs["_gop_index"]).Val(k).Index(1, true).EndInit(1)
+	gi, ok := cb.Scope().Lookup("_gop_gi"), cb.Scope().Lookup("_gop_ok")
+	cb.Val(ok).UnaryOp(gotoken.NOT).Then()
+	cb.Val(q.objs["_gop_index"]).Val(k).IndexRef(1).Val(pkg.Builtin().Ref("len")).Val(n).Call(1).Assign(1)
+	appendTo(n, func() { cb.Val(1) })
+	for i, o := range q.vars {
+		appendTo(vars[i], func() { cb.Val(o) })
+	}
+	for _, agg := range q.aggs {
+		if agg.acc != nil {
+			arg := agg.call.Args[0]
+			appendTo(agg.acc, func() { compileExpr(ctx, arg) })
+		}
+	}
+	cb.Else()
+	cb.Val(n).Val(gi).IndexRef(1).IncDec(gotoken.INC)
+	for _, agg := range q.aggs {
+		if agg.acc != nil {
+			agg.accumulate(ctx, gi)
+		}
+	}
+	cb.End()
+	end()
+
+	// for _gop_gi := range _gop_n {
+	cb.ForRange("_gop_gi").Val(n).RangeAssignThen(token.NoPos)
+	gi = cb.Scope().Lookup("_gop_gi")
+	for i, o := range q.vars {
+		cb.DefineVarStart(token.NoPos, o.Name()).Val(vars[i]).Val(gi).Index(1, false).EndInit(1)
+	}
+	old := ctx.groupAggs
+	ctx.groupAggs = make(map[*ast.CallExpr]func(), len(q.aggs))
+	for _, agg := range q.aggs {
+		agg := agg
+		ctx.groupAggs[agg.call] = func() { agg.result(ctx, n, gi) }
+	}
+	q.emit(ctx, v)
+	ctx.groupAggs = old
+	cb.End()
+}
+
+// accumulate aggregates the argument of agg to the aggregate of group gi.
+func (agg *groupAgg) accumulate(ctx *blockCtx, gi types.Object) {
+	cb := ctx.cb
+	arg := agg.call.Args[0]
+	accRef := func() { cb.Val(agg.acc).Val(gi).IndexRef(1) }
+	accVal := func() { cb.Val(agg.acc).Val(gi).Index(1, false) }
+	switch agg.fn {
+	case "sum", "avg":
+		accRef()
+		if _, ok := agg.typ.Underlying().(*types.Basic); ok { // _gop_agg[_gop_gi] += arg
+			compileExpr(ctx, arg)
+			cb.AssignOp(gotoken.ADD_ASSIGN, arg)
+		} else { // _gop_agg[_gop_gi] = _gop_agg[_gop_gi] + arg, as bigint values are pointers
+			accVal()
+			compileExpr(ctx, arg)
+			cb.BinaryOp(gotoken.ADD, arg).Assign(1)
+		}
+	default: // if _gop_v := arg; _gop_v < _gop_agg[_gop_gi] { _gop_agg[_gop_gi] = _gop_v }
+		cb.If().DefineVarStart(arg.Pos(), "_gop_v")
+		compileExpr(ctx, arg)
+		cb.EndInit(1)
+		val := cb.Scope().Lookup("_gop_v")
+		if agg.fn == "min" {
+			cb.Val(val)
+			accVal()
+		} else {
+			accVal()
+			cb.Val(val)
+		}
+		cb.BinaryOp(gotoken.LSS, arg).Then()
+		accRef()
+		cb.Val(val).Assign(1).End()
+	}
+}
+
+// result pushes the result of agg for group gi.
+func (agg *groupAgg) result(ctx *blockCtx, n, gi types.Object) {
+	cb := ctx.cb
+	switch agg.fn {
+	case "count": // _gop_n[_gop_gi]
+		cb.Val(n).Val(gi).Index(1, false)
+	case "avg":
+		if agg.ret != agg.typ { // float64(_gop_agg[_gop_gi]) / float64(_gop_n[_gop_gi])
+			cb.Typ(agg.ret).Val(agg.acc).Val(gi).Index(1, false).CallWith(1, 0, agg.call)
+			cb.Typ(agg.ret).Val(n).Val(gi).Index(1, false).CallWith(1, 0, agg.call)
+		} else { // _gop_agg[_gop_gi] / T(_gop_n[_gop_gi])
+			cb.Val(agg.acc).Val(gi).Index(1, false)
+			cb.Typ(agg.ret).Val(n).Val(gi).Index(1, false).CallWith(1, 0, agg.call)
+		}
+		cb.BinaryOp(gotoken.QUO, agg.call)
+	default: // _gop_agg[_gop_gi]
+		cb.Val(agg.acc).Val(gi).Index(1, false)
+	}
+}
+
+// emit emits a value of the query, which is dropped if it isn't distinct, and
+// is added to the records to sort if there are orderby keys.
+func (q *queryExpr) emit(ctx *blockCtx, v *ast.ComprehensionExpr) {
+	pkg, cb := ctx.pkg, ctx.cb
+	if !q.distinct && q.orderby == nil {
+		q.appendResult(ctx, func() { compileExpr(ctx, v.Elt) })
+		return
+	}
+	cb.DefineVarStart(token.NoPos, "_gop_v")
+	compileExpr(ctx, v.Elt)
+	cb.EndInit(1)
+	val := cb.Scope().Lookup("_gop_v")
+	if q.distinct { // if !_gop_seen[_gop_v] { _gop_seen[_gop_v] = true; ... }
+		seen := q.objs["_gop_seen"]
+		cb.If().Val(seen).Val(val).Index(1, false).UnaryOp(gotoken.NOT).Then()
+		cb.Val(seen).Val(val).IndexRef(1).Val(true).Assign(1)
+	}
+	if q.orderby != nil { // _gop_recs = append(_gop_recs, struct{...}{_gop_v, key, ...})
+		recs := q.objs["_gop_recs"]
+		cb.VarRef(recs).Val(pkg.Builtin().Ref("append")).Val(recs).Val(val)
+		for _, key := range q.orderby {
+			compileExpr(ctx, key.X)
+		}
+		cb.StructLit(q.recs, len(q.orderby)+1, false).Call(2).Assign(1)
+	} else {
+		q.appendResult(ctx, func() { cb.Val(val) })
+	}
+	if q.distinct {
+		cb.End()
+	}
+}
+
+// appendResult appends a value to the result, after skipping values of the
+// skip clause, and returns the result once there are enough values.
+func (q *queryExpr) appendResult(ctx *blockCtx, val func()) {
+	pkg, cb := ctx.pkg, ctx.cb
+	ret := q.objs["_gop_ret"]
+	if q.skip != nil { // if _gop_skip > 0 { _gop_skip-- } else { ... }
+		skip := q.objs["_gop_skip"]
+		cb.If().Val(skip).Val(0).BinaryOp(gotoken.GTR).Then().VarRef(skip).IncDec(gotoken.DEC).Else()
+	}
+	cb.VarRef(ret).Val(pkg.Builtin().Ref("append")).Val(ret)
+	val()
+	cb.Call(2).Assign(1)
+	if q.take != nil { // if len(_gop_ret) >= _gop_take { return }
+		cb.If().Val(pkg.Builtin().Ref("len")).Val(ret).Call(1).Val(q.objs["_gop_take"]).BinaryOp(gotoken.GEQ).
+			Then().Return(0).End()
+	}
+	if q.skip != nil {
+		cb.End()
+	}
+}
+
+// sort sorts the records by orderby keys, and appends their values to the
+// result.
+func (q *queryExpr) sort(ctx *blockCtx) {
+	pkg, cb := ctx.pkg, ctx.cb
+	recs := q.objs["_gop_recs"]
+	i := pkg.NewParam(token.NoPos, "i", types.Typ[types.Int])
+	j := pkg.NewParam(token.NoPos, "j", types.Typ[types.Int])
+	less := pkg.NewParam(token.NoPos, "", types.Typ[types.Bool])
+	key := func(idx types.Object, k int) {
+		cb.Val(recs).Val(idx).Index(1, false).MemberVal("k" + strconv.Itoa(k))
+	}
+	// sort.SliceStable(_gop_recs, func(i, j int) bool { ... })
+	cb.Val(pkg.Import("sort").Ref("SliceStable")).Val(recs)
+	cb.NewClosure(types.NewTuple(i, j), types.NewTuple(less), false).BodyStart(pkg)
+	last := len(q.orderby) - 1
+	for k, okey := range q.orderby {
+		a, b := i, j
+		if okey.Desc {
+			a, b = j, i
+		}
+		if k == last { // return a.k < b.k
+			key(a, k)
+			key(b, k)
+			cb.BinaryOp(gotoken.LSS, okey.X).Return(1)
+			break
+		}
+		// if a.k < b.k { return true }; if b.k < a.k { return false }
+		cb.If()
+		key(a, k)
+		key(b, k)
+		cb.BinaryOp(gotoken.LSS, okey.X).Then().Val(true).Return(1).End()
+		cb.If()
+		key(b, k)
+		key(a, k)
+		cb.BinaryOp(gotoken.LSS, okey.X).Then().Val(false).Return(1).End()
+	}
+	cb.End().Call(2).EndStmt()
+	// for _, _gop_r := range _gop_recs { ... }
+	cb.ForRange("_", "_gop_r").Val(recs).RangeAssignThen(token.NoPos)
+	r := cb.Scope().Lookup("_gop_r")
+	q.appendResult(ctx, func() { cb.Val(r).MemberVal("v") })
+	cb.End()
+}
+
+func (q *queryExpr) define(ctx *blockCtx, name string, val func()) {
+	cb := ctx.cb
+	cb.DefineVarStart(token.NoPos, name)
+	val()
+	cb.EndInit(1)
+	q.objs[name] = cb.Scope().Lookup(name)
+}
+
+func (q *queryExpr) newVar(ctx *blockCtx, name string, typ types.Type) types.Object {
+	cb := ctx.cb
+	cb.NewVar(typ, name)
+	o := cb.Scope().Lookup(name)
+	q.objs[name] = o
+	return o
+}
+
+// -----------------------------------------------------------------------------
@@ -203,3 +203,28 @@ func TestBigint_run(t *testing.T) {
 }
 
 // -----------------------------------------------------------------------------
+
+func TestErrWrapMsg_run(t *testing.T) {
+	testRun(t, `
+import "errors"
+
+func load(name string) error {
+	return errors.New("not found")
+}
+
+func loadConfig(name string) error {
+	load(name)? "reading config"
+	return nil
+}
+
+println loadConfig("gop.json")
+`, `not found
+
+===> errors stack:
+main.loadConfig("gop.json")
+	/foo/bar.gop:9 load(name)?: reading config
+
+`)
+}
+
+// -----------------------------------------------------------------------------
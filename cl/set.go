@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	gotoken "go/token"
+	"go/types"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+	"github.com/goplus/gox"
+)
+
+// -----------------------------------------------------------------------------
+
+// A set literal `{v1, v2, ...}` (or `set{v1, v2, ...}`) and a set comprehension
+// `set{elt for k, v <- container, cond}` are of type Gop_Set_T (T is the
+// default type of v1 or the type of elt):
+//
+//   type Gop_Set_T map[T]struct{}
+//
+//   func (p Gop_Set_T) Gop_In(x T) (ok bool)               // x in p
+//   func (p Gop_Set_T) Gop_Or(q Gop_Set_T) (r Gop_Set_T)  // p | q, union
+//   func (p Gop_Set_T) Gop_And(q Gop_Set_T) (r Gop_Set_T) // p & q, intersection
+//   func (p Gop_Set_T) Gop_Sub(q Gop_Set_T) (r Gop_Set_T) // p - q, difference
+//
+// A set literal is a map[T]struct{} of another type if it is expected, like:
+//
+//   var s map[string]struct{} = {"a", "b"}
+//
+// Sets of values of a local type or a type parameter are of type Gop_Set[T]
+// instead, which is declared by gopSetSrc with Go generics.
+
+var (
+	tyEmptyStruct = types.NewStruct(nil, nil)
+)
+
+const gopSetSrc = `
+type Gop_Set[T comparable] map[T]struct{}
+
+func (p Gop_Set[T]) Gop_In(x T) (ok bool) {
+	_, ok = p[x]
+	return
+}
+
+func (p Gop_Set[T]) Gop_Or(q Gop_Set[T]) (r Gop_Set[T]) {
+	r = Gop_Set[T]{}
+	for x := range p {
+		r[x] = struct{}{}
+	}
+	for x := range q {
+		r[x] = struct{}{}
+	}
+	return
+}
+
+func (p Gop_Set[T]) Gop_And(q Gop_Set[T]) (r Gop_Set[T]) {
+	r = Gop_Set[T]{}
+	for x := range p {
+		if q.Gop_In(x) {
+			r[x] = struct{}{}
+		}
+	}
+	return
+}
+
+func (p Gop_Set[T]) Gop_Sub(q Gop_Set[T]) (r Gop_Set[T]) {
+	r = Gop_Set[T]{}
+	for x := range p {
+		if !q.Gop_In(x) {
+			r[x] = struct{}{}
+		}
+	}
+	return
+}
+`
+
+// isSetIdent reports whether x is the set of set literals and comprehensions,
+// which is an identifier named set that isn't declared.
+func isSetIdent(ctx *blockCtx, x ast.Expr) bool {
+	ident, ok := x.(*ast.Ident)
+	if !ok || ident.Name != "set" {
+		return false
+	}
+	if _, o := ctx.cb.Scope().LookupParent("set", token.NoPos); o != nil {
+		return false
+	}
+	return !ctx.loadSymbol("set")
+}
+
+// isSetMap reports whether t is map[T]struct{}.
+func isSetMap(t types.Type) bool {
+	if m, ok := t.(*types.Map); ok {
+		return types.Identical(m.Elem(), tyEmptyStruct)
+	}
+	return false
+}
+
+// compileSetLit compiles set literal v to a map literal of type typ, or of
+// type Gop_Set_T if typ is nil.
+func compileSetLit(ctx *blockCtx, v *ast.CompositeLit, typ types.Type) {
+	cb := ctx.cb
+	if len(v.Elts) == 0 && typ == nil {
+		panic(ctx.newCodeErrorf(v.Pos(), "cannot infer element type of empty set"))
+	}
+	for _, elt := range v.Elts {
+		if _, ok := elt.(*ast.KeyValueExpr); ok {
+			panic(ctx.newCodeErrorf(elt.Pos(), "unexpected key-value element in set literal"))
+		}
+		compileExpr(ctx, elt)
+		if typ == nil {
+			typ = setType(ctx, gox.Default(ctx.pkg, cb.Get(-1).Type), elt)
+		}
+		cb.ZeroLit(tyEmptyStruct)
+	}
+	cb.MapLit(typ, len(v.Elts)<<1)
+}
+
+// compileSetComprehension compiles `set{elt for k, v <- container, cond}` to:
+//
+//   func() (_gop_ret Gop_Set_T) {
+//       _gop_ret = Gop_Set_T{}
+//       for k, v := range container {
+//           if cond {
+//               _gop_ret[elt] = struct{}{}
+//           }
+//       }
+//       return
+//   }()
+func compileSetComprehension(ctx *blockCtx, v *ast.ComprehensionExpr) {
+	if !isSetIdent(ctx, v.Type) {
+		panic(ctx.newCodeErrorf(v.Pos(), "invalid set comprehension: set is redeclared"))
+	}
+	if _, ok := v.Elt.(*ast.KeyValueExpr); ok || v.Elt == nil {
+		panic(ctx.newCodeErrorf(v.Pos(), "invalid set comprehension: want set{expr for k, v <- container}"))
+	}
+	pkg, cb := ctx.pkg, ctx.cb
+	typ := setType(ctx, comprehensionEltType(ctx, v), v.Elt)
+	if dryResult(ctx, typ) {
+		return
+	}
+	ret := pkg.NewParam(token.NoPos, "_gop_ret", typ)
+	cb.NewClosure(nil, types.NewTuple(ret), false).BodyStart(pkg)
+	cb.VarRef(ret).MapLit(typ, 0).Assign(1)
+	end := compileForPhrases(ctx, v.Fors)
+	cb.Val(ret)
+	compileExpr(ctx, v.Elt)
+	cb.IndexRef(1).ZeroLit(tyEmptyStruct).Assign(1)
+	end()
+	cb.Return(0).End().Call(0)
+}
+
+// compileInExpr compiles `x in container`. It calls container.Gop_In(x) if
+// container has the method, or checks if x is a key of container if it is a
+// map, by:
+//
+//   func() (_gop_ok bool) {
+//       _, _gop_ok = container[x]
+//       return
+//   }()
+func compileInExpr(ctx *blockCtx, v *ast.BinaryExpr) {
+	pkg, cb := ctx.pkg, ctx.cb
+	compileExpr(ctx, v.Y)
+	t := cb.Get(-1).Type
+	if named, ok := t.(*types.Named); ok {
+		ctx.loadNamed(pkg, named)
+	}
+	if o, _, _ := types.LookupFieldOrMethod(t, true, pkg.Types, "Gop_In"); o != nil {
+		if _, ok := o.(*types.Func); ok {
+			compileOpMethodCall(ctx, "Gop_In", []ast.Expr{v.X}, v)
+			return
+		}
+	}
+	if _, ok := getUnderlying(ctx, t).(*types.Map); !ok {
+		src, pos := ctx.LoadExpr(v)
+		panic(newCodeErrorf(&pos, "invalid operation: %s (%v is not a map and has no method Gop_In)", src, t))
+	}
+	container := cb.InternalStack().Pop()
+	ok := pkg.NewParam(token.NoPos, "_gop_ok", types.Typ[types.Bool])
+	cb.NewClosure(nil, types.NewTuple(ok), false).BodyStart(pkg)
+	cb.VarRef(nil).VarRef(ok)
+	cb.InternalStack().Push(container)
+	compileExpr(ctx, v.X)
+	cb.Index(1, true, v).Assign(2, 1)
+	cb.Return(0).End().Call(0)
+}
+
+// setType returns the type Gop_Set_T of sets of type T values, and declares
+// it with its methods the first time.
+func setType(ctx *blockCtx, elt types.Type, src ast.Expr) *types.Named {
+	pkg := ctx.pkg
+	targs := []types.Type{elt}
+	if o := ctx.lookupInstance("Gop_Set", targs); o != nil {
+		return o.Type().(*types.Named)
+	}
+	if !types.Comparable(elt) {
+		panic(ctx.newCodeErrorf(src.Pos(), "invalid set: values of type %v are not comparable", elt))
+	}
+	if t := localInstance(ctx, "Gop_Set", gopSetSrc, targs, src); t != nil {
+		return t
+	}
+	decl := pkg.NewType(newInstanceName(ctx, "Gop_Set", targs, src))
+	t := decl.Type()
+	ctx.addInstance("Gop_Set", targs, t.Obj())
+	decl.InitType(pkg, types.NewMap(elt, tyEmptyStruct))
+	recv := pkg.NewParam(token.NoPos, "p", t)
+
+	// func (p Gop_Set_T) Gop_In(x T) (ok bool) { _, ok = p[x]; return }
+	x := pkg.NewParam(token.NoPos, "x", elt)
+	ok := pkg.NewParam(token.NoPos, "ok", types.Typ[types.Bool])
+	pkg.NewFunc(recv, "Gop_In", types.NewTuple(x), types.NewTuple(ok), false).BodyStart(pkg).
+		VarRef(nil).VarRef(ok).Val(recv).Val(x).Index(1, true).Assign(2, 1).
+		Return(0).End()
+
+	// Gop_Or:  for x := range p { r[x] = struct{}{} }; for x := range q { ... }
+	// Gop_And: for x := range p { if q.Gop_In(x) { r[x] = struct{}{} } }
+	// Gop_Sub: for x := range p { if !q.Gop_In(x) { r[x] = struct{}{} } }
+	for _, op := range [...]string{"Gop_Or", "Gop_And", "Gop_Sub"} {
+		q, r := pkg.NewParam(token.NoPos, "q", t), pkg.NewParam(token.NoPos, "r", t)
+		cb := pkg.NewFunc(recv, op, types.NewTuple(q), types.NewTuple(r), false).BodyStart(pkg)
+		cb.VarRef(r).MapLit(t, 0).Assign(1)
+		from := []types.Object{recv}
+		if op == "Gop_Or" {
+			from = append(from, q)
+		}
+		for _, set := range from {
+			cb.ForRange("x").Val(set).RangeAssignThen(token.NoPos)
+			x := cb.Scope().Lookup("x")
+			if op != "Gop_Or" {
+				cb.If().Val(q).MemberVal("Gop_In").Val(x).Call(1)
+				if op == "Gop_Sub" {
+					cb.UnaryOp(gotoken.NOT)
+				}
+				cb.Then()
+			}
+			cb.Val(r).Val(x).IndexRef(1).ZeroLit(tyEmptyStruct).Assign(1)
+			if op != "Gop_Or" {
+				cb.End()
+			}
+			cb.End()
+		}
+		cb.Return(0).End()
+	}
+	return t
+}
+
+// -----------------------------------------------------------------------------
@@ -22,6 +22,7 @@ import (
 	"log"
 	"path/filepath"
 	"reflect"
+	"strconv"
 
 	goast "go/ast"
 	gotoken "go/token"
@@ -188,7 +189,16 @@ func compileReturnStmt(ctx *blockCtx, expr *ast.ReturnStmt) {
 }
 
 func compileIncDecStmt(ctx *blockCtx, expr *ast.IncDecStmt) {
-	compileExprLHS(ctx, expr.X)
+	if p := compilePropertyLHS(ctx, expr.X); p != nil {
+		op := token.ADD
+		if expr.Tok == token.DEC {
+			op = token.SUB
+		}
+		compilePropertyAssign(ctx, p, op, func() {
+			ctx.cb.Val(1)
+		}, expr)
+		return
+	}
 	ctx.cb.IncDec(gotoken.Token(expr.Tok))
 }
 
@@ -228,8 +238,21 @@ func compileAssignStmt(ctx *blockCtx, expr *ast.AssignStmt) {
 		ctx.cb.EndInit(len(expr.Rhs))
 		return
 	}
-	for _, lhs := range expr.Lhs {
-		compileExprLHS(ctx, lhs)
+	if len(expr.Lhs) == 1 && len(expr.Rhs) == 1 {
+		if p := compilePropertyLHS(ctx, expr.Lhs[0]); p != nil {
+			op := token.ILLEGAL
+			if tok != token.ASSIGN {
+				op = tok - (token.ADD_ASSIGN - token.ADD)
+			}
+			compilePropertyAssign(ctx, p, op, func() {
+				compileExpr(ctx, expr.Rhs[0])
+			}, expr)
+			return
+		}
+	} else {
+		for _, lhs := range expr.Lhs {
+			compileExprLHS(ctx, lhs)
+		}
 	}
 	for _, rhs := range expr.Rhs {
 		switch e := rhs.(type) {
@@ -255,6 +278,90 @@ func compileAssignStmt(ctx *blockCtx, expr *ast.AssignStmt) {
 	ctx.cb.AssignOp(gotoken.Token(tok), expr)
 }
 
+// compilePropertyAssign compiles `p = rhs` to `x.SetP(rhs)`, or `p op= rhs`
+// to `x.SetP(x.P() op rhs)`, and likewise `x[i] = rhs` to
+// `x.Gop_SetIndex(i, rhs)`. The receiver and indices are evaluated only once.
+func compilePropertyAssign(ctx *blockCtx, p *propertyRef, op token.Token, rhs func(), src ast.Node) {
+	cb := ctx.cb
+	x, args := p.x, p.args
+	if op != token.ILLEGAL {
+		if !p.readable {
+			code, pos := ctx.LoadExpr(p.src)
+			if p.desc != "" {
+				panic(newCodeErrorf(&pos, "cannot use %s in assignment operation (%s is write-only: %v has no method %s)",
+					code, p.desc, x.Type, p.getter))
+			}
+			panic(newCodeErrorf(&pos, "cannot use %s in assignment operation (%v has no method %s)",
+				code, x.Type, p.getter))
+		}
+		block := false
+		evalOnce := func(e *gox.Element, name string) *gox.Element {
+			if isSimpleExpr(e.Val) {
+				return e
+			}
+			if !block {
+				block = true
+				cb.Block()
+			}
+			cb.DefineVarStart(token.NoPos, name)
+			cb.InternalStack().Push(e)
+			cb.EndInit(1)
+			cb.Val(cb.Scope().Lookup(name))
+			return cb.InternalStack().Pop()
+		}
+		x = evalOnce(x, "_gop_recv")
+		if args != nil {
+			args = make([]*gox.Element, len(p.args))
+			for i, arg := range p.args {
+				args[i] = evalOnce(arg, "_gop_idx"+strconv.Itoa(i+1))
+			}
+		}
+		if block {
+			defer cb.End()
+		}
+	}
+	pushArgs := func() {
+		for _, arg := range args {
+			cb.InternalStack().Push(arg)
+		}
+	}
+	cb.InternalStack().Push(x)
+	if _, err := cb.Member(p.setter, gox.MemberFlagMethodAlias, p.src); err != nil {
+		panic(err)
+	}
+	pushArgs()
+	if op != token.ILLEGAL {
+		cb.InternalStack().Push(x)
+		if _, err := cb.Member(p.getter, gox.MemberFlagMethodAlias, p.src); err != nil {
+			panic(err)
+		}
+		pushArgs()
+		cb.CallWith(len(args), 0, p.src)
+		rhs()
+		cb.BinaryOp(gotoken.Token(op), src)
+	} else {
+		rhs()
+	}
+	cb.CallWith(len(args)+1, 0, src).EndStmt()
+}
+
+// isSimpleExpr reports whether evaluating x twice has no side effects.
+func isSimpleExpr(x goast.Expr) bool {
+	switch v := x.(type) {
+	case *goast.Ident, *goast.BasicLit:
+		return true
+	case *goast.SelectorExpr:
+		return isSimpleExpr(v.X)
+	case *goast.ParenExpr:
+		return isSimpleExpr(v.X)
+	case *goast.StarExpr:
+		return isSimpleExpr(v.X)
+	case *goast.UnaryExpr:
+		return (v.Op == gotoken.AND || v.Op == gotoken.SUB) && isSimpleExpr(v.X)
+	}
+	return false
+}
+
 // forRange(names...) x rangeAssignThen
 //    body
 // end
@@ -267,7 +374,13 @@ func compileRangeStmt(ctx *blockCtx, v *ast.RangeStmt) {
 		if v.Tok == token.ASSIGN {
 			tok = v.Tok
 		}
-		compileForStmt(ctx, toForStmt(v.For, v.Key, v.Body, re, tok, nil))
+		if v.Value != nil {
+			panic(errRangeVars(ctx, v.Value, re))
+		}
+		end := compileRangeLoop(ctx, v.For, v.Key, re, tok)
+		compileStmts(ctx, v.Body.List)
+		setBodyHandler(ctx)
+		end()
 		return
 	}
 	cb := ctx.cb
@@ -314,11 +427,28 @@ func compileRangeStmt(ctx *blockCtx, v *ast.RangeStmt) {
 }
 
 func compileForPhraseStmt(ctx *blockCtx, v *ast.ForPhraseStmt) {
+	cb := ctx.cb
 	if re, ok := v.X.(*ast.RangeExpr); ok {
-		compileForStmt(ctx, toForStmt(v.For, v.Value, v.Body, re, token.DEFINE, v.ForPhrase))
+		if v.Key != nil {
+			panic(errRangeVars(ctx, v.Key, re))
+		}
+		end := compileRangeLoop(ctx, v.For, v.Value, re, token.DEFINE)
+		if v.Cond != nil {
+			cb.If()
+			if v.Init != nil {
+				compileStmt(ctx, v.Init)
+			}
+			compileExpr(ctx, v.Cond)
+			cb.Then()
+			compileStmts(ctx, v.Body.List)
+			cb.End()
+		} else {
+			compileStmts(ctx, v.Body.List)
+		}
+		setBodyHandler(ctx)
+		end()
 		return
 	}
-	cb := ctx.cb
 	comments, once := cb.BackupComments()
 	names := make([]string, 1, 2)
 	if v.Key == nil {
@@ -330,7 +460,7 @@ func compileForPhraseStmt(ctx *blockCtx, v *ast.ForPhraseStmt) {
 		names = append(names, v.Value.Name)
 	}
 	cb.ForRange(names...)
-	compileExpr(ctx, v.X)
+	compileForPhraseX(ctx, v.X)
 	cb.RangeAssignThen(v.TokPos)
 	if v.Cond != nil {
 		cb.If()
@@ -347,92 +477,335 @@ func compileForPhraseStmt(ctx *blockCtx, v *ast.ForPhraseStmt) {
 	cb.End()
 }
 
-func toForStmt(forPos token.Pos, value ast.Expr, body *ast.BlockStmt, re *ast.RangeExpr, tok token.Token, fp *ast.ForPhrase) *ast.ForStmt {
-	nilIdent := value == nil
-	if !nilIdent {
-		if v, ok := value.(*ast.Ident); ok && v.Name == "_" {
-			nilIdent = true
+// compileForPhraseX compiles container x of a for phrase. An io.Reader which
+// can't be ranged over is compiled to lines(x), whose values are its lines.
+func compileForPhraseX(ctx *blockCtx, x ast.Expr) {
+	cb := ctx.cb
+	compileExpr(ctx, x)
+	if isLineReader(ctx, cb.Get(-1).Type) {
+		r := cb.InternalStack().Pop()
+		cb.Val(ctx.pkg.Import("github.com/goplus/gop/builtin/iox").Ref("Lines"))
+		cb.InternalStack().Push(r)
+		cb.CallWith(1, 0, x)
+	}
+}
+
+// isLineReader reports whether typ is an io.Reader without a Gop_Enum method,
+// whose underlying type isn't a slice, map, string or other rangeable type.
+func isLineReader(ctx *blockCtx, typ types.Type) bool {
+	switch t := getUnderlying(ctx, typ).(type) {
+	case *types.Pointer:
+		if named, ok := t.Elem().(*types.Named); ok {
+			ctx.loadNamed(ctx.pkg, named)
+		} else if _, ok := t.Elem().Underlying().(*types.Array); ok {
+			return false
 		}
+	case *types.Struct, *types.Interface:
+	default:
+		return false
 	}
-	if nilIdent {
-		value = &ast.Ident{NamePos: forPos, Name: "_gop_k"}
+	pkg := ctx.pkg
+	if m, _, _ := types.LookupFieldOrMethod(typ, false, pkg.Types, "Gop_Enum"); m != nil {
+		return false
 	}
-	first := re.First
+	reader := pkg.Import("io").Ref("Reader").Type().Underlying().(*types.Interface)
+	return types.Implements(typ, reader)
+}
+
+// compileRangeLoop starts a for statement over range expression re, which is
+// ended by calling end after its body is compiled:
+//
+//   for value := first; value < last; value += step {
+//
+// The condition is value > last if step is a negative constant. Operands that
+// aren't variables or constants are evaluated once:
+//
+//   for value, _gop_end, _gop_step := first, last, step; value < _gop_end; value += _gop_step {
+//
+// The type of value is the type of the first typed operand, or the default type
+// of the widest untyped one, eg. float64 for 0:1:0.1, and bigint for 0:10r.
+// Floating-point values are computed as first + n*step so that rounding errors
+// don't accumulate:
+//
+//   for value, _gop_n := first, 1; value < last; value, _gop_n = first+float64(_gop_n)*step, _gop_n+1 {
+//
+// Integer values that may overflow when stepping past last stop the loop
+// instead of wrapping around:
+//
+//   for value, _gop_ok := first, true; _gop_ok && value < last; value, _gop_ok = value+step, value < value+step {
+func compileRangeLoop(ctx *blockCtx, forPos token.Pos, value ast.Expr, re *ast.RangeExpr, tok token.Token) (end func()) {
+	cb := ctx.cb
+	comments, once := cb.BackupComments()
+	ident := func(name string) *ast.Ident {
+		return &ast.Ident{NamePos: forPos, Name: name}
+	}
+	first, last, step := re.First, re.Last, re.Expr3
 	if first == nil {
 		first = &ast.BasicLit{ValuePos: forPos, Kind: token.INT, Value: "0"}
 	}
-	initLhs := []ast.Expr{value}
-	initRhs := []ast.Expr{first}
-	replaceValue := false
-	var cond ast.Expr
-	var post ast.Expr
-	switch re.Last.(type) {
-	case *ast.Ident, *ast.BasicLit:
-		cond = re.Last
-	default:
-		replaceValue = true
-		cond = &ast.Ident{NamePos: forPos, Name: "_gop_end"}
-		initLhs = append(initLhs, cond)
-		initRhs = append(initRhs, re.Last)
+	if step == nil {
+		step = &ast.BasicLit{ValuePos: forPos, Kind: token.INT, Value: "1"}
 	}
-	if re.Expr3 == nil {
-		post = &ast.BasicLit{ValuePos: forPos, Kind: token.INT, Value: "1"}
+	if v, ok := value.(*ast.Ident); value == nil || (ok && v.Name == "_") {
+		value, tok = ident("_gop_k"), token.DEFINE
+	}
+	bounds := []ast.Expr{first, last, step}
+	vals := make([]*gox.Element, len(bounds))
+	for i, x := range bounds {
+		compileExpr(ctx, x)
+		vals[i] = cb.InternalStack().Pop()
+	}
+	var typ types.Type
+	if tok == token.ASSIGN {
+		compileExpr(ctx, value)
+		typ = cb.InternalStack().Pop().Type
 	} else {
-		switch re.Expr3.(type) {
-		case *ast.Ident, *ast.BasicLit:
-			post = re.Expr3
-		default:
-			replaceValue = true
-			post = &ast.Ident{NamePos: forPos, Name: "_gop_step"}
-			initLhs = append(initLhs, post)
-			initRhs = append(initRhs, re.Expr3)
-		}
-	}
-	if tok == token.ASSIGN && replaceValue {
-		oldValue := value
-		value = &ast.Ident{NamePos: forPos, Name: "_gop_k"}
-		initLhs[0] = value
-		body.List = append([]ast.Stmt{&ast.AssignStmt{
-			Lhs:    []ast.Expr{oldValue},
-			TokPos: forPos,
-			Tok:    token.ASSIGN,
-			Rhs:    []ast.Expr{value},
-		}}, body.List...)
-		tok = token.DEFINE
-	}
-	if fp != nil && fp.Cond != nil {
-		condStmt := &ast.IfStmt{
-			If:   fp.IfPos,
-			Init: fp.Init,
-			Cond: fp.Cond,
-			Body: body,
-		}
-		body = &ast.BlockStmt{
-			List: []ast.Stmt{condStmt},
-		}
-	}
-	return &ast.ForStmt{
-		For: forPos,
-		Init: &ast.AssignStmt{
-			Lhs:    initLhs,
-			TokPos: re.To,
-			Tok:    tok,
-			Rhs:    initRhs,
-		},
-		Cond: &ast.BinaryExpr{
-			X:     value,
-			OpPos: re.To,
-			Op:    token.LSS,
-			Y:     cond,
-		},
-		Post: &ast.AssignStmt{
-			Lhs:    []ast.Expr{value},
-			TokPos: re.Colon2,
-			Tok:    token.ADD_ASSIGN,
-			Rhs:    []ast.Expr{post},
-		},
-		Body: body,
+		typ = rangeType(ctx, vals)
+	}
+	t, basic := typ.Underlying().(*types.Basic)
+	float := basic && t.Info()&types.IsFloat != 0
+	unsigned := basic && t.Info()&types.IsUnsigned != 0
+	guard := basic && t.Info()&types.IsInteger != 0 && rangeMayOverflow(t, vals[1].CVal, vals[2].CVal)
+
+	pushBound := func(i int) { // first is converted to typ
+		if i == 0 && tok == token.DEFINE && !types.Identical(gox.Default(ctx.pkg, vals[0].Type), typ) {
+			cb.Typ(typ)
+			cb.InternalStack().Push(vals[0])
+			cb.CallWith(1, 0, re)
+		} else {
+			cb.InternalStack().Push(vals[i])
+		}
+	}
+	block := float && !isSimpleOperand(first, vals[0])
+	if block { // { _gop_start := first; for ... }
+		cb.Block().DefineVarStart(re.To, "_gop_start")
+		pushBound(0)
+		cb.EndInit(1)
+		first = ident("_gop_start")
+		compileExpr(ctx, first)
+		vals[0] = cb.InternalStack().Pop()
+	}
+
+	loopVar := value
+	replaceValue := tok == token.ASSIGN && (float || guard || !isSimpleOperand(last, vals[1]) || !isSimpleOperand(step, vals[2]))
+	if replaceValue {
+		loopVar, tok = ident("_gop_k"), token.DEFINE
+	}
+	cb.For() // the step is checked in the for statement to recover from errors in comprehensions
+	if c := vals[2].CVal; c != nil && (c.Kind() == constant.Int || c.Kind() == constant.Float) {
+		if constant.Sign(c) == 0 {
+			panic(ctx.newCodeErrorf(step.Pos(), "range step must not be zero"))
+		}
+		if unsigned && constant.Sign(c) < 0 {
+			panic(ctx.newCodeErrorf(step.Pos(), "negative range step %v for unsigned type %v", c, typ))
+		}
+	}
+	if tok == token.DEFINE {
+		names := []string{loopVar.(*ast.Ident).Name}
+		inits := []int{0}
+		temp := func(i int, name string) ast.Expr {
+			if isSimpleOperand(bounds[i], vals[i]) {
+				return bounds[i]
+			}
+			names, inits = append(names, name), append(inits, i)
+			return ident(name)
+		}
+		last, step = temp(1, "_gop_end"), temp(2, "_gop_step")
+		if float {
+			names = append(names, "_gop_n")
+		} else if guard {
+			names = append(names, "_gop_ok")
+		}
+		cb.DefineVarStart(re.To, names...)
+		for _, i := range inits {
+			pushBound(i)
+		}
+		if float {
+			cb.Val(1)
+		} else if guard {
+			cb.Val(true)
+		}
+		cb.EndInit(len(names))
+	} else {
+		compileExprLHS(ctx, value)
+		pushBound(0)
+		cb.Assign(1)
+	}
+	// The comparison depends on the sign of step: it is chosen at compile time
+	// for a constant step or an unsigned type, and at run time otherwise.
+	cmp := func(op gotoken.Token) {
+		compileExpr(ctx, loopVar)
+		compileExpr(ctx, last)
+		cb.BinaryOp(op, re)
+	}
+	next := func(op gotoken.Token) { // value < value+step
+		compileExpr(ctx, loopVar)
+		compileExpr(ctx, loopVar)
+		compileExpr(ctx, step)
+		cb.BinaryOp(gotoken.ADD, re)
+		cb.BinaryOp(op, re)
+	}
+	bySign := func(cond func(op gotoken.Token)) {
+		if c := vals[2].CVal; c != nil && (c.Kind() == constant.Int || c.Kind() == constant.Float) {
+			if constant.Sign(c) < 0 {
+				cond(gotoken.GTR)
+			} else {
+				cond(gotoken.LSS)
+			}
+		} else if unsigned {
+			cond(gotoken.LSS)
+		} else { // step > 0 && value < last || step < 0 && value > last
+			compileExpr(ctx, step)
+			cb.Val(0).BinaryOp(gotoken.GTR, re)
+			cond(gotoken.LSS)
+			cb.BinaryOp(gotoken.LAND)
+			compileExpr(ctx, step)
+			cb.Val(0).BinaryOp(gotoken.LSS, re)
+			cond(gotoken.GTR)
+			cb.BinaryOp(gotoken.LAND).BinaryOp(gotoken.LOR)
+		}
+	}
+	if guard {
+		compileExpr(ctx, ident("_gop_ok"))
+		bySign(cmp)
+		cb.BinaryOp(gotoken.LAND)
+	} else {
+		bySign(cmp)
+	}
+	cb.Then()
+	if replaceValue {
+		compileExprLHS(ctx, value)
+		compileExpr(ctx, loopVar)
+		cb.Assign(1)
+	}
+	return func() {
+		cb.Post()
+		switch {
+		case float: // value, _gop_n = first+T(_gop_n)*step, _gop_n+1
+			n := ident("_gop_n")
+			compileExprLHS(ctx, loopVar)
+			compileExprLHS(ctx, n)
+			compileExpr(ctx, first)
+			cb.Typ(typ)
+			compileExpr(ctx, n)
+			cb.Call(1)
+			compileExpr(ctx, step)
+			cb.BinaryOp(gotoken.MUL, re).BinaryOp(gotoken.ADD, re)
+			compileExpr(ctx, n)
+			cb.Val(1).BinaryOp(gotoken.ADD)
+			cb.Assign(2)
+		case guard: // value, _gop_ok = value+step, value < value+step
+			compileExprLHS(ctx, loopVar)
+			compileExprLHS(ctx, ident("_gop_ok"))
+			compileExpr(ctx, loopVar)
+			compileExpr(ctx, step)
+			cb.BinaryOp(gotoken.ADD, re)
+			bySign(next)
+			cb.Assign(2)
+		case basic: // value += step
+			compileExprLHS(ctx, loopVar)
+			compileExpr(ctx, step)
+			cb.AssignOp(gotoken.ADD_ASSIGN, re)
+		default: // value = value + step, as bigint values are pointers
+			compileExprLHS(ctx, loopVar)
+			compileExpr(ctx, loopVar)
+			compileExpr(ctx, step)
+			cb.BinaryOp(gotoken.ADD, re)
+			cb.Assign(1)
+		}
+		cb.SetComments(comments, once)
+		cb.End()
+		if block {
+			cb.End()
+		}
+	}
+}
+
+// rangeMayOverflow reports whether a value of integer type t may overflow when
+// stepping past last, ie. the largest value before last plus step may not be
+// representable by t. last and step are nil if they aren't constants.
+func rangeMayOverflow(t *types.Basic, last, step constant.Value) bool {
+	if step == nil {
+		return true
+	}
+	if step = constant.ToInt(step); step.Kind() != constant.Int {
+		return true
 	}
+	sign := constant.MakeInt64(int64(constant.Sign(step)))
+	if last == nil {
+		return constant.Compare(step, gotoken.NEQ, sign) // value < last implies value+1 <= max
+	}
+	if last = constant.ToInt(last); last.Kind() != constant.Int {
+		return true
+	}
+	end := constant.BinaryOp(constant.BinaryOp(last, gotoken.SUB, sign), gotoken.ADD, step)
+	bits := uint(64)
+	switch t.Kind() {
+	case types.Int8, types.Uint8:
+		bits = 8
+	case types.Int16, types.Uint16:
+		bits = 16
+	case types.Int32, types.Uint32:
+		bits = 32
+	}
+	one := constant.MakeInt64(1)
+	min, max := constant.MakeInt64(0), constant.Shift(one, gotoken.SHL, bits)
+	if t.Info()&types.IsUnsigned == 0 {
+		max = constant.Shift(one, gotoken.SHL, bits-1)
+		min = constant.UnaryOp(gotoken.SUB, max, 0)
+	}
+	return constant.Compare(end, gotoken.LSS, min) || constant.Compare(end, gotoken.GEQ, max)
+}
+
+// isSimpleOperand reports whether operand x of a range expression is cheap to
+// evaluate in every iteration, ie. it's a variable or a constant of a basic
+// type (big constants are not).
+func isSimpleOperand(x ast.Expr, v *gox.Element) bool {
+	if v.CVal == nil {
+		_, ok := x.(*ast.Ident)
+		return ok
+	}
+	_, ok := v.Type.(*types.Basic)
+	return ok
+}
+
+// rangeType returns the type of the values of a range expression whose
+// operands are vals.
+func rangeType(ctx *blockCtx, vals []*gox.Element) types.Type {
+	var typ types.Type
+	rank := -1
+	for _, v := range vals {
+		r := untypedRank(ctx, v.Type)
+		if r < 0 {
+			return v.Type
+		}
+		if r > rank {
+			typ, rank = v.Type, r
+		}
+	}
+	return gox.Default(ctx.pkg, typ)
+}
+
+func untypedRank(ctx *blockCtx, typ types.Type) int {
+	switch t := typ.(type) {
+	case *types.Basic:
+		if t.Info()&types.IsUntyped != 0 {
+			return int(t.Kind())
+		}
+	case *types.Named:
+		if gox.Default(ctx.pkg, t) != t { // untyped bigint, bigrat, ...
+			if t.Obj().Name() == "UntypedBigint" {
+				return int(types.UntypedNil) + 1
+			}
+			return int(types.UntypedNil) + 2
+		}
+	}
+	return -1
+}
+
+func errRangeVars(ctx *blockCtx, v ast.Node, x *ast.RangeExpr) error {
+	src, _ := ctx.LoadExpr(x)
+	return ctx.newCodeErrorf(v.Pos(), "range over %s permits only one iteration variable", src)
 }
 
 // for init; cond then
@@ -580,8 +953,10 @@ func compileSwitchStmt(ctx *blockCtx, v *ast.SwitchStmt) {
 	if v.Init != nil {
 		compileStmt(ctx, v.Init)
 	}
+	var tag types.Type
 	if v.Tag != nil { // switch tag {....}
 		compileExpr(ctx, v.Tag)
+		tag = cb.Get(-1).Type
 	} else {
 		cb.None() // switch {...}
 	}
@@ -636,6 +1011,7 @@ func compileSwitchStmt(ctx *blockCtx, v *ast.SwitchStmt) {
 		commentStmt(ctx, stmt)
 		cb.End()
 	}
+	checkEnumSwitch(ctx, v, tag, seen)
 	cb.SetComments(comments, once)
 	cb.End()
 }
@@ -0,0 +1,1488 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	goast "go/ast"
+	gotoken "go/token"
+	"go/types"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+	"github.com/goplus/gox"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// -----------------------------------------------------------------------------
+
+// Generic types and functions are compiled to Go generics. A generic is
+// compiled once, with placeholders for its type parameters: named types of the
+// same names, whose underlying types and methods are taken from their type
+// constraints, so that gox can check it as an ordinary declaration.
+//
+// An instance of a generic type, like `Map[string, int]`, is a named type of
+// that name, whose underlying type and methods are the ones of the generic with
+// its type arguments substituted. Instances aren't declared: references to them
+// are rewritten to Go instantiations once the package is compiled (see
+// completeGenerics), and so are the type parameter lists of the generics.
+
+type genericMethod struct {
+	ctx    *blockCtx
+	goFile string
+	decl   *ast.FuncDecl
+}
+
+type genericDecl struct {
+	ctx     *blockCtx
+	goFile  string
+	name    *ast.Ident
+	doc     *ast.CommentGroup
+	spec    *ast.TypeSpec // generic type or type constraint
+	fn      *ast.FuncDecl // generic function
+	methods []*genericMethod
+	fromGo  bool
+	loaded  bool
+
+	tparams  []types.Type          // placeholders of the type parameters
+	bindings map[string]types.Type // placeholders by type parameter names
+	cores    []types.Type          // core types of the type constraints
+	sig      *types.Signature      // signature of a generic function
+}
+
+// typeInstance is an instance of a generic type, which is a *genericDecl or the
+// *types.TypeName of a generic type of Go.
+type typeInstance struct {
+	gen   interface{}
+	name  string
+	targs []types.Type
+}
+
+// instance is an instance of a generic, or of the generic types Gop_Lazy and
+// Gop_Set declared by the compiler.
+type instance struct {
+	targs []types.Type
+	obj   types.Object
+}
+
+func (p *genericDecl) typeParams() *ast.FieldList {
+	if p.fn != nil {
+		return p.fn.Type.TypeParams
+	}
+	return p.spec.TypeParams
+}
+
+// isConstraint reports whether p is an interface that can only be used as a
+// type constraint.
+func (p *genericDecl) isConstraint() bool {
+	return p.spec != nil && (p.spec.TypeParams == nil || isConstraintType(p.spec.Type))
+}
+
+// init creates the placeholders of the type parameters of p, and the signature
+// of p if it is a generic function.
+func (p *genericDecl) init() {
+	if p.tparams != nil || p.isConstraint() {
+		return
+	}
+	names, constraints := typeParamList(p.typeParams())
+	tparams, bindings := newTypeParams(p.ctx, names, names, constraints)
+	tctx := p.ctx.withTypeArgs(bindings)
+	cores := make([]types.Type, len(constraints))
+	for i, c := range constraints {
+		if core := coreTypeExpr(c); core != nil {
+			cores[i] = toType(tctx, core)
+		}
+	}
+	if p.fn != nil {
+		p.sig = toFuncType(tctx, p.fn.Type, nil)
+	}
+	p.tparams, p.bindings, p.cores = tparams, bindings, cores
+}
+
+func getGeneric(ctx *pkgCtx, name string) *genericDecl {
+	gen, ok := ctx.generics[name]
+	if !ok {
+		gen = &genericDecl{}
+		ctx.generics[name] = gen
+		ctx.genlist = append(ctx.genlist, gen)
+	}
+	return gen
+}
+
+func preloadGeneric(
+	ctx *blockCtx, goFile string, name *ast.Ident, doc *ast.CommentGroup, spec *ast.TypeSpec, fn *ast.FuncDecl) {
+	if debugLoad {
+		log.Println("==> Preload generic", name.Name)
+	}
+	if !typeParamsSupported {
+		pos := ctx.Position(name.Pos())
+		ctx.handleCodeErrorf(&pos, "type parameters require go1.18 or later")
+		return
+	}
+	gen := getGeneric(ctx.pkgCtx, name.Name)
+	if gen.name != nil {
+		pos := ctx.Position(name.Pos())
+		ctx.handleCodeErrorf(&pos, "%s redeclared in this block\n\tprevious declaration at %v",
+			name.Name, ctx.Position(gen.name.Pos()))
+		return
+	}
+	gen.ctx, gen.goFile, gen.name, gen.doc, gen.spec, gen.fn = ctx, goFile, name, doc, spec, fn
+	gen.fromGo = goFile == skippingGoFile
+}
+
+func preloadGenericMethod(ctx *blockCtx, goFile string, name *ast.Ident, d *ast.FuncDecl) {
+	if debugLoad {
+		log.Printf("==> Preload method %s.%s\n", name.Name, d.Name.Name)
+	}
+	gen := getGeneric(ctx.pkgCtx, name.Name)
+	gen.methods = append(gen.methods, &genericMethod{ctx: ctx, goFile: goFile, decl: d})
+}
+
+// checkGenerics reports the methods whose generic receiver type is undeclared,
+// and the generics that conflict with other package-level declarations.
+func checkGenerics(ctx *pkgCtx) {
+	for _, gen := range ctx.genlist {
+		if gen.name == nil {
+			for _, m := range gen.methods {
+				name, _, _ := getGenericRecv(m.decl.Recv)
+				pos := ctx.Position(name.Pos())
+				ctx.handleCodeErrorf(&pos, "undefined: %s", name.Name)
+			}
+			continue
+		}
+		if len(gen.methods) > 0 && (gen.fn != nil || gen.isConstraint()) {
+			name, _, _ := getGenericRecv(gen.methods[0].decl.Recv)
+			pos := ctx.Position(name.Pos())
+			ctx.handleCodeErrorf(&pos, "%s is not a generic type", name.Name)
+			gen.methods = nil
+		}
+		if old, ok := ctx.syms[gen.name.Name]; ok && old.pos() != token.NoPos {
+			start, prev := gen.name.Pos(), old.pos()
+			if start < prev {
+				start, prev = prev, start
+			}
+			pos := ctx.Position(start)
+			ctx.handleCodeErrorf(&pos, "%s redeclared in this block\n\tprevious declaration at %v",
+				gen.name.Name, ctx.Position(prev))
+			gen.loaded = true // not to declare it again
+		}
+	}
+}
+
+// loadGeneric generates the declaration of generic name, and of its methods if
+// it is a generic type.
+func loadGeneric(ctx *pkgCtx, name string) {
+	gen, ok := ctx.generics[name]
+	if !ok || gen.name == nil || gen.fromGo || gen.loaded {
+		return
+	}
+	gen.loaded = true
+	ctx.genRefs = true
+	if enableRecover {
+		defer func() {
+			if e := recover(); e != nil {
+				ctx.handleRecover(e)
+			}
+		}()
+	}
+	if debugLoad {
+		log.Println("==> Load generic", name)
+	}
+	gen.init()
+	bctx := gen.ctx.withTypeArgs(gen.bindings)
+	pkg := bctx.pkg
+	old, _ := pkg.SetCurFile(gen.goFile, true)
+	defer pkg.RestoreCurFile(old)
+	if gen.fn != nil {
+		loadFunc(bctx, nil, gen.fn)
+		return
+	}
+	decl := pkg.NewType(name, gen.name.Pos())
+	if gen.doc != nil {
+		decl.SetComments(gen.doc)
+	}
+	if gen.isConstraint() { // the type set is written by completeGenerics
+		decl.InitType(pkg, types.NewInterfaceType(nil, nil).Complete())
+		return
+	}
+	decl.InitType(pkg, toType(bctx, gen.spec.Type))
+	for _, m := range gen.methods {
+		loadGenericMethod(gen, m)
+	}
+}
+
+// loadGenericMethod generates the declaration of method m of generic type gen,
+// whose receiver is an instance of gen with placeholders named after the type
+// parameters of the receiver.
+func loadGenericMethod(gen *genericDecl, m *genericMethod) {
+	names := recvTypeParams(m.ctx, m, len(gen.tparams))
+	dnames, constraints := typeParamList(gen.spec.TypeParams)
+	targs, bindings := newTypeParams(m.ctx, names, dnames, constraints)
+	mctx := m.ctx.withTypeArgs(bindings)
+	instantiateTypeEx(mctx, gen, targs, m.decl, m.decl) // gox adds m to it
+	pkg := mctx.pkg
+	old, _ := pkg.SetCurFile(m.goFile, true)
+	defer pkg.RestoreCurFile(old)
+	loadFunc(mctx, toRecv(mctx, m.decl.Recv), m.decl)
+}
+
+// recvTypeParams returns the type parameters of the receiver of method m of a
+// generic type with n type parameters.
+func recvTypeParams(ctx *blockCtx, m *genericMethod, n int) []*ast.Ident {
+	_, tparams, _ := getGenericRecv(m.decl.Recv)
+	if len(tparams) != n {
+		panic(ctx.newCodeErrorf(m.decl.Recv.Pos(), "got %d type parameters, but receiver base type declares %d",
+			len(tparams), n))
+	}
+	names := make([]*ast.Ident, n)
+	for i, tparam := range tparams {
+		ident, ok := tparam.(*ast.Ident)
+		if !ok {
+			src, _ := ctx.LoadExpr(tparam)
+			panic(ctx.newCodeErrorf(tparam.Pos(), "receiver type parameter %s must be an identifier", src))
+		}
+		names[i] = ident
+	}
+	return names
+}
+
+// getGenericRecv returns the base type name and type parameters of a method
+// receiver like `(p *Map[K, V])`.
+func getGenericRecv(recv *ast.FieldList) (name *ast.Ident, tparams []ast.Expr, ok bool) {
+	typ := recv.List[0].Type
+	if t, ok := typ.(*ast.StarExpr); ok {
+		typ = t.X
+	}
+	var x ast.Expr
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		x, tparams = t.X, []ast.Expr{t.Index}
+	case *ast.IndexListExpr:
+		x, tparams = t.X, t.Indices
+	default:
+		return
+	}
+	name, ok = x.(*ast.Ident)
+	return
+}
+
+// isConstraintType reports whether typ is an interface containing type
+// constraints (unions, approximation elements or comparable).
+func isConstraintType(typ ast.Expr) bool {
+	t, ok := typ.(*ast.InterfaceType)
+	if !ok {
+		return false
+	}
+	for _, fld := range t.Methods.List {
+		if len(fld.Names) > 0 {
+			continue
+		}
+		switch v := fld.Type.(type) {
+		case *ast.BinaryExpr, *ast.UnaryExpr:
+			return true
+		case *ast.Ident:
+			if v.Name == "comparable" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeParamList(list *ast.FieldList) (names []*ast.Ident, constraints []ast.Expr) {
+	if list == nil {
+		return
+	}
+	for _, fld := range list.List {
+		for _, name := range fld.Names {
+			names = append(names, name)
+			constraints = append(constraints, fld.Type)
+		}
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+
+// newTypeParams returns the placeholders of type parameters names, whose type
+// constraints are declared for the type parameters dnames (the receiver of a
+// method can name the type parameters of its type differently).
+func newTypeParams(ctx *blockCtx, names, dnames []*ast.Ident, constraints []ast.Expr) (
+	tparams []types.Type, bindings map[string]types.Type) {
+	pkg := ctx.pkg.Types
+	tparams = make([]types.Type, len(names))
+	bindings = make(map[string]types.Type, len(names))
+	cbindings := make(map[string]types.Type, len(names))
+	for i, name := range names {
+		t := types.NewNamed(types.NewTypeName(name.Pos(), pkg, name.Name, nil), nil, nil)
+		ctx.placeholders[t] = true
+		tparams[i] = t
+		if name.Name != "_" {
+			bindings[name.Name] = t
+		}
+		cbindings[dnames[i].Name] = t
+	}
+	cctx := ctx.withTypeArgs(cbindings)
+	for i, t := range tparams {
+		initTypeParam(cctx, t.(*types.Named), constraints[i])
+	}
+	return
+}
+
+// initTypeParam sets the underlying type and methods of placeholder t from
+// type constraint c: the underlying type is the first type in the type set of
+// c, or c itself if c has only methods.
+func initTypeParam(ctx *blockCtx, t *types.Named, c ast.Expr) {
+	under, methods := constraintOf(ctx, c)
+	t.SetUnderlying(under)
+	for _, m := range methods {
+		sig := m.Type().(*types.Signature)
+		recv := types.NewVar(token.NoPos, ctx.pkg.Types, "", t)
+		t.AddMethod(types.NewFunc(m.Pos(), m.Pkg(), m.Name(),
+			types.NewSignature(recv, sig.Params(), sig.Results(), sig.Variadic())))
+	}
+}
+
+func constraintOf(ctx *blockCtx, c ast.Expr) (under types.Type, methods []*types.Func) {
+	switch v := c.(type) {
+	case *ast.Ident:
+		switch v.Name {
+		case "any", "comparable":
+			return types.NewInterfaceType(nil, nil).Complete(), nil
+		}
+		if gen := ctx.lookupGeneric(v); gen != nil && gen.isConstraint() {
+			return constraintOf(gen.ctx, gen.spec.Type)
+		}
+	case *ast.ParenExpr:
+		return constraintOf(ctx, v.X)
+	case *ast.BinaryExpr:
+		if v.Op == token.OR {
+			return constraintOf(ctx, v.X)
+		}
+	case *ast.UnaryExpr:
+		if v.Op == token.TILDE {
+			return toType(ctx, v.X).Underlying(), nil
+		}
+	case *ast.InterfaceType:
+		var elems, fields []*ast.Field
+		for _, fld := range v.Methods.List {
+			if len(fld.Names) > 0 {
+				fields = append(fields, fld)
+			} else {
+				elems = append(elems, fld)
+			}
+		}
+		iface := toInterfaceType(ctx, &ast.InterfaceType{Methods: &ast.FieldList{List: fields}}).(*types.Interface)
+		if elems == nil {
+			return iface, nil
+		}
+		under, methods = constraintOf(ctx, elems[0].Type)
+		for i, n := 0, iface.NumMethods(); i < n; i++ {
+			methods = append(methods, iface.Method(i))
+		}
+		return
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		x, indices := splitIndexExpr(c)
+		if gen := ctx.lookupGeneric(x); gen != nil && gen.spec != nil {
+			if _, ok := gen.spec.Type.(*ast.InterfaceType); ok {
+				bindings := checkTypeArgs(ctx, gen, toTypes(ctx, indices), c)
+				return constraintOf(gen.ctx.withTypeArgs(bindings), gen.spec.Type)
+			}
+		}
+	}
+	typ := toType(ctx, c)
+	if iface, ok := typ.Underlying().(*types.Interface); ok {
+		if term := firstTermOf(iface); term != nil {
+			for i, n := 0, iface.NumMethods(); i < n; i++ {
+				methods = append(methods, iface.Method(i))
+			}
+			return term.Underlying(), methods
+		}
+		return iface, nil
+	}
+	return typ.Underlying(), nil
+}
+
+// hasTypeParam reports whether t refers to a placeholder of a type parameter.
+func (p *pkgCtx) hasTypeParam(t types.Type) bool {
+	switch v := t.(type) {
+	case *types.Named:
+		if p.placeholders[v] {
+			return true
+		}
+		if inst, ok := p.typeInsts[v]; ok {
+			return p.hasTypeParams(inst.targs)
+		}
+	case *types.Pointer:
+		return p.hasTypeParam(v.Elem())
+	case *types.Slice:
+		return p.hasTypeParam(v.Elem())
+	case *types.Array:
+		return p.hasTypeParam(v.Elem())
+	case *types.Map:
+		return p.hasTypeParam(v.Key()) || p.hasTypeParam(v.Elem())
+	case *types.Chan:
+		return p.hasTypeParam(v.Elem())
+	case *types.Signature:
+		return p.hasTypeParamIn(v.Params()) || p.hasTypeParamIn(v.Results())
+	case *types.Struct:
+		for i, n := 0, v.NumFields(); i < n; i++ {
+			if p.hasTypeParam(v.Field(i).Type()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *pkgCtx) hasTypeParams(typs []types.Type) bool {
+	for _, t := range typs {
+		if p.hasTypeParam(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pkgCtx) hasTypeParamIn(t *types.Tuple) bool {
+	for i, n := 0, t.Len(); i < n; i++ {
+		if p.hasTypeParam(t.At(i).Type()) {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------
+
+func (p *blockCtx) withTypeArgs(tparams map[string]types.Type) *blockCtx {
+	ctx := *p
+	ctx.tparams = tparams
+	return &ctx
+}
+
+// lookupGeneric returns the generic declaration that x refers to, or nil if x
+// isn't the name of a generic (or the name is shadowed).
+func (p *blockCtx) lookupGeneric(x ast.Expr) *genericDecl {
+	ident, ok := x.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	gen, ok := p.generics[ident.Name]
+	if !ok || gen.name == nil {
+		return nil
+	}
+	if _, ok := p.tparams[ident.Name]; ok {
+		return nil
+	}
+	at, o := p.cb.Scope().LookupParent(ident.Name, token.NoPos)
+	if o != nil && at != p.pkg.Types.Scope() && at != types.Universe {
+		return nil
+	}
+	return gen
+}
+
+func newGenericUseError(ctx *blockCtx, gen *genericDecl, pos token.Pos) error {
+	name := gen.name.Name
+	switch {
+	case gen.fn != nil:
+		return ctx.newCodeErrorf(pos, "cannot use generic function %s without instantiation", name)
+	case gen.isConstraint():
+		return ctx.newCodeErrorf(
+			pos, "cannot use type %s outside a type constraint: interface contains type constraints", name)
+	}
+	return ctx.newCodeErrorf(pos, "cannot use generic type %s without instantiation", name)
+}
+
+// checkTypeArgs checks that targs satisfy the type constraints of gen and
+// returns the bindings of its type parameters. Type arguments referring to
+// type parameters are left to the Go compiler.
+func checkTypeArgs(ctx *blockCtx, gen *genericDecl, targs []types.Type, src ast.Node) map[string]types.Type {
+	names, constraints := typeParamList(gen.typeParams())
+	if len(targs) != len(names) {
+		panic(ctx.newCodeErrorf(src.Pos(), "got %d type arguments but %s has %d type parameters",
+			len(targs), gen.name.Name, len(names)))
+	}
+	bindings := make(map[string]types.Type, len(names))
+	for i, name := range names {
+		bindings[name.Name] = targs[i]
+	}
+	tctx := gen.ctx.withTypeArgs(bindings)
+	for i, c := range constraints {
+		if !ctx.hasTypeParam(targs[i]) && !satisfies(tctx, targs[i], c) {
+			csrc, _ := ctx.LoadExpr(c)
+			panic(ctx.newCodeErrorf(src.Pos(), "%v does not satisfy %s", targs[i], csrc))
+		}
+	}
+	return bindings
+}
+
+func satisfies(ctx *blockCtx, t types.Type, c ast.Expr) bool {
+	switch v := c.(type) {
+	case *ast.Ident:
+		switch v.Name {
+		case "any":
+			return true
+		case "comparable":
+			return types.Comparable(t)
+		}
+		if gen := ctx.lookupGeneric(v); gen != nil && gen.isConstraint() {
+			return satisfies(gen.ctx, t, gen.spec.Type)
+		}
+	case *ast.ParenExpr:
+		return satisfies(ctx, t, v.X)
+	case *ast.BinaryExpr:
+		if v.Op == token.OR {
+			return satisfies(ctx, t, v.X) || satisfies(ctx, t, v.Y)
+		}
+	case *ast.UnaryExpr:
+		if v.Op == token.TILDE {
+			return types.Identical(t.Underlying(), toType(ctx, v.X).Underlying())
+		}
+	case *ast.InterfaceType:
+		var methods []*ast.Field
+		for _, fld := range v.Methods.List {
+			if len(fld.Names) > 0 {
+				methods = append(methods, fld)
+			} else if !satisfies(ctx, t, fld.Type) {
+				return false
+			}
+		}
+		if methods == nil {
+			return true
+		}
+		iface := toInterfaceType(ctx, &ast.InterfaceType{Methods: &ast.FieldList{List: methods}})
+		return types.Implements(t, iface.(*types.Interface))
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		x, indices := splitIndexExpr(c)
+		if gen := ctx.lookupGeneric(x); gen != nil && gen.spec != nil {
+			if _, ok := gen.spec.Type.(*ast.InterfaceType); ok {
+				bindings := checkTypeArgs(ctx, gen, toTypes(ctx, indices), c)
+				return satisfies(gen.ctx.withTypeArgs(bindings), t, gen.spec.Type)
+			}
+		}
+	}
+	typ := toType(ctx, c)
+	if iface, ok := typ.Underlying().(*types.Interface); ok {
+		return types.Implements(t, iface)
+	}
+	return types.Identical(t, typ)
+}
+
+func splitIndexExpr(v ast.Expr) (x ast.Expr, indices []ast.Expr) {
+	switch v := v.(type) {
+	case *ast.IndexExpr:
+		return v.X, []ast.Expr{v.Index}
+	case *ast.IndexListExpr:
+		return v.X, v.Indices
+	}
+	panic("unreachable")
+}
+
+func toTypes(ctx *blockCtx, exprs []ast.Expr) []types.Type {
+	typs := make([]types.Type, len(exprs))
+	for i, expr := range exprs {
+		typs[i] = toType(ctx, expr)
+	}
+	return typs
+}
+
+// -----------------------------------------------------------------------------
+
+// instanceName returns the name of the instance of a generic instantiated
+// with targs, eg. `Map_string_int` for `Map[string, int]`.
+func instanceName(pkg *types.Package, name string, targs []types.Type) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, t := range targs {
+		b.WriteByte('_')
+		writeTypeName(&b, pkg, t)
+	}
+	return b.String()
+}
+
+func writeTypeName(b *strings.Builder, pkg *types.Package, t types.Type) {
+	switch v := t.(type) {
+	case *types.Basic:
+		b.WriteString(v.Name())
+		return
+	case *types.Named:
+		o := v.Obj()
+		if at := o.Pkg(); at != nil && at != pkg {
+			b.WriteString(at.Name())
+			b.WriteByte('_')
+		}
+		b.WriteString(o.Name())
+		return
+	case *types.Pointer:
+		b.WriteString("Ptr_")
+		writeTypeName(b, pkg, v.Elem())
+		return
+	case *types.Slice:
+		b.WriteString("Slice_")
+		writeTypeName(b, pkg, v.Elem())
+		return
+	case *types.Array:
+		b.WriteString("Array")
+		b.WriteString(strconv.FormatInt(v.Len(), 10))
+		b.WriteByte('_')
+		writeTypeName(b, pkg, v.Elem())
+		return
+	case *types.Map:
+		b.WriteString("Map_")
+		writeTypeName(b, pkg, v.Key())
+		b.WriteByte('_')
+		writeTypeName(b, pkg, v.Elem())
+		return
+	case *types.Chan:
+		b.WriteString("Chan_")
+		writeTypeName(b, pkg, v.Elem())
+		return
+	case *types.Interface:
+		if v.Empty() {
+			b.WriteString("any")
+			return
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(types.TypeString(t, nil)))
+	b.WriteByte('T')
+	b.WriteString(strconv.FormatUint(uint64(h.Sum32()), 36))
+}
+
+// lookupInstance returns the instance of generic gen instantiated with targs,
+// or nil if there isn't one yet. Instances are looked up by the identity of
+// their type arguments, not by their names: distinct types of the same name
+// (eg. local types of different functions) have distinct instances.
+func (p *pkgCtx) lookupInstance(gen interface{}, targs []types.Type) types.Object {
+next:
+	for _, inst := range p.insts[gen] {
+		for i, t := range inst.targs {
+			if !types.Identical(t, targs[i]) {
+				continue next
+			}
+		}
+		return inst.obj
+	}
+	return nil
+}
+
+func (p *pkgCtx) addInstance(gen interface{}, targs []types.Type, obj types.Object) {
+	p.insts[gen] = append(p.insts[gen], &instance{targs: targs, obj: obj})
+	p.instNames[obj.Name()] = true
+}
+
+// newInstanceName returns the name of a new instance of generic name
+// instantiated with targs. A numeric suffix is added to the name if another
+// instance already has it, and it is an error if the package declares it.
+func newInstanceName(ctx *blockCtx, name string, targs []types.Type, src ast.Node) string {
+	base := instanceName(ctx.pkg.Types, name, targs)
+	if ctx.isDeclared(base) {
+		panic(ctx.newCodeErrorf(src.Pos(), "cannot instantiate %s: its name %s is already declared in this package",
+			instanceString(ctx.pkg.Types, name, targs), base))
+	}
+	ret := base
+	for i := 2; ctx.instNames[ret] || ctx.isDeclared(ret); i++ {
+		ret = base + "_" + strconv.Itoa(i)
+	}
+	return ret
+}
+
+// localInstance returns the instance of generic type name declared by the
+// compiler in gsrc, if targs refer to a local type or a type parameter, which
+// an instance declared at package level like Gop_Set_T can't refer to.
+// Otherwise it returns nil.
+func localInstance(ctx *blockCtx, name, gsrc string, targs []types.Type, src ast.Node) *types.Named {
+	var local *types.TypeName
+	for _, t := range targs {
+		if local = localTypeOf(t); local != nil {
+			break
+		}
+	}
+	if local == nil && !ctx.hasTypeParams(targs) {
+		return nil
+	}
+	if !typeParamsSupported {
+		panic(ctx.newCodeErrorf(src.Pos(), "cannot instantiate %s with local type %s: type parameters require go1.18 or later",
+			instanceString(ctx.pkg.Types, name, targs), local.Name()))
+	}
+	gen, ok := ctx.generics[name]
+	if !ok {
+		f, err := parser.ParseFile(token.NewFileSet(), name+".gop", gsrc, 0)
+		if err != nil {
+			log.Panicln("localInstance:", err)
+		}
+		gctx := *ctx
+		gctx.fileLine, gctx.tparams = false, nil
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				spec := d.Specs[0].(*ast.TypeSpec)
+				preloadGeneric(&gctx, defaultGoFile, spec.Name, nil, spec, nil)
+			case *ast.FuncDecl:
+				recv, _, _ := getGenericRecv(d.Recv)
+				preloadGenericMethod(&gctx, defaultGoFile, recv, d)
+			}
+		}
+		loadGeneric(ctx.pkgCtx, name)
+		gen = ctx.generics[name]
+	}
+	return instantiateType(ctx, gen, targs, src)
+}
+
+// instanceString returns the source form of generic name instantiated with
+// targs, eg. `Map[string, int]`. Types of other packages than pkg are
+// qualified by their package names.
+func instanceString(pkg *types.Package, name string, targs []types.Type) string {
+	qualifier := func(other *types.Package) string {
+		if other == pkg {
+			return ""
+		}
+		return other.Name()
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	for i, t := range targs {
+		if i == 0 {
+			b.WriteByte('[')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(types.TypeString(t, qualifier))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// localTypeOf returns the type declared in a function that t refers to, if
+// any. Instances are declared at package level, so they can't refer to it.
+func localTypeOf(t types.Type) *types.TypeName {
+	switch v := t.(type) {
+	case *types.Named:
+		o := v.Obj()
+		if scope := o.Parent(); o.Pkg() != nil && scope != nil && scope != o.Pkg().Scope() {
+			return o
+		}
+	case *types.Pointer:
+		return localTypeOf(v.Elem())
+	case *types.Slice:
+		return localTypeOf(v.Elem())
+	case *types.Array:
+		return localTypeOf(v.Elem())
+	case *types.Map:
+		if o := localTypeOf(v.Key()); o != nil {
+			return o
+		}
+		return localTypeOf(v.Elem())
+	case *types.Chan:
+		return localTypeOf(v.Elem())
+	}
+	return nil
+}
+
+// isDeclared reports whether name is declared at package level by the package
+// itself rather than by an instantiation.
+func (p *blockCtx) isDeclared(name string) bool {
+	if _, ok := p.syms[name]; ok {
+		return true
+	}
+	if gen, ok := p.generics[name]; ok && gen.name != nil {
+		return true
+	}
+	return !p.instNames[name] && p.pkg.Types.Scope().Lookup(name) != nil
+}
+
+// newTypeInstance returns a new instance of generic type gen named name in
+// package pkg, instantiated with targs. Its underlying type and methods are set
+// by the caller.
+func (p *pkgCtx) newTypeInstance(pkg *types.Package, name string, gen interface{}, targs []types.Type) *types.Named {
+	o := types.NewTypeName(token.NoPos, pkg, instanceString(pkg, name, targs), nil)
+	t := types.NewNamed(o, nil, nil)
+	if debugLoad {
+		log.Println("==> Instantiate type", o.Name())
+	}
+	p.addInstance(gen, targs, o)
+	p.typeInsts[t] = &typeInstance{gen: gen, name: name, targs: targs}
+	p.instRefs[o.Name()] = append(p.instRefs[o.Name()], t)
+	p.genRefs = true
+	return t
+}
+
+// instanceOf returns the generic type and type arguments of t if it is an
+// instance of a generic type.
+func (p *pkgCtx) instanceOf(t *types.Named) (gen interface{}, targs []types.Type) {
+	if inst, ok := p.typeInsts[t]; ok {
+		return inst.gen, inst.targs
+	}
+	if orig, targs := goInstanceOf(t); orig != nil {
+		return orig.Obj(), targs
+	}
+	return nil, nil
+}
+
+func toTypeInstance(ctx *blockCtx, v ast.Expr) types.Type {
+	x, indices := splitIndexExpr(v)
+	if gen := ctx.lookupGeneric(x); gen != nil && gen.spec != nil && !gen.isConstraint() {
+		return instantiateType(ctx, gen, toTypes(ctx, indices), v)
+	}
+	var t types.Type
+	switch x := x.(type) {
+	case *ast.Ident:
+		if ctx.lookupGeneric(x) == nil {
+			t = toIdentType(ctx, x)
+		}
+	case *ast.SelectorExpr:
+		t = lookupExternalType(ctx, x)
+	}
+	if orig := genericTypeOf(t); orig != nil {
+		return instantiateGoType(ctx, orig, toTypes(ctx, indices), v)
+	}
+	src, _ := ctx.LoadExpr(x)
+	panic(ctx.newCodeErrorf(x.Pos(), "%s is not a generic type", src))
+}
+
+func instantiateType(ctx *blockCtx, gen *genericDecl, targs []types.Type, src ast.Node) *types.Named {
+	return instantiateTypeEx(ctx, gen, targs, src, nil)
+}
+
+// instantiateTypeEx instantiates generic type gen with targs, leaving out method
+// skip, which is being loaded.
+func instantiateTypeEx(ctx *blockCtx, gen *genericDecl, targs []types.Type, src ast.Node, skip *ast.FuncDecl) *types.Named {
+	if o := ctx.lookupInstance(gen, targs); o != nil {
+		return o.Type().(*types.Named)
+	}
+	bindings := checkTypeArgs(ctx, gen, targs, src)
+	t := ctx.newTypeInstance(ctx.pkg.Types, gen.name.Name, gen, targs)
+	t.SetUnderlying(toType(gen.ctx.withTypeArgs(bindings), gen.spec.Type).Underlying())
+	for _, m := range gen.methods {
+		if m.decl != skip && m.decl.Name.Name != "_" {
+			addMethodInstance(ctx, t, m, targs)
+		}
+	}
+	return t
+}
+
+// addMethodInstance adds method m of a generic type to the instance t of the
+// type instantiated with targs.
+func addMethodInstance(ctx *blockCtx, t *types.Named, m *genericMethod, targs []types.Type) {
+	names := recvTypeParams(m.ctx, m, len(targs))
+	bindings := make(map[string]types.Type, len(targs))
+	for i, name := range names {
+		if name.Name != "_" {
+			bindings[name.Name] = targs[i]
+		}
+	}
+	mctx := m.ctx.withTypeArgs(bindings)
+	d := m.decl
+	name := d.Name.Name
+	if d.Operator {
+		if v, ok := binaryGopNames[name]; ok {
+			name = v
+		}
+	}
+	sig := toFuncType(mctx, d.Type, toRecv(mctx, d.Recv))
+	t.AddMethod(types.NewFunc(d.Name.Pos(), ctx.pkg.Types, name, sig))
+}
+
+// instantiateGoType instantiates generic type orig of Go with targs. The
+// instance has the underlying type and methods that go/types instantiates,
+// with the instances of generic types in them replaced too.
+func instantiateGoType(ctx *blockCtx, orig *types.Named, targs []types.Type, src ast.Node) *types.Named {
+	o := orig.Obj()
+	if inst := ctx.lookupInstance(o, targs); inst != nil {
+		return inst.Type().(*types.Named)
+	}
+	typ, err := instantiate(orig, targs, src != nil && !ctx.hasTypeParams(targs))
+	if err != nil {
+		panic(ctx.newCodeErrorf(src.Pos(), "%v", err))
+	}
+	t := ctx.newTypeInstance(o.Pkg(), o.Name(), o, targs)
+	inst := typ.(*types.Named)
+	t.SetUnderlying(ctx.goInstances(inst.Underlying()))
+	for i, n := 0, inst.NumMethods(); i < n; i++ {
+		m := inst.Method(i)
+		sig := ctx.goInstances(m.Type()).(*types.Signature)
+		t.AddMethod(types.NewFunc(m.Pos(), m.Pkg(), m.Name(), sig))
+	}
+	return t
+}
+
+// goInstances returns t with the instances of generic types of Go in it
+// replaced by instances of our own.
+func (p *blockCtx) goInstances(t types.Type) types.Type {
+	switch v := t.(type) {
+	case *types.Named:
+		if orig, targs := goInstanceOf(v); orig != nil {
+			for i, targ := range targs {
+				targs[i] = p.goInstances(targ)
+			}
+			return instantiateGoType(p, orig, targs, nil)
+		}
+	case *types.Pointer:
+		if elem := p.goInstances(v.Elem()); elem != v.Elem() {
+			return types.NewPointer(elem)
+		}
+	case *types.Slice:
+		if elem := p.goInstances(v.Elem()); elem != v.Elem() {
+			return types.NewSlice(elem)
+		}
+	case *types.Array:
+		if elem := p.goInstances(v.Elem()); elem != v.Elem() {
+			return types.NewArray(elem, v.Len())
+		}
+	case *types.Map:
+		key, elem := p.goInstances(v.Key()), p.goInstances(v.Elem())
+		if key != v.Key() || elem != v.Elem() {
+			return types.NewMap(key, elem)
+		}
+	case *types.Chan:
+		if elem := p.goInstances(v.Elem()); elem != v.Elem() {
+			return types.NewChan(v.Dir(), elem)
+		}
+	case *types.Signature:
+		recv := v.Recv()
+		if recv != nil {
+			if typ := p.goInstances(recv.Type()); typ != recv.Type() {
+				recv = types.NewParam(recv.Pos(), recv.Pkg(), recv.Name(), typ)
+			}
+		}
+		params, results := p.goInstancesIn(v.Params()), p.goInstancesIn(v.Results())
+		if recv != v.Recv() || params != v.Params() || results != v.Results() {
+			return types.NewSignature(recv, params, results, v.Variadic())
+		}
+	case *types.Struct:
+		n := v.NumFields()
+		fields := make([]*types.Var, n)
+		tags := make([]string, n)
+		changed := false
+		for i := 0; i < n; i++ {
+			fld := v.Field(i)
+			if typ := p.goInstances(fld.Type()); typ != fld.Type() {
+				fld = types.NewField(fld.Pos(), fld.Pkg(), fld.Name(), typ, fld.Embedded())
+				changed = true
+			}
+			fields[i], tags[i] = fld, v.Tag(i)
+		}
+		if changed {
+			return types.NewStruct(fields, tags)
+		}
+	}
+	return t
+}
+
+func (p *blockCtx) goInstancesIn(t *types.Tuple) *types.Tuple {
+	if t == nil {
+		return nil
+	}
+	n := t.Len()
+	vars := make([]*types.Var, n)
+	changed := false
+	for i := 0; i < n; i++ {
+		v := t.At(i)
+		if typ := p.goInstances(v.Type()); typ != v.Type() {
+			v = types.NewParam(v.Pos(), v.Pkg(), v.Name(), typ)
+			changed = true
+		}
+		vars[i] = v
+	}
+	if changed {
+		return types.NewTuple(vars...)
+	}
+	return t
+}
+
+// pushFuncInstance pushes the instance of generic function gen, which is written
+// as the Go instantiation `gen[targs]`.
+func pushFuncInstance(ctx *blockCtx, gen *genericDecl, targs []types.Type, src ast.Node, fn ast.Node) {
+	bindings := checkTypeArgs(ctx, gen, targs, src)
+	sig := toFuncType(gen.ctx.withTypeArgs(bindings), gen.fn.Type, nil)
+	ctx.genRefs = true
+	ctx.cb.InternalStack().Push(&gox.Element{
+		Val: instanceExpr(goast.NewIdent(gen.name.Name), typeASTs(ctx.pkg, targs)), Type: sig, Src: fn,
+	})
+}
+
+// pushGoFuncInstance pushes the instance of generic function fn of Go, whose
+// signature is sig.
+func pushGoFuncInstance(ctx *blockCtx, fn *gox.Element, sig *types.Signature, targs []types.Type, src ast.Node) {
+	inst, err := instantiate(sig, targs, !ctx.hasTypeParams(targs))
+	if err != nil {
+		panic(ctx.newCodeErrorf(src.Pos(), "%v", err))
+	}
+	ctx.genRefs = true
+	ctx.cb.InternalStack().Push(&gox.Element{
+		Val: instanceExpr(fn.Val, typeASTs(ctx.pkg, targs)), Type: ctx.goInstances(inst), Src: src,
+	})
+}
+
+func typeASTs(pkg *gox.Package, typs []types.Type) []goast.Expr {
+	exprs := make([]goast.Expr, len(typs))
+	for i, t := range typs {
+		exprs[i] = gox.TypeAST(pkg, t)
+	}
+	return exprs
+}
+
+// declTypeParams declares the type parameters bound in ctx as aliases of their
+// type arguments in the current scope.
+func declTypeParams(ctx *blockCtx) {
+	scope := ctx.cb.Scope()
+	for name, t := range ctx.tparams {
+		scope.Insert(types.NewTypeName(token.NoPos, ctx.pkg.Types, name, t))
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// compileGenericExpr compiles an explicit instantiation like `Sum[int]` or
+// `List[string]`.
+func compileGenericExpr(ctx *blockCtx, gen *genericDecl, v ast.Expr) {
+	_, indices := splitIndexExpr(v)
+	targs := toTypes(ctx, indices)
+	if gen.fn != nil {
+		pushFuncInstance(ctx, gen, targs, v, v)
+		return
+	}
+	if gen.isConstraint() {
+		panic(newGenericUseError(ctx, gen, v.Pos()))
+	}
+	ctx.cb.Typ(instantiateType(ctx, gen, targs, v), v)
+}
+
+// compileGenericCall pushes the instance of the generic function called by v
+// and returns the arguments compiled to infer the missing type arguments.
+func compileGenericCall(ctx *blockCtx, gen *genericDecl, v *ast.CallExpr, indices []ast.Expr) []*gox.Element {
+	if gen.fn == nil {
+		if indices == nil {
+			panic(newGenericUseError(ctx, gen, v.Fun.Pos()))
+		}
+		compileGenericExpr(ctx, gen, v.Fun)
+		return nil
+	}
+	gen.init()
+	targs, args := compileTypeArgs(ctx, v, gen.name.Name, gen.sig, gen.tparams, gen.cores, indices, v.Fun)
+	pushFuncInstance(ctx, gen, targs, v, v.Fun)
+	return args
+}
+
+// compileGoGenericCall replaces generic function x of Go on the top of the
+// stack with its instance, instantiated with indices or called by v. It returns
+// the arguments compiled to infer the missing type arguments.
+func compileGoGenericCall(ctx *blockCtx, v *ast.CallExpr, x ast.Expr, indices []ast.Expr, src ast.Expr) []*gox.Element {
+	fn := ctx.cb.InternalStack().Pop()
+	sig := fn.Type.(*types.Signature)
+	tparams, cores := goTypeParams(sig)
+	name, _ := ctx.LoadExpr(x)
+	targs, args := compileTypeArgs(ctx, v, name, sig, tparams, cores, indices, src)
+	pushGoFuncInstance(ctx, fn, sig, targs, src)
+	return args
+}
+
+// compileTypeArgs returns the type arguments of generic function name, given
+// explicitly by indices or inferred from the arguments of call v, and the
+// arguments compiled to infer them.
+func compileTypeArgs(ctx *blockCtx, v *ast.CallExpr, name string, sig *types.Signature,
+	tparams, cores []types.Type, indices []ast.Expr, src ast.Node) ([]types.Type, []*gox.Element) {
+	n := len(tparams)
+	if len(indices) > n || len(indices) < n && v == nil {
+		panic(ctx.newCodeErrorf(src.Pos(), "got %d type arguments but %s has %d type parameters",
+			len(indices), name, n))
+	}
+	targs := make([]types.Type, n)
+	for i, index := range indices {
+		targs[i] = toType(ctx, index)
+	}
+	if len(indices) == n {
+		return targs, nil
+	}
+	args := make([]*gox.Element, len(v.Args))
+	p := &typeInferer{ctx: ctx, tparams: tparams, targs: targs}
+	compileInferArgs(ctx, v, args, false)
+	p.unifyArgs(v, sig, args)
+	if p.unbound() > 0 { // untyped composite literals have their default types
+		compileInferArgs(ctx, v, args, true)
+		p.unifyArgs(v, sig, args)
+	}
+	p.inferFromCores(cores)
+	for i, t := range targs {
+		if t == nil {
+			panic(ctx.newCodeErrorf(v.Pos(), "cannot infer %s", typeParamName(tparams[i])))
+		}
+	}
+	return targs, args
+}
+
+func typeParamName(t types.Type) string {
+	return t.(interface{ Obj() *types.TypeName }).Obj().Name()
+}
+
+// compileInferArgs compiles the arguments of v not compiled yet whose types can
+// be used for type inference. Untyped composite literals are compiled only if
+// lits is true, and lambdas are never compiled.
+func compileInferArgs(ctx *blockCtx, v *ast.CallExpr, args []*gox.Element, lits bool) {
+	stk := ctx.cb.InternalStack()
+	for i, arg := range v.Args {
+		if args[i] != nil {
+			continue
+		}
+		switch expr := arg.(type) {
+		case *ast.LambdaExpr, *ast.LambdaExpr2:
+			continue
+		case *ast.SliceLit:
+			if !lits {
+				continue
+			}
+		case *ast.CompositeLit:
+			if expr.Type == nil && !lits {
+				continue
+			}
+		}
+		compileExpr(ctx, arg)
+		args[i] = stk.Pop()
+	}
+}
+
+type typeInferer struct {
+	ctx     *blockCtx
+	tparams []types.Type
+	targs   []types.Type
+}
+
+// unifyArgs infers type arguments from the types of the arguments args of call
+// v to a function of signature sig. Untyped constants bind the type parameters
+// left unbound to their default types.
+func (p *typeInferer) unifyArgs(v *ast.CallExpr, sig *types.Signature, args []*gox.Element) {
+	params := sig.Params()
+	paramOf := func(i int) (types.Type, bool) {
+		n := params.Len()
+		if sig.Variadic() && i >= n-1 {
+			t := params.At(n - 1).Type()
+			if v.Ellipsis == token.NoPos {
+				t = t.(*types.Slice).Elem()
+			}
+			return t, true
+		}
+		if i < n {
+			return params.At(i).Type(), true
+		}
+		return nil, false
+	}
+	var untyped []int
+	for i, arg := range args {
+		if arg == nil || arg.Type == nil {
+			continue
+		}
+		param, ok := paramOf(i)
+		if !ok {
+			break
+		}
+		if isUntyped(arg.Type) {
+			untyped = append(untyped, i)
+			continue
+		}
+		p.unify(param, arg.Type)
+	}
+	defaults := make(map[int]*types.Basic)
+	for _, i := range untyped {
+		param, _ := paramOf(i)
+		if idx := p.index(param); idx >= 0 && p.targs[idx] == nil {
+			t := args[i].Type.(*types.Basic)
+			if old, ok := defaults[idx]; !ok || isNumeric(old) && isNumeric(t) && t.Kind() > old.Kind() {
+				defaults[idx] = t
+			}
+		}
+	}
+	for idx, t := range defaults {
+		if t.Kind() != types.UntypedNil {
+			p.targs[idx] = types.Default(t)
+		}
+	}
+}
+
+// inferFromCores unifies the bound type parameters with the core types of their
+// constraints, eg. `M ~map[K]V` binds K and V once M is known.
+func (p *typeInferer) inferFromCores(cores []types.Type) {
+	for n := p.unbound(); n > 0; {
+		for i, core := range cores {
+			if core != nil && p.targs[i] != nil {
+				p.unify(core, p.targs[i])
+			}
+		}
+		if m := p.unbound(); m < n {
+			n = m
+		} else {
+			break
+		}
+	}
+}
+
+func (p *typeInferer) unbound() (n int) {
+	for _, t := range p.targs {
+		if t == nil {
+			n++
+		}
+	}
+	return
+}
+
+func (p *typeInferer) index(t types.Type) int {
+	for i, tparam := range p.tparams {
+		if t == tparam {
+			return i
+		}
+	}
+	return -1
+}
+
+func isUntyped(t types.Type) bool {
+	if b, ok := t.(*types.Basic); ok {
+		return b.Info()&types.IsUntyped != 0
+	}
+	return false
+}
+
+func isNumeric(t *types.Basic) bool {
+	return t.Info()&types.IsNumeric != 0
+}
+
+func (p *typeInferer) unify(param, t types.Type) {
+	if i := p.index(param); i >= 0 {
+		if p.targs[i] == nil {
+			p.targs[i] = t
+		}
+		return
+	}
+	switch v := param.(type) {
+	case *types.Pointer:
+		if t, ok := t.Underlying().(*types.Pointer); ok {
+			p.unify(v.Elem(), t.Elem())
+		}
+	case *types.Slice:
+		if t, ok := t.Underlying().(*types.Slice); ok {
+			p.unify(v.Elem(), t.Elem())
+		}
+	case *types.Array:
+		if t, ok := t.Underlying().(*types.Array); ok {
+			p.unify(v.Elem(), t.Elem())
+		}
+	case *types.Map:
+		if t, ok := t.Underlying().(*types.Map); ok {
+			p.unify(v.Key(), t.Key())
+			p.unify(v.Elem(), t.Elem())
+		}
+	case *types.Chan:
+		if t, ok := t.Underlying().(*types.Chan); ok {
+			p.unify(v.Elem(), t.Elem())
+		}
+	case *types.Signature:
+		if t, ok := t.Underlying().(*types.Signature); ok {
+			p.unifyTuple(v.Params(), t.Params())
+			p.unifyTuple(v.Results(), t.Results())
+		}
+	case *types.Named:
+		if t, ok := t.(*types.Named); ok {
+			gen, targs := p.ctx.instanceOf(v)
+			if gen2, targs2 := p.ctx.instanceOf(t); gen != nil && gen == gen2 && len(targs) == len(targs2) {
+				for i, targ := range targs {
+					p.unify(targ, targs2[i])
+				}
+			}
+		}
+	}
+}
+
+func (p *typeInferer) unifyTuple(params, t *types.Tuple) {
+	if params.Len() != t.Len() {
+		return
+	}
+	for i, n := 0, params.Len(); i < n; i++ {
+		p.unify(params.At(i).Type(), t.At(i).Type())
+	}
+}
+
+func coreTypeExpr(c ast.Expr) ast.Expr {
+	switch v := c.(type) {
+	case *ast.UnaryExpr:
+		if v.Op == token.TILDE {
+			return v.X
+		}
+	case *ast.InterfaceType:
+		if list := v.Methods.List; len(list) == 1 && len(list[0].Names) == 0 {
+			return coreTypeExpr(list[0].Type)
+		}
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.StarExpr:
+		return c
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// completeGenerics writes the type parameter lists of the generics declared,
+// and the references to instances of generic types as Go instantiations.
+// The printer of gox doesn't support type parameter lists and instantiations
+// with multiple type arguments, so they are written as identifiers at last.
+func completeGenerics(ctx *pkgCtx, pkg *gox.Package) {
+	if !ctx.genRefs {
+		return
+	}
+	var fnames []string
+	pkg.ForEachFile(func(fname string, _ *gox.File) {
+		fnames = append(fnames, fname)
+	})
+	sort.Strings(fnames)
+	for _, fname := range fnames {
+		old, _ := pkg.SetCurFile(fname, false)
+		for _, decl := range pkg.ASTFile(fname).Decls {
+			switch d := decl.(type) {
+			case *goast.FuncDecl:
+				if gen := ctx.loadedGeneric(d.Name.Name, fname); gen != nil && gen.fn != nil && d.Recv == nil {
+					setFuncTypeParams(d.Type, typeParamsAST(gen))
+				}
+			case *goast.GenDecl:
+				for _, spec := range d.Specs {
+					if s, ok := spec.(*goast.TypeSpec); ok {
+						if gen := ctx.loadedGeneric(s.Name.Name, fname); gen != nil && gen.spec != nil {
+							if gen.isConstraint() {
+								s.Type = constraintAST(gen.ctx, gen.spec.Type)
+							}
+							if gen.spec.TypeParams != nil {
+								setTypeSpecParams(s, typeParamsAST(gen))
+							}
+						}
+					}
+				}
+			}
+			ctx.instanceRefs(pkg, decl)
+		}
+		pkg.RestoreCurFile(old)
+	}
+	for _, fname := range fnames { // imports are renamed by ASTFile
+		printableGenerics(pkg.ASTFile(fname).Decls)
+	}
+}
+
+func (p *pkgCtx) loadedGeneric(name, fname string) *genericDecl {
+	if gen, ok := p.generics[name]; ok && gen.loaded && gen.goFile == fname {
+		return gen
+	}
+	return nil
+}
+
+func typeParamsAST(gen *genericDecl) *goast.FieldList {
+	ctx := gen.ctx.withTypeArgs(gen.bindings)
+	list := gen.typeParams().List
+	fields := make([]*goast.Field, len(list))
+	for i, fld := range list {
+		names := make([]*goast.Ident, len(fld.Names))
+		for j, name := range fld.Names {
+			names[j] = goast.NewIdent(name.Name)
+		}
+		fields[i] = &goast.Field{Names: names, Type: constraintAST(ctx, fld.Type)}
+	}
+	return &goast.FieldList{List: fields}
+}
+
+// constraintAST returns the Go AST of type constraint c.
+func constraintAST(ctx *blockCtx, c ast.Expr) goast.Expr {
+	switch v := c.(type) {
+	case *ast.Ident:
+		if v.Name == "any" || v.Name == "comparable" || ctx.lookupGeneric(v) != nil {
+			return goast.NewIdent(v.Name)
+		}
+	case *ast.ParenExpr:
+		return &goast.ParenExpr{X: constraintAST(ctx, v.X)}
+	case *ast.BinaryExpr:
+		if v.Op == token.OR {
+			return &goast.BinaryExpr{X: constraintAST(ctx, v.X), Op: gotoken.OR, Y: constraintAST(ctx, v.Y)}
+		}
+	case *ast.UnaryExpr:
+		if v.Op == token.TILDE {
+			return tildeExpr(gox.TypeAST(ctx.pkg, toType(ctx, v.X)))
+		}
+	case *ast.InterfaceType:
+		fields := make([]*goast.Field, len(v.Methods.List))
+		for i, fld := range v.Methods.List {
+			if len(fld.Names) == 0 {
+				fields[i] = &goast.Field{Type: constraintAST(ctx, fld.Type)}
+				continue
+			}
+			names := make([]*goast.Ident, len(fld.Names))
+			for j, name := range fld.Names {
+				names[j] = goast.NewIdent(name.Name)
+			}
+			fields[i] = &goast.Field{Names: names, Type: gox.TypeAST(ctx.pkg, toType(ctx, fld.Type))}
+		}
+		return &goast.InterfaceType{Methods: &goast.FieldList{List: fields}}
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		x, indices := splitIndexExpr(c)
+		if gen := ctx.lookupGeneric(x); gen != nil {
+			return instanceExpr(goast.NewIdent(gen.name.Name), typeASTs(ctx.pkg, toTypes(ctx, indices)))
+		}
+	}
+	return gox.TypeAST(ctx.pkg, toType(ctx, c))
+}
+
+// instanceRefs rewrites the references to instances of generic types in node,
+// like `Map[string, int]`, to Go instantiations.
+func (p *pkgCtx) instanceRefs(pkg *gox.Package, node goast.Node) goast.Node {
+	return astutil.Apply(node, nil, func(c *astutil.Cursor) bool {
+		switch v := c.Node().(type) {
+		case *goast.Ident:
+			if c.Name() != "Sel" {
+				if t := p.instanceRef(v.Name, pkg.Types, true); t != nil {
+					c.Replace(p.instanceAST(pkg, t, goast.NewIdent(p.typeInsts[t].name)))
+				}
+			}
+		case *goast.SelectorExpr:
+			if t := p.instanceRef(v.Sel.Name, pkg.Types, false); t != nil {
+				sel := &goast.SelectorExpr{X: v.X, Sel: goast.NewIdent(p.typeInsts[t].name)}
+				c.Replace(p.instanceAST(pkg, t, sel))
+			}
+		}
+		return true
+	})
+}
+
+// instanceRef returns the instance named name, of package pkg if local is true,
+// or of another package otherwise.
+func (p *pkgCtx) instanceRef(name string, pkg *types.Package, local bool) *types.Named {
+	if strings.IndexByte(name, '[') < 0 {
+		return nil
+	}
+	for _, t := range p.instRefs[name] {
+		if (t.Obj().Pkg() == pkg) == local {
+			return t
+		}
+	}
+	return nil
+}
+
+func (p *pkgCtx) instanceAST(pkg *gox.Package, t *types.Named, x goast.Expr) goast.Expr {
+	targs := typeASTs(pkg, p.typeInsts[t].targs)
+	for i, targ := range targs {
+		targs[i] = p.instanceRefs(pkg, targ).(goast.Expr)
+	}
+	return instanceExpr(x, targs)
+}
+
+// -----------------------------------------------------------------------------
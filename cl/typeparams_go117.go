@@ -0,0 +1,75 @@
+//go:build !go1.18
+// +build !go1.18
+
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	goast "go/ast"
+	"go/types"
+)
+
+// -----------------------------------------------------------------------------
+
+const typeParamsSupported = false
+
+func genericSigOf(t types.Type) *types.Signature {
+	return nil
+}
+
+func genericTypeOf(t types.Type) *types.Named {
+	return nil
+}
+
+func goInstanceOf(t *types.Named) (orig *types.Named, targs []types.Type) {
+	return nil, nil
+}
+
+func goTypeParams(sig *types.Signature) (tparams, cores []types.Type) {
+	return nil, nil
+}
+
+func firstTermOf(iface *types.Interface) types.Type {
+	return nil
+}
+
+func instantiate(orig types.Type, targs []types.Type, validate bool) (types.Type, error) {
+	panic("unreachable")
+}
+
+func setFuncTypeParams(t *goast.FuncType, tparams *goast.FieldList) {
+	panic("unreachable")
+}
+
+func setTypeSpecParams(spec *goast.TypeSpec, tparams *goast.FieldList) {
+	panic("unreachable")
+}
+
+func tildeExpr(x goast.Expr) goast.Expr {
+	panic("unreachable")
+}
+
+func printableGenerics(decls []goast.Decl) {
+	panic("unreachable")
+}
+
+func instanceExpr(x goast.Expr, indices []goast.Expr) goast.Expr {
+	panic("unreachable")
+}
+
+// -----------------------------------------------------------------------------
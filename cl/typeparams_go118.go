@@ -0,0 +1,201 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl
+
+import (
+	"bytes"
+	goast "go/ast"
+	"go/printer"
+	gotoken "go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// -----------------------------------------------------------------------------
+
+const typeParamsSupported = true
+
+// genericSigOf returns t if it is the signature of a generic function of Go.
+func genericSigOf(t types.Type) *types.Signature {
+	if sig, ok := t.(*types.Signature); ok && sig.TypeParams().Len() > 0 {
+		return sig
+	}
+	return nil
+}
+
+// genericTypeOf returns t if it is a generic type of Go not instantiated.
+func genericTypeOf(t types.Type) *types.Named {
+	if named, ok := t.(*types.Named); ok && named.TypeParams().Len() > 0 && named.TypeArgs().Len() == 0 {
+		return named
+	}
+	return nil
+}
+
+// goInstanceOf returns the generic type and type arguments of t if it is an
+// instance of a generic type of Go.
+func goInstanceOf(t *types.Named) (orig *types.Named, targs []types.Type) {
+	list := t.TypeArgs()
+	if list.Len() == 0 {
+		return nil, nil
+	}
+	targs = make([]types.Type, list.Len())
+	for i := range targs {
+		targs[i] = list.At(i)
+	}
+	return t.Origin(), targs
+}
+
+// goTypeParams returns the type parameters of generic function sig of Go, and
+// the core types of their constraints.
+func goTypeParams(sig *types.Signature) (tparams, cores []types.Type) {
+	list := sig.TypeParams()
+	n := list.Len()
+	tparams, cores = make([]types.Type, n), make([]types.Type, n)
+	for i := 0; i < n; i++ {
+		tparam := list.At(i)
+		tparams[i], cores[i] = tparam, coreTypeOf(tparam.Constraint())
+	}
+	return
+}
+
+// coreTypeOf returns the single type in the type set of constraint c, if any.
+func coreTypeOf(c types.Type) types.Type {
+	iface, ok := c.Underlying().(*types.Interface)
+	if !ok || iface.NumEmbeddeds() != 1 {
+		return nil
+	}
+	t := iface.EmbeddedType(0)
+	if u, ok := t.(*types.Union); ok {
+		if u.Len() != 1 {
+			return nil
+		}
+		t = u.Term(0).Type()
+	}
+	if _, ok := t.Underlying().(*types.Interface); ok {
+		return coreTypeOf(t)
+	}
+	return t
+}
+
+// firstTermOf returns the first type in the type set of constraint iface, or
+// nil if iface has no type terms.
+func firstTermOf(iface *types.Interface) types.Type {
+	for i, n := 0, iface.NumEmbeddeds(); i < n; i++ {
+		t := iface.EmbeddedType(i)
+		if u, ok := t.(*types.Union); ok {
+			if u.Len() == 0 {
+				continue
+			}
+			t = u.Term(0).Type()
+		}
+		if it, ok := t.Underlying().(*types.Interface); ok {
+			if term := firstTermOf(it); term != nil {
+				return term
+			}
+			continue
+		}
+		return t
+	}
+	return nil
+}
+
+func instantiate(orig types.Type, targs []types.Type, validate bool) (types.Type, error) {
+	return types.Instantiate(nil, orig, targs, validate)
+}
+
+func setFuncTypeParams(t *goast.FuncType, tparams *goast.FieldList) {
+	t.TypeParams = tparams
+}
+
+func setTypeSpecParams(spec *goast.TypeSpec, tparams *goast.FieldList) {
+	spec.TypeParams = tparams
+}
+
+func tildeExpr(x goast.Expr) goast.Expr {
+	return &goast.UnaryExpr{Op: gotoken.TILDE, X: x}
+}
+
+// instanceExpr returns the Go instantiation `x[indices]`.
+func instanceExpr(x goast.Expr, indices []goast.Expr) goast.Expr {
+	if len(indices) == 1 {
+		return &goast.IndexExpr{X: x, Index: indices[0]}
+	}
+	return &goast.IndexListExpr{X: x, Indices: indices}
+}
+
+// printableGenerics rewrites the type parameter lists and the instantiations
+// with multiple type arguments in decls to identifiers of their Go source. The
+// type parameter lists are kept, for gox to find the imports they use.
+func printableGenerics(decls []goast.Decl) {
+	for _, decl := range decls {
+		astutil.Apply(decl, nil, func(c *astutil.Cursor) bool {
+			if v, ok := c.Node().(*goast.IndexListExpr); ok {
+				c.Replace(&goast.IndexExpr{X: v.X, Index: goast.NewIdent(exprListString(v.Indices))})
+			}
+			return true
+		})
+		switch d := decl.(type) {
+		case *goast.FuncDecl:
+			if list := d.Type.TypeParams; list != nil {
+				d.Name = goast.NewIdent(d.Name.Name + typeParamsString(list))
+			}
+		case *goast.GenDecl:
+			for _, spec := range d.Specs {
+				if s, ok := spec.(*goast.TypeSpec); ok && s.TypeParams != nil {
+					s.Name = goast.NewIdent(s.Name.Name + typeParamsString(s.TypeParams))
+				}
+			}
+		}
+	}
+}
+
+func typeParamsString(list *goast.FieldList) string {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, fld := range list.List {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		for j, name := range fld.Names {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(name.Name)
+		}
+		b.WriteByte(' ')
+		printer.Fprint(&b, gotoken.NewFileSet(), fld.Type)
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func exprListString(exprs []goast.Expr) string {
+	var b bytes.Buffer
+	for i, expr := range exprs {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		printer.Fprint(&b, gotoken.NewFileSet(), expr)
+	}
+	return b.String()
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,612 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cl_test
+
+import (
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"go/types"
+	"os"
+	"testing"
+
+	"github.com/goplus/gop/cl"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/parser/parsertest"
+	"github.com/goplus/gop/scanner"
+)
+
+const glibPkgPath = "github.com/goplus/gop/cl/internal/glib"
+
+const glibSrc = `package glib
+
+type Ordered interface {
+	~int | ~float64 | ~string
+}
+
+func Max[T Ordered](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+type Box[T any] struct {
+	V T
+}
+
+func (b *Box[T]) Get() T {
+	return b.V
+}
+
+func NewBox[T any](v T) *Box[T] {
+	return &Box[T]{V: v}
+}
+
+type Pair[K comparable, V any] struct {
+	Key K
+	Val V
+}
+
+func Swap[K, V comparable](p Pair[K, V]) Pair[V, K] {
+	return Pair[V, K]{p.Val, p.Key}
+}
+`
+
+// glibImporter imports the generic package glib, type checked from glibSrc.
+type glibImporter struct {
+	types.Importer
+	glib *types.Package
+}
+
+func (p *glibImporter) Import(pkgPath string) (*types.Package, error) {
+	if pkgPath == glibPkgPath {
+		return p.glib, nil
+	}
+	return p.Importer.Import(pkgPath)
+}
+
+func newGlibConf(t *testing.T) *cl.Config {
+	fset := gotoken.NewFileSet()
+	f, err := goparser.ParseFile(fset, "glib.go", glibSrc, 0)
+	if err != nil {
+		t.Fatal("ParseFile:", err)
+	}
+	glib, err := new(types.Config).Check(glibPkgPath, fset, []*goast.File{f}, nil)
+	if err != nil {
+		t.Fatal("Check:", err)
+	}
+	conf := *gblConf
+	conf.Importer = &glibImporter{Importer: gblConf.Importer, glib: glib}
+	return &conf
+}
+
+func glibErrorTest(t *testing.T, msg, src string) {
+	fs := parsertest.NewSingleFileFS("/foo", "bar.gop", src)
+	pkgs, err := parser.ParseFSDir(gblFset, fs, "/foo", parser.Config{})
+	if err != nil {
+		scanner.PrintError(os.Stderr, err)
+		t.Fatal("parser.ParseFSDir failed")
+	}
+	conf := newGlibConf(t)
+	conf.NoFileLine = false
+	conf.WorkingDir = "/foo"
+	conf.TargetDir = "/foo"
+	_, err = cl.NewPackage("", pkgs["main"], conf)
+	if err == nil {
+		t.Fatal("no error?")
+	}
+	if ret := err.Error(); ret != msg {
+		t.Fatalf("\nError: \"%s\"\nExpected: \"%s\"\n", ret, msg)
+	}
+}
+
+func TestGenericFunc(t *testing.T) {
+	gopClTest(t, `
+type Number interface {
+	~int | ~float64
+}
+
+func Max[T Number](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Keys[M ~map[K]V, K comparable, V any](m M) []K {
+	var ret []K
+	for k := range m {
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func Sum[T Number](vals []T) T {
+	var s T
+	for _, v := range vals {
+		s += v
+	}
+	return s
+}
+
+type MyInt int
+
+println Max(1, 2.5), Max[MyInt](1, 2), Keys(map[string]int{"a": 1}), Sum([1.5, 2.5])
+`, `package main
+
+import fmt "fmt"
+
+type Number interface {
+	~int | ~float64
+}
+
+func Max[T Number](a T, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+func Keys[M ~map[K]V, K comparable, V any](m M) []K {
+	var ret []K
+	for k := range m {
+		ret = append(ret, k)
+	}
+	return ret
+}
+func Sum[T Number](vals []T) T {
+	var s T
+	for _, v := range vals {
+		s += v
+	}
+	return s
+}
+
+type MyInt int
+
+func main() {
+	fmt.Println(Max[float64](1, 2.5), Max[MyInt](1, 2), Keys[map[string]int, string, int](map[string]int{"a": 1}), Sum[float64]([]float64{1.5, 2.5}))
+}
+`)
+}
+
+func TestGenericLocalType(t *testing.T) {
+	gopClTest(t, `
+func Id[T any](v T) T {
+	return v
+}
+
+func f() {
+	type P int
+	println Id(P(1))
+}
+`, `package main
+
+import fmt "fmt"
+
+func Id[T any](v T) T {
+	return v
+}
+func f() {
+	type P int
+	fmt.Println(Id[P](P(1)))
+}
+`)
+}
+
+func TestGenericType(t *testing.T) {
+	gopClTest(t, `
+type List[T any] struct {
+	next *List[T]
+	val  T
+}
+
+func (l *List[T]) Push(v T) *List[T] {
+	return &List[T]{next: l, val: v}
+}
+
+func (l *List[E]) Len() int {
+	n := 0
+	for p := l; p != nil; p = p.next {
+		n++
+	}
+	return n
+}
+
+func Map[T, U any](l *List[T], f func(T) U) *List[U] {
+	var ret *List[U]
+	for p := l; p != nil; p = p.next {
+		ret = ret.Push(f(p.val))
+	}
+	return ret
+}
+
+type Pair[K comparable, V any] struct {
+	k K
+	v V
+}
+
+var l *List[int]
+l = l.Push(1)
+println Map[int, string](l, x => string(rune(x))).Len()
+p := Pair[string, *List[int]]{"a", l}
+println p.k
+`, `package main
+
+import fmt "fmt"
+
+type List[T any] struct {
+	next *List[T]
+	val  T
+}
+
+func (l *List[T]) Push(v T) *List[T] {
+	return &List[T]{next: l, val: v}
+}
+func (l *List[E]) Len() int {
+	n := 0
+	for p := l; p != nil; p = p.next {
+		n++
+	}
+	return n
+}
+func Map[T, U any](l *List[T], f func(T) U) *List[U] {
+	var ret *List[U]
+	for p := l; p != nil; p = p.next {
+		ret = ret.Push(f(p.val))
+	}
+	return ret
+}
+
+type Pair[K comparable, V any] struct {
+	k K
+	v V
+}
+
+var l *List[int]
+
+func main() {
+	l = l.Push(1)
+	fmt.Println(Map[int, string](l, func(x int) string {
+		return string(rune(x))
+	}).Len())
+	p := Pair[string, *List[int]]{"a", l}
+	fmt.Println(p.k)
+}
+`)
+}
+
+func TestGenericExport(t *testing.T) {
+	gopClTestEx(t, newGlibConf(t), "foo", `
+package foo
+
+import "github.com/goplus/gop/cl/internal/glib"
+
+func Max[T glib.Ordered](a, b T) T {
+	return glib.Max(a, b)
+}
+
+type Stack[T any] struct {
+	items []T
+}
+
+func (s *Stack[T]) Push(v T) {
+	s.items = append(s.items, v)
+}
+`, `package foo
+
+import glib "github.com/goplus/gop/cl/internal/glib"
+
+func Max[T glib.Ordered](a T, b T) T {
+	return glib.Max[T](a, b)
+}
+
+type Stack[T any] struct {
+	items []T
+}
+
+func (s *Stack[T]) Push(v T) {
+	s.items = append(s.items, v)
+}
+`)
+}
+
+func TestImportedGeneric(t *testing.T) {
+	gopClTestEx(t, newGlibConf(t), "main", `
+import "github.com/goplus/gop/cl/internal/glib"
+
+println glib.Max[float64](1, 2), glib.Max("a", "b")
+b := glib.NewBox(1)
+var c glib.Box[int]
+c.V = b.Get()
+q := glib.Swap(glib.Pair[string, int]{"a", 1})
+d := &glib.Box[glib.Pair[int, string]]{}
+println c.Get(), q.Key, d.Get().Val
+`, `package main
+
+import (
+	fmt "fmt"
+	glib "github.com/goplus/gop/cl/internal/glib"
+)
+
+func main() {
+	fmt.Println(glib.Max[float64](1, 2), glib.Max[string]("a", "b"))
+	b := glib.NewBox[int](1)
+	var c glib.Box[int]
+	c.V = b.Get()
+	q := glib.Swap[string, int](glib.Pair[string, int]{"a", 1})
+	d := &glib.Box[glib.Pair[int, string]]{}
+	fmt.Println(c.Get(), q.Key, d.Get().Val)
+}
+`)
+}
+
+func TestMixedGeneric(t *testing.T) {
+	gopMixedClTest(t, "main", `package main
+
+type Number interface {
+	~int | ~float64
+}
+
+func Sum[T Number](vals ...T) T {
+	var s T
+	for _, v := range vals {
+		s += v
+	}
+	return s
+}
+
+type Box[T any] struct {
+	v T
+}
+
+func (b *Box[T]) Get() T {
+	return b.v
+}
+`, `
+b := &Box[string]{}
+println Sum(1, 2), Sum[float64](1), b.Get()
+`, `package main
+
+import fmt "fmt"
+
+func main() {
+	b := &Box[string]{}
+	fmt.Println(Sum[int](1, 2), Sum[float64](1), b.Get())
+}
+`)
+}
+
+func TestErrGeneric(t *testing.T) {
+	codeErrorTest(t, `./bar.gop:7:1: string does not satisfy ~int | ~float64`, `
+func Sum[T ~int | ~float64](vals ...T) T {
+	var s T
+	return s
+}
+
+Sum("a", "b")
+`)
+	codeErrorTest(t, `./bar.gop:7:1: cannot infer T`, `
+func Sum[T ~int | ~float64](vals ...T) T {
+	var s T
+	return s
+}
+
+Sum()
+`)
+	codeErrorTest(t, `./bar.gop:7:6: cannot use generic function Sum without instantiation`, `
+func Sum[T ~int | ~float64](vals ...T) T {
+	var s T
+	return s
+}
+
+f := Sum
+`)
+	codeErrorTest(t, `./bar.gop:6:7: got 2 type arguments but Box has 1 type parameters`, `
+type Box[T any] struct {
+	v T
+}
+
+var b Box[int, string]
+`)
+	codeErrorTest(t, `./bar.gop:6:7: cannot use generic type Box without instantiation`, `
+type Box[T any] struct {
+	v T
+}
+
+var b Box
+`)
+	codeErrorTest(t,
+		`./bar.gop:6:7: cannot use type Number outside a type constraint: interface contains type constraints`, `
+type Number interface {
+	~int | ~float64
+}
+
+var n Number
+`)
+	codeErrorTest(t, `./bar.gop:2:10: undefined: Foo`, `
+func (p *Foo[T]) Get() T {
+	return p.v
+}
+`)
+	codeErrorTest(t, `./bar.gop:6:6: Box redeclared in this block
+	previous declaration at ./bar.gop:2:6`, `
+type Box[T any] struct {
+	v T
+}
+
+type Box int
+`)
+}
+
+func TestErrImportedGeneric(t *testing.T) {
+	glibErrorTest(t, `./bar.gop:4:9: []int does not implement github.com/goplus/gop/cl/internal/glib.Ordered`, `
+import "github.com/goplus/gop/cl/internal/glib"
+
+println glib.Max[[]int](nil, nil)
+`)
+	glibErrorTest(t, `./bar.gop:4:7: cannot use generic type glib.Box without instantiation`, `
+import "github.com/goplus/gop/cl/internal/glib"
+
+var x glib.Box
+`)
+	glibErrorTest(t, `./bar.gop:4:6: got 1 type arguments but glib.Swap has 2 type parameters`, `
+import "github.com/goplus/gop/cl/internal/glib"
+
+f := glib.Swap[int]
+`)
+	glibErrorTest(t, `./bar.gop:4:9: cannot infer T`, `
+import "github.com/goplus/gop/cl/internal/glib"
+
+println glib.Max()
+`)
+}
+
+func TestGenericSet(t *testing.T) {
+	gopClTest(t, `
+func f() {
+	type P int
+	s := {P(1), P(2)}
+	println P(1) in s, s - {P(2)}
+}
+
+func Uniq[T comparable](vals []T) int {
+	return len(set{v for v <- vals})
+}
+`, `package main
+
+import fmt "fmt"
+
+func f() {
+	type P int
+	s := Gop_Set[P]{P(1): struct {
+	}{}, P(2): struct {
+	}{}}
+	fmt.Println(s.Gop_In(P(1)), s.Gop_Sub(Gop_Set[P]{P(2): struct {
+	}{}}))
+}
+
+type Gop_Set[T comparable] map[T]struct {
+}
+
+func (p Gop_Set[T]) Gop_In(x T) (ok bool) {
+	_, ok = p[x]
+	return
+}
+func (p Gop_Set[T]) Gop_Or(q Gop_Set[T]) (r Gop_Set[T]) {
+	r = Gop_Set[T]{}
+	for x := range p {
+		r[x] = struct {
+		}{}
+	}
+	for x := range q {
+		r[x] = struct {
+		}{}
+	}
+	return
+}
+func (p Gop_Set[T]) Gop_And(q Gop_Set[T]) (r Gop_Set[T]) {
+	r = Gop_Set[T]{}
+	for x := range p {
+		if q.Gop_In(x) {
+			r[x] = struct {
+			}{}
+		}
+	}
+	return
+}
+func (p Gop_Set[T]) Gop_Sub(q Gop_Set[T]) (r Gop_Set[T]) {
+	r = Gop_Set[T]{}
+	for x := range p {
+		if !q.Gop_In(x) {
+			r[x] = struct {
+			}{}
+		}
+	}
+	return
+}
+func Uniq[T comparable](vals []T) int {
+	return len(func() (_gop_ret Gop_Set[T]) {
+		_gop_ret = Gop_Set[T]{}
+		for _, v := range vals {
+			_gop_ret[v] = struct {
+			}{}
+		}
+		return
+	}())
+}
+`)
+}
+
+func TestGenericLazy(t *testing.T) {
+	gopClTest(t, `
+func f() {
+	type P int
+	for x <- (x*2 for x <- [P(1), P(2)]) {
+		println x
+	}
+}
+`, `package main
+
+import fmt "fmt"
+
+func f() {
+	type P int
+	for _gop_it := func() Gop_Lazy[P] {
+		var x P
+		var _gop_c0 []P
+		var _gop_i0 int
+		_gop_c0, _gop_i0 = []P{P(1), P(2)}, -1
+		_gop_lv := 0
+		return func() (P, bool) {
+			for {
+				switch _gop_lv {
+				case 0:
+					if _gop_i0++; _gop_i0 >= len(_gop_c0) {
+						_gop_lv--
+						continue
+					}
+					x = _gop_c0[_gop_i0]
+					return x * 2, true
+				default:
+					return 0, false
+				}
+			}
+		}
+	}().Gop_Enum(); ; {
+		var _gop_ok bool
+		x, _gop_ok := _gop_it.Next()
+		if !_gop_ok {
+			break
+		}
+		fmt.Println(x)
+	}
+}
+
+type Gop_Lazy[T any] func() (T, bool)
+
+func (p Gop_Lazy[T]) Gop_Enum() Gop_Lazy[T] {
+	return p
+}
+func (p Gop_Lazy[T]) Next() (T, bool) {
+	return p()
+}
+`)
+}
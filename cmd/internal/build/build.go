@@ -36,14 +36,17 @@ import (
 
 // gop build
 var Cmd = &base.Command{
-	UsageLine: "gop build [-v -o output] [packages]",
+	UsageLine: "gop build [-v -a -p n -gendir dir -o output] [packages]",
 	Short:     "Build Go+ files",
 }
 
 var (
-	flagVerbose = flag.Bool("v", false, "print verbose information")
-	flagOutput  = flag.String("o", "", "gop build output file")
-	flag        = &Cmd.Flag
+	flagVerbose  = flag.Bool("v", false, "print verbose information")
+	flagGenDir   = flag.String("gendir", "", "generate Go files in `dir` instead of the directories of Go+ packages")
+	flagForce    = flag.Bool("a", false, "force regeneration of Go files that are up to date")
+	flagParallel = flag.Int("p", 0, "the number of packages generated in parallel, GOMAXPROCS by default")
+	flagOutput   = flag.String("o", "", "gop build output file")
+	flag         = &Cmd.Flag
 )
 
 func init() {
@@ -76,7 +79,7 @@ func runCmd(_ *base.Command, args []string) {
 	}
 
 	gopEnv := gopenv.Get()
-	conf := &gop.Config{Gop: gopEnv}
+	conf := &gop.Config{Gop: gopEnv, Force: *flagForce, Parallel: *flagParallel, GenDir: *flagGenDir}
 	confCmd := &gocmd.BuildConfig{Gop: gopEnv}
 	if *flagOutput != "" {
 		output, err := filepath.Abs(*flagOutput)
@@ -31,6 +31,7 @@ const (
 	autoGenFile      = "gop_autogen.go"
 	autoGenTestFile  = "gop_autogen_test.go"
 	autoGen2TestFile = "gop_autogen2_test.go"
+	autoGenManifest  = "gop_autogen.json"
 )
 
 // -----------------------------------------------------------------------------
@@ -55,7 +56,7 @@ func cleanAGFiles(dir string) {
 			continue
 		}
 	}
-	autogens := []string{autoGenFile, autoGenTestFile, autoGen2TestFile}
+	autogens := []string{autoGenFile, autoGenTestFile, autoGen2TestFile, autoGenManifest}
 	for _, autogen := range autogens {
 		file := filepath.Join(dir, autogen)
 		if _, err = os.Stat(file); err == nil {
@@ -32,13 +32,15 @@ import (
 
 // gop go
 var Cmd = &base.Command{
-	UsageLine: "gop go [-v] [packages]",
+	UsageLine: "gop go [-v -p n -gendir dir] [packages]",
 	Short:     "Convert Go+ packages into Go packages",
 }
 
 var (
-	flagVerbose = flag.Bool("v", false, "print verbose information.")
-	flag        = &Cmd.Flag
+	flagVerbose  = flag.Bool("v", false, "print verbose information.")
+	flagGenDir   = flag.String("gendir", "", "generate Go files in `dir` instead of the directories of Go+ packages.")
+	flagParallel = flag.Int("p", 0, "the number of packages generated in parallel, GOMAXPROCS by default.")
+	flag         = &Cmd.Flag
 )
 
 func init() {
@@ -60,18 +62,20 @@ func runCmd(cmd *base.Command, args []string) {
 		log.Panicln("gopprojs.ParseAll:", err)
 	}
 
+	conf := &gop.Config{Parallel: *flagParallel, GenDir: *flagGenDir}
 	if *flagVerbose {
 		gox.SetDebug(gox.DbgFlagAll &^ gox.DbgFlagComments)
 		cl.SetDebug(cl.DbgFlagAll)
 		cl.SetDisableRecover(true)
+		conf.Parallel = 1 // don't interleave the debug logs of packages
 	}
 
 	for _, proj := range projs {
 		switch v := proj.(type) {
 		case *gopprojs.DirProj:
-			_, _, err = gop.GenGo(v.Dir, nil, true)
+			_, _, err = gop.GenGo(v.Dir, conf, true)
 		case *gopprojs.PkgPathProj:
-			_, _, err = gop.GenGoPkgPath("", v.Path, nil, true)
+			_, _, err = gop.GenGoPkgPath("", v.Path, conf, true)
 		default:
 			log.Panicln("`gop go` doesn't support", reflect.TypeOf(v))
 		}
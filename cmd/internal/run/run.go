@@ -35,7 +35,7 @@ import (
 
 // gop run
 var Cmd = &base.Command{
-	UsageLine: "gop run [-nc -asm -quiet -debug -prof] package [arguments...]",
+	UsageLine: "gop run [-nc -asm -quiet -debug -prof -gendir dir] package [arguments...]",
 	Short:     "Run a Go+ program",
 }
 
@@ -43,6 +43,7 @@ var (
 	flag        = &Cmd.Flag
 	flagAsm     = flag.Bool("asm", false, "generates `asm` code of Go+ bytecode backend")
 	flagVerbose = flag.Bool("v", false, "print verbose information")
+	flagGenDir  = flag.String("gendir", "", "generate Go files in `dir` instead of the directories of Go+ packages")
 	flagQuiet   = flag.Bool("quiet", false, "don't generate any compiling stage log")
 	flagDebug   = flag.Bool("debug", false, "set log level to debug")
 	flagNoChdir = flag.Bool("nc", false, "don't change dir (only for `gop run pkgPath`)")
@@ -87,7 +88,7 @@ func runCmd(cmd *base.Command, args []string) {
 
 	noChdir := *flagNoChdir
 	gopEnv := gopenv.Get()
-	conf := &gop.Config{Gop: gopEnv}
+	conf := &gop.Config{Gop: gopEnv, GenDir: *flagGenDir}
 	confCmd := &gocmd.Config{Gop: gopEnv}
 	run(proj, args, !noChdir, conf, confCmd)
 }
@@ -101,7 +102,7 @@ func run(proj gopprojs.Proj, args []string, chDir bool, conf *gop.Config, run *g
 		err = gop.RunDir(obj, args, conf, run)
 	case *gopprojs.PkgPathProj:
 		obj = v.Path
-		err = gop.RunPkgPath(v.Path, args, chDir, conf, run)
+		err = gop.RunPkgPath("", v.Path, args, chDir, conf, run)
 	case *gopprojs.FilesProj:
 		err = gop.RunFiles("", v.Files, args, conf, run)
 	default:
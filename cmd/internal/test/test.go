@@ -35,13 +35,14 @@ import (
 
 // gop test
 var Cmd = &base.Command{
-	UsageLine: "gop test [-v] [packages]",
+	UsageLine: "gop test [-v -gendir dir] [packages]",
 	Short:     "Test Go+ packages",
 }
 
 var (
 	flag        = &Cmd.Flag
 	flagVerbose = flag.Bool("v", false, "print verbose information")
+	flagGenDir  = flag.String("gendir", "", "generate Go files in `dir` instead of the directories of Go+ packages")
 )
 
 func init() {
@@ -71,7 +72,7 @@ func runCmd(_ *base.Command, args []string) {
 	}
 
 	gopEnv := gopenv.Get()
-	conf := &gop.Config{Gop: gopEnv}
+	conf := &gop.Config{Gop: gopEnv, GenDir: *flagGenDir}
 	confCmd := &gocmd.Config{Gop: gopEnv}
 	for _, proj := range projs {
 		test(proj, conf, confCmd)
@@ -17,13 +17,20 @@
 package gop
 
 import (
+	"bytes"
 	"fmt"
+	"go/token"
+	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/x/gopenv"
 	"github.com/goplus/mod/gopmod"
 	"github.com/goplus/mod/modcache"
 	"github.com/goplus/mod/modfetch"
@@ -49,15 +56,13 @@ func GenGo(dir string, conf *Config, genTestPkg bool) (string, bool, error) {
 
 func genGoDir(dir string, conf *Config, genTestPkg, recursively bool) (err error) {
 	if recursively {
-		var list errors.List
+		var dirs []string
 		fn := func(path string, d fs.DirEntry, err error) error {
 			if err == nil && d.IsDir() {
 				if strings.HasPrefix(d.Name(), "_") { // skip _
 					return filepath.SkipDir
 				}
-				if e := genGoIn(path, conf, genTestPkg, true); e != nil {
-					list.Add(e)
-				}
+				dirs = append(dirs, path)
 			}
 			return err
 		}
@@ -65,42 +70,293 @@ func genGoDir(dir string, conf *Config, genTestPkg, recursively bool) (err error
 		if err != nil {
 			return errors.NewWith(err, `filepath.WalkDir(dir, fn)`, -2, "filepath.WalkDir", dir, fn)
 		}
-		return list.ToError()
+		return genGoDirs(dirs, conf, genTestPkg)
 	}
-	return genGoIn(dir, conf, genTestPkg, false)
+	_, err = genGoIn(dir, conf, genTestPkg, nil)
+	return
+}
+
+// genGoJob is a job generating Go files of the Go+ package in dir.
+type genGoJob struct {
+	dir     string
+	pkgPath string       // "" if dir isn't in a module
+	conf    *Config      // with an importer of the job
+	deps    []*genGoJob  // jobs of the packages imported
+	visit   int          // state of visiting by dropCycles
+	stdout  bytes.Buffer // what the job prints
+	stderr  bytes.Buffer
+	err     error
+	done    chan struct{}
 }
 
-func genGoIn(dir string, conf *Config, genTestPkg, prompt bool) (err error) {
-	out, test, err := LoadDir(dir, conf, genTestPkg)
+// genGoDirs generates Go files of the Go+ packages in dirs, on a pool of
+// conf.Parallel workers. A package is generated after the ones it imports, and
+// what the jobs print and their errors are reported in the order of dirs.
+func genGoDirs(dirs []string, conf *Config, genTestPkg bool) error {
+	if conf == nil {
+		conf = new(Config)
+	}
+	gop := conf.Gop
+	if gop == nil {
+		gop = gopenv.Get()
+	}
+	fset := conf.Fset
+	if fset == nil {
+		fset = token.NewFileSet()
+	}
+	n := conf.Parallel
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	var gen *genGos
+	switch imp := conf.Importer.(type) {
+	case nil:
+		gen = newGenGos(conf)
+	case *Importer:
+		gen = imp.gen
+	default: // an importer isn't shared by packages compiled concurrently
+		n = 1
+	}
+
+	jobs := make([]*genGoJob, len(dirs))
+	pkgs := make(map[string]*genGoJob)
+	imports := make([][]string, len(dirs))
+	for i, dir := range dirs {
+		job := &genGoJob{dir: dir, done: make(chan struct{})}
+		jobs[i] = job
+		mod, err := loadMod(dir, gop, conf)
+		if err != nil {
+			job.err = err
+			continue
+		}
+		c := *conf
+		c.Gop, c.Fset = gop, fset
+		if c.OnWarning == nil {
+			c.OnWarning = func(err error) {
+				fmt.Fprintln(&job.stderr, "warning:", err)
+			}
+		}
+		switch imp := conf.Importer.(type) {
+		case nil:
+			c.Importer = newImporterWith(mod, gop, fset, gen)
+		case *Importer:
+			c.Importer = imp.fork()
+		}
+		job.conf = &c
+		if job.pkgPath, err = pkgPathOf(mod, dir); err == nil && job.pkgPath != "" {
+			pkgs[job.pkgPath] = job
+			imports[i] = importsIn(dir, mod, conf)
+		}
+	}
+	for i, job := range jobs {
+		for _, pkgPath := range imports[i] {
+			if dep, ok := pkgs[pkgPath]; ok && dep != job {
+				job.deps = append(job.deps, dep)
+			}
+		}
+	}
+	for _, job := range jobs {
+		job.dropCycles()
+	}
+
+	sem := make(chan struct{}, n)
+	for _, job := range jobs {
+		go func(job *genGoJob) {
+			defer close(job.done)
+			for _, dep := range job.deps {
+				<-dep.done
+			}
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			job.run(gen, genTestPkg)
+		}(job)
+	}
+	var list errors.List
+	for _, job := range jobs {
+		<-job.done
+		os.Stdout.Write(job.stdout.Bytes())
+		os.Stderr.Write(job.stderr.Bytes())
+		if job.err != nil {
+			list.Add(job.err)
+		}
+	}
+	return list.ToError()
+}
+
+// dropCycles drops the dependencies of import cycles, so that the jobs don't
+// wait for each other. The cycles are reported when the packages are compiled.
+func (p *genGoJob) dropCycles() {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	if p.visit != 0 {
+		return
+	}
+	p.visit = visiting
+	deps := p.deps[:0]
+	for _, dep := range p.deps {
+		if dep.visit == visiting { // import cycle
+			continue
+		}
+		dep.dropCycles()
+		deps = append(deps, dep)
+	}
+	p.deps = deps
+	p.visit = visited
+}
+
+func (p *genGoJob) run(gen *genGos, genTestPkg bool) {
+	if p.err != nil {
+		return
+	}
+	genGo := func() (string, error) {
+		return genGoIn(p.dir, p.conf, genTestPkg, &p.stdout)
+	}
+	if imp, ok := p.conf.Importer.(*Importer); ok && p.pkgPath != "" {
+		_, p.err = gen.do(imp, p.pkgPath, true, genGo)
+	} else {
+		_, p.err = genGo()
+	}
+}
+
+// pkgPathOf returns the path of the package in dir, or "" if dir isn't in a
+// module.
+func pkgPathOf(mod *gopmod.Module, dir string) (string, error) {
+	if !mod.IsValid() {
+		return "", nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(mod.Root(), dir)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(mod.Path(), filepath.ToSlash(rel)), nil
+}
+
+// importsIn returns the paths of the packages imported by the Go+ package in
+// dir. Errors are ignored, which are reported when the package is compiled.
+func importsIn(dir string, mod *gopmod.Module, conf *Config) (imports []string) {
+	pkgs, _ := parser.ParseDirEx(token.NewFileSet(), dir, parser.Config{
+		IsClass: mod.IsClass,
+		Filter:  conf.Filter,
+		Mode:    parser.ImportsOnly,
+	})
+	for _, pkg := range pkgs {
+		imports = append(imports, importsOf(pkg)...)
+	}
+	return
+}
+
+// genGoIn generates Go files of the Go+ package in dir unless they are up to
+// date, and returns the fingerprint of what they are generated from; or ""
+// if there are no Go+ files.
+func genGoIn(dir string, conf *Config, genTestPkg bool, prompt io.Writer) (fprint string, err error) {
+	if conf == nil {
+		conf = new(Config)
+	}
+	fset := conf.Fset
+	if fset == nil {
+		fset = token.NewFileSet()
+	}
+	gop := conf.Gop
+	if gop == nil {
+		gop = gopenv.Get()
+	}
+	mod, err := loadMod(dir, gop, conf)
+	if err != nil {
+		return
+	}
+	m, err := newManifest(dir, conf, gop, mod, genTestPkg)
+	if err != nil || !m.hasGopFiles() { // no Go+ source files
+		return
+	}
+	imp := conf.Importer
+	if imp == nil {
+		imp = newImporter(mod, gop, fset, conf)
+	}
+	genDir, err := genDirOf(dir, conf)
+	if err != nil {
+		return
+	}
+	gopImp, _ := imp.(*Importer)
+	if !conf.Force {
+		var depFprint func(pkgPath string) (string, error)
+		if gopImp != nil {
+			depFprint = gopImp.fingerprint
+		}
+		old := loadManifest(genDir)
+		if _, e := os.Lstat(filepath.Join(genDir, autoGenFile)); e == nil && m.upToDate(old, depFprint) {
+			return old.fingerprint(), updateGenDir(dir, genDir, conf)
+		}
+	}
+
+	out, test, imports, err := loadDir(dir, conf, fset, mod, imp, genTestPkg)
 	if err != nil {
 		if err == syscall.ENOENT { // no Go+ source files
-			return nil
+			err = nil
 		}
 		return
 	}
 
-	if prompt {
-		fmt.Printf("GenGo %v ...\n", dir)
+	if prompt != nil {
+		fmt.Fprintf(prompt, "GenGo %v ...\n", dir)
 	}
 
-	os.MkdirAll(dir, 0755)
-	file := filepath.Join(dir, autoGenFile)
+	os.MkdirAll(genDir, 0755)
+	file := filepath.Join(genDir, autoGenFile)
 	err = out.WriteFile(file)
 	if err != nil {
 		return
 	}
 
-	err = out.WriteFile(filepath.Join(dir, autoGenTestFile), testingGoFile)
-	if err != nil && err != syscall.ENOENT {
+	file = filepath.Join(genDir, autoGenTestFile)
+	err = out.WriteFile(file, testingGoFile)
+	if err == syscall.ENOENT && conf.GenDir != "" { // no test files
+		os.Remove(file)
+	} else if err != nil && err != syscall.ENOENT {
 		return
 	}
 
+	file = filepath.Join(genDir, autoGen2TestFile)
 	if test != nil {
-		err = test.WriteFile(filepath.Join(dir, autoGen2TestFile), testingGoFile)
-	} else {
-		err = nil
+		err = test.WriteFile(file, testingGoFile)
+		if err != nil {
+			return
+		}
+	} else if genTestPkg && conf.GenDir != "" {
+		os.Remove(file)
 	}
-	return
+
+	if gopImp != nil {
+		for _, pkgPath := range imports {
+			if dep := gopImp.gen.fingerprint(pkgPath); dep != "" {
+				if m.Deps == nil {
+					m.Deps = make(map[string]string)
+				}
+				m.Deps[pkgPath] = dep
+			}
+		}
+	}
+	if err = m.save(genDir); err != nil {
+		return
+	}
+	if err = updateGenDir(dir, genDir, conf); err != nil {
+		return
+	}
+	return m.fingerprint(), nil
+}
+
+// updateGenDir updates the overlay file of conf.GenDir by the Go files of the
+// package in dir generated in genDir, if conf.GenDir is set.
+func updateGenDir(dir, genDir string, conf *Config) error {
+	if conf.GenDir == "" {
+		return nil
+	}
+	return updateOverlay(conf.GenDir, dir, genDir, autoGenFile, autoGenTestFile, autoGen2TestFile)
 }
 
 // -----------------------------------------------------------------------------
@@ -111,18 +367,28 @@ const (
 )
 
 func GenGoPkgPath(workDir, pkgPath string, conf *Config, allowExtern bool) (localDir string, recursively bool, err error) {
+	localDir, recursively, _, err = genGoPkgPath(workDir, pkgPath, conf, allowExtern)
+	return
+}
+
+// genGoPkgPath is like GenGoPkgPath, and returns the config the Go files are
+// generated by. Go files of packages in the module cache are generated in the
+// default GenDir if conf.GenDir isn't set, so that the module cache is never
+// written.
+func genGoPkgPath(workDir, pkgPath string, conf *Config, allowExtern bool) (localDir string, recursively bool, genConf *Config, err error) {
 	recursively = strings.HasSuffix(pkgPath, "/...")
 	if recursively {
 		pkgPath = pkgPath[:len(pkgPath)-4]
 	}
 
+	genConf = conf
 	mod, err := gopmod.Load(workDir, 0)
 	if err == syscall.ENOENT && allowExtern {
 		remotePkgPathDo(pkgPath, func(dir string) {
-			os.Chmod(dir, modWritable)
-			defer os.Chmod(dir, modReadonly)
 			localDir = dir
-			err = genGoDir(dir, conf, false, recursively)
+			if genConf, err = externConf(conf); err == nil {
+				err = genGoDir(dir, genConf, false, recursively)
+			}
 		}, func(e error) {
 			err = e
 		})
@@ -136,14 +402,34 @@ func GenGoPkgPath(workDir, pkgPath string, conf *Config, allowExtern bool) (loca
 		return
 	}
 	localDir = pkg.Dir
-	if pkg.Type == gopmod.PkgtExtern {
-		os.Chmod(localDir, modWritable)
-		defer os.Chmod(localDir, modReadonly)
+	if pkg.Type == gopmod.PkgtExtern && pkg.Real.Version != "" { // in the module cache
+		if genConf, err = externConf(conf); err != nil {
+			return
+		}
 	}
-	err = genGoDir(localDir, conf, false, recursively)
+	err = genGoDir(localDir, genConf, false, recursively)
 	return
 }
 
+// externConf returns the config to generate Go files of packages in the
+// module cache by. It is conf with GenDir set to the default one, which is
+// gop-gen in the user cache directory, if conf.GenDir isn't set.
+func externConf(conf *Config) (*Config, error) {
+	if conf != nil && conf.GenDir != "" {
+		return conf, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	var ret Config
+	if conf != nil {
+		ret = *conf
+	}
+	ret.GenDir = filepath.Join(cacheDir, "gop-gen")
+	return &ret, nil
+}
+
 func remotePkgPathDo(pkgPath string, doSth func(dir string), onErr func(e error)) {
 	modVer, leftPart, err := modfetch.GetPkg(pkgPath, "")
 	if err != nil {
@@ -172,6 +458,14 @@ func GenGoFiles(autogen string, files []string, conf *Config) (result []string,
 	if err != nil {
 		return
 	}
+	if conf != nil && conf.GenDir != "" {
+		dir, fname := filepath.Split(autogen)
+		if dir, err = genDirOf(dir, conf); err != nil {
+			return
+		}
+		os.MkdirAll(dir, 0755)
+		autogen = filepath.Join(dir, fname)
+	}
 	result = append(result, autogen)
 	err = out.WriteFile(autogen)
 	return
@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gop_test
+
+import (
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/cl"
+	"github.com/goplus/gop/env"
+	modenv "github.com/goplus/mod/env"
+)
+
+const staleMark = "// stale\n"
+
+func newGenGoConf(t *testing.T) *gop.Config {
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopEnv := &modenv.Gop{Version: env.Version(), BuildDate: env.BuildDate(), Root: root}
+	return &gop.Config{Gop: gopEnv, DontUpdateGoMod: true}
+}
+
+// markGenerated marks the Go files generated in dirs, so that genGo can tell
+// whether they are generated again.
+func markGenerated(t *testing.T, dirs ...string) {
+	for _, dir := range dirs {
+		file := filepath.Join(dir, "gop_autogen.go")
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(file, append(b, staleMark...), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// genGo generates Go files of dir, and reports whether the Go files of the
+// packages in dirs are generated again.
+func genGo(t *testing.T, dir string, conf *gop.Config, dirs ...string) []bool {
+	if _, _, err := gop.GenGo(dir, conf, false); err != nil {
+		t.Fatal("GenGo:", err)
+	}
+	ret := make([]bool, len(dirs))
+	for i, dir := range dirs {
+		b, err := os.ReadFile(filepath.Join(dir, "gop_autogen.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret[i] = !strings.HasSuffix(string(b), staleMark)
+	}
+	return ret
+}
+
+func TestGenGoUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/foo\n\ngo 1.16\n")
+	writeFile(t, filepath.Join(dir, "foo.gop"), "println \"Hi\"\n")
+	conf := newGenGoConf(t)
+	genGo(t, dir, conf)
+
+	markGenerated(t, dir)
+	if ret := genGo(t, dir, conf, dir); ret[0] {
+		t.Fatal("unchanged package is generated again")
+	}
+
+	writeFile(t, filepath.Join(dir, "foo.gop"), "println \"Hello\"\n")
+	if ret := genGo(t, dir, conf, dir); !ret[0] {
+		t.Fatal("edited source: package isn't generated again")
+	}
+
+	markGenerated(t, dir)
+	c := *conf
+	gopEnv := *conf.Gop
+	gopEnv.Version += ".1"
+	c.Gop = &gopEnv
+	if ret := genGo(t, dir, &c, dir); !ret[0] {
+		t.Fatal("changed gop version: package isn't generated again")
+	}
+
+	markGenerated(t, dir)
+	c = *conf
+	c.BuiltinMethods = []*cl.BuiltinMethods{
+		{Type: types.Typ[types.String], PkgPath: "strings", Methods: map[string]string{"fields": "Fields"}},
+	}
+	if ret := genGo(t, dir, &c, dir); !ret[0] {
+		t.Fatal("changed options: package isn't generated again")
+	}
+
+	markGenerated(t, dir)
+	c = *conf
+	c.Force = true
+	if ret := genGo(t, dir, &c, dir); !ret[0] {
+		t.Fatal("force: package isn't generated again")
+	}
+}
+
+func TestGenGoDepChanged(t *testing.T) {
+	dir := t.TempDir()
+	foo, bar := filepath.Join(dir, "foo"), filepath.Join(dir, "bar")
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/m\n\ngo 1.16\n")
+	writeFile(t, filepath.Join(foo, "foo.gop"), "import \"example.com/m/bar\"\n\nprintln bar.Name\n")
+	writeFile(t, filepath.Join(bar, "bar.gop"), "package bar\n\nvar Name = \"bar\"\n")
+	conf := newGenGoConf(t)
+	genGo(t, dir+"/...", conf)
+
+	markGenerated(t, foo, bar)
+	if ret := genGo(t, dir+"/...", conf, foo, bar); ret[0] || ret[1] {
+		t.Fatal("unchanged packages are generated again:", ret)
+	}
+
+	writeFile(t, filepath.Join(bar, "bar.gop"), "package bar\n\nvar Name = \"bar2\"\n")
+	if ret := genGo(t, dir+"/...", conf, foo, bar); !ret[0] || !ret[1] {
+		t.Fatal("edited dependency: packages aren't generated again:", ret)
+	}
+}
+
+func TestGenGoReplaceChanged(t *testing.T) {
+	dir := t.TempDir()
+	foo, bar := filepath.Join(dir, "foo"), filepath.Join(dir, "bar")
+	writeFile(t, filepath.Join(foo, "go.mod"), "module example.com/foo\n\ngo 1.16\n\n"+
+		"require example.com/bar v0.0.0\n\nreplace example.com/bar => ../bar\n")
+	writeFile(t, filepath.Join(foo, "foo.gop"), "import \"example.com/bar\"\n\nprintln bar.Name\n")
+	writeFile(t, filepath.Join(bar, "go.mod"), "module example.com/bar\n\ngo 1.16\n")
+	writeFile(t, filepath.Join(bar, "bar.go"), "package bar\n\nvar Name = \"bar\"\n")
+	conf := newGenGoConf(t)
+	genGo(t, foo, conf)
+
+	markGenerated(t, foo)
+	if ret := genGo(t, foo, conf, foo); ret[0] {
+		t.Fatal("unchanged package is generated again")
+	}
+
+	writeFile(t, filepath.Join(bar, "bar.go"), "package bar\n\nconst Name = \"bar\"\n")
+	if ret := genGo(t, foo, conf, foo); !ret[0] {
+		t.Fatal("edited replaced package: package isn't generated again")
+	}
+}
+
+func TestGenGoParallel(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/m\n\ngo 1.16\n")
+	chain := []string{"a", "b", "c", "d"} // a imports b, b imports c, ...
+	for i, name := range chain {
+		src := "package " + name + "\n\n"
+		if i+1 < len(chain) {
+			dep := chain[i+1]
+			src += "import \"example.com/m/" + dep + "\"\n\nvar Name = \"" + name + "\" + " + dep + ".Name\n"
+		} else {
+			src += "var Name = \"" + name + "\"\n"
+		}
+		writeFile(t, filepath.Join(dir, name, name+".gop"), src)
+	}
+	writeFile(t, filepath.Join(dir, "x", "x.gop"), "package x\n\nimport \"example.com/m/y\"\n\nvar Name = y.Name\n")
+	writeFile(t, filepath.Join(dir, "y", "y.gop"), "package y\n\nimport \"example.com/m/x\"\n\nvar Name = x.Name\n")
+	writeFile(t, filepath.Join(dir, "z", "z.gop"), "package z\n\nvar Name = undefinedName\n")
+
+	conf := newGenGoConf(t)
+	conf.Parallel = 4
+	var err error
+	out := captureStdout(t, func() {
+		_, _, err = gop.GenGo(dir+"/...", conf, false)
+	})
+
+	var want string
+	for _, name := range chain {
+		want += "GenGo " + filepath.Join(dir, name) + " ...\n"
+	}
+	if out != want {
+		t.Fatalf("output:\n%s\nwant:\n%s", out, want)
+	}
+	if err == nil {
+		t.Fatal("GenGo: no error")
+	}
+	msg := err.Error() // errors of x, y and z in order
+	if !strings.HasPrefix(msg, "./x.gop:3:8: ") || !strings.Contains(msg, "import cycle not allowed: example.com/m/y") ||
+		!strings.Contains(msg, "\n./y.gop:3:8: ") || !strings.HasSuffix(msg, "\n./z.gop:3:12: undefined: undefinedName") {
+		t.Fatal("GenGo:", msg)
+	}
+	for _, name := range chain {
+		b, err := os.ReadFile(filepath.Join(dir, name, "gop_autogen.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), "var Name = ") {
+			t.Fatalf("%s/gop_autogen.go:\n%s", name, b)
+		}
+	}
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
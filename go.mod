@@ -8,4 +8,5 @@ require (
 	github.com/goplus/libc v0.3.9
 	github.com/goplus/mod v0.9.12
 	github.com/qiniu/x v1.11.9
+	golang.org/x/tools v0.1.11
 )
@@ -17,11 +17,13 @@
 package gop
 
 import (
+	"fmt"
 	"go/token"
 	"go/types"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/goplus/gox/packages"
 	"github.com/goplus/mod/env"
@@ -31,23 +33,68 @@ import (
 
 // -----------------------------------------------------------------------------
 
+// Importer imports packages for a Go+ package, after generating Go files of
+// the Go+ packages imported. An Importer isn't safe for concurrent use, as gox
+// updates the Go+ packages imported when compiling; packages compiled
+// concurrently use importers of their own, sharing what is generated.
 type Importer struct {
-	impFrom *packages.Importer
+	impFrom typesImporter
 	mod     *gopmod.Module
 	gop     *env.Gop
 	fset    *token.FileSet
+
+	gen     *genGos   // Go+ packages generated, shared by the importers of a build
+	waiting *genGoPkg // package generated by another importer to wait for, guarded by gen.mu
+}
+
+type typesImporter interface {
+	types.Importer
+	ImportFrom(pkgPath, dir string, mode types.ImportMode) (*types.Package, error)
 }
 
 func NewImporter(mod *gopmod.Module, gop *env.Gop, fset *token.FileSet) *Importer {
+	return newImporter(mod, gop, fset, new(Config))
+}
+
+func newImporter(mod *gopmod.Module, gop *env.Gop, fset *token.FileSet, conf *Config) *Importer {
+	return newImporterWith(mod, gop, fset, newGenGos(conf))
+}
+
+func newImporterWith(mod *gopmod.Module, gop *env.Gop, fset *token.FileSet, gen *genGos) *Importer {
 	dir := ""
 	if mod.IsValid() {
 		dir = mod.Root()
 	}
-	impFrom := packages.NewImporter(fset, dir)
-	return &Importer{mod: mod, gop: gop, impFrom: impFrom, fset: fset}
+	var impFrom typesImporter
+	if gen.genDir != "" {
+		impFrom = newOverlayImporter(fset, dir, overlayOf(gen.genDir))
+	} else {
+		impFrom = packages.NewImporter(fset, dir)
+	}
+	return &Importer{mod: mod, gop: gop, impFrom: impFrom, fset: fset, gen: gen}
+}
+
+// fork returns an importer like p that doesn't share the imported packages
+// with p, for a package compiled concurrently.
+func (p *Importer) fork() *Importer {
+	return newImporterWith(p.mod, p.gop, p.fset, p.gen)
 }
 
 func (p *Importer) Import(pkgPath string) (pkg *types.Package, err error) {
+	from, err := p.genGo(pkgPath)
+	if err != nil {
+		return
+	}
+	if from != "" {
+		return p.impFrom.ImportFrom(pkgPath, from, 0)
+	}
+	return p.impFrom.Import(pkgPath)
+}
+
+// genGo generates Go files of package pkgPath if it is a Go+ package and they
+// are stale. It returns the directory to import the package from, or "" if it
+// is imported from the module.
+func (p *Importer) genGo(pkgPath string) (from string, err error) {
 	const (
 		gop = "github.com/goplus/gop"
 	)
@@ -55,17 +102,17 @@ func (p *Importer) Import(pkgPath string) (pkg *types.Package, err error) {
 		if suffix := pkgPath[len(gop):]; suffix == "" || suffix[0] == '/' {
 			gopRoot := p.gop.Root
 			if suffix == "/cl/internal/gop-in-go/foo" {
-				if err = p.genGoExtern(gopRoot+suffix, false); err != nil {
+				if err = p.genGoExtern(pkgPath, gopRoot+suffix, false); err != nil {
 					return
 				}
 			}
-			return p.impFrom.ImportFrom(pkgPath, gopRoot, 0)
+			return gopRoot, nil
 		}
 	}
 	if mod := p.mod; mod.IsValid() {
 		ret, e := mod.Lookup(pkgPath)
 		if e != nil {
-			return nil, e
+			return "", e
 		}
 		switch ret.Type {
 		case gopmod.PkgtExtern:
@@ -77,30 +124,114 @@ func (p *Importer) Import(pkgPath string) (pkg *types.Package, err error) {
 			}
 			modfile := filepath.Join(ret.ModDir, "gop.mod")
 			if _, e := os.Lstat(modfile); e == nil { // has gop.mod
-				if err = p.genGoExtern(ret.Dir, isExtern); err != nil {
+				if err = p.genGoExtern(pkgPath, ret.Dir, isExtern); err != nil {
 					return
 				}
 			}
-			return p.impFrom.ImportFrom(pkgPath, ret.ModDir, 0)
+			return ret.ModDir, nil
 		case gopmod.PkgtModule, gopmod.PkgtLocal:
-			if err = p.genGoExtern(ret.Dir, false); err != nil {
-				return
-			}
+			err = p.genGoExtern(pkgPath, ret.Dir, false)
 		}
 	}
-	return p.impFrom.Import(pkgPath)
+	return
 }
 
-func (p *Importer) genGoExtern(dir string, isExtern bool) (err error) {
-	genfile := filepath.Join(dir, autoGenFile)
-	if _, err = os.Lstat(genfile); err == nil { // has gop_autogen.go
+// fingerprint returns the fingerprint of Go+ package pkgPath after its Go
+// files are generated, or "" if it isn't a Go+ package.
+func (p *Importer) fingerprint(pkgPath string) (fprint string, err error) {
+	if _, err = p.genGo(pkgPath); err != nil {
 		return
 	}
-	if isExtern {
-		os.Chmod(dir, modWritable)
-		defer os.Chmod(dir, modReadonly)
+	return p.gen.fingerprint(pkgPath), nil
+}
+
+func (p *Importer) genGoExtern(pkgPath, dir string, isExtern bool) (err error) {
+	_, err = p.gen.do(p, pkgPath, false, func() (string, error) {
+		if isExtern && p.gen.genDir == "" {
+			os.Chmod(dir, modWritable)
+			defer os.Chmod(dir, modReadonly)
+		}
+		conf := &Config{Gop: p.gop, Importer: p, Fset: p.fset, Force: p.gen.force, GenDir: p.gen.genDir}
+		return genGoIn(dir, conf, false, nil)
+	})
+	return
+}
+
+// -----------------------------------------------------------------------------
+
+// genGos records the Go+ packages whose Go files are generated, or checked up
+// to date, in a build. It is safe for concurrent use.
+type genGos struct {
+	force  bool   // regenerate Go files even if they are up to date
+	genDir string // directory to generate Go files in, see Config.GenDir
+
+	mu   sync.Mutex
+	pkgs map[string]*genGoPkg // by package paths
+}
+
+type genGoPkg struct {
+	done   chan struct{} // closed when generated
+	owner  *Importer     // importer generating the package
+	fprint string
+	err    error
+}
+
+func (p *genGoPkg) isDone() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func newGenGos(conf *Config) *genGos {
+	return &genGos{force: conf.Force, genDir: conf.GenDir, pkgs: make(map[string]*genGoPkg)}
+}
+
+// do generates Go files of package pkgPath by gen for importer imp, unless
+// they are generated already and again is false. If they are being generated
+// by another importer, it waits for that first.
+func (p *genGos) do(imp *Importer, pkgPath string, again bool, gen func() (string, error)) (fprint string, err error) {
+	p.mu.Lock()
+	for {
+		e, ok := p.pkgs[pkgPath]
+		if !ok || again && e.isDone() {
+			break
+		}
+		if e.isDone() {
+			p.mu.Unlock()
+			return e.fprint, e.err
+		}
+		for w := e; w != nil; w = w.owner.waiting {
+			if w.owner == imp {
+				p.mu.Unlock()
+				return "", fmt.Errorf("import cycle not allowed: %s", pkgPath)
+			}
+		}
+		imp.waiting = e
+		p.mu.Unlock()
+		<-e.done
+		p.mu.Lock()
+		imp.waiting = nil
+	}
+	e := &genGoPkg{done: make(chan struct{}), owner: imp}
+	p.pkgs[pkgPath] = e
+	p.mu.Unlock()
+	defer close(e.done)
+	e.fprint, e.err = gen()
+	return e.fprint, e.err
+}
+
+// fingerprint returns the fingerprint of Go+ package pkgPath if it is
+// generated, or "".
+func (p *genGos) fingerprint(pkgPath string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.pkgs[pkgPath]; ok && e.isDone() {
+		return e.fprint
 	}
-	return genGoIn(dir, &Config{Gop: p.gop, Importer: p, Fset: p.fset}, false, false)
+	return ""
 }
 
 // -----------------------------------------------------------------------------
@@ -18,11 +18,14 @@ package gop
 
 import (
 	"errors"
+	"fmt"
 	"go/token"
 	"go/types"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/goplus/gop/ast"
@@ -32,6 +35,7 @@ import (
 	"github.com/goplus/gox"
 	"github.com/goplus/mod/env"
 	"github.com/goplus/mod/gopmod"
+	"github.com/goplus/mod/modfile"
 )
 
 type Config struct {
@@ -40,13 +44,43 @@ type Config struct {
 	Filter   func(fs.FileInfo) bool
 	Importer types.Importer
 
+	// OnWarning is called to report a warning of the compiler. If it is nil,
+	// warnings are printed to stderr.
+	OnWarning func(err error)
+
 	DontUpdateGoMod     bool
 	DontCheckModChanged bool
+
+	// Force regenerates Go files of Go+ packages and their dependencies even
+	// if they are up to date.
+	Force bool
+
+	// Parallel is the number of packages generated in parallel when
+	// generating Go files of packages recursively. If it is 0, it is
+	// runtime.GOMAXPROCS(0). OnWarning may be called concurrently then.
+	Parallel int
+
+	// GenDir is the directory in which to generate Go files, instead of the
+	// directories of the Go+ packages. Go files of the package in dir are
+	// generated in GenDir/dir, and go commands find them by the overlay file
+	// GenDir/overlay.json. So the source directories and the module cache are
+	// never written. go.mod isn't updated either, requires of gop.mod missing
+	// in it are reported by OnWarning unless DontUpdateGoMod is set.
+	GenDir string
+
+	// BuiltinMethods specifies methods of builtin types in addition to the
+	// default ones, like cl.Config.BuiltinMethods.
+	BuiltinMethods []*cl.BuiltinMethods
 }
 
 // -----------------------------------------------------------------------------
 
+// modMutex serializes loading modules, which may update go.mod files.
+var modMutex sync.Mutex
+
 func loadMod(dir string, gop *env.Gop, conf *Config) (mod *gopmod.Module, err error) {
+	modMutex.Lock()
+	defer modMutex.Unlock()
 	mod, err = gopmod.Load(dir, 0)
 	if err != nil && err != syscall.ENOENT {
 		return
@@ -56,14 +90,50 @@ func loadMod(dir string, gop *env.Gop, conf *Config) (mod *gopmod.Module, err er
 		if err != nil {
 			return
 		}
-		if !conf.DontUpdateGoMod {
+		if conf.DontUpdateGoMod {
+			return
+		}
+		if conf.GenDir == "" {
 			err = mod.UpdateGoMod(gop, !conf.DontCheckModChanged)
+		} else if missing := missingRequires(mod); len(missing) > 0 { // GenDir never writes the source directories
+			onWarning(conf)(fmt.Errorf(
+				"%s doesn't require %s of gop.mod, run `gop mod tidy` to update it",
+				filepath.Join(mod.Root(), "go.mod"), strings.Join(missing, ", ")))
 		}
 		return
 	}
 	return new(gopmod.Module), nil
 }
 
+// missingRequires returns the modules which mod.UpdateGoMod would add to the
+// go.mod file of mod: the ones required by gop.mod and github.com/goplus/gop.
+func missingRequires(mod *gopmod.Module) (missing []string) {
+	gomod, file := filepath.Split(mod.Modfile())
+	if file == "go.mod" {
+		return
+	}
+	required := make(map[string]bool)
+	if b, err := os.ReadFile(gomod + "go.mod"); err == nil {
+		if f, err := modfile.ParseLax(gomod+"go.mod", b, nil); err == nil {
+			for _, r := range f.Require {
+				required[r.Mod.Path] = true
+			}
+		}
+	}
+	for _, r := range mod.Require {
+		if !required[r.Mod.Path] {
+			missing = append(missing, r.Mod.Path)
+			required[r.Mod.Path] = true
+		}
+	}
+	if !required[gopModPath] {
+		missing = append(missing, gopModPath)
+	}
+	return
+}
+
+const gopModPath = "github.com/goplus/gop"
+
 func lookupPub(mod *gopmod.Module) func(pkgPath string) (pubfile string, err error) {
 	return func(pkgPath string) (pubfile string, err error) {
 		if mod.File == nil { // no go.mod/gop.mod file
@@ -77,6 +147,15 @@ func lookupPub(mod *gopmod.Module) func(pkgPath string) (pubfile string, err err
 	}
 }
 
+func onWarning(conf *Config) func(err error) {
+	if conf.OnWarning != nil {
+		return conf.OnWarning
+	}
+	return func(err error) {
+		fmt.Fprintln(os.Stderr, "warning:", err)
+	}
+}
+
 // -----------------------------------------------------------------------------
 
 func LoadDir(dir string, conf *Config, genTestPkg bool) (out, test *gox.Package, err error) {
@@ -95,7 +174,19 @@ func LoadDir(dir string, conf *Config, genTestPkg bool) (out, test *gox.Package,
 	if err != nil {
 		return
 	}
+	imp := conf.Importer
+	if imp == nil {
+		imp = newImporter(mod, gop, fset, conf)
+	}
+	out, test, _, err = loadDir(dir, conf, fset, mod, imp, genTestPkg)
+	return
+}
 
+// loadDir compiles the Go+ package in dir, and its test package if genTestPkg
+// is true. It returns the paths of the packages they import.
+func loadDir(
+	dir string, conf *Config, fset *token.FileSet, mod *gopmod.Module, imp types.Importer,
+	genTestPkg bool) (out, test *gox.Package, imports []string, err error) {
 	pkgs, err := parser.ParseDirEx(fset, dir, parser.Config{
 		IsClass: mod.IsClass,
 		Filter:  conf.Filter,
@@ -105,11 +196,6 @@ func LoadDir(dir string, conf *Config, genTestPkg bool) (out, test *gox.Package,
 		return
 	}
 
-	imp := conf.Importer
-	if imp == nil {
-		imp = NewImporter(mod, gop, fset)
-	}
-
 	var pkgTest *ast.Package
 	var clConf = &cl.Config{
 		WorkingDir:  dir,
@@ -117,17 +203,20 @@ func LoadDir(dir string, conf *Config, genTestPkg bool) (out, test *gox.Package,
 		Importer:    imp,
 		LookupClass: mod.LookupClass,
 		LookupPub:   lookupPub(mod),
+		OnWarning:   onWarning(conf),
+
+		BuiltinMethods: conf.BuiltinMethods,
 	}
 	for name, pkg := range pkgs {
 		if strings.HasSuffix(name, "_test") {
 			if pkgTest != nil {
-				return nil, nil, errMultiTestPackges
+				return nil, nil, nil, errMultiTestPackges
 			}
 			pkgTest = pkg
 			continue
 		}
 		if out != nil {
-			return nil, nil, errMultiPackges
+			return nil, nil, nil, errMultiPackges
 		}
 		if len(pkg.Files) == 0 { // no Go+ source files
 			break
@@ -136,12 +225,14 @@ func LoadDir(dir string, conf *Config, genTestPkg bool) (out, test *gox.Package,
 		if err != nil {
 			return
 		}
+		imports = append(imports, importsOf(pkg)...)
 	}
 	if out == nil {
-		return nil, nil, syscall.ENOENT
+		return nil, nil, nil, syscall.ENOENT
 	}
 	if pkgTest != nil && genTestPkg {
 		test, err = cl.NewPackage("", pkgTest, clConf)
+		imports = append(imports, importsOf(pkgTest)...)
 	}
 	return
 }
@@ -175,13 +266,16 @@ func LoadFiles(files []string, conf *Config) (out *gox.Package, err error) {
 	for _, pkg := range pkgs {
 		imp := conf.Importer
 		if imp == nil {
-			imp = NewImporter(mod, gop, fset)
+			imp = newImporter(mod, gop, fset, conf)
 		}
 		out, err = cl.NewPackage("", pkg, &cl.Config{
 			Fset:        fset,
 			Importer:    imp,
 			LookupClass: mod.LookupClass,
 			LookupPub:   lookupPub(mod),
+			OnWarning:   onWarning(conf),
+
+			BuiltinMethods: conf.BuiltinMethods,
 		})
 		break
 	}
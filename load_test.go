@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gop_test
+
+import (
+	"bytes"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/cl"
+	"github.com/goplus/gop/env"
+	modenv "github.com/goplus/mod/env"
+)
+
+func TestLoadDirBuiltinMethods(t *testing.T) {
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/foo\n\ngo 1.16\n")
+	writeFile(t, filepath.Join(dir, "foo.gop"), "println \"a b\".fields\n")
+	conf := &gop.Config{
+		Gop:             &modenv.Gop{Version: env.Version(), BuildDate: env.BuildDate(), Root: root},
+		DontUpdateGoMod: true,
+		BuiltinMethods: []*cl.BuiltinMethods{
+			{Type: types.Typ[types.String], PkgPath: "strings", Methods: map[string]string{"fields": "Fields"}},
+		},
+	}
+	out, _, err := gop.LoadDir(dir, conf, false)
+	if err != nil {
+		t.Fatal("LoadDir:", err)
+	}
+	var b bytes.Buffer
+	if err = out.WriteTo(&b); err != nil {
+		t.Fatal("WriteTo:", err)
+	}
+	if ret := b.String(); !strings.Contains(ret, `strings.Fields("a b")`) {
+		t.Fatal("builtin method not compiled:\n" + ret)
+	}
+}
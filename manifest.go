@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gop
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/mod/env"
+	"github.com/goplus/mod/gopmod"
+)
+
+const (
+	autoGenManifest = "gop_autogen.json"
+)
+
+// -----------------------------------------------------------------------------
+
+// manifest records what the Go files of a Go+ package are generated from,
+// which are stale if any of them changes. It is saved in gop_autogen.json.
+type manifest struct {
+	Gop     string            `json:"gop"`            // version of gop
+	Options string            `json:"options"`        // hash of the options of the compiler
+	Test    bool              `json:"test,omitempty"` // whether the test package is generated
+	Files   map[string]string `json:"files"`          // hashes of the sources by file names
+	Deps    map[string]string `json:"deps,omitempty"` // fingerprints of the Go+ dependencies by package paths
+
+	// Replaces are the hashes of the sources of the modules replaced by local
+	// directories in the module file, by module paths.
+	Replaces map[string]string `json:"replaces,omitempty"`
+}
+
+// newManifest returns the manifest of the sources of Go+ package dir, without
+// the dependencies.
+func newManifest(dir string, conf *Config, gop *env.Gop, mod *gopmod.Module, genTestPkg bool) (m *manifest, err error) {
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	files := make(map[string]string)
+	for _, d := range list {
+		if d.IsDir() || !isGopSource(d.Name(), mod) {
+			continue
+		}
+		if conf.Filter != nil {
+			fi, e := d.Info()
+			if e != nil {
+				return nil, e
+			}
+			if !conf.Filter(fi) {
+				continue
+			}
+		}
+		b, e := os.ReadFile(filepath.Join(dir, d.Name()))
+		if e != nil {
+			return nil, e
+		}
+		files[d.Name()] = hashOf(b)
+	}
+	options, err := optionsHash(dir, mod, conf)
+	if err != nil {
+		return
+	}
+	replaces, err := replacesHash(mod)
+	if err != nil {
+		return
+	}
+	return &manifest{Gop: gop.Version, Options: options, Test: genTestPkg, Files: files, Replaces: replaces}, nil
+}
+
+// replacesHash returns the hashes of the sources of the modules replaced by
+// local directories in the module file of mod, by module paths. Go packages
+// in them aren't Go+ dependencies, whose fingerprints are recorded instead.
+func replacesHash(mod *gopmod.Module) (map[string]string, error) {
+	if !mod.IsValid() {
+		return nil, nil
+	}
+	var ret map[string]string
+	for modPath, real := range mod.DepMods() {
+		if real.Version != "" { // not a local directory
+			continue
+		}
+		hash, err := dirHash(real.Path, mod)
+		if err != nil {
+			return nil, err
+		}
+		if ret == nil {
+			ret = make(map[string]string)
+		}
+		ret[modPath] = hash
+	}
+	return ret, nil
+}
+
+// dirHash returns the hash of the module files and the sources of Go+ packages
+// in dir and its subdirectories.
+func dirHash(dir string, mod *gopmod.Module) (string, error) {
+	var b bytes.Buffer
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name != "go.mod" && name != "gop.mod" && !isGopSource(name, mod) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(&b, "%s %s\n", filepath.ToSlash(rel), hashOf(data))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hashOf(b.Bytes()), nil
+}
+
+// hasGopFiles reports whether there are Go+ files in the sources, but not only
+// Go files.
+func (p *manifest) hasGopFiles() bool {
+	for name := range p.Files {
+		if filepath.Ext(name) != ".go" {
+			return true
+		}
+	}
+	return false
+}
+
+// isGopSource reports whether the file fname is parsed as a source of a Go+
+// package, like parser.ParseDirEx.
+func isGopSource(fname string, mod *gopmod.Module) bool {
+	if strings.HasPrefix(fname, "_") {
+		return false
+	}
+	switch ext := filepath.Ext(fname); ext {
+	case ".gop":
+		return true
+	case ".go":
+		return !strings.HasPrefix(fname, "gop_autogen")
+	default:
+		_, ok := mod.IsClass(ext)
+		return ok
+	}
+}
+
+// optionsHash returns the hash of the options of the compiler that change the
+// code generated for the Go+ package in dir, as loadDir sets them: the working
+// directory (in line directives), the module file that registers classfiles
+// and requires the dependencies, and the builtin methods of conf.
+func optionsHash(dir string, mod *gopmod.Module, conf *Config) (string, error) {
+	var b bytes.Buffer
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&b, "dir %s\n", abs)
+	if mod.IsValid() {
+		data, err := os.ReadFile(mod.Modfile())
+		if err != nil {
+			return "", err
+		}
+		b.Write(data)
+	}
+	for _, bm := range conf.BuiltinMethods {
+		names := make([]string, 0, len(bm.Methods))
+		for name := range bm.Methods {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, "\nbuiltin %v %s", bm.Type, bm.PkgPath)
+		for _, name := range names {
+			fmt.Fprintf(&b, " %s=%s", name, bm.Methods[name])
+		}
+	}
+	return hashOf(b.Bytes()), nil
+}
+
+// importsOf returns the paths of the packages imported by the files of pkg.
+func importsOf(pkg *ast.Package) []string {
+	var paths []string
+	for _, f := range pkg.Files {
+		for _, imp := range f.Imports {
+			paths = append(paths, strings.Trim(imp.Path.Value, "`\""))
+		}
+	}
+	for _, f := range pkg.GoFiles {
+		for _, imp := range f.Imports {
+			paths = append(paths, strings.Trim(imp.Path.Value, "`\""))
+		}
+	}
+	return paths
+}
+
+// loadManifest loads the manifest of the Go files generated in dir; or nil
+// if there isn't one.
+func loadManifest(dir string) *manifest {
+	b, err := os.ReadFile(filepath.Join(dir, autoGenManifest))
+	if err != nil {
+		return nil
+	}
+	m := new(manifest)
+	if json.Unmarshal(b, m) != nil {
+		return nil
+	}
+	return m
+}
+
+// save saves the manifest to dir.
+func (p *manifest) save(dir string) error {
+	return os.WriteFile(filepath.Join(dir, autoGenManifest), p.bytes(), 0644)
+}
+
+// fingerprint returns the fingerprint of the manifest, which changes whenever
+// the Go files are generated from other sources.
+func (p *manifest) fingerprint() string {
+	return hashOf(p.bytes())
+}
+
+func (p *manifest) bytes() []byte {
+	b, _ := json.MarshalIndent(p, "", "\t")
+	return append(b, '\n')
+}
+
+// upToDate reports whether the Go files generated with manifest old are up to
+// date, which are generated from the same sources as p. The fingerprints of
+// the dependencies of old are looked up by fprint.
+func (p *manifest) upToDate(old *manifest, fprint func(pkgPath string) (string, error)) bool {
+	if old == nil || old.Gop != p.Gop || old.Options != p.Options || p.Test && !old.Test ||
+		len(old.Files) != len(p.Files) {
+		return false
+	}
+	for name, hash := range p.Files {
+		if old.Files[name] != hash {
+			return false
+		}
+	}
+	if len(old.Replaces) != len(p.Replaces) {
+		return false
+	}
+	for modPath, hash := range p.Replaces {
+		if old.Replaces[modPath] != hash {
+			return false
+		}
+	}
+	if len(old.Deps) != 0 && fprint == nil {
+		return false
+	}
+	for pkgPath, dep := range old.Deps {
+		if v, err := fprint(pkgPath); err != nil || v != dep {
+			return false
+		}
+	}
+	return true
+}
+
+func hashOf(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// -----------------------------------------------------------------------------
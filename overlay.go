@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2022 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gop
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/goplus/gop/x/gocmd"
+	"golang.org/x/tools/go/gcexportdata"
+)
+
+const (
+	overlayFile = "overlay.json"
+)
+
+// -----------------------------------------------------------------------------
+
+// genDirOf returns the directory in which to generate Go files of the Go+
+// package in dir. It is dir itself, or GenDir/dir if conf.GenDir is set.
+func genDirOf(dir string, conf *Config) (string, error) {
+	if conf.GenDir == "" {
+		return dir, nil
+	}
+	genRoot, err := filepath.Abs(conf.GenDir)
+	if err != nil {
+		return "", err
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return "", err
+	}
+	vol := filepath.VolumeName(dir)
+	return filepath.Join(genRoot, strings.TrimSuffix(vol, ":"), dir[len(vol):]), nil
+}
+
+// overlayOf returns the overlay file of the Go files generated in genDir, which
+// go commands are run with.
+func overlayOf(genDir string) string {
+	genRoot, err := filepath.Abs(genDir)
+	if err != nil {
+		genRoot = genDir
+	}
+	return filepath.Join(genRoot, overlayFile)
+}
+
+// withOverlay returns the config of go commands to find the Go files generated
+// in conf.GenDir, if it is set, with the flags of the go command added.
+func withOverlay(c *gocmd.Config, conf *Config, flags ...string) *gocmd.Config {
+	if conf == nil || conf.GenDir == "" {
+		return c
+	}
+	var ret gocmd.Config
+	if c != nil {
+		ret = *c
+	}
+	flags = append([]string{"-overlay=" + overlayOf(conf.GenDir)}, flags...)
+	ret.Flags = append(flags, ret.Flags...)
+	return &ret
+}
+
+// -----------------------------------------------------------------------------
+
+// overlay is an overlay file of go commands, in which the Go files of the
+// source directories are replaced by the ones generated.
+type overlay struct {
+	Replace map[string]string
+}
+
+// overlayMutex serializes updating overlay files. They are read again every
+// time since other processes, or GenDir removed, may change them.
+var overlayMutex sync.Mutex
+
+// updateOverlay replaces the files of Go+ package dir in the overlay file of
+// genRoot by the ones generated in genDir, or deletes them if there aren't.
+// So Go files generated in dir before aren't used.
+func updateOverlay(genRoot, dir, genDir string, files ...string) (err error) {
+	if dir, err = filepath.Abs(dir); err != nil {
+		return
+	}
+	file := overlayOf(genRoot)
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+	o := new(overlay)
+	if b, e := os.ReadFile(file); e == nil {
+		json.Unmarshal(b, o)
+	} else if !os.IsNotExist(e) {
+		return e
+	}
+	changed := o.Replace == nil
+	if changed {
+		o.Replace = make(map[string]string)
+	}
+	for _, name := range files {
+		gen := filepath.Join(genDir, name)
+		if _, e := os.Lstat(gen); e != nil {
+			gen = ""
+		}
+		src := filepath.Join(dir, name)
+		if v, ok := o.Replace[src]; !ok || v != gen {
+			o.Replace[src] = gen
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	b, err := json.MarshalIndent(o, "", "\t")
+	if err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, file)
+}
+
+// -----------------------------------------------------------------------------
+
+// overlayImporter imports Go packages by their export data like
+// packages.Importer, with the overlay file of Go files generated out of the
+// source directories.
+type overlayImporter struct {
+	loaded  map[string]*types.Package
+	fset    *token.FileSet
+	dir     string
+	overlay string
+}
+
+func newOverlayImporter(fset *token.FileSet, dir, overlay string) *overlayImporter {
+	loaded := make(map[string]*types.Package)
+	loaded["unsafe"] = types.Unsafe
+	return &overlayImporter{loaded: loaded, fset: fset, dir: dir, overlay: overlay}
+}
+
+func (p *overlayImporter) Import(pkgPath string) (*types.Package, error) {
+	return p.ImportFrom(pkgPath, p.dir, 0)
+}
+
+func (p *overlayImporter) ImportFrom(pkgPath, dir string, mode types.ImportMode) (pkg *types.Package, err error) {
+	if ret, ok := p.loaded[pkgPath]; ok && ret.Complete() {
+		return ret, nil
+	}
+	args := []string{"list", "-export", "-f", "{{.Export}}", pkgPath}
+	if _, e := os.Lstat(p.overlay); e == nil { // no Go files generated yet if it doesn't exist
+		args = append([]string{"list", "-overlay=" + p.overlay}, args[1:]...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Dir = dir
+	if err = cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			err = errors.New(stderr.String())
+		}
+		return
+	}
+	f, err := os.Open(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	r, err := gcexportdata.NewReader(f)
+	if err == nil {
+		pkg, err = gcexportdata.Read(r, p.fset, p.loaded, pkgPath)
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
@@ -813,8 +813,9 @@ func (p *parser) parseArrayTypeOrSliceLit(state int, slice ast.Expr) (expr ast.E
 				sliceLit := p.parseSliceLit(lbrack, len)
 				p.exprLev--
 				return sliceLit, resultSliceLit
-			case token.FOR: // [expr for k, v <- container if cond]
+			case token.FOR: // [expr for k, v <- container if cond orderby key ...]
 				phrases := p.parseForPhrases()
+				query := p.parseQueryClauses()
 				p.exprLev--
 				rbrack := p.expect(token.RBRACK)
 				if debugParseOutput {
@@ -822,7 +823,7 @@ func (p *parser) parseArrayTypeOrSliceLit(state int, slice ast.Expr) (expr ast.E
 				}
 				return &ast.ComprehensionExpr{
 					Lpos: lbrack, Tok: token.LBRACK, Elt: len,
-					Fors: phrases, Rpos: rbrack,
+					Fors: phrases, Query: query, Rpos: rbrack,
 				}, resultComprehensionExpr
 			}
 		case stateTypeOrSliceOp:
@@ -925,15 +926,26 @@ func (p *parser) parseFieldDecl(scope *ast.Scope) *ast.Field {
 	// 1st FieldDecl
 	// A type name used as an anonymous field looks like a field identifier.
 	var list []ast.Expr
+	var typ ast.Expr
 	for {
-		list = append(list, p.parseVarType(false))
+		x := p.parseVarType(false)
+		if name, ok := x.(*ast.Ident); ok && p.tok == token.LBRACK {
+			if name, typ = p.parseArrayFieldOrTypeInstance(name); name != nil {
+				list = append(list, name)
+				break
+			}
+			x, typ = typ, nil
+		}
+		list = append(list, x)
 		if p.tok != token.COMMA {
 			break
 		}
 		p.next()
 	}
 
-	typ := p.tryVarType(false)
+	if typ == nil {
+		typ = p.tryTypeInstance(p.tryVarType(false))
+	}
 
 	// analyze case
 	var idents []*ast.Ident
@@ -946,7 +958,7 @@ func (p *parser) parseFieldDecl(scope *ast.Scope) *ast.Field {
 		if n := len(list); n > 1 {
 			p.errorExpected(p.pos, "type", 2)
 			typ = &ast.BadExpr{From: p.pos, To: p.pos}
-		} else if !isTypeName(deref(typ)) {
+		} else if !isTypeName(deref(typ)) && !isTypeInstance(deref(typ)) {
 			p.errorExpected(typ.Pos(), "anonymous field", 2)
 			typ = &ast.BadExpr{From: typ.Pos(), To: p.safePos(typ.End())}
 		}
@@ -995,6 +1007,30 @@ func (p *parser) parseStructType() *ast.StructType {
 	}
 }
 
+func (p *parser) parseEnumType(pos token.Pos) *ast.EnumType {
+	if p.trace {
+		defer un(trace(p, "EnumType"))
+	}
+
+	lbrace := p.expect(token.LBRACE)
+	var list []*ast.EnumValue
+	for p.tok != token.RBRACE && p.tok != token.EOF {
+		v := &ast.EnumValue{Name: p.parseIdent()}
+		p.declare(v, nil, p.topScope, ast.Con, v.Name)
+		if p.tok == token.ASSIGN {
+			p.next()
+			v.Value = p.parseRHS()
+		}
+		list = append(list, v)
+		if p.tok != token.COMMA && p.tok != token.SEMICOLON {
+			break
+		}
+		p.next()
+	}
+	rbrace := p.expectClosing(token.RBRACE, "enum type")
+	return &ast.EnumType{Enum: pos, Lbrace: lbrace, Values: list, Rbrace: rbrace}
+}
+
 func (p *parser) parsePointerType() *ast.StarExpr {
 	if p.trace {
 		defer un(trace(p, "PointerType"))
@@ -1044,8 +1080,17 @@ func (p *parser) parseParameterList(scope *ast.Scope, ellipsisOk bool) (params [
 	// 1st ParameterDecl
 	// A list of identifiers looks like a list of type names.
 	var list []ast.Expr
+	var typ ast.Expr
 	for {
-		list = append(list, p.parseVarType(ellipsisOk))
+		x := p.parseVarType(ellipsisOk)
+		if name, ok := x.(*ast.Ident); ok && p.tok == token.LBRACK {
+			if name, typ = p.parseArrayFieldOrTypeInstance(name); name != nil {
+				list = append(list, name)
+				break
+			}
+			x, typ = typ, nil
+		}
+		list = append(list, x)
 		if p.tok != token.COMMA {
 			break
 		}
@@ -1056,10 +1101,16 @@ func (p *parser) parseParameterList(scope *ast.Scope, ellipsisOk bool) (params [
 	}
 
 	// analyze case
-	if typ := p.tryVarType(ellipsisOk); typ != nil {
+	if typ == nil {
+		typ = p.tryTypeInstance(p.tryVarType(ellipsisOk))
+	}
+	if typ != nil {
 		// IdentifierList Type
 		idents := p.makeIdentList(list)
 		field := &ast.Field{Names: idents, Type: typ}
+		if ellipsisOk {
+			field.Default = p.tryParamDefault()
+		}
 		params = append(params, field)
 		// Go spec: The scope of an identifier denoting a function
 		// parameter or result variable is the function body.
@@ -1071,8 +1122,11 @@ func (p *parser) parseParameterList(scope *ast.Scope, ellipsisOk bool) (params [
 		p.next()
 		for p.tok != token.RPAREN && p.tok != token.EOF {
 			idents := p.parseIdentList()
-			typ := p.parseVarType(ellipsisOk)
+			typ := p.tryTypeInstance(p.parseVarType(ellipsisOk))
 			field := &ast.Field{Names: idents, Type: typ}
+			if ellipsisOk {
+				field.Default = p.tryParamDefault()
+			}
 			params = append(params, field)
 			// Go spec: The scope of an identifier denoting a function
 			// parameter or result variable is the function body.
@@ -1095,6 +1149,16 @@ func (p *parser) parseParameterList(scope *ast.Scope, ellipsisOk bool) (params [
 	return
 }
 
+// tryParamDefault parses the default value of parameters `name T = value`,
+// if any.
+func (p *parser) tryParamDefault() ast.Expr {
+	if p.tok != token.ASSIGN {
+		return nil
+	}
+	p.next()
+	return p.parseRHS()
+}
+
 func (p *parser) parseParameters(scope *ast.Scope, ellipsisOk bool) *ast.FieldList {
 	if p.trace {
 		defer un(trace(p, "Parameters"))
@@ -1168,9 +1232,9 @@ func (p *parser) parseMethodSpec(scope *ast.Scope) *ast.Field {
 		params, results := p.parseSignature(scope)
 		typ = &ast.FuncType{Func: token.NoPos, Params: params, Results: results}
 	} else {
-		// embedded interface
-		typ = x
-		p.resolve(typ)
+		// embedded interface or type constraint element
+		p.resolve(x)
+		typ = p.parseConstraintContinue(p.tryTypeInstance(x))
 	}
 	p.expectSemi() // call before accessing p.linecomment
 
@@ -1180,6 +1244,20 @@ func (p *parser) parseMethodSpec(scope *ast.Scope) *ast.Field {
 	return spec
 }
 
+// parseEmbeddedElem parses a type constraint element in an interface which
+// doesn't start with a type name, eg. ~int | ~string.
+func (p *parser) parseEmbeddedElem() *ast.Field {
+	if p.trace {
+		defer un(trace(p, "EmbeddedElem"))
+	}
+
+	doc := p.leadComment
+	typ := p.parseConstraint()
+	p.expectSemi() // call before accessing p.linecomment
+
+	return &ast.Field{Doc: doc, Type: typ, Comment: p.lineComment}
+}
+
 func (p *parser) parseInterfaceType() *ast.InterfaceType {
 	if p.trace {
 		defer un(trace(p, "InterfaceType"))
@@ -1189,8 +1267,14 @@ func (p *parser) parseInterfaceType() *ast.InterfaceType {
 	lbrace := p.expect(token.LBRACE)
 	scope := ast.NewScope(nil) // interface scope
 	var list []*ast.Field
-	for p.tok == token.IDENT {
-		list = append(list, p.parseMethodSpec(scope))
+	for {
+		if p.tok == token.IDENT {
+			list = append(list, p.parseMethodSpec(scope))
+		} else if p.tok == token.TILDE || isTypeElemStart(p.tok) {
+			list = append(list, p.parseEmbeddedElem())
+		} else {
+			break
+		}
 	}
 	rbrace := p.expect(token.RBRACE)
 
@@ -1282,10 +1366,160 @@ func (p *parser) tryType() ast.Expr {
 	typ, _ := p.tryIdentOrType(stateType, nil)
 	if typ != nil {
 		p.resolve(typ)
+		typ = p.tryTypeInstance(typ)
+	}
+	return typ
+}
+
+// If typ is a type name followed by "[", tryTypeInstance parses it as an
+// instantiated generic type: T[A] or T[A, B, ...].
+func (p *parser) tryTypeInstance(typ ast.Expr) ast.Expr {
+	if p.tok == token.LBRACK && isTypeName(typ) {
+		return p.parseTypeInstance(typ)
 	}
 	return typ
 }
 
+func (p *parser) parseTypeInstance(typ ast.Expr) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "TypeInstance"))
+	}
+
+	lbrack := p.expect(token.LBRACK)
+	p.exprLev++
+	var list []ast.Expr
+	for p.tok != token.RBRACK && p.tok != token.EOF {
+		list = append(list, p.parseType())
+		if !p.atComma("type argument list", token.RBRACK) {
+			break
+		}
+		p.next()
+	}
+	p.exprLev--
+	rbrack := p.expectClosing(token.RBRACK, "type argument list")
+	if len(list) == 0 {
+		p.errorExpected(rbrack, "type argument list", 2)
+		list = append(list, &ast.BadExpr{From: lbrack + 1, To: rbrack})
+	}
+	return packIndexExpr(typ, lbrack, list, rbrack)
+}
+
+// parseArrayFieldOrTypeInstance parses `x []E`, `x [N]E` (x is a field or
+// parameter name) or `x[A, B, ...]` (x is a generic type).
+// It returns a nil name if x is a generic type.
+func (p *parser) parseArrayFieldOrTypeInstance(x *ast.Ident) (*ast.Ident, ast.Expr) {
+	if p.trace {
+		defer un(trace(p, "ArrayFieldOrTypeInstance"))
+	}
+
+	lbrack := p.expect(token.LBRACK)
+	var args []ast.Expr
+	if p.tok != token.RBRACK {
+		p.exprLev++
+		if p.tok == token.ELLIPSIS {
+			args = append(args, &ast.Ellipsis{Ellipsis: p.pos})
+			p.next()
+		} else {
+			args = append(args, p.parseRHSOrType())
+		}
+		for p.tok == token.COMMA {
+			p.next()
+			args = append(args, p.parseRHSOrType())
+		}
+		p.exprLev--
+	}
+	rbrack := p.expect(token.RBRACK)
+	if len(args) == 0 { // x []E
+		elt := p.parseType()
+		return x, &ast.ArrayType{Lbrack: lbrack, Elt: elt}
+	}
+	if len(args) == 1 { // x [N]E
+		if elt := p.tryType(); elt != nil {
+			return x, &ast.ArrayType{Lbrack: lbrack, Len: args[0], Elt: elt}
+		}
+	}
+	p.resolve(x)
+	return nil, packIndexExpr(x, lbrack, args, rbrack) // x[A, B, ...]
+}
+
+func packIndexExpr(x ast.Expr, lbrack token.Pos, exprs []ast.Expr, rbrack token.Pos) ast.Expr {
+	if len(exprs) == 1 {
+		if debugParseOutput {
+			log.Printf("ast.IndexExpr{X: %v, Index: %v}\n", x, exprs[0])
+		}
+		return &ast.IndexExpr{X: x, Lbrack: lbrack, Index: exprs[0], Rbrack: rbrack}
+	}
+	if debugParseOutput {
+		log.Printf("ast.IndexListExpr{X: %v, Indices: %v}\n", x, exprs)
+	}
+	return &ast.IndexListExpr{X: x, Lbrack: lbrack, Indices: exprs, Rbrack: rbrack}
+}
+
+// parseTypeParams parses a type parameter list: [T1, T2 C1, T3 C2].
+// The opening "[" is already consumed.
+func (p *parser) parseTypeParams(lbrack token.Pos, scope *ast.Scope) *ast.FieldList {
+	if p.trace {
+		defer un(trace(p, "TypeParams"))
+	}
+
+	var list []*ast.Field
+	p.exprLev++
+	for p.tok != token.RBRACK && p.tok != token.EOF {
+		idents := p.parseIdentList()
+		typ := p.parseConstraint()
+		field := &ast.Field{Names: idents, Type: typ}
+		list = append(list, field)
+		p.declare(field, nil, scope, ast.Typ, idents...)
+		if !p.atComma("type parameter list", token.RBRACK) {
+			break
+		}
+		p.next()
+	}
+	p.exprLev--
+	rbrack := p.expectClosing(token.RBRACK, "type parameter list")
+	if len(list) == 0 {
+		p.error(rbrack, "empty type parameter list")
+	}
+	return &ast.FieldList{Opening: lbrack, List: list, Closing: rbrack}
+}
+
+// parseConstraint parses a type constraint: [~]T1 | [~]T2 | ...
+func (p *parser) parseConstraint() ast.Expr {
+	if p.trace {
+		defer un(trace(p, "Constraint"))
+	}
+
+	return p.parseConstraintContinue(p.parseConstraintTerm())
+}
+
+func (p *parser) parseConstraintContinue(x ast.Expr) ast.Expr {
+	for p.tok == token.OR {
+		pos := p.pos
+		p.next()
+		y := p.parseConstraintTerm()
+		x = &ast.BinaryExpr{X: x, OpPos: pos, Op: token.OR, Y: y}
+	}
+	return x
+}
+
+func isTypeElemStart(tok token.Token) bool {
+	switch tok {
+	case token.LBRACK, token.MUL, token.STRUCT, token.FUNC, token.INTERFACE,
+		token.MAP, token.CHAN, token.ARROW, token.LPAREN:
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseConstraintTerm() ast.Expr {
+	if p.tok == token.TILDE {
+		pos := p.pos
+		p.next()
+		return &ast.UnaryExpr{OpPos: pos, Op: token.TILDE, X: p.parseType()}
+	}
+	return p.parseType()
+}
+
 // ----------------------------------------------------------------------------
 // Blocks
 
@@ -1394,6 +1628,11 @@ func (p *parser) parseOperand(lhs, allowTuple, allowCmd bool) ast.Expr {
 		return x
 
 	case token.STRING, token.CSTRING, token.INT, token.FLOAT, token.IMAG, token.CHAR, token.RAT:
+		if p.tok == token.STRING && isStringLitEx(p.lit) {
+			x := p.parseStringLitEx(p.pos, p.lit)
+			p.next()
+			return x
+		}
 		x := &ast.BasicLit{ValuePos: p.pos, Kind: p.tok, Value: p.lit}
 		if debugParseOutput {
 			log.Printf("ast.BasicLit{Kind: %v, Value: %v}\n", p.tok, p.lit)
@@ -1422,6 +1661,12 @@ func (p *parser) parseOperand(lhs, allowTuple, allowCmd bool) ast.Expr {
 			p.expect(token.RPAREN)
 			return &tupleExpr{items: items}
 		}
+		if p.tok == token.FOR { // (expr for k, v <- container if cond)
+			phrases := p.parseForPhrases()
+			p.exprLev--
+			rparen := p.expect(token.RPAREN)
+			return &ast.ComprehensionExpr{Lpos: lparen, Tok: token.LPAREN, Elt: x, Fors: phrases, Rpos: rparen}
+		}
 		p.exprLev--
 		rparen := p.expect(token.RPAREN)
 		if debugParseOutput {
@@ -1434,7 +1679,7 @@ func (p *parser) parseOperand(lhs, allowTuple, allowCmd bool) ast.Expr {
 
 	case token.LBRACE:
 		if !lhs { // rhs: mapLit - {k1: v1, k2: v2, ...}
-			return p.parseLiteralValueOrMapComprehension()
+			return p.parseLiteralValueOrMapComprehension(nil)
 		}
 
 	case token.MAP:
@@ -1512,7 +1757,20 @@ func (p *parser) parseIndexOrSlice(x ast.Expr) ast.Expr {
 
 	var idx ast.Expr
 	if p.tok != token.COLON {
-		idx = p.parseRHS()
+		idx = p.parseRHSOrType()
+		if p.tok == token.COMMA { // x[i, j, ...]
+			list := []ast.Expr{idx}
+			for p.tok == token.COMMA {
+				p.next()
+				if p.tok == token.RBRACK {
+					break
+				}
+				list = append(list, p.parseRHSOrType())
+			}
+			p.exprLev--
+			rbrack := p.expect(token.RBRACK)
+			return packIndexExpr(x, lbrack, list, rbrack)
+		}
 	}
 	return p.parseIndexOrSliceContinue(x, lbrack, idx)
 }
@@ -1578,9 +1836,23 @@ func (p *parser) parseCallOrConversion(fun ast.Expr, isCmd bool) *ast.CallExpr {
 	}
 	p.exprLev++
 	var list []ast.Expr
+	var kwargs []*ast.KwargExpr
 	var ellipsis token.Pos
 	for p.tok != endTok && p.tok != token.EOF && !ellipsis.IsValid() {
-		list = append(list, p.parseRHSOrType()) // builtins may expect a type: make(some type, ...)
+		if kw := p.tryKwarg(); kw != nil {
+			kwargs = append(kwargs, kw)
+		} else {
+			arg := p.parseRHSOrType() // builtins may expect a type: make(some type, ...)
+			if kwargs != nil {
+				p.error(arg.Pos(), "positional argument follows keyword argument")
+			}
+			list = append(list, arg)
+			if p.tok == token.FOR && len(list) == 1 && !isCmd { // sum(expr for k, v <- container if cond)
+				phrases := p.parseForPhrases()
+				list[0] = &ast.ComprehensionExpr{Lpos: lparen, Tok: token.FOR, Elt: arg, Fors: phrases}
+				break
+			}
+		}
 		if p.tok == token.ELLIPSIS {
 			ellipsis = p.pos
 			p.next()
@@ -1602,12 +1874,34 @@ func (p *parser) parseCallOrConversion(fun ast.Expr, isCmd bool) *ast.CallExpr {
 		noParenEnd = p.pos
 	} else {
 		rparen = p.expectClosing(token.RPAREN, "argument list")
+		if len(list) == 1 {
+			if ce, ok := list[0].(*ast.ComprehensionExpr); ok && ce.Tok == token.FOR {
+				ce.Rpos = rparen
+			}
+		}
 	}
 	if debugParseOutput {
 		log.Printf("ast.CallExpr{Fun: %v, Ellipsis: %v, isCmd: %v}\n", fun, ellipsis != 0, isCmd)
 	}
 	return &ast.CallExpr{
-		Fun: fun, Lparen: lparen, Args: list, Ellipsis: ellipsis, Rparen: rparen, NoParenEnd: noParenEnd}
+		Fun: fun, Lparen: lparen, Args: list, Ellipsis: ellipsis, Rparen: rparen, NoParenEnd: noParenEnd,
+		Kwargs: kwargs}
+}
+
+// tryKwarg parses a keyword argument `name = value`, if any.
+func (p *parser) tryKwarg() *ast.KwargExpr {
+	if p.tok != token.IDENT {
+		return nil
+	}
+	name := p.parseIdent()
+	if p.tok != token.ASSIGN {
+		p.unget(name.NamePos, token.IDENT, name.Name)
+		return nil
+	}
+	kw := &ast.KwargExpr{Name: name, Assign: p.pos}
+	p.next()
+	kw.Value = p.parseRHS()
+	return kw
 }
 
 func (p *parser) parseValue(keyOk bool) ast.Expr {
@@ -1616,7 +1910,7 @@ func (p *parser) parseValue(keyOk bool) ast.Expr {
 	}
 
 	if p.tok == token.LBRACE {
-		return p.parseLiteralValueOrMapComprehension()
+		return p.parseLiteralValueOrMapComprehension(nil)
 	}
 
 	// Because the parser doesn't know the composite literal type, it cannot
@@ -1671,7 +1965,9 @@ func (p *parser) parseElement() ast.Expr {
 // {for k, v <- listOrMap, cond}
 // {expr for k, v <- listOrMap, cond}
 // {kexpr: vexpr for k, v <- listOrMap, cond}
-func (p *parser) parseLiteralValueOrMapComprehension() ast.Expr {
+// set{v1, v2, ...}
+// set{expr for k, v <- listOrMap, cond}
+func (p *parser) parseLiteralValueOrMapComprehension(typ ast.Expr) ast.Expr {
 	if p.trace {
 		defer un(trace(p, "LiteralValue"))
 	}
@@ -1686,10 +1982,10 @@ func (p *parser) parseLiteralValueOrMapComprehension() ast.Expr {
 	p.exprLev--
 	rbrace := p.expectClosing(token.RBRACE, "composite literal")
 	if mce != nil {
-		mce.Lpos, mce.Rpos, mce.Tok = lbrace, rbrace, token.LBRACE
+		mce.Type, mce.Lpos, mce.Rpos, mce.Tok = typ, lbrace, rbrace, token.LBRACE
 		return mce
 	}
-	return &ast.CompositeLit{Lbrace: lbrace, Elts: elts, Rbrace: rbrace}
+	return &ast.CompositeLit{Type: typ, Lbrace: lbrace, Elts: elts, Rbrace: rbrace}
 }
 
 func (p *parser) parseElementListOrComprehension() (list []ast.Expr, mce *ast.ComprehensionExpr) {
@@ -1756,6 +2052,7 @@ func (p *parser) checkExpr(x ast.Expr) ast.Expr {
 	case *ast.BadExpr:
 	case *ast.Ident:
 	case *ast.BasicLit:
+	case *ast.StringLitEx:
 	case *ast.FuncLit:
 	case *ast.CompositeLit:
 	case *ast.SliceLit:
@@ -1764,6 +2061,7 @@ func (p *parser) checkExpr(x ast.Expr) ast.Expr {
 		panic("unreachable")
 	case *ast.SelectorExpr:
 	case *ast.IndexExpr:
+	case *ast.IndexListExpr:
 	case *ast.SliceExpr:
 	case *ast.TypeAssertExpr:
 		// If t.Type == nil we have a type assertion of the form
@@ -1812,12 +2110,31 @@ func isLiteralType(x ast.Expr) bool {
 	case *ast.ArrayType:
 	case *ast.StructType:
 	case *ast.MapType:
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return isTypeInstance(t)
 	default:
 		return false // all other nodes are not legal composite literal types
 	}
 	return true
 }
 
+// isSetIdent reports whether x is the set of set literals and comprehensions.
+func isSetIdent(x ast.Expr) bool {
+	ident, ok := x.(*ast.Ident)
+	return ok && ident.Name == "set"
+}
+
+// isTypeInstance reports whether x may be an instantiated generic type.
+func isTypeInstance(x ast.Expr) bool {
+	switch t := x.(type) {
+	case *ast.IndexExpr:
+		return isTypeName(t.X)
+	case *ast.IndexListExpr:
+		return isTypeName(t.X)
+	}
+	return false
+}
+
 // If x is of the form *T, deref returns T, otherwise it returns x.
 func deref(x ast.Expr) ast.Expr {
 	if p, isPtr := x.(*ast.StarExpr); isPtr {
@@ -1872,8 +2189,8 @@ L:
 				x = p.parseSelector(p.checkExprOrType(x))
 			case token.LPAREN:
 				x = p.parseTypeAssertion(p.checkExpr(x))
-			case token.GOTO, token.BREAK, token.CONTINUE, token.FALLTHROUGH:
-				// Go+: allow goto() as a function
+			case token.GOTO, token.BREAK, token.CONTINUE, token.FALLTHROUGH, token.MAP:
+				// Go+: allow goto() as a function, and a.map(x => expr) as a method
 				p.tok = token.IDENT
 				x = p.parseSelector(p.checkExprOrType(x))
 			default:
@@ -1900,11 +2217,15 @@ L:
 		case token.LBRACE: // {
 			if allowCmd && x.End() != p.pos { // println {}
 				x = p.parseCallOrConversion(p.checkExprOrType(x), true)
-			} else if isLiteralType(x) && (p.exprLev >= 0 || !isTypeName(x)) {
+			} else if isLiteralType(x) && (p.exprLev >= 0 || !isTypeName(x) && !isTypeInstance(x)) {
 				if lhs {
 					p.resolve(x)
 				}
-				x = p.parseLiteralValue(x)
+				if isSetIdent(x) { // Go+: set{v1, v2, ...} or set{expr for k, v <- container}
+					x = p.parseLiteralValueOrMapComprehension(x)
+				} else {
+					x = p.parseLiteralValue(x)
+				}
 			} else {
 				break L
 			}
@@ -1993,20 +2314,25 @@ func (p *parser) parseUnaryExpr(lhs, allowTuple, allowCmd bool) ast.Expr {
 	return p.parseErrWrapExpr(lhs, allowTuple, allowCmd)
 }
 
-// parseErrWrapExpr: expr! expr? expr?:defval
+// parseErrWrapExpr: expr! expr!"msg" expr? expr?"msg" expr?:defval
 func (p *parser) parseErrWrapExpr(lhs, allowTuple, allowCmd bool) ast.Expr {
 	x := p.parsePrimaryExpr(lhs, allowTuple, allowCmd)
 	switch p.tok {
-	case token.NOT: // expr!
+	case token.NOT: // expr! expr!"msg"
 		expr := &ast.ErrWrapExpr{X: x, Tok: token.NOT, TokPos: p.pos}
 		p.next()
+		if p.tok == token.STRING {
+			expr.Msg = p.parseOperand(false, false, false)
+		}
 		return expr
-	case token.QUESTION: // expr? expr?:defval
+	case token.QUESTION: // expr? expr?"msg" expr?:defval
 		expr := &ast.ErrWrapExpr{X: x, Tok: token.QUESTION, TokPos: p.pos}
 		p.next()
 		if p.tok == token.COLON {
 			p.next()
 			expr.Default = p.parseUnaryExpr(false, true, false)
+		} else if p.tok == token.STRING {
+			expr.Msg = p.parseOperand(false, false, false)
 		}
 		return expr
 	default:
@@ -2018,6 +2344,8 @@ func (p *parser) tokPrec() (token.Token, int) {
 	tok := p.tok
 	if p.inRHS && tok == token.ASSIGN {
 		tok = token.EQL
+	} else if tok == token.IDENT && p.lit == "in" { // Go+: x in container
+		tok = token.IN
 	}
 	return tok, tok.Precedence()
 }
@@ -2034,7 +2362,12 @@ func (p *parser) parseBinaryExpr(lhs bool, prec1 int, allowTuple, allowCmd bool)
 		if oprec < prec1 {
 			return x
 		}
-		pos := p.expect(op)
+		pos := p.pos
+		if op == token.IN {
+			p.next()
+		} else {
+			p.expect(op)
+		}
 		if lhs {
 			p.resolve(x)
 			lhs = false
@@ -2442,7 +2775,7 @@ func (p *parser) parseIfHeader() (init ast.Stmt, cond ast.Expr) {
 }
 
 func isForPhraseCondEnd(tok token.Token) bool {
-	return tok == token.RBRACK || tok == token.RBRACE || tok == token.FOR
+	return tok == token.RBRACK || tok == token.RBRACE || tok == token.RPAREN || tok == token.FOR
 }
 
 // parseForPhraseCond is an adjusted version of parseIfHeader
@@ -2470,7 +2803,7 @@ func (p *parser) parseForPhraseCond() (init ast.Stmt, cond ast.Expr) {
 		pos token.Pos
 		lit string // ";" or "\n"; valid if pos.IsValid()
 	}
-	if !isForPhraseCondEnd(p.tok) {
+	if !isForPhraseCondEnd(p.tok) && !p.atQueryClause() {
 		if p.tok == token.SEMICOLON {
 			semi.pos = p.pos
 			semi.lit = p.lit
@@ -2743,6 +3076,61 @@ func (p *parser) parseForPhrases() (phrases []*ast.ForPhrase) {
 	}
 }
 
+// queryKeywords are the keywords of query clauses, in the order of clauses.
+var queryKeywords = []string{"groupby", "distinct", "orderby", "skip", "take"}
+
+func queryKeywordIndex(lit string) int {
+	for i, kw := range queryKeywords {
+		if kw == lit {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *parser) atQueryClause() bool {
+	return p.tok == token.IDENT && queryKeywordIndex(p.lit) >= 0
+}
+
+// parseQueryClauses parses the query clauses after the for phrases of a list
+// comprehension: groupby, distinct, orderby, skip and take, in this order.
+func (p *parser) parseQueryClauses() (clauses []*ast.QueryClause) {
+	if p.trace {
+		defer un(trace(p, "QueryClauses"))
+	}
+
+	last := -1
+	for p.atQueryClause() {
+		idx := queryKeywordIndex(p.lit)
+		if idx <= last {
+			p.error(p.pos, "unexpected "+p.lit+", query clauses must be in order of groupby, distinct, orderby, skip and take")
+		}
+		last = idx
+		clause := &ast.QueryClause{Keyword: p.parseIdent()}
+		switch clause.Keyword.Name {
+		case "groupby":
+			clause.Args = p.parseRHSList()
+		case "orderby":
+			for {
+				key := &ast.OrderKey{X: p.parseRHS()}
+				if p.tok == token.IDENT && (p.lit == "asc" || p.lit == "desc") {
+					key.DirPos, key.Desc = p.pos, p.lit == "desc"
+					p.next()
+				}
+				clause.Orders = append(clause.Orders, key)
+				if p.tok != token.COMMA {
+					break
+				}
+				p.next()
+			}
+		case "skip", "take":
+			clause.Args = []ast.Expr{p.parseRHS()}
+		}
+		clauses = append(clauses, clause)
+	}
+	return
+}
+
 func (p *parser) parseForPhraseStmtPart(lhs []ast.Expr) *ast.ForPhraseStmt {
 	tokPos := p.expect(token.ARROW) // <-
 	x := p.parseExpr(false, false, true)
@@ -3073,17 +3461,75 @@ func (p *parser) parseTypeSpec(doc *ast.CommentGroup, _ token.Token, _ int) ast.
 	// (Global identifiers are resolved in a separate phase after parsing.)
 	spec := &ast.TypeSpec{Doc: doc, Name: ident}
 	p.declare(spec, nil, p.topScope, ast.Typ, ident)
-	if p.tok == token.ASSIGN {
-		spec.Assign = p.pos
+	if p.tok == token.LBRACK {
+		// type T[P C] ... or type T [N]E
+		lbrack := p.pos
 		p.next()
+		if p.tok == token.IDENT {
+			name := p.parseIdent()
+			isTypeParams := isTypeParamsStart(p.tok)
+			p.unget(name.NamePos, token.IDENT, name.Name)
+			if isTypeParams {
+				p.openScope()
+				defer p.closeScope()
+				spec.TypeParams = p.parseTypeParams(lbrack, p.topScope)
+				spec.Type = p.parseType()
+				p.expectSemi()
+				spec.Comment = p.lineComment
+				return spec
+			}
+		}
+		spec.Type = p.parseArrayTypeContinue(lbrack)
+	} else if p.tok == token.IDENT && p.lit == "enum" {
+		// type T enum {...}
+		name := p.parseIdent()
+		if p.tok == token.LBRACE {
+			spec.Type = p.parseEnumType(name.NamePos)
+		} else {
+			p.unget(name.NamePos, token.IDENT, name.Name)
+			spec.Type = p.parseType()
+		}
+	} else {
+		if p.tok == token.ASSIGN {
+			spec.Assign = p.pos
+			p.next()
+		}
+		spec.Type = p.parseType()
 	}
-	spec.Type = p.parseType()
 	p.expectSemi() // call before accessing p.linecomment
 	spec.Comment = p.lineComment
 
 	return spec
 }
 
+// isTypeParamsStart reports whether `type T[P tok` starts a type parameter
+// list rather than an array length expression.
+func isTypeParamsStart(tok token.Token) bool {
+	switch tok {
+	case token.IDENT, token.COMMA, token.LBRACK, token.TILDE, token.INTERFACE,
+		token.MAP, token.CHAN, token.ARROW, token.FUNC, token.STRUCT:
+		return true
+	}
+	return false
+}
+
+// parseArrayTypeContinue parses an array or slice type whose opening "[" is
+// already consumed.
+func (p *parser) parseArrayTypeContinue(lbrack token.Pos) ast.Expr {
+	var len ast.Expr
+	if p.tok == token.ELLIPSIS {
+		len = &ast.Ellipsis{Ellipsis: p.pos}
+		p.next()
+	} else if p.tok != token.RBRACK {
+		p.exprLev++
+		len = p.parseRHS()
+		p.exprLev--
+	}
+	p.expect(token.RBRACK)
+	elt := p.parseType()
+	return &ast.ArrayType{Lbrack: lbrack, Len: len, Elt: elt}
+}
+
 func (p *parser) parseGenDecl(keyword token.Token, f parseSpecFunction) *ast.GenDecl {
 	if p.trace {
 		defer un(trace(p, "GenDecl("+keyword.String()+")"))
@@ -3128,12 +3574,17 @@ func (p *parser) parseFuncDeclOrCall() (*ast.FuncDecl, *ast.CallExpr) {
 	pos := p.expect(token.FUNC)
 	scope := ast.NewScope(p.topScope) // function scope
 
-	var recv, params, results *ast.FieldList
+	var recv, tparams, params, results *ast.FieldList
 	var ident *ast.Ident
 	var isOp, isFunLit, ok bool
 
 	if p.tok != token.LPAREN { // func identOrOp(...)
 		ident, isOp = p.parseIdentOrOp()
+		if !isOp && p.tok == token.LBRACK { // func ident[T C](...)
+			lbrack := p.pos
+			p.next()
+			tparams = p.parseTypeParams(lbrack, scope)
+		}
 		params, results = p.parseSignature(scope)
 	} else {
 		// method: func (recv) XXX(params) results { ... }
@@ -3211,9 +3662,10 @@ func (p *parser) parseFuncDeclOrCall() (*ast.FuncDecl, *ast.CallExpr) {
 		Recv: recv,
 		Name: ident,
 		Type: &ast.FuncType{
-			Func:    pos,
-			Params:  params,
-			Results: results,
+			Func:       pos,
+			TypeParams: tparams,
+			Params:     params,
+			Results:    results,
 		},
 		Body:     body,
 		Operator: isOp,
@@ -101,6 +101,24 @@ func h() { var }
 `, `/foo/bar.gop:2:16: expected 'IDENT', found '}' (and 10 more errors)`, ``)
 }
 
+func TestErrRatLit(t *testing.T) {
+	testErrCode(t, `a := 0b12r`, `/foo/bar.gop:1:9: invalid digit '2' in binary literal`, ``)
+	testErrCode(t, `a := 08r`, `/foo/bar.gop:1:7: invalid digit '8' in octal literal`, ``)
+	testErrCode(t, `a := 0x1.8r`, `/foo/bar.gop:1:11: hexadecimal mantissa requires a 'p' exponent`, ``)
+}
+
+func TestErrStringLitEx(t *testing.T) {
+	testErrCode(t, `a := "x ${}"`, `/foo/bar.gop:1:11: expected operand, found 'EOF'`, ``)
+	testErrCode(t, `a := "x ${b c}"`, `/foo/bar.gop:1:13: expected '}', found c`, ``)
+	testErrCode(t, `a := "x ${b"`, `/foo/bar.gop:1:12: string literal not terminated`, ``)
+	testErrCode(t, "a := \"x ${b\n", `/foo/bar.gop:1:6: string literal not terminated`, ``)
+}
+
+func TestErrQueryClause(t *testing.T) {
+	testErrCode(t, `a := [x for x <- b take 3 orderby x]`, `/foo/bar.gop:1:27: unexpected orderby, query clauses must be in order of groupby, distinct, orderby, skip and take`, ``)
+	testErrCode(t, `a := [x for x <- b distinct distinct]`, `/foo/bar.gop:1:29: unexpected distinct, query clauses must be in order of groupby, distinct, orderby, skip and take`, ``)
+}
+
 // -----------------------------------------------------------------------------
 
 var testStdCode = `package bar; import "io"
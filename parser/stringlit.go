@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2021 The GoPlus Authors (goplus.org). All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+	"github.com/qiniu/x/log"
+)
+
+// -----------------------------------------------------------------------------
+
+// isStringLitEx reports whether lit is a "..." string literal that embeds
+// `${expr}` expressions. In such a literal `$$` stands for a single `$`.
+func isStringLitEx(lit string) bool {
+	return len(lit) > 1 && lit[0] == '"' && strings.Contains(lit, "${")
+}
+
+func (p *parser) parseStringLitEx(pos token.Pos, lit string) *ast.StringLitEx {
+	end := len(lit)
+	if lit[end-1] == '"' { // else not terminated, which the scanner has reported
+		end--
+	}
+
+	parts := make([]ast.Expr, 0, 4)
+	seg, start := make([]byte, 0, end), 1
+	for i := 1; i < end; {
+		ch := lit[i]
+		switch {
+		case ch == '\\' && i+1 < end:
+			seg = append(seg, ch, lit[i+1])
+			i += 2
+		case ch == '$' && i+1 < end && lit[i+1] == '$':
+			seg = append(seg, '$')
+			i += 2
+		case ch == '$' && i+1 < end && lit[i+1] == '{':
+			parts = appendStringSeg(parts, pos+token.Pos(start), seg)
+			seg = seg[:0]
+			j := interpEnd(lit[:end], i+2)
+			if j < 0 { // not terminated, which the scanner has reported
+				i, start = end, end
+				break
+			}
+			parts = append(parts, p.parseInterpolation(pos+token.Pos(i+2), lit[i+2:j]))
+			i, start = j+1, j+1
+		default:
+			seg = append(seg, ch)
+			i++
+		}
+	}
+	parts = appendStringSeg(parts, pos+token.Pos(start), seg)
+	if debugParseOutput {
+		log.Printf("ast.StringLitEx{Value: %v, Parts: %d}\n", lit, len(parts))
+	}
+	return &ast.StringLitEx{ValuePos: pos, Value: lit, Parts: parts}
+}
+
+func appendStringSeg(parts []ast.Expr, pos token.Pos, seg []byte) []ast.Expr {
+	if len(seg) == 0 {
+		return parts
+	}
+	val, err := strconv.Unquote("\"" + string(seg) + "\"")
+	if err != nil { // invalid escapes are reported by the scanner
+		val = string(seg)
+	}
+	return append(parts, &ast.BasicLit{ValuePos: pos, Kind: token.STRING, Value: strconv.Quote(val)})
+}
+
+// parseInterpolation parses the expression src of a `${expr}`, which starts at
+// pos of the current file.
+func (p *parser) parseInterpolation(pos token.Pos, src string) ast.Expr {
+	var sub parser
+	sub.file = token.NewFileSet().AddFile(p.file.Name(), int(pos), len(src))
+	eh := func(epos token.Position, msg string) { sub.errors.Add(epos, msg) }
+	sub.scanner.Init(sub.file, []byte(src), eh, 0)
+	sub.mode = p.mode | AllErrors
+	sub.pkgScope, sub.topScope = p.pkgScope, p.topScope
+	sub.next()
+
+	x := sub.parseRHS()
+	if sub.tok == token.SEMICOLON && sub.lit == "\n" {
+		sub.next()
+	}
+	if sub.tok != token.EOF {
+		sub.errorExpected(sub.pos, "'}'", 2)
+	}
+	for _, e := range sub.errors {
+		p.error(pos+token.Pos(e.Pos.Offset), e.Msg)
+	}
+	p.unresolved = append(p.unresolved, sub.unresolved...)
+	return x
+}
+
+// interpEnd returns the index of the '}' that closes the `${expr}` whose
+// expression starts at lit[i], or -1 if there is none.
+func interpEnd(lit string, i int) int {
+	depth := 0
+	for ; i < len(lit); i++ {
+		switch lit[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case '"', '\'', '`':
+			if i = quoteEnd(lit, i); i < 0 {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+// quoteEnd returns the index of the quote that closes the literal starting at
+// lit[i], or -1 if there is none.
+func quoteEnd(lit string, i int) int {
+	quote := lit[i]
+	for i++; i < len(lit); i++ {
+		switch ch := lit[i]; {
+		case ch == quote:
+			return i
+		case ch == '\\' && quote != '`':
+			i++
+		case ch == '$' && quote == '"' && i+1 < len(lit):
+			if lit[i+1] == '$' {
+				i++
+			} else if lit[i+1] == '{' {
+				if i = interpEnd(lit, i+2); i < 0 {
+					return -1
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// -----------------------------------------------------------------------------
@@ -347,8 +347,20 @@ func (p *printer) exprList(prev0 token.Pos, list []ast.Expr, depth int, mode exp
 	}
 }
 
-func (p *printer) parameters(fields *ast.FieldList) {
-	p.print(fields.Opening, token.LPAREN)
+type paramMode int
+
+const (
+	funcParam paramMode = iota
+	funcTParam
+	typeTParam
+)
+
+func (p *printer) parameters(fields *ast.FieldList, mode paramMode) {
+	openTok, closeTok := token.LPAREN, token.RPAREN
+	if mode != funcParam {
+		openTok, closeTok = token.LBRACK, token.RBRACK
+	}
+	p.print(fields.Opening, openTok)
 	if len(fields.List) > 0 {
 		prevLine := p.lineFor(fields.Opening)
 		ws := indent
@@ -394,6 +406,11 @@ func (p *printer) parameters(fields *ast.FieldList) {
 			}
 			// parameter type
 			p.expr(stripParensAlways(par.Type))
+			if par.Default != nil {
+				p.print(blank, token.ASSIGN, blank)
+				p.expr(par.Default)
+				parLineEnd = p.lineFor(par.Default.End())
+			}
 			prevLine = parLineEnd
 		}
 		// if the closing ")" is on a separate line from the last parameter,
@@ -401,18 +418,58 @@ func (p *printer) parameters(fields *ast.FieldList) {
 		if closing := p.lineFor(fields.Closing); 0 < prevLine && prevLine < closing {
 			p.print(token.COMMA)
 			p.linebreak(closing, 0, ignore, true)
+		} else if mode == typeTParam && fields.NumFields() == 1 && combinesWithName(fields.List[0].Type) {
+			// A type parameter list [P T] where the name P and the type expression T syntactically
+			// combine to another valid (value) expression requires a trailing comma, as in [P *T,]
+			p.print(token.COMMA)
 		}
 		// unindent if we indented
 		if ws == ignore {
 			p.print(unindent)
 		}
 	}
-	p.print(fields.Closing, token.RPAREN)
+	p.print(fields.Closing, closeTok)
+}
+
+// combinesWithName reports whether a name followed by the expression x
+// syntactically combines to another valid (value) expression. For instance
+// using *T for x, "name *T" syntactically appears as the expression x*T.
+// On the other hand, using  P|Q or *P|~Q for x, "name P|Q" or name *P|~Q"
+// cannot be combined into a valid (value) expression.
+func combinesWithName(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.StarExpr:
+		// name *x.X combines to name*x.X if x.X is not a type element
+		return !isTypeElem(x.X)
+	case *ast.BinaryExpr:
+		return combinesWithName(x.X) && !isTypeElem(x.Y)
+	case *ast.ParenExpr:
+		// name(x) combines but we are making sure at
+		// the call site that x is never parenthesized.
+		panic("unexpected parenthesized expression")
+	}
+	return false
+}
+
+// isTypeElem reports whether x is a (possibly parenthesized) type element expression.
+// The result is false if x could be a type element OR an ordinary (value) expression.
+func isTypeElem(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.ArrayType, *ast.StructType, *ast.FuncType, *ast.InterfaceType, *ast.MapType, *ast.ChanType:
+		return true
+	case *ast.UnaryExpr:
+		return x.Op == token.TILDE
+	case *ast.BinaryExpr:
+		return isTypeElem(x.X) || isTypeElem(x.Y)
+	case *ast.ParenExpr:
+		return isTypeElem(x.X)
+	}
+	return false
 }
 
 func (p *printer) signature(params, result *ast.FieldList) {
 	if params != nil {
-		p.parameters(params)
+		p.parameters(params, funcParam)
 	} else {
 		p.print(token.LPAREN, token.RPAREN)
 	}
@@ -425,7 +482,7 @@ func (p *printer) signature(params, result *ast.FieldList) {
 			p.expr(stripParensAlways(result.List[0].Type))
 			return
 		}
-		p.parameters(result)
+		p.parameters(result, funcParam)
 	}
 }
 
@@ -594,6 +651,43 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 	p.print(unindent, formfeed, rbrace, token.RBRACE)
 }
 
+func (p *printer) enumType(x *ast.EnumType) {
+	p.print(&ast.Ident{NamePos: x.Enum, Name: "enum"})
+	if p.lineFor(x.Lbrace) == p.lineFor(x.Rbrace) && !p.commentBefore(p.posFor(x.Rbrace)) {
+		// one-line enum
+		p.print(x.Lbrace, token.LBRACE)
+		for i, v := range x.Values {
+			if i > 0 {
+				p.print(token.COMMA, blank)
+			}
+			p.enumValue(v)
+		}
+		p.print(x.Rbrace, token.RBRACE)
+		return
+	}
+	p.print(blank, x.Lbrace, token.LBRACE, indent)
+	if len(x.Values) > 0 {
+		p.print(formfeed)
+	}
+	var line int
+	for i, v := range x.Values {
+		if i > 0 {
+			p.linebreak(p.lineFor(v.Pos()), 1, ignore, p.linesFrom(line) > 0)
+		}
+		p.recordLine(&line)
+		p.enumValue(v)
+	}
+	p.print(unindent, formfeed, x.Rbrace, token.RBRACE)
+}
+
+func (p *printer) enumValue(v *ast.EnumValue) {
+	p.expr(v.Name)
+	if v.Value != nil {
+		p.print(blank, token.ASSIGN, blank)
+		p.expr(v.Value)
+	}
+}
+
 // ----------------------------------------------------------------------------
 // Expressions
 
@@ -733,7 +827,7 @@ func (p *printer) binaryExpr(x *ast.BinaryExpr, prec1, cutoff, depth int) {
 		return
 	}
 
-	printBlank := prec < cutoff
+	printBlank := prec < cutoff || x.Op == token.IN // x in container
 
 	ws := indent
 	p.expr1(x.X, prec, depth+diffPrec(x.X, prec))
@@ -824,6 +918,9 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 	case *ast.BasicLit:
 		p.print(x)
 
+	case *ast.StringLitEx:
+		p.print(&ast.BasicLit{ValuePos: x.ValuePos, Kind: token.STRING, Value: x.Value})
+
 	case *ast.FuncLit:
 		p.print(x.Type.Pos(), token.FUNC)
 		// See the comment in funcDecl about how the header size is computed.
@@ -862,6 +959,14 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.expr0(x.Index, depth+1)
 		p.print(x.Rbrack, token.RBRACK)
 
+	case *ast.IndexListExpr:
+		// TODO(gri): as for IndexExpr, should treat [] like parentheses and undo
+		// one level of depth
+		p.expr1(x.X, token.HighestPrec, 1)
+		p.print(x.Lbrack, token.LBRACK)
+		p.exprList(x.Lbrack, x.Indices, depth+1, commaTerm, x.Rbrack, false)
+		p.print(x.Rbrack, token.RBRACK)
+
 	case *ast.SliceExpr:
 		// TODO(gri): should treat[] like parentheses and undo one level of depth
 		p.expr1(x.X, token.HighestPrec, 1)
@@ -929,7 +1034,14 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 				p.print(token.COMMA, formfeed)
 			}
 		} else {
-			p.exprList(x.Lparen, x.Args, depth, commaTerm, x.Rparen, false)
+			args := x.Args
+			if x.Kwargs != nil {
+				args = args[:len(args):len(args)]
+				for _, kw := range x.Kwargs {
+					args = append(args, kw)
+				}
+			}
+			p.exprList(x.Lparen, args, depth, commaTerm, x.Rparen, false)
 		}
 		if x.NoParenEnd == token.NoPos {
 			p.print(x.Rparen, token.RPAREN)
@@ -986,6 +1098,14 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.print(token.INTERFACE)
 		p.fieldList(x.Methods, false, x.Incomplete)
 
+	case *ast.EnumType:
+		p.enumType(x)
+
+	case *ast.KwargExpr:
+		p.expr(x.Name)
+		p.print(blank, x.Assign, token.ASSIGN, blank)
+		p.expr(x.Value)
+
 	case *ast.MapType:
 		p.print(token.MAP, token.LBRACK)
 		p.expr(x.Key)
@@ -1027,8 +1147,22 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 			p.expr0(x.Elt, depth+1)
 			p.print(blank)
 			p.listForPhrase(x.Lpos, x.Fors, depth, x.Rpos)
+			p.listQueryClause(x.Query, depth)
 			p.print(token.RBRACK)
+		case token.LPAREN: // (...)
+			p.print(token.LPAREN)
+			p.expr0(x.Elt, depth+1)
+			p.print(blank)
+			p.listForPhrase(x.Lpos, x.Fors, depth, x.Rpos)
+			p.print(token.RPAREN)
+		case token.FOR: // argument of sum(...) etc.
+			p.expr0(x.Elt, depth+1)
+			p.print(blank)
+			p.listForPhrase(x.Lpos, x.Fors, depth, x.Rpos)
 		default: // {...}
+			if x.Type != nil { // set{...}
+				p.expr1(x.Type, token.HighestPrec, depth)
+			}
 			p.print(token.LBRACE)
 			if x.Elt != nil {
 				if elt, ok := x.Elt.(*ast.KeyValueExpr); ok {
@@ -1046,6 +1180,10 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 	case *ast.ErrWrapExpr:
 		p.expr(x.X)
 		p.print(x.Tok)
+		if x.Msg != nil {
+			p.print(blank)
+			p.expr(x.Msg)
+		}
 		if x.Default != nil {
 			p.print(token.COLON)
 			p.expr(x.Default)
@@ -1122,6 +1260,30 @@ func (p *printer) listForPhrase(prev0 token.Pos, list []*ast.ForPhrase, depth in
 	}
 }
 
+func (p *printer) listQueryClause(list []*ast.QueryClause, depth int) {
+	for _, x := range list {
+		p.print(blank, x.Keyword)
+		switch x.Keyword.Name {
+		case "groupby", "skip", "take":
+			p.print(blank)
+			p.exprList(token.NoPos, x.Args, depth+1, 0, token.NoPos, false)
+		case "orderby":
+			for i, key := range x.Orders {
+				if i > 0 {
+					p.print(token.COMMA)
+				}
+				p.print(blank)
+				p.expr0(key.X, depth+1)
+				if key.Desc {
+					p.print(blank, key.DirPos, &ast.Ident{Name: "desc"})
+				} else if key.DirPos.IsValid() {
+					p.print(blank, key.DirPos, &ast.Ident{Name: "asc"})
+				}
+			}
+		}
+	}
+}
+
 func (p *printer) possibleSelectorExpr(expr ast.Expr, prec1, depth int) bool {
 	if x, ok := expr.(*ast.SelectorExpr); ok {
 		return p.selectorExpr(x, depth, true)
@@ -1702,6 +1864,9 @@ func (p *printer) spec(spec ast.Spec, n int, doIndent bool) {
 	case *ast.TypeSpec:
 		p.setComment(s.Doc)
 		p.expr(s.Name)
+		if s.TypeParams != nil {
+			p.parameters(s.TypeParams, typeTParam)
+		}
 		if n == 1 {
 			p.print(blank)
 		} else {
@@ -1966,13 +2131,16 @@ func (p *printer) funcDecl(d *ast.FuncDecl) {
 	// FUNC is emitted).
 	startCol := p.out.Column - len("func ")
 	if d.Recv != nil {
-		p.parameters(d.Recv) // method: print receiver
+		p.parameters(d.Recv, funcParam) // method: print receiver
 		p.print(blank)
 	}
 	p.expr(d.Name)
 	if d.Operator && d.Recv != nil {
 		p.print(blank)
 	}
+	if tparams := d.Type.TypeParams; tparams != nil {
+		p.parameters(tparams, funcTParam)
+	}
 	p.signature(d.Type.Params, d.Type.Results)
 	p.funcBody(p.distanceFrom(d.Pos(), startCol), vtab, d.Body)
 }
@@ -498,7 +498,8 @@ func (s *Scanner) scanNumber() (token.Token, string) {
 		s.error(s.offset, "hexadecimal mantissa requires a 'p' exponent")
 	}
 
-	// suffix 'i'
+	// suffix 'i' or 'r'
+	intLit := tok == token.INT
 	if s.ch == 'i' {
 		tok = token.IMAG
 		s.next()
@@ -508,7 +509,7 @@ func (s *Scanner) scanNumber() (token.Token, string) {
 	}
 
 	lit := string(s.src[offs:s.offset])
-	if tok == token.INT && invalid >= 0 {
+	if (tok == token.INT || tok == token.RAT && intLit) && invalid >= 0 {
 		s.errorf(invalid, "invalid digit %q in %s", lit[invalid-offs], litname(prefix))
 	}
 	if digsep&2 != 0 {
@@ -681,12 +682,53 @@ func (s *Scanner) scanString() string {
 		}
 		if ch == '\\' {
 			s.scanEscape('"')
+		} else if ch == '$' {
+			if s.ch == '$' { // $$
+				s.next()
+			} else if s.ch == '{' { // ${expr}
+				s.next()
+				if !s.scanInterpolation(offs) {
+					break
+				}
+			}
 		}
 	}
 
 	return string(s.src[offs:s.offset])
 }
 
+// scanInterpolation skips the expression of a `${expr}` embedded in the string
+// literal starting at offs, up to and including the closing '}'.
+func (s *Scanner) scanInterpolation(offs int) bool {
+	// '${' opening already consumed
+	depth, errs := 0, s.ErrorCount
+	for {
+		ch := s.ch
+		if ch == '\n' || ch < 0 {
+			if s.ErrorCount == errs { // not reported by a nested literal yet
+				s.error(offs, "string literal not terminated")
+			}
+			return false
+		}
+		s.next()
+		switch ch {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return true
+			}
+			depth--
+		case '"':
+			s.scanString()
+		case '\'':
+			s.scanRune()
+		case '`':
+			s.scanRawString()
+		}
+	}
+}
+
 func stripCR(b []byte, comment bool) []byte {
 	c := make([]byte, len(b))
 	i := 0
@@ -981,6 +1023,8 @@ scanAgain:
 		case '?':
 			tok = token.QUESTION
 			insertSemi = true
+		case '~':
+			tok = token.TILDE
 		default:
 			// next reports unexpected BOMs - don't repeat
 			if ch != bom {
@@ -139,6 +139,7 @@ const (
 
 	additional_beg
 	TILDE // additional tokens, handled in an ad-hoc manner
+	IN    // in
 	additional_end
 
 	CSTRING  = literal_beg  // C"Hello"
@@ -220,6 +221,7 @@ var tokens = [...]string{
 	QUESTION:  "?",
 	RARROW:    "=>",
 	TILDE:     "~",
+	IN:        "in",
 
 	BREAK:    "break",
 	CASE:     "case",
@@ -291,7 +293,7 @@ func (op Token) Precedence() int {
 		return 1
 	case LAND:
 		return 2
-	case EQL, NEQ, LSS, LEQ, GTR, GEQ:
+	case EQL, NEQ, LSS, LEQ, GTR, GEQ, IN:
 		return 3
 	case ADD, SUB, OR, XOR:
 		return 4
@@ -332,7 +334,7 @@ func (tok Token) IsLiteral() bool {
 // delimiters; it returns false otherwise.
 //
 func (tok Token) IsOperator() bool {
-	return operator_beg <= tok && tok <= operator_end || tok == TILDE
+	return operator_beg <= tok && tok <= operator_end || tok == TILDE || tok == IN
 }
 
 // IsKeyword returns true for tokens corresponding to keywords;
@@ -17,6 +17,9 @@
 package format
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/goplus/gop/ast"
 	"github.com/goplus/gop/token"
 )
@@ -65,3 +68,116 @@ func fncallStartingLowerCase(v *ast.CallExpr) {
 }
 
 // -----------------------------------------------------------------------------
+
+// sprintfToStringLitEx converts `sprintf("...", args...)` into a string literal
+// with `${expr}` interpolations, if the format only contains %v verbs and the
+// arguments are plain names. `${expr}` formats expr like %v does, so other
+// verbs (eg. %s and %d, which differ from %v for some types) are kept as is.
+func sprintfToStringLitEx(ctx *formatCtx, v *ast.CallExpr, ref *ast.Expr) {
+	fn, ok := v.Fun.(*ast.Ident)
+	if !ok || fn.Name != "sprintf" || len(v.Args) < 2 || v.Ellipsis != token.NoPos {
+		return
+	}
+	if _, o := ctx.scope.LookupParent(fn.Name, token.NoPos); o != nil {
+		return
+	}
+	format, ok := v.Args[0].(*ast.BasicLit)
+	if !ok || format.Kind != token.STRING || format.Value[0] != '"' {
+		return
+	}
+	if val, err := strconv.Unquote(format.Value); err != nil ||
+		strings.Count(val, "%") != strings.Count(format.Value, "%") {
+		return // a % written as an escape sequence
+	}
+	args := v.Args[1:]
+	names := make([]string, len(args))
+	for i, arg := range args {
+		if names[i] = plainName(arg); names[i] == "" {
+			return
+		}
+	}
+
+	lit := format.Value[1 : len(format.Value)-1]
+	var b strings.Builder
+	var parts []ast.Expr
+	var seg strings.Builder
+	flush := func() {
+		if seg.Len() > 0 {
+			val, _ := strconv.Unquote("\"" + seg.String() + "\"")
+			parts = append(parts, &ast.BasicLit{ValuePos: format.ValuePos, Kind: token.STRING, Value: strconv.Quote(val)})
+			seg.Reset()
+		}
+	}
+	b.WriteByte('"')
+	n := 0
+	for i := 0; i < len(lit); i++ {
+		switch ch := lit[i]; ch {
+		case '%':
+			if i+1 == len(lit) {
+				return
+			}
+			i++
+			switch lit[i] {
+			case '%':
+				b.WriteByte('%')
+				seg.WriteByte('%')
+			case 'v':
+				if n == len(args) {
+					return
+				}
+				flush()
+				b.WriteString("${" + names[n] + "}")
+				parts = append(parts, args[n])
+				n++
+			default:
+				return
+			}
+		case '$':
+			b.WriteString("$$")
+			seg.WriteByte('$')
+		default:
+			b.WriteByte(ch)
+			seg.WriteByte(ch)
+		}
+	}
+	if n != len(args) {
+		return
+	}
+	flush()
+	b.WriteByte('"')
+	*ref = &ast.StringLitEx{ValuePos: v.Pos(), Value: b.String(), Parts: parts}
+}
+
+func plainName(expr ast.Expr) string {
+	switch v := expr.(type) {
+	case *ast.Ident:
+		return v.Name
+	case *ast.SelectorExpr:
+		if x := plainName(v.X); x != "" {
+			return x + "." + v.Sel.Name
+		}
+	}
+	return ""
+}
+
+// markImportsUsed marks the imports referenced by the expressions embedded in
+// a string literal as used. They are kept as is since the literal is printed
+// from its source text.
+func markImportsUsed(ctx *formatCtx, v *ast.StringLitEx) {
+	for _, part := range v.Parts {
+		ast.Inspect(part, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					if _, o := ctx.scope.LookupParent(x.Name, token.NoPos); o == nil {
+						if imp, ok := ctx.imports[x.Name]; ok {
+							imp.isUsed = true
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// -----------------------------------------------------------------------------
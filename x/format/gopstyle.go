@@ -223,6 +223,7 @@ func formatGenDecl(ctx *formatCtx, v *ast.GenDecl) {
 	case token.TYPE:
 		for _, item := range v.Specs {
 			spec := item.(*ast.TypeSpec)
+			formatFields(ctx, spec.TypeParams)
 			formatType(ctx, spec.Type, &spec.Type)
 		}
 	}
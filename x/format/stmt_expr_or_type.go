@@ -45,16 +45,34 @@ func formatType(ctx *formatCtx, typ ast.Expr, ref *ast.Expr) {
 		formatType(ctx, t.Value, &t.Value)
 	case *ast.InterfaceType:
 		formatFields(ctx, t.Methods)
+	case *ast.EnumType:
+		for _, ev := range t.Values {
+			formatExpr(ctx, ev.Value, &ev.Value)
+		}
 	case *ast.FuncType:
 		formatFuncType(ctx, t)
 	case *ast.Ellipsis:
 		formatType(ctx, t.Elt, &t.Elt)
+	case *ast.IndexExpr: // generic type instance: T[A]
+		formatType(ctx, t.X, &t.X)
+		formatType(ctx, t.Index, &t.Index)
+	case *ast.IndexListExpr: // generic type instance: T[A, B]
+		formatType(ctx, t.X, &t.X)
+		for i, index := range t.Indices {
+			formatType(ctx, index, &t.Indices[i])
+		}
+	case *ast.UnaryExpr: // constraint term: ~T
+		formatType(ctx, t.X, &t.X)
+	case *ast.BinaryExpr: // constraint union: A | B
+		formatType(ctx, t.X, &t.X)
+		formatType(ctx, t.Y, &t.Y)
 	default:
 		log.Panicln("TODO: format -", reflect.TypeOf(typ))
 	}
 }
 
 func formatFuncType(ctx *formatCtx, t *ast.FuncType) {
+	formatFields(ctx, t.TypeParams)
 	formatFields(ctx, t.Params)
 	formatFields(ctx, t.Results)
 }
@@ -69,6 +87,9 @@ func formatFields(ctx *formatCtx, flds *ast.FieldList) {
 
 func formatField(ctx *formatCtx, fld *ast.Field) {
 	formatType(ctx, fld.Type, &fld.Type)
+	if fld.Default != nil {
+		formatExpr(ctx, fld.Default, &fld.Default)
+	}
 }
 
 // -----------------------------------------------------------------------------
@@ -87,8 +108,11 @@ func formatExpr(ctx *formatCtx, expr ast.Expr, ref *ast.Expr) {
 		formatExpr(ctx, v.Y, &v.Y)
 	case *ast.UnaryExpr:
 		formatExpr(ctx, v.X, &v.X)
+	case *ast.StringLitEx:
+		markImportsUsed(ctx, v)
 	case *ast.CallExpr:
 		formatCallExpr(ctx, v)
+		sprintfToStringLitEx(ctx, v, ref)
 	case *ast.SelectorExpr:
 		formatSelectorExpr(ctx, v, ref)
 	case *ast.SliceExpr:
@@ -96,6 +120,9 @@ func formatExpr(ctx *formatCtx, expr ast.Expr, ref *ast.Expr) {
 	case *ast.IndexExpr:
 		formatExpr(ctx, v.X, &v.X)
 		formatExpr(ctx, v.Index, &v.Index)
+	case *ast.IndexListExpr:
+		formatExpr(ctx, v.X, &v.X)
+		formatExprs(ctx, v.Indices)
 	case *ast.SliceLit:
 		formatExprs(ctx, v.Elts)
 	case *ast.CompositeLit:
@@ -122,6 +149,7 @@ func formatExpr(ctx *formatCtx, expr ast.Expr, ref *ast.Expr) {
 		formatComprehensionExpr(ctx, v)
 	case *ast.ErrWrapExpr:
 		formatExpr(ctx, v.X, &v.X)
+		formatExpr(ctx, v.Msg, &v.Msg)
 		formatExpr(ctx, v.Default, &v.Default)
 	case *ast.ParenExpr:
 		formatExpr(ctx, v.X, &v.X)
@@ -144,6 +172,12 @@ func formatComprehensionExpr(ctx *formatCtx, v *ast.ComprehensionExpr) {
 
 	formatForPhrases(ctx, v.Fors)
 	formatExpr(ctx, v.Elt, &v.Elt)
+	for _, q := range v.Query {
+		formatExprs(ctx, q.Args)
+		for _, key := range q.Orders {
+			formatExpr(ctx, key.X, &key.X)
+		}
+	}
 }
 
 func formatForPhrases(ctx *formatCtx, fors []*ast.ForPhrase) {
@@ -169,6 +203,9 @@ func formatCallExpr(ctx *formatCtx, v *ast.CallExpr) {
 	formatExpr(ctx, v.Fun, &v.Fun)
 	fncallStartingLowerCase(v)
 	formatExprs(ctx, v.Args)
+	for _, kw := range v.Kwargs {
+		formatExpr(ctx, kw.Value, &kw.Value)
+	}
 }
 
 func formatSelectorExpr(ctx *formatCtx, v *ast.SelectorExpr, ref *ast.Expr) {
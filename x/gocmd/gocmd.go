@@ -31,6 +31,11 @@ type Config struct {
 	Gop   *GopEnv
 	Flags []string
 	Run   func(cmd *exec.Cmd) error
+
+	// Dir is the working directory of the go command, which relative paths
+	// are relative to. If it is empty, the go command runs in the current
+	// directory.
+	Dir string
 }
 
 // -----------------------------------------------------------------------------
@@ -45,6 +50,7 @@ func doWithArgs(op string, conf *Config, args ...string) (err error) {
 	exargs = append(exargs, conf.Flags...)
 	exargs = append(exargs, args...)
 	cmd := exec.Command("go", exargs...)
+	cmd.Dir = conf.Dir
 	run := conf.Run
 	if run == nil {
 		run = runCmd
@@ -27,7 +27,11 @@ import (
 type RunConfig = Config
 
 func RunDir(dir string, args []string, conf *RunConfig) (err error) {
-	fis, err := os.ReadDir(dir)
+	path := dir
+	if conf != nil && conf.Dir != "" && !filepath.IsAbs(dir) {
+		path = filepath.Join(conf.Dir, dir)
+	}
+	fis, err := os.ReadDir(path)
 	if err != nil {
 		return
 	}